@@ -0,0 +1,22 @@
+package main
+
+import (
+    "fmt"
+    "os"
+
+    "github.com/Aadithya-J/alcaIDE/internal/config"
+)
+
+func printConfig(cfg config.Config, args []string) error {
+    if len(args) != 1 || args[0] != "print" {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    out, err := cfg.Redacted().JSON()
+    if err != nil {
+        return err
+    }
+    fmt.Println(string(out))
+    return nil
+}
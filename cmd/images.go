@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/signal"
+    "slices"
+    "syscall"
+
+    "github.com/Aadithya-J/alcaIDE/internal/config"
+    "github.com/Aadithya-J/alcaIDE/internal/docker"
+)
+
+// buildImages builds the images of the named languages, or of every
+// language with a Dockerfile, on every configured Docker host, streaming
+// the build output to stdout.
+func buildImages(cfg config.Config, args []string) error {
+    if len(args) == 0 || args[0] != "build" {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    languages := cfg.ResolvedLanguages()
+    if names := args[1:]; len(names) > 0 {
+        for _, name := range names {
+            lang, ok := languages[name]
+            if !ok {
+                return fmt.Errorf("unknown language %q", name)
+            }
+            if lang.Build == nil {
+                return fmt.Errorf("language %q uses a pulled image, not a Dockerfile", name)
+            }
+        }
+        for name := range languages {
+            if !slices.Contains(names, name) {
+                delete(languages, name)
+            }
+        }
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    hosts := dockerHosts(cfg)
+    tags, err := docker.BuildImages(ctx, hosts, languages, func(host, language, line string) {
+        if len(hosts) > 1 {
+            language += "@" + host
+        }
+        fmt.Printf("[%s] %s\n", language, line)
+    })
+    built := make([]string, 0, len(tags))
+    for name := range tags {
+        built = append(built, name)
+    }
+    slices.Sort(built)
+    for _, name := range built {
+        fmt.Printf("%s: %s\n", name, tags[name])
+    }
+    return err
+}
@@ -3,86 +3,385 @@ package main
 import (
     "context"
     "errors"
-    "log"
+    "flag"
+    "fmt"
+    "log/slog"
     "net/http"
     "os"
     "os/signal"
+    "strings"
     "syscall"
     "time"
 
+    "github.com/Aadithya-J/alcaIDE/internal/auth"
     "github.com/Aadithya-J/alcaIDE/internal/config"
     "github.com/Aadithya-J/alcaIDE/internal/db"
-    "github.com/Aadithya-J/alcaIDE/internal/docker"
+    "github.com/Aadithya-J/alcaIDE/internal/handler"
+    "github.com/Aadithya-J/alcaIDE/internal/logging"
+    "github.com/Aadithya-J/alcaIDE/internal/lsp"
+    "github.com/Aadithya-J/alcaIDE/internal/mail"
+    "github.com/Aadithya-J/alcaIDE/internal/notebook"
+    "github.com/Aadithya-J/alcaIDE/internal/repl"
     "github.com/Aadithya-J/alcaIDE/internal/router"
+    "github.com/Aadithya-J/alcaIDE/internal/sandbox"
+    "github.com/Aadithya-J/alcaIDE/internal/store"
+    "github.com/Aadithya-J/alcaIDE/internal/telemetry"
+    "github.com/google/uuid"
 )
 
-const (
-    INITIAL_CONTAINER_COUNT = 2
-    SERVER_SHUTDOWN_TIMEOUT = 5 * time.Second
-    SERVER_ADDR             = ":8080"
-    DOCKER_IMAGE            = "docker.io/library/python:3.11-slim"
-)
+const usage = `usage: alcaide [flags] [command]
+
+commands:
+  serve                 run the HTTP server (default)
+  migrate up            apply all pending database migrations
+  migrate down [n]      revert the last n migrations (default 1)
+  migrate status        list migrations and whether they are applied
+  config print          print the effective configuration, secrets redacted
+  images build [lang]   build the language images that have a Dockerfile
+
+Run "alcaide -h" to list flags. Every flag can also be set through the
+environment variable named in its help text or through the config file.
+`
 
-var languageImages = map[string]string{
-    "python":     "docker.io/library/python:3.11-slim",
-    "javascript": "docker.io/library/node:20-slim",
-    // Add other languages and their corresponding images here
+func fatal(msg string, err error) {
+    slog.Error(msg, "error", err)
+    os.Exit(1)
 }
 
 func main() {
-    config.LoadEnv()
+    cfg, args, err := config.Load(os.Args[1:])
+    if errors.Is(err, flag.ErrHelp) {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(0)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(2)
+    }
+
+    if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+        fatal("Failed to set up logging", err)
+    }
 
-    db.Initialize()
+    command := "serve"
+    if len(args) > 0 {
+        command, args = args[0], args[1:]
+    }
+
+    switch command {
+    case "serve":
+        serve(cfg)
+    case "migrate":
+        if err := migrate(cfg, args); err != nil {
+            fatal("Migration failed", err)
+        }
+    case "config":
+        if err := printConfig(cfg, args); err != nil {
+            fatal("Config command failed", err)
+        }
+    case "images":
+        if err := buildImages(cfg, args); err != nil {
+            fatal("Image build failed", err)
+        }
+    default:
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+}
+
+func newMailer(cfg config.MailConfig) mail.Mailer {
+    switch cfg.Driver {
+    case "smtp":
+        return &mail.SMTPMailer{
+            Host:     cfg.SMTPHost,
+            Port:     cfg.SMTPPort,
+            Username: cfg.SMTPUsername,
+            Password: cfg.SMTPPassword,
+            From:     cfg.From,
+        }
+    case "file":
+        return &mail.FileMailer{Dir: cfg.Dir, From: cfg.From}
+    default:
+        return &mail.FileMailer{From: cfg.From}
+    }
+}
+
+// historyPruneInterval is how often expired execution history is deleted.
+const historyPruneInterval = time.Hour
+
+// pruneHistory deletes execution records older than retention until ctx is
+// cancelled.
+func pruneHistory(ctx context.Context, executions store.ExecutionStore, retention time.Duration) {
+    ticker := time.NewTicker(historyPruneInterval)
+    defer ticker.Stop()
+    for {
+        removed, err := executions.DeleteExecutionsBefore(ctx, time.Now().Add(-retention))
+        if err != nil && ctx.Err() == nil {
+            slog.Error("Error pruning execution history", "error", err)
+        } else if removed > 0 {
+            slog.Info("Pruned execution history", "removed", removed, "retention", retention)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func serve(cfg config.Config) {
+    shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeout)
+
+    shutdownTracing, err := telemetry.Setup(context.Background(), telemetry.Config{
+        Exporter:    cfg.Tracing.Exporter,
+        Endpoint:    cfg.Tracing.Endpoint,
+        ServiceName: cfg.Tracing.ServiceName,
+    })
+    if err != nil {
+        fatal("Failed to set up tracing", err)
+    }
     defer func() {
-        log.Println("Closing database connection...")
-        db.Close()
+        flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+        defer cancel()
+        if err := shutdownTracing(flushCtx); err != nil {
+            slog.Error("Error flushing traces", "error", err)
+        }
     }()
 
-    dockerManager, err := docker.NewManager(languageImages)
+    pool, err := db.Connect(context.Background(), cfg.Database)
     if err != nil {
-        log.Fatalf("Failed to create Docker manager: %v", err)
+        fatal("Failed to initialize database", err)
+    }
+    defer func() {
+        slog.Info("Closing database connection pool")
+        pool.Close()
+    }()
+
+    if cfg.Database.AutoMigrate {
+        migrator, err := db.NewMigrator(pool)
+        if err != nil {
+            fatal("Failed to load migrations", err)
+        }
+        if _, err := migrator.Up(context.Background()); err != nil {
+            fatal("Failed to apply migrations", err)
+        }
     }
-    defer dockerManager.Close()
 
-    ctx := context.Background()
-    if err := dockerManager.PullImages(ctx); err != nil {
-        log.Printf("Warning: Failed to pull Docker image: %v. Proceeding might use a local image.", err)
+    sandboxManager, stopSandboxes := startSandboxes(cfg)
+    defer stopSandboxes()
+
+    users := store.NewPostgresUserStore(pool)
+    apiKeys := store.NewPostgresAPIKeyStore(pool)
+    jwtSecret := []byte(cfg.Auth.JWTSecret)
+
+    var oidcHandler *handler.OIDCHandler
+    if cfg.OIDC.Enabled() {
+        discoverCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        provider, err := auth.NewOIDCProvider(discoverCtx, auth.OIDCOptions{
+            Issuer:       cfg.OIDC.Issuer,
+            ClientID:     cfg.OIDC.ClientID,
+            ClientSecret: cfg.OIDC.ClientSecret,
+            RedirectURL:  cfg.OIDCRedirectURL(),
+            Scopes:       cfg.OIDC.Scopes,
+        }, jwtSecret)
+        cancel()
+        if err != nil {
+            fatal("Failed to set up OIDC login", err)
+        }
+        oidcHandler = &handler.OIDCHandler{
+            Provider:         provider,
+            Users:            users,
+            Identities:       users,
+            JWTSecret:        jwtSecret,
+            TokenTTL:         time.Duration(cfg.Auth.TokenTTL),
+            UnverifiedPolicy: cfg.Auth.UnverifiedPolicy,
+            SecureCookie:     strings.HasPrefix(cfg.Server.PublicURL, "https://"),
+        }
+        slog.Info("OIDC login enabled", "issuer", cfg.OIDC.Issuer)
     }
 
-    if err := dockerManager.StartInitialContainers(ctx, INITIAL_CONTAINER_COUNT); err != nil {
-        log.Fatalf("Failed to start initial containers: %v", err)
+    execHandler := &handler.ExecHandler{
+        Sandboxes:        sandboxManager,
+        AcquireTimeout:   time.Duration(cfg.Exec.AcquireTimeout),
+        ExecutionTimeout: time.Duration(cfg.Exec.ExecutionTimeout),
+        InstallTimeout:   time.Duration(cfg.Exec.InstallTimeout),
+        PremiumUsers:     make(map[uuid.UUID]bool),
+    }
+    for _, id := range cfg.Queue.PremiumUsers {
+        execHandler.PremiumUsers[uuid.MustParse(id)] = true
     }
+    var historyHandler *handler.HistoryHandler
+    if cfg.History.Enabled {
+        executions := store.NewPostgresExecutionStore(pool)
+        execHandler.History = executions
+        execHandler.StoreCode = cfg.History.StoreCode
+        historyHandler = &handler.HistoryHandler{Executions: executions, Exec: execHandler}
 
-    defer dockerManager.CleanupContainers()
+        if retention := time.Duration(cfg.History.Retention); retention > 0 {
+            pruneCtx, stopPruning := context.WithCancel(context.Background())
+            defer stopPruning()
+            go pruneHistory(pruneCtx, executions, retention)
+        }
+    }
+
+    lspHandler := &handler.LSPHandler{
+        Exec:        execHandler,
+        Sessions:    lsp.NewSessions(cfg.LSP.MaxSessionsPerUser),
+        IdleTimeout: time.Duration(cfg.LSP.IdleTimeout),
+    }
 
-    mux := router.Setup(dockerManager)
+    replHandler := &handler.REPLHandler{
+        Exec:        execHandler,
+        Sessions:    repl.NewSessions(cfg.REPL.MaxSessionsPerUser, time.Duration(cfg.REPL.IdleTimeout), time.Duration(cfg.REPL.MaxLifetime)),
+        CellTimeout: time.Duration(cfg.REPL.CellTimeout),
+    }
+    notebookHandler := &handler.NotebookHandler{
+        Exec:        execHandler,
+        Jobs:        notebook.NewJobs(cfg.Notebooks.MaxJobsPerUser, time.Duration(cfg.Notebooks.JobRetention)),
+        CellTimeout: time.Duration(cfg.Notebooks.CellTimeout),
+        MaxRuntime:  time.Duration(cfg.Notebooks.MaxRuntime),
+    }
+    sweepCtx, stopSweeping := context.WithCancel(context.Background())
+    defer stopSweeping()
+    go replHandler.Sessions.Sweep(sweepCtx)
+    go notebookHandler.Jobs.Sweep(sweepCtx)
+
+    // reload re-reads the configuration from the same flags, environment
+    // and file as at startup and applies its languages. Other settings only
+    // take effect on restart.
+    reload := func(ctx context.Context) (sandbox.ReloadResult, error) {
+        newCfg, _, err := config.Load(os.Args[1:])
+        if err != nil {
+            return sandbox.ReloadResult{}, fmt.Errorf("%w: %v", handler.ErrReloadConfig, err)
+        }
+        return sandboxManager.Reload(ctx, newCfg.ResolvedLanguages())
+    }
+    var adminHandler *handler.AdminHandler
+    if cfg.Server.AdminToken != "" {
+        adminHandler = &handler.AdminHandler{Sandboxes: sandboxManager, Reload: reload}
+    }
+
+    mux := router.Setup(router.Deps{
+        Auth: &handler.AuthHandler{
+            Users:            users,
+            Tokens:           store.NewPostgresTokenStore(pool),
+            Signer:           auth.NewActionSigner(jwtSecret),
+            Mailer:           newMailer(cfg.Mail),
+            JWTSecret:        jwtSecret,
+            TokenTTL:         time.Duration(cfg.Auth.TokenTTL),
+            PublicURL:        strings.TrimSuffix(cfg.Server.PublicURL, "/"),
+            VerificationTTL:  time.Duration(cfg.Auth.VerificationTTL),
+            PasswordResetTTL: time.Duration(cfg.Auth.PasswordResetTTL),
+            UnverifiedPolicy: cfg.Auth.UnverifiedPolicy,
+        },
+        OIDC: oidcHandler,
+        APIKeys: &handler.APIKeyHandler{Keys: apiKeys},
+        Authenticator: &handler.Authenticator{
+            JWTSecret: jwtSecret,
+            Users:     users,
+            Keys:      apiKeys,
+        },
+        ExecRequiresVerified: cfg.Auth.UnverifiedPolicy == config.UnverifiedRestrictExec,
+        Exec:    execHandler,
+        LSP:     lspHandler,
+        REPL:    replHandler,
+        Notebooks: notebookHandler,
+        History: historyHandler,
+        Admin:      adminHandler,
+        AdminToken: cfg.Server.AdminToken,
+        HealthCheck: func(ctx context.Context) error {
+            // Report unavailable while draining so load balancers stop
+            // routing new work here.
+            if sandboxManager.Draining() {
+                return sandbox.ErrShuttingDown
+            }
+            return pool.Ping(ctx)
+        },
+    })
 
     server := &http.Server{
-        Addr:    SERVER_ADDR,
-        Handler: mux,
+        Addr:     cfg.Server.Addr,
+        Handler:  mux,
+        ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
     }
 
     sigs := make(chan os.Signal, 1)
     signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+    hups := make(chan os.Signal, 1)
+    signal.Notify(hups, syscall.SIGHUP)
+    go func() {
+        for range hups {
+            slog.Info("Received SIGHUP, reloading language configuration")
+            result, err := reload(context.Background())
+            if err != nil {
+                slog.Error("Language reload failed", "error", err)
+                continue
+            }
+            slog.Info("Language reload finished",
+                "added", result.Added, "removed", result.Removed, "updated", result.Updated,
+                "resized", result.Resized, "failed", result.Failed)
+        }
+    }()
+
     go func() {
-        log.Printf("Server starting on %s", SERVER_ADDR)
+        slog.Info("Server starting", "addr", cfg.Server.Addr)
         if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-            log.Fatalf("Server ListenAndServe error: %v", err)
+            fatal("Server ListenAndServe error", err)
         }
     }()
 
     sig := <-sigs
-    log.Printf("Received signal: %s. Shutting down gracefully...", sig)
+    slog.Info("Received signal, shutting down gracefully", "signal", sig.String())
+    signal.Stop(hups)
+
+    // Stop starting executions and give running ones until the drain
+    // deadline; a second signal aborts them at once. The server keeps
+    // answering meanwhile, so new /exec requests get a clear 503 rather
+    // than a refused connection.
+    drainTimeout := time.Duration(cfg.Exec.DrainTimeout)
+    drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+    go func() {
+        select {
+        case sig := <-sigs:
+            slog.Warn("Received second signal, aborting running executions", "signal", sig.String())
+            cancelDrain()
+        case <-drainCtx.Done():
+        }
+    }()
+    // Language server and REPL sessions hold their containers until the
+    // client leaves, so they would never drain on their own.
+    if n := lspHandler.Sessions.CloseAll(); n > 0 {
+        slog.Info("Closed language server sessions", "sessions", n)
+    }
+    if n := replHandler.Sessions.CloseAll(); n > 0 {
+        slog.Info("Closed REPL sessions", "sessions", n)
+    }
+    // Background notebook runs are kept in memory only, so their results
+    // would be lost with the process anyway.
+    if n := notebookHandler.Jobs.CancelAll(); n > 0 {
+        slog.Info("Cancelled notebook jobs", "jobs", n)
+    }
+    aborted, err := sandboxManager.Drain(drainCtx)
+    cancelDrain()
+    if err != nil {
+        slog.Error("Executions did not drain cleanly", "aborted", aborted, "error", err)
+    } else if aborted > 0 {
+        slog.Warn("Aborted executions still running at the drain deadline", "aborted", aborted, "drain_timeout", drainTimeout)
+    }
 
-    shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVER_SHUTDOWN_TIMEOUT)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
     defer cancel()
 
     if err := server.Shutdown(shutdownCtx); err != nil {
-        log.Printf("Server forced to shutdown: %v", err)
+        slog.Error("Server forced to shutdown", "error", err)
     } else {
-        log.Println("Server exited gracefully")
+        slog.Info("Server exited gracefully")
     }
 
-    log.Println("Application shutdown complete.")
+    // Deferred teardown now runs in reverse order of setup: the history
+    // pruner, the sandboxes and their backend, the database pool and
+    // finally the trace exporter.
+    slog.Info("Application shutdown complete")
 }
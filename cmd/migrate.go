@@ -0,0 +1,82 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "strconv"
+    "text/tabwriter"
+    "time"
+
+    "github.com/Aadithya-J/alcaIDE/internal/config"
+    "github.com/Aadithya-J/alcaIDE/internal/db"
+)
+
+func migrate(cfg config.Config, args []string) error {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    ctx := context.Background()
+    pool, err := db.Connect(ctx, cfg.Database)
+    if err != nil {
+        return err
+    }
+    defer pool.Close()
+
+    migrator, err := db.NewMigrator(pool)
+    if err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "up":
+        applied, err := migrator.Up(ctx)
+        if err != nil {
+            return err
+        }
+        if len(applied) == 0 {
+            fmt.Println("No pending migrations.")
+        }
+        for _, m := range applied {
+            fmt.Printf("Applied %d_%s\n", m.Version, m.Name)
+        }
+    case "down":
+        steps := 1
+        if len(args) > 1 {
+            steps, err = strconv.Atoi(args[1])
+            if err != nil {
+                return fmt.Errorf("invalid step count %q", args[1])
+            }
+        }
+        reverted, err := migrator.Down(ctx, steps)
+        if err != nil {
+            return err
+        }
+        if len(reverted) == 0 {
+            fmt.Println("No applied migrations to revert.")
+        }
+        for _, m := range reverted {
+            fmt.Printf("Reverted %d_%s\n", m.Version, m.Name)
+        }
+    case "status":
+        statuses, err := migrator.Status(ctx)
+        if err != nil {
+            return err
+        }
+        tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+        for _, s := range statuses {
+            appliedAt := "pending"
+            if s.AppliedAt != nil {
+                appliedAt = s.AppliedAt.Format(time.RFC3339)
+            }
+            fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+        }
+        return tw.Flush()
+    default:
+        return fmt.Errorf("unknown migrate command %q", args[0])
+    }
+    return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+
+    "github.com/Aadithya-J/alcaIDE/internal/config"
+    "github.com/Aadithya-J/alcaIDE/internal/docker"
+    "github.com/Aadithya-J/alcaIDE/internal/handler"
+    "github.com/Aadithya-J/alcaIDE/internal/kube"
+    "github.com/Aadithya-J/alcaIDE/internal/sandbox"
+    "k8s.io/client-go/kubernetes"
+)
+
+// sandboxes is what serve needs from a sandbox backend.
+type sandboxes interface {
+    handler.Sandboxes
+    handler.SandboxAdmin
+    Reload(ctx context.Context, languages map[string]config.LanguageConfig) (sandbox.ReloadResult, error)
+    Draining() bool
+    Drain(ctx context.Context) (aborted int, err error)
+}
+
+// startSandboxes creates the configured backend and fills its pools. The
+// returned function removes every sandbox and releases the backend.
+func startSandboxes(cfg config.Config) (sandboxes, func()) {
+    if cfg.Exec.Backend == config.BackendKubernetes {
+        return startKubernetes(cfg)
+    }
+    return startDocker(cfg)
+}
+
+// dockerHosts converts the configured Docker hosts for the manager.
+func dockerHosts(cfg config.Config) []docker.Host {
+    hosts := make([]docker.Host, 0, len(cfg.Docker.Hosts))
+    for _, h := range cfg.Docker.Hosts {
+        hosts = append(hosts, docker.Host{
+            Name:          h.Name,
+            Address:       h.Address,
+            TLSCACert:     h.TLSCACert,
+            TLSCert:       h.TLSCert,
+            TLSKey:        h.TLSKey,
+            MaxContainers: h.MaxContainers,
+        })
+    }
+    return hosts
+}
+
+func startDocker(cfg config.Config) (sandboxes, func()) {
+    dockerManager, err := docker.NewManager(cfg.ResolvedLanguages(), docker.Options{
+        User:                cfg.Exec.User,
+        PackageCache:        cfg.Exec.PackageCache,
+        RuntimeFallback:     cfg.Exec.RuntimeFallback,
+        QueueMaxPerUser:     cfg.Queue.MaxPerUser,
+        QueueMaxPerLanguage: cfg.Queue.MaxPerLanguage,
+        Hosts:               dockerHosts(cfg),
+    })
+    if err != nil {
+        fatal("Failed to create Docker manager", err)
+    }
+
+    ctx := context.Background()
+    if err := dockerManager.CheckHosts(ctx); err != nil {
+        fatal("Failed to reach Docker", err)
+    }
+    if err := dockerManager.CheckRuntimes(ctx); err != nil {
+        fatal("Failed to check sandbox runtimes", err)
+    }
+    if err := dockerManager.CheckSecurityProfiles(ctx); err != nil {
+        fatal("Failed to load sandbox security profiles", err)
+    }
+    if err := dockerManager.PullImages(ctx); err != nil {
+        slog.Error("Some language images are unavailable; those languages are disabled", "error", err)
+    }
+
+    if err := dockerManager.StartInitialContainers(ctx); err != nil {
+        fatal("Failed to start initial containers", err)
+    }
+
+    monitorCtx, stopMonitoring := context.WithCancel(context.Background())
+    go dockerManager.MonitorHosts(monitorCtx, time.Duration(cfg.Docker.HealthInterval), cfg.Docker.UnhealthyAfter)
+
+    return dockerManager, func() {
+        stopMonitoring()
+        dockerManager.CleanupContainers()
+        dockerManager.Close()
+    }
+}
+
+func startKubernetes(cfg config.Config) (sandboxes, func()) {
+    restConfig, err := kube.RESTConfig(cfg.Kubernetes.Kubeconfig)
+    if err != nil {
+        fatal("Failed to load Kubernetes client configuration", err)
+    }
+    client, err := kubernetes.NewForConfig(restConfig)
+    if err != nil {
+        fatal("Failed to create Kubernetes client", err)
+    }
+    podManager, err := kube.NewManager(client, restConfig, cfg.ResolvedLanguages(), kube.Options{
+        Namespace:             cfg.Kubernetes.Namespace,
+        Instance:              cfg.Kubernetes.Instance,
+        User:                  cfg.Exec.User,
+        CPURequest:            cfg.Kubernetes.CPURequest,
+        CPULimit:              cfg.Kubernetes.CPULimit,
+        MemoryRequest:         cfg.Kubernetes.MemoryRequest,
+        MemoryLimit:           cfg.Kubernetes.MemoryLimit,
+        EphemeralStorageLimit: cfg.Kubernetes.EphemeralStorageLimit,
+        AllowEgress:           cfg.Kubernetes.AllowEgress,
+        SeccompProfile:        cfg.Kubernetes.SeccompProfile,
+        RuntimeFallback:       cfg.Exec.RuntimeFallback,
+        PodStartTimeout:       time.Duration(cfg.Kubernetes.PodStartTimeout),
+        QueueMaxPerUser:       cfg.Queue.MaxPerUser,
+        QueueMaxPerLanguage:   cfg.Queue.MaxPerLanguage,
+    })
+    if err != nil {
+        fatal("Failed to create Kubernetes pod manager", err)
+    }
+
+    ctx := context.Background()
+    if err := podManager.EnsureNetworkPolicy(ctx); err != nil {
+        fatal("Failed to isolate sandbox pods", err)
+    }
+    if err := podManager.CheckRuntimes(ctx); err != nil {
+        fatal("Failed to check sandbox runtime classes", err)
+    }
+    // Nothing is tracked yet, so this deletes pods a previous run of this
+    // instance left behind.
+    if err := podManager.Reconcile(ctx); err != nil {
+        fatal("Failed to clean up old sandbox pods", err)
+    }
+    if err := podManager.StartInitialContainers(ctx); err != nil {
+        fatal("Failed to start initial pods", err)
+    }
+
+    monitorCtx, stopMonitoring := context.WithCancel(context.Background())
+    go podManager.MonitorPods(monitorCtx, time.Duration(cfg.Kubernetes.ReconcileInterval))
+
+    return podManager, func() {
+        stopMonitoring()
+        podManager.CleanupContainers()
+    }
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+// APIKeyPrefix starts every personal API key so keys are easy to recognise
+// in an Authorization header and for secret scanners.
+const APIKeyPrefix = "alk_"
+
+// apiKeyDisplayLength is how much of a key is kept in clear for display.
+const apiKeyDisplayLength = len(APIKeyPrefix) + 8
+
+// Scopes an API key can be limited to. Session tokens carry every scope.
+const (
+	ScopeExec = "exec"
+	// ScopeHistory allows reading execution history.
+	ScopeHistory = "history"
+)
+
+// Scopes lists every scope an API key may be granted.
+var Scopes = []string{ScopeExec, ScopeHistory}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// NewAPIKey generates a key. The key itself is shown to the user once; only
+// its hash and display prefix are stored.
+func NewAPIKey() (key, prefix string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", nil, err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:apiKeyDisplayLength], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the digest a key is stored and looked up by. Keys carry
+// 256 bits of entropy, so a fast unsalted hash is sufficient.
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
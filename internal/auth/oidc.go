@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrLoginState is returned when the callback from the identity provider
+// does not belong to a login started by this server.
+var ErrLoginState = errors.New("invalid login state")
+
+// oidcLoginTTL bounds how long a user may take at the identity provider.
+const oidcLoginTTL = 10 * time.Minute
+
+type OIDCOptions struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// HTTPClient is used for discovery, key fetches and the code exchange.
+	// nil means http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// OIDCProvider runs the authorization-code flow with PKCE against an
+// OpenID Connect identity provider. The state, nonce and code verifier of a
+// login in progress are not stored on the server; Begin seals them into a
+// value the caller keeps in a cookie and hands back to Complete.
+type OIDCProvider struct {
+	oauth    oauth2.Config
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	client   *http.Client
+	key      []byte
+}
+
+// OIDCIdentity is what the identity provider asserts about the user.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// NewOIDCProvider fetches the issuer's discovery document. secret keys the
+// MAC over sealed login state.
+func NewOIDCProvider(ctx context.Context, opts OIDCOptions, secret []byte) (*OIDCProvider, error) {
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), opts.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering OIDC issuer %s: %w", opts.Issuer, err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("alcaide oidc state v1"))
+
+	return &OIDCProvider{
+		oauth: oauth2.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			RedirectURL:  opts.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       opts.Scopes,
+		},
+		provider: provider,
+		// Key fetches must not inherit ctx's deadline: they happen lazily,
+		// long after startup.
+		verifier: provider.VerifierContext(oidc.ClientContext(context.Background(), client),
+			&oidc.Config{ClientID: opts.ClientID}),
+		client: client,
+		key:    mac.Sum(nil),
+	}, nil
+}
+
+type loginState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"exp"`
+}
+
+// Begin starts a login. It returns the URL to send the user to and the
+// sealed state to keep until the provider redirects back.
+func (p *OIDCProvider) Begin() (authURL, sealed string, err error) {
+	login := loginState{
+		Verifier: oauth2.GenerateVerifier(),
+		Expires:  time.Now().Add(oidcLoginTTL).Unix(),
+	}
+	if login.State, err = randomString(); err != nil {
+		return "", "", err
+	}
+	if login.Nonce, err = randomString(); err != nil {
+		return "", "", err
+	}
+
+	payload, err := json.Marshal(login)
+	if err != nil {
+		return "", "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	sealed = encoded + "." + base64.RawURLEncoding.EncodeToString(p.mac(encoded))
+
+	authURL = p.oauth.AuthCodeURL(login.State,
+		oauth2.S256ChallengeOption(login.Verifier),
+		oidc.Nonce(login.Nonce),
+	)
+	return authURL, sealed, nil
+}
+
+// LoginTTL is how long a login started with Begin stays valid.
+func (p *OIDCProvider) LoginTTL() time.Duration {
+	return oidcLoginTTL
+}
+
+// Complete finishes a login: it checks state against the sealed value from
+// Begin, redeems code for tokens and verifies the ID token.
+func (p *OIDCProvider) Complete(ctx context.Context, sealed, state, code string) (OIDCIdentity, error) {
+	login, err := p.open(sealed, time.Now())
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	if !hmac.Equal([]byte(state), []byte(login.State)) {
+		return OIDCIdentity{}, fmt.Errorf("%w: state mismatch", ErrLoginState)
+	}
+
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return OIDCIdentity{}, errors.New("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(login.Nonce)) {
+		return OIDCIdentity{}, errors.New("ID token nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("decoding ID token claims: %w", err)
+	}
+	identity := OIDCIdentity{
+		Issuer:            idToken.Issuer,
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	}
+
+	// Some providers leave the email out of the ID token and only serve it
+	// from the userinfo endpoint.
+	if identity.Email == "" && p.provider.UserInfoEndpoint() != "" {
+		info, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return OIDCIdentity{}, fmt.Errorf("fetching userinfo: %w", err)
+		}
+		if info.Subject == identity.Subject {
+			identity.Email = info.Email
+			identity.EmailVerified = info.EmailVerified
+		}
+	}
+	return identity, nil
+}
+
+func (p *OIDCProvider) open(sealed string, now time.Time) (loginState, error) {
+	encoded, sig, ok := strings.Cut(sealed, ".")
+	if !ok {
+		return loginState{}, fmt.Errorf("%w: malformed", ErrLoginState)
+	}
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, p.mac(encoded)) {
+		return loginState{}, fmt.Errorf("%w: bad signature", ErrLoginState)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return loginState{}, fmt.Errorf("%w: malformed payload", ErrLoginState)
+	}
+	var login loginState
+	if err := json.Unmarshal(raw, &login); err != nil {
+		return loginState{}, fmt.Errorf("%w: malformed payload", ErrLoginState)
+	}
+	if now.Unix() >= login.Expires {
+		return loginState{}, fmt.Errorf("%w: expired", ErrLoginState)
+	}
+	return login, nil
+}
+
+func (p *OIDCProvider) mac(data string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Principal identifies the authenticated caller of a request.
+type Principal struct {
+	UserID   uuid.UUID
+	Username string
+	Email    string
+	// APIKeyID is set when the caller authenticated with an API key, which
+	// limits it to Scopes.
+	APIKeyID uuid.UUID
+	Scopes   []string
+	// IssuedAt is when the caller's session token was issued.
+	IssuedAt time.Time
+}
+
+// ViaAPIKey reports whether the caller authenticated with an API key.
+func (p Principal) ViaAPIKey() bool {
+	return p.APIKeyID != uuid.Nil
+}
+
+// Allows reports whether the caller may use scope. Session tokens allow
+// everything.
+func (p Principal) Allows(scope string) bool {
+	return !p.ViaAPIKey() || slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the caller attached by the authentication middleware.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// NewSessionToken issues the JWT returned from login and registration.
+func NewSessionToken(user model.User, secret []byte, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id":       user.ID,
+		"user_email":    user.Email,
+		"user_username": user.Username,
+		// Milliseconds, so a login just after a password change is not
+		// mistaken for a session from before it.
+		"iat": float64(now.UnixMilli()) / 1000,
+		"exp": now.Add(ttl).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(secret)
+}
+
+// ParseSessionToken verifies a JWT issued by NewSessionToken.
+func ParseSessionToken(token string, secret []byte) (Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	rawID, _ := claims["user_id"].(string)
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: bad user_id claim", ErrInvalidToken)
+	}
+	username, _ := claims["user_username"].(string)
+	email, _ := claims["user_email"].(string)
+	// Tokens from before iat was added have none and are treated as issued
+	// at the zero time.
+	var issuedAt time.Time
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = time.UnixMilli(int64(math.Round(iat * 1000)))
+	}
+	return Principal{UserID: id, Username: username, Email: email, IssuedAt: issuedAt}, nil
+}
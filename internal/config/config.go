@@ -1,28 +1,1073 @@
 package config
 
 import (
-    "log"
-    "os"
-    
-    "github.com/joho/godotenv"
-)
-
-func LoadEnv() {
-    err := godotenv.Load()
-    if err != nil {
-        log.Fatal("Error loading .env file")
-    }
-    dbURL := os.Getenv("DATABASE_URL")
-    if dbURL == "" {
-        log.Fatal("DATABASE_URL not set in .env file")
-    }
-
-    jwtSecret := os.Getenv("JWT_SECRET")
-    if jwtSecret == "" {
-        log.Fatal("JWT_SECRET not set in .env file")
-    }
-}
-
-func GetEnv(key string) string {
-    return os.Getenv(key)
-}
\ No newline at end of file
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Config is the complete server configuration. It is assembled by Load from
+// defaults, an optional JSON file, environment variables and flags.
+type Config struct {
+	Server     ServerConfig              `json:"server"`
+	Database   DatabaseConfig            `json:"database"`
+	Auth       AuthConfig                `json:"auth"`
+	OIDC       OIDCConfig                `json:"oidc"`
+	Mail       MailConfig                `json:"mail"`
+	Log        LogConfig                 `json:"log"`
+	Tracing    TracingConfig             `json:"tracing"`
+	Docker     DockerConfig              `json:"docker"`
+	Kubernetes KubernetesConfig          `json:"kubernetes"`
+	Exec       ExecConfig                `json:"exec"`
+	Queue      QueueConfig               `json:"queue"`
+	History    HistoryConfig             `json:"history"`
+	LSP        LSPConfig                 `json:"lsp"`
+	REPL       REPLConfig                `json:"repl"`
+	Notebooks  NotebookConfig            `json:"notebooks"`
+	Images     ImagesConfig              `json:"images"`
+	Languages  map[string]LanguageConfig `json:"languages"`
+}
+
+type ServerConfig struct {
+	Addr            string   `json:"addr"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// PublicURL is the externally reachable base URL, used to build links
+	// in outgoing email.
+	PublicURL string `json:"public_url"`
+	// AdminToken authorizes the /admin endpoints; they are disabled while
+	// it is empty.
+	AdminToken string `json:"admin_token"`
+}
+
+type DatabaseConfig struct {
+	URL               string   `json:"url"`
+	MaxConns          int32    `json:"max_conns"`
+	MinConns          int32    `json:"min_conns"`
+	MaxConnLifetime   Duration `json:"max_conn_lifetime"`
+	MaxConnIdleTime   Duration `json:"max_conn_idle_time"`
+	HealthCheckPeriod Duration `json:"health_check_period"`
+	AutoMigrate       bool     `json:"auto_migrate"`
+}
+
+// Policies applied to accounts whose email address is not yet verified.
+const (
+	UnverifiedAllow        = "allow"
+	UnverifiedRestrictExec = "restrict_exec"
+	UnverifiedBlockLogin   = "block_login"
+)
+
+type AuthConfig struct {
+	JWTSecret        string   `json:"jwt_secret"`
+	TokenTTL         Duration `json:"token_ttl"`
+	VerificationTTL  Duration `json:"verification_ttl"`
+	PasswordResetTTL Duration `json:"password_reset_ttl"`
+	// UnverifiedPolicy is one of allow, restrict_exec (only verified users
+	// may run code) or block_login (unverified users cannot log in).
+	UnverifiedPolicy string `json:"unverified_policy"`
+}
+
+// OIDCConfig enables single sign-on through an OpenID Connect provider. It
+// is disabled while Issuer is empty.
+type OIDCConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL defaults to the callback route under server.public_url.
+	RedirectURL string   `json:"redirect_url"`
+	Scopes      []string `json:"scopes"`
+}
+
+// Enabled reports whether an identity provider is configured.
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// OIDCCallbackPath is the route the identity provider redirects back to.
+const OIDCCallbackPath = "/auth/oidc/callback"
+
+// OIDCRedirectURL returns the configured redirect URL, or the callback route
+// under the public URL if none is set.
+func (c *Config) OIDCRedirectURL() string {
+	if c.OIDC.RedirectURL != "" {
+		return c.OIDC.RedirectURL
+	}
+	return strings.TrimSuffix(c.Server.PublicURL, "/") + OIDCCallbackPath
+}
+
+type MailConfig struct {
+	// Driver is log, file or smtp.
+	Driver       string `json:"driver"`
+	From         string `json:"from"`
+	Dir          string `json:"dir"`
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+}
+
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+type TracingConfig struct {
+	Exporter    string `json:"exporter"`
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"service_name"`
+}
+
+// DockerConfig lists the Docker daemons sandboxes are placed on. With no
+// hosts, the daemon named by the DOCKER_HOST environment is used alone.
+type DockerConfig struct {
+	Hosts []DockerHostConfig `json:"hosts"`
+	// HealthInterval is how often every host is pinged.
+	HealthInterval Duration `json:"health_interval"`
+	// UnhealthyAfter is how many pings in a row must fail before a host is
+	// marked unhealthy and its containers are replaced elsewhere.
+	UnhealthyAfter int `json:"unhealthy_after"`
+}
+
+type DockerHostConfig struct {
+	// Name identifies the host in logs, metrics and the admin API.
+	Name string `json:"name"`
+	// Address is a Docker host URL, such as unix:///var/run/docker.sock or
+	// tcp://10.0.0.5:2376.
+	Address string `json:"address"`
+	// TLSCACert, TLSCert and TLSKey are paths of PEM files used to connect
+	// over TCP with mutual TLS; set all three or none.
+	TLSCACert string `json:"tls_ca_cert"`
+	TLSCert   string `json:"tls_cert"`
+	TLSKey    string `json:"tls_key"`
+	// MaxContainers is the most sandbox containers placed on the host; 0
+	// means no limit.
+	MaxContainers int `json:"max_containers"`
+}
+
+// KubernetesConfig configures the kubernetes backend, which keeps each
+// language's warm sandboxes as pods and runs code through pods/exec.
+type KubernetesConfig struct {
+	// Kubeconfig is the path of a kubeconfig file; empty uses the
+	// in-cluster service account.
+	Kubeconfig string `json:"kubeconfig"`
+	// Namespace holds the sandbox pods and their network policy.
+	Namespace string `json:"namespace"`
+	// Instance labels the pods this server owns, so a restarted server
+	// finds and removes the ones it left behind. It defaults to the host
+	// name, which is the pod name when the server runs on Kubernetes.
+	Instance string `json:"instance"`
+	// CPU and memory requests and limits of every sandbox pod, as
+	// Kubernetes quantities such as "500m" or "256Mi".
+	CPURequest    string `json:"cpu_request"`
+	CPULimit      string `json:"cpu_limit"`
+	MemoryRequest string `json:"memory_request"`
+	MemoryLimit   string `json:"memory_limit"`
+	// EphemeralStorageLimit bounds what a sandbox may write, /tmp included.
+	EphemeralStorageLimit string `json:"ephemeral_storage_limit"`
+	// AllowEgress lets sandboxes open outbound connections. Otherwise
+	// their network policy denies all traffic in both directions.
+	AllowEgress bool `json:"allow_egress"`
+	// SeccompProfile is a Localhost profile path relative to the kubelet's
+	// seccomp directory; empty uses the runtime's default profile.
+	SeccompProfile string `json:"seccomp_profile"`
+	// PodStartTimeout bounds how long a new pod may take to start running.
+	PodStartTimeout Duration `json:"pod_start_timeout"`
+	// ReconcileInterval is how often pods are compared with the cluster,
+	// replacing ones that died and deleting ones no pool knows.
+	ReconcileInterval Duration `json:"reconcile_interval"`
+}
+
+// QueueConfig bounds the executions waiting for a container when a
+// language's pool is exhausted. Waiters are served by priority class, then
+// round-robin across users.
+type QueueConfig struct {
+	// MaxPerUser is how many executions one caller may have waiting per
+	// language; further ones are rejected at once.
+	MaxPerUser int `json:"max_per_user"`
+	// MaxPerLanguage is how many executions may wait for one language in
+	// total.
+	MaxPerLanguage int `json:"max_per_language"`
+	// PremiumUsers are user IDs, such as those of paying customers, whose
+	// executions are served ahead of others of the same kind.
+	PremiumUsers []string `json:"premium_users"`
+}
+
+type ExecConfig struct {
+	// Backend runs sandboxes as Docker containers (docker) or as pods
+	// (kubernetes).
+	Backend          string   `json:"backend"`
+	AcquireTimeout   Duration `json:"acquire_timeout"`
+	ExecutionTimeout Duration `json:"execution_timeout"`
+	// DrainTimeout is how long running executions may continue after a
+	// shutdown signal before they are aborted.
+	DrainTimeout Duration `json:"drain_timeout"`
+	// PoolSize is the number of warm containers per language unless the
+	// language sets its own.
+	PoolSize int `json:"pool_size"`
+	// User runs submitted code, as "uid[:gid]" or a name known to the
+	// image. Dependency installs always run as root, so code run as
+	// another user cannot tamper with the shared package cache.
+	User string `json:"user"`
+	// InstallTimeout bounds installing a run's declared dependencies.
+	InstallTimeout Duration `json:"install_timeout"`
+	// PackageCache mounts a Docker volume per language as the package
+	// manager's download cache, shared by all of its containers.
+	PackageCache bool `json:"package_cache"`
+	// Runtime is the OCI runtime sandbox containers run under, as
+	// registered with the Docker daemon (runc, runsc, kata, ...). Empty
+	// uses the daemon's default. Languages may override it.
+	Runtime string `json:"runtime"`
+	// RuntimeFallback decides what happens when a configured runtime is not
+	// registered with the daemon: fail refuses to start the language,
+	// default runs it under the daemon's default runtime instead.
+	RuntimeFallback string `json:"runtime_fallback"`
+	// SeccompProfile is the path of a seccomp profile in Docker's JSON
+	// format applied to sandbox containers. Empty uses Docker's default
+	// profile. Languages may override it.
+	SeccompProfile string `json:"seccomp_profile"`
+	// AppArmorProfile names an AppArmor profile already loaded on the
+	// Docker host. Empty uses Docker's default. Languages may override it.
+	AppArmorProfile string `json:"apparmor_profile"`
+}
+
+// Sandbox backends.
+const (
+	BackendDocker     = "docker"
+	BackendKubernetes = "kubernetes"
+)
+
+// Runtime fallback policies.
+const (
+	RuntimeFallbackFail    = "fail"
+	RuntimeFallbackDefault = "default"
+)
+
+// LSPConfig governs language server sessions. Each connected session holds
+// a container of its language's pool for as long as it lasts.
+type LSPConfig struct {
+	// IdleTimeout closes a session whose client has sent nothing for this
+	// long.
+	IdleTimeout Duration `json:"idle_timeout"`
+	// MaxSessionsPerUser is how many sessions one user may have open.
+	MaxSessionsPerUser int `json:"max_sessions_per_user"`
+}
+
+// REPLConfig governs stateful REPL sessions. Each session holds a
+// container of its language's pool for as long as it lasts.
+type REPLConfig struct {
+	// IdleTimeout ends a session that has run no cell for this long.
+	IdleTimeout Duration `json:"idle_timeout"`
+	// MaxLifetime ends a session this long after it was created, even if
+	// it is in use.
+	MaxLifetime Duration `json:"max_lifetime"`
+	// CellTimeout interrupts a cell that runs for longer.
+	CellTimeout Duration `json:"cell_timeout"`
+	// MaxSessionsPerUser is how many sessions one user may have open.
+	MaxSessionsPerUser int `json:"max_sessions_per_user"`
+}
+
+// NotebookConfig governs running Jupyter notebooks. Notebooks run in the
+// REPL kernel of their language, in a container of its pool held for the
+// whole run.
+type NotebookConfig struct {
+	// CellTimeout is the default limit on one cell; requests may ask for
+	// less or more, up to MaxRuntime.
+	CellTimeout Duration `json:"cell_timeout"`
+	// MaxRuntime bounds a whole run.
+	MaxRuntime Duration `json:"max_runtime"`
+	// MaxJobsPerUser is how many background runs one user may have
+	// unfinished.
+	MaxJobsPerUser int `json:"max_jobs_per_user"`
+	// JobRetention is how long a finished background run's result is
+	// kept.
+	JobRetention Duration `json:"job_retention"`
+}
+
+type HistoryConfig struct {
+	// Enabled records every authenticated execution.
+	Enabled bool `json:"enabled"`
+	// StoreCode keeps submitted source and stdin. Without it only a hash of
+	// the source is kept and past runs cannot be re-run.
+	StoreCode bool `json:"store_code"`
+	// Retention is how long records are kept; zero keeps them forever.
+	Retention Duration `json:"retention"`
+}
+
+type ImagesConfig struct {
+	// Dir holds the build contexts of languages built from a Dockerfile.
+	Dir string `json:"dir"`
+	// Repository names built images; they are tagged
+	// <repository>/<language>:<content hash>.
+	Repository string `json:"repository"`
+	// Labels are added to every built image.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BuildConfig builds a language's image from a Dockerfile instead of
+// pulling one.
+type BuildConfig struct {
+	// Context is the build context directory, relative to images.dir. It
+	// defaults to the language name.
+	Context string `json:"context,omitempty"`
+	// Dockerfile is relative to the context; it defaults to "Dockerfile".
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Args       map[string]string `json:"args,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+
+	// Repository is filled in by ResolvedLanguages.
+	Repository string `json:"-"`
+}
+
+type LanguageConfig struct {
+	// Image is pulled from a registry. Pin it by digest with
+	// "name@sha256:..." or "name:tag@sha256:...".
+	Image string `json:"image,omitempty"`
+	// Build, when set, builds the image instead; Image must then be empty.
+	Build    *BuildConfig `json:"build,omitempty"`
+	PoolSize int          `json:"pool_size,omitempty"`
+	// Command is the argv the submitted code is appended to, such as
+	// ["python", "-c"]. It may be omitted for the built-in languages.
+	Command []string `json:"command,omitempty"`
+	// Installer installs dependencies declared by a run: pip, npm, or none.
+	// It defaults to pip for python and npm for javascript.
+	Installer string `json:"installer,omitempty"`
+	// Formatter formats code for /format: black, prettier, gofmt,
+	// clang-format, rustfmt or none. It defaults by language name, and the
+	// image must include it.
+	Formatter string `json:"formatter,omitempty"`
+	// Linter checks code for /diagnostics without running it: ruff,
+	// pyflakes, eslint, govet, tsc or none. It defaults by language name,
+	// and the image must include it. LinterArgs are appended to its
+	// command line, for example to select rules.
+	Linter     string   `json:"linter,omitempty"`
+	LinterArgs []string `json:"linter_args,omitempty"`
+	// LanguageServer is the argv of a language server speaking LSP over
+	// stdio, for /lsp sessions. It defaults by language name; set it to
+	// [] to disable sessions.
+	LanguageServer []string `json:"language_server,omitempty"`
+	// Kernel runs cells for /repl sessions in a long-lived interpreter
+	// started with Command: python or none. It defaults by language name.
+	Kernel string `json:"kernel,omitempty"`
+	// PackageIndex is the registry or mirror the installer downloads from;
+	// empty means the installer's default.
+	PackageIndex string `json:"package_index,omitempty"`
+	// Runtime overrides exec.runtime for this language.
+	Runtime string `json:"runtime,omitempty"`
+	// SeccompProfile and AppArmorProfile override the exec settings of the
+	// same name for this language.
+	SeccompProfile  string `json:"seccomp_profile,omitempty"`
+	AppArmorProfile string `json:"apparmor_profile,omitempty"`
+
+	// SecurityOpt holds the Docker security options derived from the
+	// profiles; it is filled in by the Docker manager.
+	SecurityOpt []string `json:"-"`
+}
+
+// Dependency installers.
+const (
+	InstallerNone = "none"
+	InstallerPip  = "pip"
+	InstallerNpm  = "npm"
+)
+
+// Code formatters.
+const (
+	FormatterNone        = "none"
+	FormatterBlack       = "black"
+	FormatterPrettier    = "prettier"
+	FormatterGofmt       = "gofmt"
+	FormatterClangFormat = "clang-format"
+	FormatterRustfmt     = "rustfmt"
+)
+
+// Linters and type checkers.
+const (
+	LinterNone     = "none"
+	LinterRuff     = "ruff"
+	LinterPyflakes = "pyflakes"
+	LinterESLint   = "eslint"
+	LinterGoVet    = "govet"
+	LinterTsc      = "tsc"
+)
+
+// REPL kernels.
+const (
+	KernelNone   = "none"
+	KernelPython = "python"
+)
+
+// builtinCommands are used for languages that do not set a command.
+var builtinCommands = map[string][]string{
+	"python":     {"python", "-c"},
+	"javascript": {"node", "-e"},
+}
+
+// builtinInstallers are used for languages that do not set an installer.
+var builtinInstallers = map[string]string{
+	"python":     InstallerPip,
+	"javascript": InstallerNpm,
+}
+
+// builtinFormatters are used for languages that do not set a formatter.
+var builtinFormatters = map[string]string{
+	"python":     FormatterBlack,
+	"javascript": FormatterPrettier,
+	"typescript": FormatterPrettier,
+	"go":         FormatterGofmt,
+	"c":          FormatterClangFormat,
+	"cpp":        FormatterClangFormat,
+	"rust":       FormatterRustfmt,
+}
+
+// builtinLanguageServers are used for languages that do not set a
+// language server.
+var builtinLanguageServers = map[string][]string{
+	"python":     {"pylsp"},
+	"javascript": {"typescript-language-server", "--stdio"},
+	"typescript": {"typescript-language-server", "--stdio"},
+	"go":         {"gopls"},
+}
+
+// builtinKernels are used for languages that do not set a kernel.
+var builtinKernels = map[string]string{
+	"python": KernelPython,
+}
+
+// builtinLinters are used for languages that do not set a linter.
+var builtinLinters = map[string]string{
+	"python":     LinterRuff,
+	"javascript": LinterESLint,
+	"typescript": LinterTsc,
+	"go":         LinterGoVet,
+}
+
+// ExecCommand returns the command for the language called name, or nil if
+// it has none.
+func (l LanguageConfig) ExecCommand(name string) []string {
+	if len(l.Command) > 0 {
+		return l.Command
+	}
+	return builtinCommands[name]
+}
+
+// Default returns the configuration used when no other source sets a value.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ShutdownTimeout: Duration(5 * time.Second),
+			PublicURL:       "http://localhost:8080",
+		},
+		Auth: AuthConfig{
+			TokenTTL:         Duration(72 * time.Hour),
+			VerificationTTL:  Duration(48 * time.Hour),
+			PasswordResetTTL: Duration(time.Hour),
+			UnverifiedPolicy: UnverifiedAllow,
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Mail: MailConfig{
+			Driver:   "log",
+			From:     "alcaIDE <no-reply@localhost>",
+			SMTPPort: 587,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "alcaide",
+		},
+		Exec: ExecConfig{
+			Backend:          BackendDocker,
+			AcquireTimeout:   Duration(10 * time.Second),
+			ExecutionTimeout: Duration(10 * time.Second),
+			DrainTimeout:     Duration(30 * time.Second),
+			PoolSize:         2,
+			User:             "65534:65534",
+			InstallTimeout:   Duration(2 * time.Minute),
+			PackageCache:     true,
+			RuntimeFallback:  RuntimeFallbackFail,
+		},
+		Docker: DockerConfig{
+			HealthInterval: Duration(10 * time.Second),
+			UnhealthyAfter: 3,
+		},
+		Kubernetes: KubernetesConfig{
+			Namespace:             "alcaide-sandbox",
+			CPURequest:            "100m",
+			CPULimit:              "1",
+			MemoryRequest:         "64Mi",
+			MemoryLimit:           "256Mi",
+			EphemeralStorageLimit: "100Mi",
+			PodStartTimeout:       Duration(2 * time.Minute),
+			ReconcileInterval:     Duration(30 * time.Second),
+		},
+		Queue: QueueConfig{
+			MaxPerUser:     4,
+			MaxPerLanguage: 100,
+		},
+		LSP: LSPConfig{
+			IdleTimeout:        Duration(10 * time.Minute),
+			MaxSessionsPerUser: 2,
+		},
+		REPL: REPLConfig{
+			IdleTimeout:        Duration(15 * time.Minute),
+			MaxLifetime:        Duration(2 * time.Hour),
+			CellTimeout:        Duration(time.Minute),
+			MaxSessionsPerUser: 2,
+		},
+		Notebooks: NotebookConfig{
+			CellTimeout:    Duration(time.Minute),
+			MaxRuntime:     Duration(10 * time.Minute),
+			MaxJobsPerUser: 4,
+			JobRetention:   Duration(time.Hour),
+		},
+		History: HistoryConfig{
+			Enabled:   true,
+			StoreCode: true,
+			Retention: Duration(30 * 24 * time.Hour),
+		},
+		Images: ImagesConfig{
+			Dir:        "images",
+			Repository: "alcaide",
+		},
+		Languages: map[string]LanguageConfig{
+			"python":     {Image: "docker.io/library/python:3.11-slim"},
+			"javascript": {Image: "docker.io/library/node:20-slim"},
+		},
+	}
+}
+
+// PoolSizeFor returns the number of warm containers to keep for lang.
+func (c *Config) PoolSizeFor(lang string) int {
+	if l, ok := c.Languages[lang]; ok && l.PoolSize > 0 {
+		return l.PoolSize
+	}
+	return c.Exec.PoolSize
+}
+
+// ResolvedLanguages returns the configured languages with pool sizes,
+// commands, runtimes, security profiles, installers, formatters, linters,
+// language servers, kernels and build settings filled in from the
+// defaults. An installer, formatter, linter or kernel of none becomes
+// empty. A built language's Context becomes a path and its labels include
+// images.labels.
+func (c *Config) ResolvedLanguages() map[string]LanguageConfig {
+	languages := make(map[string]LanguageConfig, len(c.Languages))
+	for name, lang := range c.Languages {
+		lang.PoolSize = c.PoolSizeFor(name)
+		lang.Command = lang.ExecCommand(name)
+		if lang.Runtime == "" {
+			lang.Runtime = c.Exec.Runtime
+		}
+		if lang.SeccompProfile == "" {
+			lang.SeccompProfile = c.Exec.SeccompProfile
+		}
+		if lang.AppArmorProfile == "" {
+			lang.AppArmorProfile = c.Exec.AppArmorProfile
+		}
+		switch lang.Installer {
+		case "":
+			lang.Installer = builtinInstallers[name]
+		case InstallerNone:
+			lang.Installer = ""
+		}
+		switch lang.Formatter {
+		case "":
+			lang.Formatter = builtinFormatters[name]
+		case FormatterNone:
+			lang.Formatter = ""
+		}
+		if lang.LanguageServer == nil {
+			lang.LanguageServer = builtinLanguageServers[name]
+		}
+		switch lang.Linter {
+		case "":
+			lang.Linter = builtinLinters[name]
+		case LinterNone:
+			lang.Linter = ""
+		}
+		switch lang.Kernel {
+		case "":
+			lang.Kernel = builtinKernels[name]
+		case KernelNone:
+			lang.Kernel = ""
+		}
+		if lang.Build != nil {
+			build := *lang.Build
+			if build.Context == "" {
+				build.Context = name
+			}
+			build.Context = filepath.Join(c.Images.Dir, build.Context)
+			if build.Dockerfile == "" {
+				build.Dockerfile = "Dockerfile"
+			}
+			labels := make(map[string]string, len(c.Images.Labels)+len(build.Labels))
+			maps.Copy(labels, c.Images.Labels)
+			maps.Copy(labels, build.Labels)
+			build.Labels = labels
+			build.Repository = c.Images.Repository + "/" + name
+			lang.Build = &build
+		}
+		languages[name] = lang
+	}
+	return languages
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("server.addr must be set")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout must be positive")
+	}
+
+	if c.Database.URL == "" {
+		add("database.url must be set (DATABASE_URL)")
+	} else if _, err := pgxpool.ParseConfig(c.Database.URL); err != nil {
+		// pgx's error quotes the connection string, password and all.
+		add("database.url is not a valid Postgres connection string")
+	}
+	if c.Database.MaxConns < 0 || c.Database.MinConns < 0 {
+		add("database.max_conns and database.min_conns must not be negative")
+	}
+	if c.Database.MaxConns > 0 && c.Database.MinConns > c.Database.MaxConns {
+		add("database.min_conns (%d) exceeds database.max_conns (%d)", c.Database.MinConns, c.Database.MaxConns)
+	}
+
+	if c.Auth.JWTSecret == "" {
+		add("auth.jwt_secret must be set (JWT_SECRET)")
+	}
+	if c.Auth.TokenTTL <= 0 {
+		add("auth.token_ttl must be positive")
+	}
+	if c.Auth.VerificationTTL <= 0 || c.Auth.PasswordResetTTL <= 0 {
+		add("auth.verification_ttl and auth.password_reset_ttl must be positive")
+	}
+	switch c.Auth.UnverifiedPolicy {
+	case UnverifiedAllow, UnverifiedRestrictExec, UnverifiedBlockLogin:
+	default:
+		add("auth.unverified_policy must be one of allow, restrict_exec, block_login; got %q", c.Auth.UnverifiedPolicy)
+	}
+	if u, err := url.Parse(c.Server.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("server.public_url must be an absolute URL")
+	}
+
+	if c.OIDC.Enabled() {
+		if u, err := url.Parse(c.OIDC.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+			add("oidc.issuer must be an absolute URL")
+		}
+		if c.OIDC.ClientID == "" {
+			add("oidc.client_id must be set when oidc.issuer is set (OIDC_CLIENT_ID)")
+		}
+		if c.OIDC.RedirectURL != "" {
+			if u, err := url.Parse(c.OIDC.RedirectURL); err != nil || u.Scheme == "" || u.Host == "" {
+				add("oidc.redirect_url must be an absolute URL")
+			}
+		}
+		if !slices.Contains(c.OIDC.Scopes, "openid") {
+			add("oidc.scopes must include openid")
+		}
+	}
+
+	switch c.Mail.Driver {
+	case "log", "file":
+		if c.Mail.Driver == "file" && c.Mail.Dir == "" {
+			add("mail.dir must be set when mail.driver is file")
+		}
+	case "smtp":
+		if c.Mail.SMTPHost == "" {
+			add("mail.smtp_host must be set when mail.driver is smtp")
+		}
+		if c.Mail.SMTPPort <= 0 || c.Mail.SMTPPort > 65535 {
+			add("mail.smtp_port must be a valid port")
+		}
+	default:
+		add("mail.driver must be one of log, file, smtp; got %q", c.Mail.Driver)
+	}
+	if c.Mail.From == "" {
+		add("mail.from must be set")
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level must be one of debug, info, warn, error; got %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		add("log.format must be text or json; got %q", c.Log.Format)
+	}
+
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "none", "stdout", "otlp":
+	default:
+		add("tracing.exporter must be one of none, stdout, otlp; got %q", c.Tracing.Exporter)
+	}
+
+	if c.Exec.AcquireTimeout <= 0 {
+		add("exec.acquire_timeout must be positive")
+	}
+	if c.Exec.ExecutionTimeout <= 0 {
+		add("exec.execution_timeout must be positive")
+	}
+	if c.Exec.DrainTimeout <= 0 {
+		add("exec.drain_timeout must be positive")
+	}
+	if c.Exec.PoolSize <= 0 {
+		add("exec.pool_size must be positive")
+	}
+	if c.Exec.InstallTimeout <= 0 {
+		add("exec.install_timeout must be positive")
+	}
+	if c.Exec.Runtime != "" && !validRuntime(c.Exec.Runtime) {
+		add("exec.runtime %q is not a valid runtime name", c.Exec.Runtime)
+	}
+	if c.Exec.AppArmorProfile != "" && !validAppArmorProfile(c.Exec.AppArmorProfile) {
+		add("exec.apparmor_profile %q is not a valid profile name", c.Exec.AppArmorProfile)
+	}
+	switch c.Exec.RuntimeFallback {
+	case RuntimeFallbackFail, RuntimeFallbackDefault:
+	default:
+		add("exec.runtime_fallback must be fail or default; got %q", c.Exec.RuntimeFallback)
+	}
+
+	switch c.Exec.Backend {
+	case BackendDocker:
+	case BackendKubernetes:
+		if !validNamespace(c.Kubernetes.Namespace) {
+			add("kubernetes.namespace %q is not a valid namespace name", c.Kubernetes.Namespace)
+		}
+		if c.Kubernetes.Instance != "" && !validLabelValue(c.Kubernetes.Instance) {
+			add("kubernetes.instance %q is not a valid label value", c.Kubernetes.Instance)
+		}
+		if c.Kubernetes.PodStartTimeout <= 0 || c.Kubernetes.ReconcileInterval <= 0 {
+			add("kubernetes.pod_start_timeout and kubernetes.reconcile_interval must be positive")
+		}
+		if c.Exec.SeccompProfile != "" {
+			add("exec.seccomp_profile is a Docker profile; the kubernetes backend uses kubernetes.seccomp_profile")
+		}
+		for _, name := range sortedKeys(c.Languages) {
+			lang := c.Languages[name]
+			if lang.Build != nil {
+				add("languages.%s.build is not supported by the kubernetes backend; push the image and set image", name)
+			}
+			if lang.SeccompProfile != "" {
+				add("languages.%s.seccomp_profile is a Docker profile; the kubernetes backend uses kubernetes.seccomp_profile", name)
+			}
+		}
+	default:
+		add("exec.backend must be docker or kubernetes; got %q", c.Exec.Backend)
+	}
+
+	if c.Docker.HealthInterval <= 0 {
+		add("docker.health_interval must be positive")
+	}
+	if c.Docker.UnhealthyAfter <= 0 {
+		add("docker.unhealthy_after must be positive")
+	}
+	hostNames := make(map[string]bool)
+	capacity, limited := 0, len(c.Docker.Hosts) > 0
+	for i, host := range c.Docker.Hosts {
+		switch {
+		case !validHostName(host.Name):
+			add("docker.hosts[%d].name %q is not a valid name", i, host.Name)
+		case hostNames[host.Name]:
+			add("docker.hosts[%d].name %q is used more than once", i, host.Name)
+		}
+		hostNames[host.Name] = true
+		u, err := url.Parse(host.Address)
+		if err != nil || (u.Scheme != "unix" && u.Scheme != "tcp" && u.Scheme != "npipe") {
+			add("docker.hosts[%d].address must be a unix://, tcp:// or npipe:// URL", i)
+		}
+		tls := []string{host.TLSCACert, host.TLSCert, host.TLSKey}
+		if slices.Contains(tls, "") && slices.ContainsFunc(tls, func(s string) bool { return s != "" }) {
+			add("docker.hosts[%d] must set all or none of tls_ca_cert, tls_cert and tls_key", i)
+		}
+		if host.MaxContainers < 0 {
+			add("docker.hosts[%d].max_containers must not be negative", i)
+		}
+		if host.MaxContainers == 0 {
+			limited = false
+		}
+		capacity += host.MaxContainers
+	}
+	if limited {
+		total := 0
+		for name := range c.Languages {
+			total += c.PoolSizeFor(name)
+		}
+		if total > capacity {
+			add("docker.hosts allow %d containers in total, fewer than the %d the language pools need", capacity, total)
+		}
+	}
+
+	if c.LSP.IdleTimeout <= 0 || c.LSP.MaxSessionsPerUser <= 0 {
+		add("lsp.idle_timeout and lsp.max_sessions_per_user must be positive")
+	}
+	if c.REPL.IdleTimeout <= 0 || c.REPL.MaxLifetime <= 0 || c.REPL.CellTimeout <= 0 || c.REPL.MaxSessionsPerUser <= 0 {
+		add("repl.idle_timeout, repl.max_lifetime, repl.cell_timeout and repl.max_sessions_per_user must be positive")
+	}
+	if c.Notebooks.CellTimeout <= 0 || c.Notebooks.MaxRuntime <= 0 || c.Notebooks.MaxJobsPerUser <= 0 || c.Notebooks.JobRetention <= 0 {
+		add("notebooks.cell_timeout, notebooks.max_runtime, notebooks.max_jobs_per_user and notebooks.job_retention must be positive")
+	}
+	if c.Queue.MaxPerUser <= 0 || c.Queue.MaxPerLanguage <= 0 {
+		add("queue.max_per_user and queue.max_per_language must be positive")
+	}
+	for _, id := range c.Queue.PremiumUsers {
+		if err := uuid.Validate(id); err != nil {
+			add("queue.premium_users: %q is not a user ID", id)
+		}
+	}
+
+	if c.History.Retention < 0 {
+		add("history.retention must not be negative")
+	}
+
+	if len(c.Languages) == 0 {
+		add("at least one language must be configured")
+	}
+	for _, name := range sortedKeys(c.Languages) {
+		lang := c.Languages[name]
+		switch {
+		case lang.Image == "" && lang.Build == nil:
+			add("languages.%s must set image or build", name)
+		case lang.Image != "" && lang.Build != nil:
+			add("languages.%s must set only one of image and build", name)
+		case lang.Image != "":
+			if _, digest, pinned := strings.Cut(lang.Image, "@"); pinned && !validDigest(digest) {
+				add("languages.%s.image digest must be sha256: followed by 64 hex digits", name)
+			}
+		default:
+			if lang.Build.Context != "" && !filepath.IsLocal(lang.Build.Context) {
+				add("languages.%s.build.context must be a relative path inside images.dir", name)
+			}
+			if !validRepository(c.Images.Repository + "/" + name) {
+				add("languages.%s: %s/%s is not a valid image name", name, c.Images.Repository, name)
+			}
+		}
+		if lang.PoolSize < 0 {
+			add("languages.%s.pool_size must not be negative", name)
+		}
+		if lang.Runtime != "" && !validRuntime(lang.Runtime) {
+			add("languages.%s.runtime %q is not a valid runtime name", name, lang.Runtime)
+		}
+		if lang.AppArmorProfile != "" && !validAppArmorProfile(lang.AppArmorProfile) {
+			add("languages.%s.apparmor_profile %q is not a valid profile name", name, lang.AppArmorProfile)
+		}
+		switch lang.Installer {
+		case "", InstallerNone, InstallerPip, InstallerNpm:
+		default:
+			add("languages.%s.installer must be one of pip, npm, none; got %q", name, lang.Installer)
+		}
+		switch lang.Formatter {
+		case "", FormatterNone, FormatterBlack, FormatterPrettier, FormatterGofmt, FormatterClangFormat, FormatterRustfmt:
+		default:
+			add("languages.%s.formatter must be one of black, prettier, gofmt, clang-format, rustfmt, none; got %q", name, lang.Formatter)
+		}
+		switch lang.Linter {
+		case "", LinterRuff, LinterPyflakes, LinterESLint, LinterGoVet, LinterTsc:
+		case LinterNone:
+			if len(lang.LinterArgs) > 0 {
+				add("languages.%s.linter_args is set but the linter is none", name)
+			}
+		default:
+			add("languages.%s.linter must be one of ruff, pyflakes, eslint, govet, tsc, none; got %q", name, lang.Linter)
+		}
+		switch lang.Kernel {
+		case "", KernelNone, KernelPython:
+		default:
+			add("languages.%s.kernel must be one of python, none; got %q", name, lang.Kernel)
+		}
+		if lang.PackageIndex != "" {
+			if u, err := url.Parse(lang.PackageIndex); err != nil || u.Scheme == "" || u.Host == "" {
+				add("languages.%s.package_index must be an absolute URL", name)
+			}
+		}
+		if len(lang.ExecCommand(name)) == 0 {
+			add("languages.%s.command must be set for languages other than %s", name, strings.Join(sortedKeys(builtinCommands), ", "))
+		}
+	}
+
+	if c.Images.Dir == "" {
+		add("images.dir must be set")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+var (
+	digestPattern     = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	runtimePattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	namespacePattern  = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+	// AppArmor profile names may contain more, but these are all Docker
+	// passes through without quoting trouble.
+	appArmorPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]*$`)
+)
+
+// validHostName follows the rules for runtime names, so host names are
+// safe in metric labels and URL paths.
+func validHostName(name string) bool {
+	return runtimePattern.MatchString(name)
+}
+
+func validNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+func validLabelValue(value string) bool {
+	return labelValuePattern.MatchString(value)
+}
+
+func validAppArmorProfile(name string) bool {
+	return appArmorPattern.MatchString(name)
+}
+
+func validRuntime(name string) bool {
+	return runtimePattern.MatchString(name)
+}
+
+func validDigest(digest string) bool {
+	return digestPattern.MatchString(digest)
+}
+
+// validRepository reports whether name is a valid image name without a
+// registry host or tag.
+func validRepository(name string) bool {
+	return repositoryPattern.MatchString(name)
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration that is safe to print.
+func (c Config) Redacted() Config {
+	if c.Auth.JWTSecret != "" {
+		c.Auth.JWTSecret = redacted
+	}
+	if c.Server.AdminToken != "" {
+		c.Server.AdminToken = redacted
+	}
+	if c.OIDC.ClientSecret != "" {
+		c.OIDC.ClientSecret = redacted
+	}
+	if c.Mail.SMTPPassword != "" {
+		c.Mail.SMTPPassword = redacted
+	}
+	if c.Database.URL != "" {
+		c.Database.URL = redactDatabaseURL(c.Database.URL)
+	}
+	return c
+}
+
+// dsnPassword matches a password setting in a keyword/value connection
+// string, whose value is either quoted, with backslash escapes, or runs to
+// the next space.
+var dsnPassword = regexp.MustCompile(`(^|\s)((?:ssl)?password)\s*=\s*(?:'(?:[^'\\]|\\.)*'|(?:[^\s'\\]|\\.)*)`)
+
+// redactDatabaseURL masks the passwords in a Postgres connection string in
+// either its URL or its keyword/value form. A string pgx cannot parse, or
+// that still contains the password pgx parsed from it, is masked whole.
+func redactDatabaseURL(dsn string) string {
+	config, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return redacted
+	}
+
+	var masked string
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return redacted
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		query := u.Query()
+		for _, key := range []string{"password", "sslpassword"} {
+			if query.Has(key) {
+				query.Set(key, redacted)
+				u.RawQuery = query.Encode()
+			}
+		}
+		masked = u.String()
+	} else {
+		masked = dsnPassword.ReplaceAllString(dsn, "${1}${2}="+redacted)
+	}
+
+	if config.Password != "" && strings.Contains(masked, config.Password) {
+		return redacted
+	}
+	return masked
+}
+
+// JSON renders the configuration in the same shape the config file uses.
+func (c Config) JSON() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Duration is a time.Duration that reads and writes as a string such as
+// "10s" in config files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactedDatabaseURL(t *testing.T) {
+	t.Setenv("PGPASSWORD", "")
+	t.Setenv("PGPASSFILE", "/nonexistent")
+
+	for _, tc := range []struct {
+		dsn  string
+		want string
+	}{
+		{"postgres://app:s3cret@db:5432/app", "postgres://app:REDACTED@db:5432/app"},
+		{"postgres://app@db/app", "postgres://app@db/app"},
+		{"postgres://db/app?password=s3cret&sslmode=disable", "postgres://db/app?password=REDACTED&sslmode=disable"},
+		{"postgresql://app:s3cret@db/app?sslpassword=keypass", "postgresql://app:REDACTED@db/app?sslpassword=REDACTED"},
+		{"host=db user=app password=s3cret dbname=app", "host=db user=app password=REDACTED dbname=app"},
+		{"host=db password = 's3 \\'cret' dbname=app", "host=db password=REDACTED dbname=app"},
+		{"password=s3cret host=db", "password=REDACTED host=db"},
+		{"host=db user=app dbname=app", "host=db user=app dbname=app"},
+		{"host=db password='unterminated", "REDACTED"},
+	} {
+		var c Config
+		c.Database.URL = tc.dsn
+		got := c.Redacted().Database.URL
+		if got != tc.want {
+			t.Errorf("Redacted(%q) = %q, want %q", tc.dsn, got, tc.want)
+		}
+		if strings.Contains(got, "s3") {
+			t.Errorf("Redacted(%q) = %q still shows the password", tc.dsn, got)
+		}
+	}
+}
+
+func TestValidateDatabaseURL(t *testing.T) {
+	for dsn, valid := range map[string]bool{
+		"postgres://app:s3cret@db:5432/app": true,
+		"host=db user=app dbname=app":       true,
+		"postgres://db:notaport/app":        false,
+		"host=db port=five":                 false,
+		"just some text":                    false,
+	} {
+		c := Config{Database: DatabaseConfig{URL: dsn}}
+		err := c.Validate()
+		invalid := err != nil && strings.Contains(err.Error(), "not a valid Postgres connection string")
+		if invalid == valid {
+			t.Errorf("Validate with database.url %q: got %v", dsn, err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ConfigFileEnv names the environment variable that points at a config file
+// when --config is not given.
+const ConfigFileEnv = "ALCAIDE_CONFIG"
+
+// binding ties one configuration value to its flag and environment variable.
+type binding struct {
+	flag   string
+	env    string
+	usage  string
+	target any
+}
+
+func bindings(c *Config) []binding {
+	return []binding{
+		{"addr", "SERVER_ADDR", "address the HTTP server listens on", &c.Server.Addr},
+		{"admin-token", "ADMIN_TOKEN", "bearer token for the /admin endpoints; empty disables them", &c.Server.AdminToken},
+		{"shutdown-timeout", "SERVER_SHUTDOWN_TIMEOUT", "time allowed for graceful shutdown", &c.Server.ShutdownTimeout},
+
+		{"database-url", "DATABASE_URL", "Postgres connection URL", &c.Database.URL},
+		{"db-max-conns", "DB_MAX_CONNS", "maximum pooled database connections", &c.Database.MaxConns},
+		{"db-min-conns", "DB_MIN_CONNS", "minimum pooled database connections", &c.Database.MinConns},
+		{"db-max-conn-lifetime", "DB_MAX_CONN_LIFETIME", "maximum lifetime of a database connection", &c.Database.MaxConnLifetime},
+		{"db-max-conn-idle-time", "DB_MAX_CONN_IDLE_TIME", "close database connections idle for longer than this", &c.Database.MaxConnIdleTime},
+		{"db-health-check-period", "DB_HEALTH_CHECK_PERIOD", "interval between pool health checks", &c.Database.HealthCheckPeriod},
+		{"db-auto-migrate", "DB_AUTO_MIGRATE", "apply pending migrations when the server starts", &c.Database.AutoMigrate},
+
+		{"jwt-secret", "JWT_SECRET", "secret used to sign session tokens", &c.Auth.JWTSecret},
+		{"token-ttl", "JWT_TTL", "lifetime of issued session tokens", &c.Auth.TokenTTL},
+		{"verification-ttl", "VERIFICATION_TTL", "lifetime of email verification links", &c.Auth.VerificationTTL},
+		{"password-reset-ttl", "PASSWORD_RESET_TTL", "lifetime of password reset links", &c.Auth.PasswordResetTTL},
+		{"unverified-policy", "UNVERIFIED_POLICY", "allow, restrict_exec or block_login", &c.Auth.UnverifiedPolicy},
+		{"public-url", "PUBLIC_URL", "externally reachable base URL used in emailed links", &c.Server.PublicURL},
+
+		{"oidc-issuer", "OIDC_ISSUER", "OpenID Connect issuer URL; enables single sign-on", &c.OIDC.Issuer},
+		{"oidc-client-id", "OIDC_CLIENT_ID", "OAuth2 client ID registered with the issuer", &c.OIDC.ClientID},
+		{"oidc-client-secret", "OIDC_CLIENT_SECRET", "OAuth2 client secret, empty for public clients", &c.OIDC.ClientSecret},
+		{"oidc-redirect-url", "OIDC_REDIRECT_URL", "callback URL registered with the issuer", &c.OIDC.RedirectURL},
+		{"oidc-scopes", "OIDC_SCOPES", "comma-separated scopes to request", &c.OIDC.Scopes},
+
+		{"mail-driver", "MAIL_DRIVER", "log, file or smtp", &c.Mail.Driver},
+		{"mail-from", "MAIL_FROM", "sender address of outgoing email", &c.Mail.From},
+		{"mail-dir", "MAIL_DIR", "directory the file mail driver writes to", &c.Mail.Dir},
+		{"smtp-host", "SMTP_HOST", "SMTP relay host", &c.Mail.SMTPHost},
+		{"smtp-port", "SMTP_PORT", "SMTP relay port", &c.Mail.SMTPPort},
+		{"smtp-username", "SMTP_USERNAME", "SMTP username", &c.Mail.SMTPUsername},
+		{"smtp-password", "SMTP_PASSWORD", "SMTP password", &c.Mail.SMTPPassword},
+
+		{"log-level", "LOG_LEVEL", "debug, info, warn or error", &c.Log.Level},
+		{"log-format", "LOG_FORMAT", "text or json", &c.Log.Format},
+
+		{"trace-exporter", "OTEL_TRACES_EXPORTER", "none, stdout or otlp", &c.Tracing.Exporter},
+		{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP/HTTP collector URL", &c.Tracing.Endpoint},
+		{"service-name", "OTEL_SERVICE_NAME", "service name reported in traces", &c.Tracing.ServiceName},
+
+		{"backend", "EXEC_BACKEND", "sandbox backend: docker or kubernetes", &c.Exec.Backend},
+		{"acquire-timeout", "EXEC_ACQUIRE_TIMEOUT", "how long a request waits for a free container", &c.Exec.AcquireTimeout},
+		{"exec-timeout", "EXEC_TIMEOUT", "maximum run time of submitted code", &c.Exec.ExecutionTimeout},
+		{"drain-timeout", "EXEC_DRAIN_TIMEOUT", "how long running executions may finish after a shutdown signal", &c.Exec.DrainTimeout},
+		{"pool-size", "POOL_SIZE", "warm containers per language", &c.Exec.PoolSize},
+		{"exec-user", "EXEC_USER", "user that runs submitted code inside the sandbox", &c.Exec.User},
+		{"install-timeout", "EXEC_INSTALL_TIMEOUT", "maximum time to install a run's dependencies", &c.Exec.InstallTimeout},
+		{"package-cache", "EXEC_PACKAGE_CACHE", "share a package download cache volume between containers of a language", &c.Exec.PackageCache},
+		{"runtime", "EXEC_RUNTIME", "OCI runtime for sandbox containers, e.g. runsc; empty uses the daemon default", &c.Exec.Runtime},
+		{"runtime-fallback", "EXEC_RUNTIME_FALLBACK", "fail or default: what to do when the runtime is not registered with the daemon", &c.Exec.RuntimeFallback},
+		{"seccomp-profile", "EXEC_SECCOMP_PROFILE", "path of a seccomp profile for sandbox containers; empty uses Docker's default", &c.Exec.SeccompProfile},
+		{"apparmor-profile", "EXEC_APPARMOR_PROFILE", "AppArmor profile loaded on the Docker host for sandbox containers", &c.Exec.AppArmorProfile},
+
+		{"docker-health-interval", "DOCKER_HEALTH_INTERVAL", "how often each Docker host is pinged", &c.Docker.HealthInterval},
+		{"docker-unhealthy-after", "DOCKER_UNHEALTHY_AFTER", "failed pings in a row before a Docker host is marked unhealthy", &c.Docker.UnhealthyAfter},
+
+		{"kubeconfig", "KUBECONFIG", "kubeconfig file for the kubernetes backend; empty uses the in-cluster service account", &c.Kubernetes.Kubeconfig},
+		{"kubernetes-namespace", "KUBERNETES_NAMESPACE", "namespace sandbox pods run in", &c.Kubernetes.Namespace},
+		{"kubernetes-instance", "KUBERNETES_INSTANCE", "label identifying this server's sandbox pods; defaults to the host name", &c.Kubernetes.Instance},
+
+		{"queue-max-per-user", "QUEUE_MAX_PER_USER", "executions one caller may have waiting per language", &c.Queue.MaxPerUser},
+		{"queue-max-per-language", "QUEUE_MAX_PER_LANGUAGE", "executions that may wait for one language in total", &c.Queue.MaxPerLanguage},
+		{"queue-premium-users", "QUEUE_PREMIUM_USERS", "comma-separated user IDs served ahead of others", &c.Queue.PremiumUsers},
+
+		{"lsp-idle-timeout", "LSP_IDLE_TIMEOUT", "how long a language server session may go without a client message", &c.LSP.IdleTimeout},
+		{"lsp-max-sessions-per-user", "LSP_MAX_SESSIONS_PER_USER", "language server sessions one user may have open", &c.LSP.MaxSessionsPerUser},
+		{"repl-idle-timeout", "REPL_IDLE_TIMEOUT", "how long a REPL session may go without running a cell", &c.REPL.IdleTimeout},
+		{"repl-max-lifetime", "REPL_MAX_LIFETIME", "how long a REPL session may last", &c.REPL.MaxLifetime},
+		{"repl-cell-timeout", "REPL_CELL_TIMEOUT", "how long a REPL cell may run before it is interrupted", &c.REPL.CellTimeout},
+		{"repl-max-sessions-per-user", "REPL_MAX_SESSIONS_PER_USER", "REPL sessions one user may have open", &c.REPL.MaxSessionsPerUser},
+		{"notebook-cell-timeout", "NOTEBOOK_CELL_TIMEOUT", "default limit on one notebook cell", &c.Notebooks.CellTimeout},
+		{"notebook-max-runtime", "NOTEBOOK_MAX_RUNTIME", "limit on running a whole notebook", &c.Notebooks.MaxRuntime},
+		{"notebook-max-jobs-per-user", "NOTEBOOK_MAX_JOBS_PER_USER", "background notebook runs one user may have unfinished", &c.Notebooks.MaxJobsPerUser},
+		{"notebook-job-retention", "NOTEBOOK_JOB_RETENTION", "how long a finished background notebook run is kept", &c.Notebooks.JobRetention},
+
+		{"history", "HISTORY_ENABLED", "record authenticated executions", &c.History.Enabled},
+		{"history-store-code", "HISTORY_STORE_CODE", "keep submitted code and stdin in history, not just a hash", &c.History.StoreCode},
+		{"history-retention", "HISTORY_RETENTION", "how long execution history is kept; 0 keeps it forever", &c.History.Retention},
+
+		{"images-dir", "IMAGES_DIR", "directory holding the build contexts of built language images", &c.Images.Dir},
+		{"images-repository", "IMAGES_REPOSITORY", "repository name for built language images", &c.Images.Repository},
+	}
+}
+
+// recordedValue is a flag.Value that only remembers what was passed, so flag
+// values can be applied after the file and environment have been read.
+type recordedValue struct {
+	raw    string
+	isBool bool
+}
+
+func (v *recordedValue) String() string     { return v.raw }
+func (v *recordedValue) Set(s string) error { v.raw = s; return nil }
+func (v *recordedValue) IsBoolFlag() bool   { return v.isBool }
+
+// Load builds the configuration from, in increasing order of precedence:
+// built-in defaults, the JSON config file, environment variables (including
+// a .env file if present) and command-line flags. args are the process
+// arguments without the program name; the returned slice holds whatever
+// follows the flags.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+	binds := bindings(&cfg)
+
+	flags := flag.NewFlagSet("alcaide", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", "", "path to a JSON config file (env "+ConfigFileEnv+")")
+	recorded := make(map[string]*recordedValue, len(binds))
+	for _, b := range binds {
+		_, isBool := b.target.(*bool)
+		v := &recordedValue{isBool: isBool}
+		recorded[b.flag] = v
+		flags.Var(v, b.flag, fmt.Sprintf("%s (env %s)", b.usage, b.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+		return cfg, nil, err
+	}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil, fmt.Errorf("loading .env: %w", err)
+	}
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	var problems []string
+	for _, b := range binds {
+		if raw, ok := os.LookupEnv(b.env); ok && raw != "" {
+			if err := setValue(b.target, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", b.env, err))
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		v, ok := recorded[f.Name]
+		if !ok {
+			return
+		}
+		for _, b := range binds {
+			if b.flag == f.Name {
+				if err := setValue(b.target, v.raw); err != nil {
+					problems = append(problems, fmt.Sprintf("--%s: %v", f.Name, err))
+				}
+			}
+		}
+	})
+
+	var invalid *ValidationError
+	if err := cfg.Validate(); errors.As(err, &invalid) {
+		problems = append(problems, invalid.Problems...)
+	}
+	if len(problems) > 0 {
+		return cfg, flags.Args(), &ValidationError{Problems: problems}
+	}
+	return cfg, flags.Args(), nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	// Languages in the file replace the defaults rather than merging with
+	// them, so a file can drop a default language.
+	var probe struct {
+		Languages map[string]LanguageConfig `json:"languages"`
+	}
+	if err := json.Unmarshal(data, &probe); err == nil && probe.Languages != nil {
+		cfg.Languages = nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func setValue(target any, raw string) error {
+	switch t := target.(type) {
+	case *string:
+		*t = raw
+	case *bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*t = v
+	case *int:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*t = v
+	case *int32:
+		v, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*t = int32(v)
+	case *[]string:
+		var values []string
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		*t = values
+	case *Duration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		*t = Duration(v)
+	default:
+		return fmt.Errorf("unsupported config type %T", target)
+	}
+	return nil
+}
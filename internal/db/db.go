@@ -1,29 +1,54 @@
 package db
 
 import (
-    "context"
-    "log"
-    "os"
-    
-    "github.com/jackc/pgx/v5"
-    "github.com/Aadithya-J/alcaIDE/internal/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var Conn *pgx.Conn
+// Connect opens a connection pool and verifies the database is reachable.
+// Zero values in cfg leave pgxpool's own defaults in place.
+func Connect(ctx context.Context, cfg config.DatabaseConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse database URL: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = multitracer.New(otelTracer{}, metricsTracer{})
 
-func Initialize() {
-    var err error
-    Conn, err = pgx.Connect(context.Background(), config.GetEnv("DATABASE_URL"))
-    if err != nil {
-        log.Fatalf("Unable to connect to database: %v", err)
-        os.Exit(1)
-    }
-    log.Println("Connected to Postgres Database!")
-}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = time.Duration(cfg.MaxConnLifetime)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = time.Duration(cfg.MaxConnIdleTime)
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = time.Duration(cfg.HealthCheckPeriod)
+	}
 
-func Close() {
-    if Conn != nil {
-        Conn.Close(context.Background())
-        log.Println("Database connection closed.")
-    }
-}
\ No newline at end of file
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Connected to Postgres database",
+		"max_conns", poolConfig.MaxConns,
+		"min_conns", poolConfig.MinConns,
+		"health_check_period", poolConfig.HealthCheckPeriod,
+	)
+	return pool, nil
+}
@@ -0,0 +1,31 @@
+-- Deployments from before migrations already have a users table created by
+-- hand, so this adopts it if present: missing columns and constraints are
+-- added under the names the code expects, and nothing is recreated.
+CREATE TABLE IF NOT EXISTS users (
+    id         UUID PRIMARY KEY,
+    username   TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    password   TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT users_username_key UNIQUE (username),
+    CONSTRAINT users_email_key UNIQUE (email)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+DO $$
+BEGIN
+    -- Later tables reference users (id), which needs it to be unique.
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'users'::regclass AND contype = 'p') THEN
+        ALTER TABLE users ADD CONSTRAINT users_pkey PRIMARY KEY (id);
+    END IF;
+    -- Conflicts are reported by constraint name, so an existing unique
+    -- constraint or index only counts if it has the expected one.
+    IF to_regclass('users_username_key') IS NULL THEN
+        ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+    END IF;
+    IF to_regclass('users_email_key') IS NULL THEN
+        ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+    END IF;
+END
+$$;
@@ -0,0 +1,2 @@
+-- The original casing is not recorded, so there is nothing to restore.
+SELECT 1;
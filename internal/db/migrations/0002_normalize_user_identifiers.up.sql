@@ -0,0 +1,39 @@
+-- Registration and login now fold usernames and emails to lower case, so
+-- existing rows must be stored the same way to stay reachable.
+--
+-- Accounts that differ only by case or surrounding whitespace, such as
+-- "Bob" and "bob", would collide once folded, and which one to keep is not
+-- for a migration to decide. If there are any, nothing is changed and the
+-- migration fails listing them; rename or remove all but one of each and
+-- run it again.
+DO $$
+DECLARE
+    conflicts TEXT;
+BEGIN
+    SELECT string_agg(format('%s %L: %s', field, folded, ids), E'\n' ORDER BY field, folded)
+    INTO conflicts
+    FROM (
+        SELECT 'username' AS field, lower(btrim(username)) AS folded, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY lower(btrim(username))
+        HAVING count(*) > 1
+        UNION ALL
+        SELECT 'email', lower(btrim(email)), string_agg(id::text, ', ' ORDER BY id)
+        FROM users
+        GROUP BY lower(btrim(email))
+        HAVING count(*) > 1
+    ) collisions;
+
+    IF conflicts IS NOT NULL THEN
+        -- The list goes in the message, which is all the server logs.
+        RAISE EXCEPTION 'users differ only by case or surrounding whitespace; rename or remove all but one of each and migrate again:%',
+            E'\n' || conflicts;
+    END IF;
+END
+$$;
+
+UPDATE users
+SET username = lower(btrim(username)),
+    email    = lower(btrim(email))
+WHERE username <> lower(btrim(username))
+   OR email <> lower(btrim(email));
@@ -0,0 +1,12 @@
+-- Links between local accounts and identities at an external OpenID Connect
+-- provider. An identity is the (issuer, subject) pair from the ID token; the
+-- subject is stable even if the user changes their email at the provider.
+CREATE TABLE user_identities (
+    issuer     TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    user_id    UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,22 @@
+-- Execution history. code is NULL when the server was configured not to
+-- retain source; code_sha256 is always kept so identical runs can still be
+-- matched.
+CREATE TABLE executions (
+    id          UUID PRIMARY KEY,
+    user_id     UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    api_key_id  UUID REFERENCES api_keys (id) ON DELETE SET NULL,
+    language    TEXT NOT NULL,
+    code        TEXT,
+    code_sha256 TEXT NOT NULL,
+    stdin       TEXT,
+    output      TEXT NOT NULL,
+    error       TEXT NOT NULL,
+    status      TEXT NOT NULL,
+    exit_code   INTEGER,
+    duration_ms BIGINT NOT NULL,
+    image       TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX executions_user_id_created_at_idx ON executions (user_id, created_at DESC, id DESC);
+CREATE INDEX executions_created_at_idx ON executions (created_at);
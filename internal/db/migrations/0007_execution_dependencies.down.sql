@@ -0,0 +1,3 @@
+ALTER TABLE executions
+    DROP COLUMN package_json,
+    DROP COLUMN dependencies;
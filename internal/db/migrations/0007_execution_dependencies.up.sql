@@ -0,0 +1,5 @@
+-- Dependencies a run declared, kept alongside its code so it can be re-run.
+-- Both are NULL when the source was not retained.
+ALTER TABLE executions
+    ADD COLUMN dependencies TEXT[],
+    ADD COLUMN package_json JSONB;
@@ -0,0 +1,3 @@
+-- When the password last changed. Session tokens issued before it are
+-- rejected, so resetting a password signs out every other session.
+ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMPTZ;
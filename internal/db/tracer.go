@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/telemetry"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	operation string
+	at        time.Time
+}
+
+// metricsTracer records the latency of every query issued on the connection.
+type metricsTracer struct{}
+
+func (metricsTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{
+		operation: sqlOperation(data.SQL),
+		at:        time.Now(),
+	})
+}
+
+func (metricsTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	status := "ok"
+	if data.Err != nil {
+		status = "error"
+	}
+	metrics.DBQueryDuration.WithLabelValues(start.operation, status).Observe(time.Since(start.at).Seconds())
+}
+
+// sqlOperation returns the leading keyword of a statement (SELECT, INSERT, ...)
+// for use as a low-cardinality metric label.
+func sqlOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// otelTracer wraps each query in a client span carrying the statement text.
+// Arguments are never recorded since they can contain credentials.
+type otelTracer struct{}
+
+func (otelTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = telemetry.Tracer().Start(ctx, "db."+sqlOperation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", sqlOperation(data.SQL)),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (otelTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"context"
+	"log/slog"
+	"path"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+)
+
+const (
+	// packageCacheDir is where the package cache volume is mounted.
+	packageCacheDir = "/var/cache/alcaide"
+	// dependencyRoot holds one directory of installed dependencies per run.
+	// Installs run as root, so code running as another user can read but
+	// not modify it.
+	dependencyRoot = "/tmp/alcaide-deps"
+)
+
+// installer describes how one package manager installs into a directory.
+type installer struct {
+	// command returns the argv, stdin and environment of the install.
+	command func(dir, index string, deps sandbox.Dependencies) (cmd []string, stdin string, env []string)
+	// env points the language's runtime at dir.
+	env func(dir string) []string
+}
+
+var installers = map[string]installer{
+	// Only wheels are accepted: building a source distribution would run
+	// its setup code as root, with write access to the shared cache.
+	config.InstallerPip: {
+		command: func(dir, index string, deps sandbox.Dependencies) ([]string, string, []string) {
+			cmd := append([]string{"pip", "install",
+				"--no-input", "--disable-pip-version-check", "--no-warn-script-location",
+				"--only-binary=:all:", "--target", dir,
+			}, deps.Packages...)
+			env := []string{"PIP_CACHE_DIR=" + path.Join(packageCacheDir, "pip")}
+			if index != "" {
+				env = append(env, "PIP_INDEX_URL="+index)
+			}
+			return cmd, "", env
+		},
+		env: func(dir string) []string {
+			return []string{"PYTHONPATH=" + dir}
+		},
+	},
+	// Lifecycle scripts are disabled for the same reason.
+	config.InstallerNpm: {
+		command: func(dir, index string, deps sandbox.Dependencies) ([]string, string, []string) {
+			const script = `set -e; dir="$1"; shift; mkdir -p "$dir"; cd "$dir"; cat > package.json; ` +
+				`exec npm install --ignore-scripts --no-audit --no-fund --no-update-notifier --omit=dev "$@"`
+			cmd := append([]string{"sh", "-c", script, "sh", dir}, deps.Packages...)
+			stdin := "{}"
+			if len(deps.PackageJSON) > 0 {
+				stdin = string(deps.PackageJSON)
+			}
+			env := []string{"npm_config_cache=" + path.Join(packageCacheDir, "npm")}
+			if index != "" {
+				env = append(env, "npm_config_registry="+index)
+			}
+			return cmd, stdin, env
+		},
+		env: func(dir string) []string {
+			return []string{"NODE_PATH=" + path.Join(dir, "node_modules")}
+		},
+	},
+}
+
+// packageCacheMount is the cache volume shared by a language's containers.
+func packageCacheMount(language string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: "alcaide-packages-" + language,
+		Target: packageCacheDir,
+	}
+}
+
+// Installer returns the dependency installer configured for language, or
+// "" if it has none.
+func (m *DockerManager) Installer(language string) string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].Installer
+}
+
+// User is the user submitted code runs as.
+func (m *DockerManager) User() string {
+	return m.opts.User
+}
+
+// InstallDependencies installs deps into a directory private to this run.
+// If the installer fails the returned error is a *model.ExitError carrying
+// its output. The caller must pass the Installation to RemoveDependencies
+// before releasing the container.
+func (m *DockerManager) InstallDependencies(ctx context.Context, c *model.ContainerInfo, deps sandbox.Dependencies) (sandbox.Installation, error) {
+	m.poolsLock.RLock()
+	lang := m.languages[c.Language]
+	m.poolsLock.RUnlock()
+
+	inst, ok := installers[lang.Installer]
+	if !ok {
+		return sandbox.Installation{}, sandbox.ErrNoInstaller
+	}
+
+	dir := path.Join(dependencyRoot, uuid.NewString())
+	cmd, stdin, env := inst.command(dir, lang.PackageIndex, deps)
+	installation := sandbox.Installation{Env: inst.env(dir), Dir: dir}
+
+	output, err := m.Exec(ctx, c, cmd, model.ExecOptions{Stdin: stdin, Env: env, User: "0"})
+	installation.Output = output
+	return installation, err
+}
+
+// RemoveDependencies deletes what InstallDependencies installed so the
+// container can be reused.
+func (m *DockerManager) RemoveDependencies(ctx context.Context, c *model.ContainerInfo, installation sandbox.Installation) {
+	if installation.Dir == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, ContainerCleanupTimeout)
+	defer cancel()
+	_, err := m.Exec(ctx, c, []string{"rm", "-rf", installation.Dir}, model.ExecOptions{User: "0"})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to remove installed dependencies", "container_id", c.ID, "dir", installation.Dir, "error", err)
+	}
+}
@@ -0,0 +1,567 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LocalHost names the host used when none are configured.
+const LocalHost = "local"
+
+// hostPingTimeout bounds a single health check.
+const hostPingTimeout = 5 * time.Second
+
+// ErrNoCapacity is returned when no healthy, undrained host has room for
+// another container.
+var ErrNoCapacity = errors.New("no healthy Docker host has room for another container")
+
+// Host is a Docker daemon sandboxes may be placed on.
+type Host struct {
+	Name string
+	// Address is a Docker host URL; empty uses the DOCKER_HOST environment.
+	Address string
+	// TLSCACert, TLSCert and TLSKey enable mutual TLS when all are set.
+	TLSCACert, TLSCert, TLSKey string
+	// MaxContainers caps the containers placed on the host; 0 means no
+	// limit.
+	MaxContainers int
+}
+
+// dockerClient is the part of the Docker API the manager uses, so tests can
+// stand in for daemons.
+type dockerClient interface {
+	model.ExecClient
+	DaemonHost() string
+	Ping(ctx context.Context) (types.Ping, error)
+	Info(ctx context.Context) (system.Info, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, container string, options container.StartOptions) error
+	ContainerStop(ctx context.Context, container string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, container string, options container.RemoveOptions) error
+	ImageInspect(ctx context.Context, image string, opts ...client.ImageInspectOption) (image.InspectResponse, error)
+	ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error)
+	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	Close() error
+}
+
+// node is a Host the manager is connected to. Containers are only placed
+// on usable nodes: healthy ones that are not draining.
+type node struct {
+	Host
+	cli      dockerClient
+	healthy  atomic.Bool
+	draining atomic.Bool
+
+	// mu guards the health check state.
+	mu        sync.Mutex
+	failures  int
+	lastError string
+
+	// pending counts containers being started on the node, by language, so
+	// concurrent placements account for each other. Guarded by
+	// DockerManager.placeLock.
+	pending map[string]int
+}
+
+func newNode(h Host) (*node, error) {
+	opts := []client.Opt{client.FromEnv}
+	if h.Address != "" {
+		// The environment describes the default daemon, not this one.
+		opts = []client.Opt{client.WithHost(h.Address), client.WithVersionFromEnv()}
+		if h.TLSCACert != "" {
+			opts = append(opts, client.WithTLSClientConfig(h.TLSCACert, h.TLSCert, h.TLSKey))
+		}
+	}
+	opts = append(opts, client.WithAPIVersionNegotiation())
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Docker host %s: %w", h.Name, err)
+	}
+	return nodeFor(h, cli), nil
+}
+
+// nodeFor returns a healthy node for h reached through cli.
+func nodeFor(h Host, cli dockerClient) *node {
+	if h.Address == "" {
+		h.Address = cli.DaemonHost()
+	}
+	n := &node{Host: h, cli: cli, pending: make(map[string]int)}
+	n.healthy.Store(true)
+	return n
+}
+
+func (n *node) usable() bool {
+	return n.healthy.Load() && !n.draining.Load()
+}
+
+// local reports whether the daemon runs on this machine.
+func (n *node) local() bool {
+	return strings.HasPrefix(n.cli.DaemonHost(), "unix://")
+}
+
+// hostUsable reports whether containers on the named host may be handed out
+// and kept.
+func (m *DockerManager) hostUsable(name string) bool {
+	n, ok := m.hosts[name]
+	return ok && n.usable()
+}
+
+// usableNodes returns the usable nodes in configuration order.
+func (m *DockerManager) usableNodes() []*node {
+	var nodes []*node
+	for _, n := range m.nodes {
+		if n.usable() {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// clientFor returns the client of the host c runs on.
+func (m *DockerManager) clientFor(c *model.ContainerInfo) dockerClient {
+	return m.hosts[c.Host].cli
+}
+
+// place picks the node for a new container of language: among usable nodes
+// with room, the one with the fewest containers of the language, then the
+// fewest overall, so every pool stays spread across hosts. The caller must
+// call unplace once the container is registered or failed to start.
+func (m *DockerManager) place(language string) (*node, error) {
+	m.placeLock.Lock()
+	defer m.placeLock.Unlock()
+
+	total := make(map[string]int, len(m.nodes))
+	ofLanguage := make(map[string]int, len(m.nodes))
+	m.allContainersLock.RLock()
+	for _, c := range m.allContainers {
+		total[c.Host]++
+		if c.Language == language {
+			ofLanguage[c.Host]++
+		}
+	}
+	m.allContainersLock.RUnlock()
+
+	var (
+		best                *node
+		bestLang, bestTotal int
+	)
+	for _, n := range m.nodes {
+		if !n.usable() {
+			continue
+		}
+		nodeTotal := total[n.Name]
+		for _, count := range n.pending {
+			nodeTotal += count
+		}
+		if n.MaxContainers > 0 && nodeTotal >= n.MaxContainers {
+			continue
+		}
+		nodeLang := ofLanguage[n.Name] + n.pending[language]
+		if best == nil || nodeLang < bestLang || (nodeLang == bestLang && nodeTotal < bestTotal) {
+			best, bestLang, bestTotal = n, nodeLang, nodeTotal
+		}
+	}
+	if best == nil {
+		return nil, ErrNoCapacity
+	}
+	best.pending[language]++
+	return best, nil
+}
+
+func (m *DockerManager) unplace(n *node, language string) {
+	m.placeLock.Lock()
+	defer m.placeLock.Unlock()
+	if n.pending[language]--; n.pending[language] <= 0 {
+		delete(n.pending, language)
+	}
+}
+
+// CheckHosts pings every host once, marking those that do not answer
+// unhealthy, and fails if none does. It is meant for startup, before images
+// are prepared; MonitorHosts keeps checking afterwards.
+func (m *DockerManager) CheckHosts(ctx context.Context) error {
+	var errs []error
+	for _, n := range m.nodes {
+		err := m.ping(ctx, n)
+		m.setHealth(n, err == nil)
+		if err != nil {
+			slog.ErrorContext(ctx, "Docker host unreachable", "host", n.Name, "address", n.Address, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", n.Name, err))
+			continue
+		}
+		slog.InfoContext(ctx, "Docker host reachable", "host", n.Name, "address", n.Address, "max_containers", n.MaxContainers)
+	}
+	if len(m.usableNodes()) == 0 {
+		return fmt.Errorf("no Docker host is reachable: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// MonitorHosts checks every host each interval until ctx is done. A host
+// that fails unhealthyAfter checks in a row is marked unhealthy: its idle
+// containers are dropped and the pools refilled on other hosts. A host that
+// answers again is marked healthy and the pools are rebalanced onto it.
+func (m *DockerManager) MonitorHosts(ctx context.Context, interval time.Duration, unhealthyAfter int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var wg sync.WaitGroup
+		for _, n := range m.nodes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.checkHost(ctx, n, unhealthyAfter)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func (m *DockerManager) checkHost(ctx context.Context, n *node, unhealthyAfter int) {
+	err := m.ping(ctx, n)
+	logger := slog.With("host", n.Name, "address", n.Address)
+	switch {
+	case err == nil && !n.healthy.Load():
+		logger.InfoContext(ctx, "Docker host recovered")
+		m.setHealth(n, true)
+		m.rebalance(ctx)
+	case err != nil && n.healthy.Load():
+		n.mu.Lock()
+		failures := n.failures
+		n.mu.Unlock()
+		if failures < unhealthyAfter {
+			logger.WarnContext(ctx, "Docker host health check failed", "failures", failures, "error", err)
+			return
+		}
+		logger.ErrorContext(ctx, "Docker host unhealthy, replacing its containers elsewhere", "failures", failures, "error", err)
+		m.setHealth(n, false)
+		m.evacuate(ctx, n)
+	}
+}
+
+// ping checks n and records the outcome in its failure count.
+func (m *DockerManager) ping(ctx context.Context, n *node) error {
+	ctx, cancel := context.WithTimeout(ctx, hostPingTimeout)
+	defer cancel()
+	_, err := n.cli.Ping(ctx)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil {
+		n.failures++
+		n.lastError = err.Error()
+	} else {
+		n.failures = 0
+		n.lastError = ""
+	}
+	return err
+}
+
+func (m *DockerManager) setHealth(n *node, healthy bool) {
+	n.healthy.Store(healthy)
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	metrics.HostHealthy.WithLabelValues(n.Name).Set(value)
+}
+
+// DrainHost stops placing containers on a host and replaces its idle ones
+// elsewhere; busy ones are retired when released.
+func (m *DockerManager) DrainHost(ctx context.Context, name string) error {
+	n, ok := m.hosts[name]
+	if !ok {
+		return sandbox.ErrUnknownHost
+	}
+	if n.draining.Swap(true) {
+		return nil
+	}
+	metrics.HostDraining.WithLabelValues(n.Name).Set(1)
+	slog.InfoContext(ctx, "Draining Docker host", "host", name)
+	m.evacuate(ctx, n)
+	return nil
+}
+
+// UndrainHost lets containers be placed on a drained host again and
+// rebalances the pools onto it.
+func (m *DockerManager) UndrainHost(ctx context.Context, name string) error {
+	n, ok := m.hosts[name]
+	if !ok {
+		return sandbox.ErrUnknownHost
+	}
+	if !n.draining.Swap(false) {
+		return nil
+	}
+	metrics.HostDraining.WithLabelValues(n.Name).Set(0)
+	slog.InfoContext(ctx, "Docker host accepting containers again", "host", name)
+	m.rebalance(ctx)
+	return nil
+}
+
+// evacuate removes the idle containers on n, which is no longer usable,
+// and refills every pool on the remaining hosts.
+func (m *DockerManager) evacuate(ctx context.Context, n *node) {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	for _, c := range m.takeIdle(func(c *model.ContainerInfo) bool { return c.Host == n.Name }, -1) {
+		// On an unhealthy host this only fails, but the container is
+		// forgotten either way.
+		go m.removeContainer(c)
+	}
+	for _, err := range m.refill(ctx) {
+		slog.ErrorContext(ctx, "Failed to replace container", "host", n.Name, "error", err)
+	}
+}
+
+// takeIdle removes up to limit idle containers matching match from the
+// pools; a negative limit takes all of them.
+func (m *DockerManager) takeIdle(match func(c *model.ContainerInfo) bool, limit int) []*model.ContainerInfo {
+	m.poolsLock.Lock()
+	defer m.poolsLock.Unlock()
+
+	var taken []*model.ContainerInfo
+	for lang, p := range m.availablePools {
+		var keep []*model.ContainerInfo
+	drain:
+		for {
+			select {
+			case c := <-p.ch:
+				if match(c) && (limit < 0 || len(taken) < limit) {
+					taken = append(taken, c)
+				} else {
+					keep = append(keep, c)
+				}
+			default:
+				break drain
+			}
+		}
+		for _, c := range keep {
+			p.ch <- c
+		}
+		metrics.PoolIdle.WithLabelValues(lang).Set(float64(len(p.ch)))
+	}
+	return taken
+}
+
+// refill starts the containers every pool is missing.
+func (m *DockerManager) refill(ctx context.Context) []error {
+	m.poolsLock.RLock()
+	pools := make(map[string]*pool, len(m.availablePools))
+	for lang, p := range m.availablePools {
+		pools[lang] = p
+	}
+	m.poolsLock.RUnlock()
+
+	var errs []error
+	for lang, p := range pools {
+		if missing := p.size - m.countContainers(lang, p); missing > 0 {
+			slog.InfoContext(ctx, "Replacing containers", "language", lang, "count", missing)
+			errs = append(errs, m.fill(ctx, lang, p, missing)...)
+		}
+	}
+	return errs
+}
+
+// rebalance moves idle containers, one at a time, from the host with the
+// most containers of a language to the one with the fewest, until no two
+// usable hosts differ by more than one.
+func (m *DockerManager) rebalance(ctx context.Context) {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	// Containers missing after an outage are replaced first.
+	for _, err := range m.refill(ctx) {
+		slog.ErrorContext(ctx, "Failed to replace container", "error", err)
+	}
+
+	m.poolsLock.RLock()
+	pools := make(map[string]*pool, len(m.availablePools))
+	for lang, p := range m.availablePools {
+		pools[lang] = p
+	}
+	m.poolsLock.RUnlock()
+
+	for lang, p := range pools {
+		for moves := 0; moves < p.size; moves++ {
+			most, gap := m.imbalance(lang, p)
+			if gap <= 1 {
+				break
+			}
+			taken := m.takeIdle(func(c *model.ContainerInfo) bool {
+				return c.Language == lang && c.Host == most.Name
+			}, 1)
+			if len(taken) == 0 {
+				break
+			}
+			m.removeContainer(taken[0])
+			if errs := m.fill(ctx, lang, p, 1); len(errs) > 0 {
+				slog.ErrorContext(ctx, "Failed to rebalance container", "language", lang, "error", errors.Join(errs...))
+				break
+			}
+			slog.InfoContext(ctx, "Moved container to rebalance hosts", "language", lang, "from", most.Name)
+		}
+	}
+}
+
+// imbalance returns the usable host with the most of p's containers and by
+// how many it exceeds the usable host with the fewest that still has room.
+func (m *DockerManager) imbalance(language string, p *pool) (*node, int) {
+	counts := make(map[string]int)
+	total := make(map[string]int)
+	m.allContainersLock.RLock()
+	for _, c := range m.allContainers {
+		total[c.Host]++
+		if c.Language == language && p.owns(c) {
+			counts[c.Host]++
+		}
+	}
+	m.allContainersLock.RUnlock()
+
+	var most *node
+	least := -1
+	for _, n := range m.usableNodes() {
+		if most == nil || counts[n.Name] > counts[most.Name] {
+			most = n
+		}
+		if n.MaxContainers > 0 && total[n.Name] >= n.MaxContainers {
+			continue
+		}
+		if least < 0 || counts[n.Name] < least {
+			least = counts[n.Name]
+		}
+	}
+	if most == nil || least < 0 {
+		return nil, 0
+	}
+	return most, counts[most.Name] - least
+}
+
+// Hosts reports the state of every host, in configuration order.
+func (m *DockerManager) Hosts() []sandbox.HostStatus {
+	statuses := make([]sandbox.HostStatus, len(m.nodes))
+	index := make(map[string]int, len(m.nodes))
+	for i, n := range m.nodes {
+		n.mu.Lock()
+		lastError := n.lastError
+		n.mu.Unlock()
+		statuses[i] = sandbox.HostStatus{
+			Name:          n.Name,
+			Address:       n.Address,
+			Healthy:       n.healthy.Load(),
+			Draining:      n.draining.Load(),
+			LastError:     lastError,
+			MaxContainers: n.MaxContainers,
+			Languages:     make(map[string]int),
+		}
+		index[n.Name] = i
+	}
+
+	m.allContainersLock.RLock()
+	defer m.allContainersLock.RUnlock()
+	for _, c := range m.allContainers {
+		if i, ok := index[c.Host]; ok {
+			statuses[i].Containers++
+			statuses[i].Languages[c.Language]++
+		}
+	}
+	return statuses
+}
+
+// hostFeatures is what every usable host supports.
+type hostFeatures struct {
+	// runtimes are registered on every host.
+	runtimes map[string]bool
+	// defaultRuntime is the first host's default runtime.
+	defaultRuntime string
+	// seccomp and apparmor are supported on every host.
+	seccomp, apparmor bool
+	// local is set if a host runs on this machine, whose loaded AppArmor
+	// profiles can therefore be listed.
+	local bool
+}
+
+// features queries every usable host for what it supports.
+func (m *DockerManager) features(ctx context.Context) (hostFeatures, error) {
+	nodes := m.usableNodes()
+	if len(nodes) == 0 {
+		return hostFeatures{}, ErrNoCapacity
+	}
+
+	f := hostFeatures{seccomp: true, apparmor: true}
+	for i, n := range nodes {
+		info, err := n.cli.Info(ctx)
+		if err != nil {
+			return hostFeatures{}, fmt.Errorf("querying Docker host %s: %w", n.Name, err)
+		}
+		if i == 0 {
+			f.defaultRuntime = info.DefaultRuntime
+			f.runtimes = make(map[string]bool, len(info.Runtimes))
+			for name := range info.Runtimes {
+				f.runtimes[name] = true
+			}
+		} else {
+			for name := range f.runtimes {
+				if _, ok := info.Runtimes[name]; !ok {
+					delete(f.runtimes, name)
+				}
+			}
+		}
+
+		var seccomp, apparmor bool
+		for _, opt := range info.SecurityOptions {
+			for _, field := range strings.Split(opt, ",") {
+				switch field {
+				case "name=seccomp":
+					seccomp = true
+				case "name=apparmor":
+					apparmor = true
+				}
+			}
+		}
+		f.seccomp = f.seccomp && seccomp
+		f.apparmor = f.apparmor && apparmor
+		f.local = f.local || n.local()
+	}
+
+	if !f.runtimes[f.defaultRuntime] {
+		return hostFeatures{}, fmt.Errorf("default runtime %q of host %s is not registered on every host (%s)",
+			f.defaultRuntime, nodes[0].Name, hostNames(nodes))
+	}
+	return f, nil
+}
+
+// hostNames lists the names of nodes, for error messages.
+func hostNames(nodes []*node) string {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Name
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
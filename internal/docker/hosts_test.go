@@ -0,0 +1,267 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeDocker is a daemon that creates containers instantly, with the runc
+// runtime and every image but those in missing. Calls the manager makes
+// outside placement and image preparation are not implemented.
+type fakeDocker struct {
+	dockerClient
+	name string
+
+	mu      sync.Mutex
+	down    bool
+	missing map[string]bool
+	next    int
+	created []*container.HostConfig
+	removed []string
+}
+
+func (d *fakeDocker) DaemonHost() string { return "tcp://" + d.name + ":2376" }
+
+func (d *fakeDocker) Close() error { return nil }
+
+func (d *fakeDocker) Ping(context.Context) (types.Ping, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return types.Ping{}, errors.New("connection refused")
+	}
+	return types.Ping{}, nil
+}
+
+func (d *fakeDocker) Info(context.Context) (system.Info, error) {
+	return system.Info{DefaultRuntime: "runc", Runtimes: map[string]system.RuntimeWithStatus{"runc": {}}}, nil
+}
+
+func (d *fakeDocker) ImagePull(_ context.Context, ref string, _ image.PullOptions) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.missing[ref] {
+		return nil, errors.New("manifest unknown")
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (d *fakeDocker) ImageInspect(_ context.Context, ref string, _ ...client.ImageInspectOption) (image.InspectResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.missing[ref] {
+		return image.InspectResponse{}, errors.New("no such image")
+	}
+	return image.InspectResponse{ID: "sha256:" + ref}, nil
+}
+
+func (d *fakeDocker) ContainerCreate(_ context.Context, _ *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.next++
+	d.created = append(d.created, hostConfig)
+	return container.CreateResponse{ID: fmt.Sprintf("%s-%d", d.name, d.next)}, nil
+}
+
+func (d *fakeDocker) ContainerStart(context.Context, string, container.StartOptions) error {
+	return nil
+}
+
+func (d *fakeDocker) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removed = append(d.removed, id)
+	return nil
+}
+
+func (d *fakeDocker) removals() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.removed)
+}
+
+func newTestManager(hosts ...Host) (*DockerManager, map[string]*fakeDocker) {
+	daemons := make(map[string]*fakeDocker, len(hosts))
+	nodes := make([]*node, len(hosts))
+	for i, h := range hosts {
+		daemons[h.Name] = &fakeDocker{name: h.Name}
+		nodes[i] = nodeFor(h, daemons[h.Name])
+	}
+	return newManager(map[string]config.LanguageConfig{}, Options{}, nodes), daemons
+}
+
+// addPool starts a pool of size containers for language.
+func addPool(t *testing.T, m *DockerManager, language string, size int) *pool {
+	t.Helper()
+	p := newPool(config.LanguageConfig{Image: language + ":latest", PoolSize: size})
+	m.poolsLock.Lock()
+	m.availablePools[language] = p
+	m.poolsLock.Unlock()
+	if errs := m.fill(context.Background(), language, p, size); len(errs) > 0 {
+		t.Fatal(errors.Join(errs...))
+	}
+	return p
+}
+
+// idleByHost counts p's idle containers on each host.
+func idleByHost(m *DockerManager, p *pool) map[string]int {
+	counts := make(map[string]int)
+	for _, c := range m.takeIdle(p.owns, -1) {
+		counts[c.Host]++
+		p.ch <- c
+	}
+	return counts
+}
+
+func TestPlaceSpreadsAcrossHosts(t *testing.T) {
+	m, daemons := newTestManager(Host{Name: "a"}, Host{Name: "b"}, Host{Name: "c"})
+
+	python := addPool(t, m, "python", 5)
+	counts := idleByHost(m, python)
+	var sizes []int
+	for _, n := range counts {
+		sizes = append(sizes, n)
+	}
+	slices.Sort(sizes)
+	if !slices.Equal(sizes, []int{1, 2, 2}) {
+		t.Fatalf("python containers per host = %v, want 1, 2 and 2", counts)
+	}
+
+	// Every host is free of javascript, so the one with the fewest
+	// containers overall wins.
+	javascript := addPool(t, m, "javascript", 1)
+	for host, n := range idleByHost(m, javascript) {
+		if n != 1 || counts[host] != 1 {
+			t.Errorf("javascript placed on %s, which has %d python containers; want the host with 1", host, counts[host])
+		}
+	}
+
+	for name, d := range daemons {
+		for _, hc := range d.created {
+			if !slices.Equal(hc.CapDrop, []string{"ALL"}) {
+				t.Errorf("container on %s created with CapDrop %v, want ALL", name, hc.CapDrop)
+			}
+		}
+	}
+}
+
+func TestPlaceCountsPendingContainers(t *testing.T) {
+	m, _ := newTestManager(Host{Name: "a"}, Host{Name: "b"})
+
+	first, err := m.place("python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.place("python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("two containers being started were both placed on %s", first.Name)
+	}
+	m.unplace(first, "python")
+	m.unplace(second, "python")
+	if len(first.pending)+len(second.pending) != 0 {
+		t.Errorf("pending after unplace: %v and %v", first.pending, second.pending)
+	}
+}
+
+func TestPlaceRespectsMaxContainers(t *testing.T) {
+	m, _ := newTestManager(Host{Name: "a", MaxContainers: 1}, Host{Name: "b", MaxContainers: 3})
+
+	python := addPool(t, m, "python", 3)
+	addPool(t, m, "javascript", 1)
+	counts := idleByHost(m, python)
+	if counts["a"] != 1 || counts["b"] != 2 {
+		t.Errorf("python containers per host = %v, want a:1 b:2", counts)
+	}
+
+	if _, err := m.place("python"); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("place on full hosts: got %v, want ErrNoCapacity", err)
+	}
+}
+
+func TestPlaceSkipsUnusableHosts(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestManager(Host{Name: "a"}, Host{Name: "b"}, Host{Name: "c"})
+	m.setHealth(m.hosts["a"], false)
+	if err := m.DrainHost(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	python := addPool(t, m, "python", 3)
+	if counts := idleByHost(m, python); counts["c"] != 3 {
+		t.Errorf("python containers per host = %v, want all on c", counts)
+	}
+
+	m.setHealth(m.hosts["c"], false)
+	if _, err := m.place("python"); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("place with no usable host: got %v, want ErrNoCapacity", err)
+	}
+}
+
+func TestDrainHostMovesIdleContainers(t *testing.T) {
+	ctx := context.Background()
+	m, daemons := newTestManager(Host{Name: "a"}, Host{Name: "b"})
+	python := addPool(t, m, "python", 4)
+
+	if err := m.DrainHost(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if counts := idleByHost(m, python); counts["a"] != 0 || counts["b"] != 4 {
+		t.Errorf("after draining a, python containers per host = %v, want all on b", counts)
+	}
+	// Evacuated containers are removed in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for daemons["a"].removals() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if removed := daemons["a"].removals(); removed != 2 {
+		t.Errorf("removed %d containers from a, want 2", removed)
+	}
+
+	if err := m.UndrainHost(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if counts := idleByHost(m, python); counts["a"] != 2 || counts["b"] != 2 {
+		t.Errorf("after undraining a, python containers per host = %v, want 2 each", counts)
+	}
+}
+
+func TestUnhealthyHostIsReplaced(t *testing.T) {
+	ctx := context.Background()
+	m, daemons := newTestManager(Host{Name: "a"}, Host{Name: "b"})
+	python := addPool(t, m, "python", 2)
+
+	daemons["a"].mu.Lock()
+	daemons["a"].down = true
+	daemons["a"].mu.Unlock()
+
+	m.checkHost(ctx, m.hosts["a"], 2)
+	if !m.hostUsable("a") {
+		t.Fatal("host marked unhealthy after one failed check, want two")
+	}
+	m.checkHost(ctx, m.hosts["a"], 2)
+	if m.hostUsable("a") {
+		t.Fatal("host still usable after two failed checks")
+	}
+	if counts := idleByHost(m, python); counts["b"] != 2 {
+		t.Errorf("python containers per host = %v, want both on b", counts)
+	}
+}
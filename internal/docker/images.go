@@ -0,0 +1,444 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// Labels set on every built image.
+const (
+	LabelLanguage    = "alcaide.language"
+	LabelContentHash = "alcaide.content-hash"
+)
+
+// BuildProgress receives build output one line at a time.
+type BuildProgress func(host, language, line string)
+
+// prepareImages makes the image of every language available on every usable
+// host and returns the languages with Image set to what pools should run:
+//
+//   - built languages are tagged by the hash of their build context and
+//     only rebuilt when that changes;
+//   - images pinned by digest are used as is and only pulled if missing;
+//   - other images are pulled and resolved to the digest that was pulled,
+//     so a tag that moves later is picked up by the next reload rather than
+//     silently by new containers. Further hosts pull that digest, so every
+//     host runs the same image.
+//
+// Languages whose image could not be made available on every host are left
+// out of the result and reported in the error map.
+func (m *DockerManager) prepareImages(ctx context.Context, languages map[string]config.LanguageConfig) (map[string]config.LanguageConfig, map[string]error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		prepared = make(map[string]config.LanguageConfig, len(languages))
+		failed   = make(map[string]error)
+	)
+
+	nodes := m.usableNodes()
+	slog.InfoContext(ctx, "Preparing Docker images", "languages", len(languages), "hosts", len(nodes))
+
+	for name, lang := range languages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				ref string
+				err error
+			)
+			switch {
+			case len(nodes) == 0:
+				err = ErrNoCapacity
+			case lang.Build != nil:
+				for _, n := range nodes {
+					if ref, err = buildImage(ctx, n.cli, name, *lang.Build, logBuildProgress(ctx, n.Name)); err != nil {
+						err = fmt.Errorf("on %s: %w", n.Name, err)
+						break
+					}
+				}
+			default:
+				ref = lang.Image
+				for _, n := range nodes {
+					if ref, err = m.pullImage(ctx, n, name, ref); err != nil {
+						err = fmt.Errorf("on %s: %w", n.Name, err)
+						break
+					}
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.ErrorContext(ctx, "Image unavailable", "language", name, "error", err)
+				failed[name] = err
+				return
+			}
+			lang.Image = ref
+			prepared[name] = lang
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) == 0 {
+		slog.InfoContext(ctx, "All images ready")
+	}
+	return prepared, failed
+}
+
+// ensureImage makes p's image available on n, for hosts that were not
+// usable when images were prepared.
+func (m *DockerManager) ensureImage(ctx context.Context, n *node, language string, p *pool) error {
+	if p.build == nil {
+		_, err := m.pullImage(ctx, n, language, p.image)
+		return err
+	}
+	tag, err := buildImage(ctx, n.cli, language, *p.build, logBuildProgress(ctx, n.Name))
+	if err == nil && tag != p.image {
+		// The build context changed since the pool was started.
+		err = fmt.Errorf("build context of %s no longer produces %s", language, p.image)
+	}
+	return err
+}
+
+func logBuildProgress(ctx context.Context, host string) func(language, line string) {
+	return func(language, line string) {
+		slog.InfoContext(ctx, line, "language", language, "host", host, "source", "docker build")
+	}
+}
+
+// pullImage makes ref available on n and returns the reference pools should
+// use for it.
+func (m *DockerManager) pullImage(ctx context.Context, n *node, language, ref string) (string, error) {
+	logger := slog.With("language", language, "image", ref, "host", n.Name)
+
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	_, pinned := named.(reference.Digested)
+	if pinned {
+		if _, err := n.cli.ImageInspect(ctx, ref); err == nil {
+			logger.DebugContext(ctx, "Pinned image already present")
+			return ref, nil
+		}
+	}
+
+	logger.InfoContext(ctx, "Pulling image")
+	pullErr := func() error {
+		reader, err := n.cli.ImagePull(ctx, ref, image.PullOptions{})
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return readProgress(reader, nil)
+	}()
+	if pullErr != nil {
+		logger.WarnContext(ctx, "Failed to pull image, looking for a local copy", "error", pullErr)
+	}
+
+	inspect, err := n.cli.ImageInspect(ctx, ref)
+	if err != nil {
+		if pullErr != nil {
+			return "", fmt.Errorf("failed to pull image %s: %w", ref, pullErr)
+		}
+		return "", fmt.Errorf("inspecting image %s: %w", ref, err)
+	}
+	if pinned {
+		return ref, nil
+	}
+
+	for _, repoDigest := range inspect.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err == nil && digested.Name() == named.Name() {
+			logger.InfoContext(ctx, "Image ready", "digest", repoDigest)
+			return repoDigest, nil
+		}
+	}
+	// Images that never came from a registry have no repo digest.
+	logger.InfoContext(ctx, "Image ready", "id", inspect.ID)
+	return ref, nil
+}
+
+// BuildImages builds the image of every language that has a Dockerfile on
+// every host, skipping those already built from the same context. It
+// returns the tag of each. Unlike the pool manager it uses its own Docker
+// clients, so it can run without starting the server.
+func BuildImages(ctx context.Context, hosts []Host, languages map[string]config.LanguageConfig, progress BuildProgress) (map[string]string, error) {
+	if len(hosts) == 0 {
+		hosts = []Host{{Name: LocalHost}}
+	}
+
+	tags := make(map[string]string)
+	var errs []error
+	for _, h := range hosts {
+		n, err := newNode(h)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, name := range sortedNames(languages) {
+			lang := languages[name]
+			if lang.Build == nil {
+				continue
+			}
+			tag, err := buildImage(ctx, n.cli, name, *lang.Build, func(language, line string) {
+				if progress != nil {
+					progress(h.Name, language, line)
+				}
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s on %s: %w", name, h.Name, err))
+				continue
+			}
+			tags[name] = tag
+		}
+		n.cli.Close()
+	}
+	return tags, errors.Join(errs...)
+}
+
+// buildImage builds the language's image unless one tagged with the hash of
+// its context already exists, and returns the tag.
+func buildImage(ctx context.Context, cli dockerClient, language string, build config.BuildConfig, progress func(language, line string)) (string, error) {
+	hash, err := contextHash(build)
+	if err != nil {
+		return "", fmt.Errorf("hashing build context %s: %w", build.Context, err)
+	}
+	tag := build.Repository + ":" + hash[:12]
+	logger := slog.With("language", language, "image", tag)
+
+	if _, err := cli.ImageInspect(ctx, tag); err == nil {
+		logger.InfoContext(ctx, "Built image is up to date")
+		return tag, nil
+	} else if !client.IsErrNotFound(err) {
+		return "", fmt.Errorf("inspecting image %s: %w", tag, err)
+	}
+
+	logger.InfoContext(ctx, "Building image", "context", build.Context, "dockerfile", build.Dockerfile)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, build.Context))
+	}()
+	defer pr.Close()
+
+	args := make(map[string]*string, len(build.Args))
+	for k, v := range build.Args {
+		args[k] = &v
+	}
+	labels := make(map[string]string, len(build.Labels)+2)
+	for k, v := range build.Labels {
+		labels[k] = v
+	}
+	labels[LabelLanguage] = language
+	labels[LabelContentHash] = hash
+
+	resp, err := cli.ImageBuild(ctx, pr, types.ImageBuildOptions{
+		Tags:        []string{tag},
+		Dockerfile:  build.Dockerfile,
+		BuildArgs:   args,
+		Labels:      labels,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("building image %s: %w", tag, err)
+	}
+	defer resp.Body.Close()
+
+	err = readProgress(resp.Body, func(line string) {
+		if progress != nil {
+			progress(language, line)
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("building image %s: %w", tag, err)
+	}
+	logger.InfoContext(ctx, "Image built")
+	return tag, nil
+}
+
+// readProgress consumes a stream of JSON messages from an image build or
+// pull, passing each line of output to emit, and returns the error the
+// stream reports, if any.
+func readProgress(r io.Reader, emit func(line string)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Stream      string `json:"stream"`
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading progress: %w", err)
+		}
+
+		if msg.ErrorDetail.Message != "" {
+			return errors.New(msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		if emit == nil {
+			continue
+		}
+		for _, line := range strings.Split(msg.Stream, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				emit(line)
+			}
+		}
+	}
+}
+
+// contextHash identifies a build by the paths, modes and contents of the
+// files in its context together with its Dockerfile name, arguments and
+// labels.
+func contextHash(build config.BuildConfig) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "dockerfile %s\n", build.Dockerfile)
+	for _, k := range sortedKeys(build.Args) {
+		fmt.Fprintf(h, "arg %q=%q\n", k, build.Args[k])
+	}
+	for _, k := range sortedKeys(build.Labels) {
+		fmt.Fprintf(h, "label %q=%q\n", k, build.Labels[k])
+	}
+
+	err := walkContext(build.Context, func(rel string, info fs.FileInfo, path string) error {
+		fmt.Fprintf(h, "%s %q %o\n", fileKind(info), rel, info.Mode().Perm())
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "-> %q\n", target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fmt.Fprintf(h, "%d\n", info.Size())
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeContext writes the build context directory to w as a tar archive.
+func writeContext(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := walkContext(dir, func(rel string, info fs.FileInfo, path string) error {
+		if fileKind(info) == "other" {
+			return nil
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// walkContext calls fn for every entry below dir in lexical order. A
+// .dockerignore file is not honoured; keep build contexts small.
+func walkContext(dir string, fn func(rel string, info fs.FileInfo, path string) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(rel, info, path)
+	})
+}
+
+func fileKind(info fs.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "dir"
+	case info.Mode()&fs.ModeSymlink != 0:
+		return "symlink"
+	case info.Mode().IsRegular():
+		return "file"
+	default:
+		return "other"
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedNames(languages map[string]config.LanguageConfig) []string {
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
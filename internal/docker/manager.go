@@ -1,337 +1,580 @@
 package docker
 
 import (
-    "fmt"
-    "context"
-    "io"
-    "log"
-    // "os"
-    "sync"
-    "sync/atomic"
-    "time"
-    "errors"
-
-    "github.com/Aadithya-J/alcaIDE/model"
-    "github.com/docker/docker/api/types/container"
-    "github.com/docker/docker/api/types/image"
-    "github.com/docker/docker/client"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/internal/telemetry"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-    ContainerStopTimeout    = 5 * time.Second
-    ContainerCleanupTimeout = 10 * time.Second
+	ContainerStopTimeout    = 5 * time.Second
+	ContainerCleanupTimeout = 10 * time.Second
 )
 
+// pool holds the idle containers of one language. A pool is never resized
+// or re-imaged in place: Reload swaps in a new pool and closes the old
+// channel, which wakes anyone waiting on it.
+type pool struct {
+	image string
+	// build is how the image is built, if it is; hosts that lack the image
+	// build it from this.
+	build       *config.BuildConfig
+	runtime     string
+	securityOpt []string
+	security    string
+	size        int
+	ch          chan *model.ContainerInfo
+}
+
+func newPool(lang config.LanguageConfig) *pool {
+	return &pool{
+		image:       lang.Image,
+		build:       lang.Build,
+		runtime:     lang.Runtime,
+		securityOpt: lang.SecurityOpt,
+		security:    securityID(lang.SecurityOpt),
+		size:        lang.PoolSize,
+		ch:          make(chan *model.ContainerInfo, lang.PoolSize),
+	}
+}
+
+// owns reports whether c was started for p's image, runtime and security
+// profiles.
+func (p *pool) owns(c *model.ContainerInfo) bool {
+	return c.Image == p.image && c.Runtime == p.runtime && c.Security == p.security
+}
+
+// Options configure the sandbox containers.
+type Options struct {
+	// User runs submitted code; empty uses the image's user.
+	User string
+	// PackageCache mounts a per-language cache volume for dependency
+	// installs.
+	PackageCache bool
+	// RuntimeFallback is config.RuntimeFallbackFail or
+	// config.RuntimeFallbackDefault.
+	RuntimeFallback string
+	// QueueMaxPerUser and QueueMaxPerLanguage bound the executions waiting
+	// for a container; see AcquireContainer.
+	QueueMaxPerUser     int
+	QueueMaxPerLanguage int
+	// Hosts are the Docker daemons containers are placed on. Empty uses
+	// the daemon from the environment, named LocalHost.
+	Hosts []Host
+}
+
 type DockerManager struct {
-    cli               *client.Client
-    languageImages    map[string]string 
-    availablePools    map[string]chan *model.ContainerInfo
-    allContainers     map[string]*model.ContainerInfo
-    allContainersLock sync.RWMutex
-    poolsLock         sync.RWMutex
-    shuttingDown      atomic.Bool
+	// nodes and hosts hold the same nodes, in configuration order and by
+	// name. They are fixed at construction.
+	nodes []*node
+	hosts map[string]*node
+	// placeLock serialises container placement.
+	placeLock         sync.Mutex
+	opts              Options
+	languages         map[string]config.LanguageConfig
+	availablePools    map[string]*pool
+	allContainers     map[string]*model.ContainerInfo
+	allContainersLock sync.RWMutex
+	// poolsLock guards languages and availablePools.
+	poolsLock    sync.RWMutex
+	shuttingDown atomic.Bool
+	// reloadLock serialises changes to the set of pools: Reload, and the
+	// refills and rebalancing that follow host failures and drains.
+	reloadLock sync.Mutex
+
+	// Lifecycle hands out, queues for and drains the pooled containers.
+	*sandbox.Lifecycle
+}
+
+// NewManager creates a manager for the given languages. Each language's
+// PoolSize must already be resolved to a positive value.
+func NewManager(languages map[string]config.LanguageConfig, opts Options) (*DockerManager, error) {
+	if len(languages) == 0 {
+		return nil, fmt.Errorf("No language images provided")
+	}
+
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		hosts = []Host{{Name: LocalHost}}
+	}
+	nodes := make([]*node, 0, len(hosts))
+	for _, h := range hosts {
+		n, err := newNode(h)
+		if err != nil {
+			for _, n := range nodes {
+				n.cli.Close()
+			}
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return newManager(languages, opts, nodes), nil
 }
 
-func NewManager(langImages map[string]string) (*DockerManager, error) {
-    cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-    if err != nil {
-        return nil, err
-    }
-
-    if len(langImages) == 0 {
-        return nil, fmt.Errorf("No language images provided")
-    }
-
-    return &DockerManager{
-        cli:            cli,
-        languageImages: langImages,
-        availablePools: make(map[string]chan *model.ContainerInfo),
-        allContainers:  make(map[string]*model.ContainerInfo),
-    }, nil
+// newManager creates a manager placing containers on nodes.
+func newManager(languages map[string]config.LanguageConfig, opts Options, nodes []*node) *DockerManager {
+	byName := make(map[string]*node, len(nodes))
+	for _, n := range nodes {
+		byName[n.Name] = n
+	}
+	m := &DockerManager{
+		nodes:          nodes,
+		hosts:          byName,
+		opts:           opts,
+		languages:      languages,
+		availablePools: make(map[string]*pool),
+		allContainers:  make(map[string]*model.ContainerInfo),
+	}
+	m.Lifecycle = sandbox.NewLifecycle("docker", m, opts.QueueMaxPerUser, opts.QueueMaxPerLanguage)
+	return m
 }
 
+// PullImages builds or pulls the image of every language; see
+// prepareImages. Languages whose image is unavailable are dropped, and the
+// returned error lists them.
 func (m *DockerManager) PullImages(ctx context.Context) error {
-    var wg sync.WaitGroup
-    errChan := make(chan error, len(m.languageImages))
-
-    log.Printf("Pulling Docker images: %s...", m.languageImages)
-
-    for lang, imageName := range m.languageImages {
-        wg.Add(1)
-        go func(l, img string) {
-            defer wg.Done()
-            log.Printf("Pulling image for %s: %s...", l, img)
-
-            reader, err := m.cli.ImagePull(ctx, img, image.PullOptions{})
-            if err != nil {
-                log.Printf("Failed to pull Docker image %s: %v", img, err)
-                errChan <- fmt.Errorf("failed to pull image %s: %w", img, err)
-                return
-            }
-            defer reader.Close()
-
-            _, err = io.Copy(io.Discard, reader)
-            if err != nil {
-                log.Printf("Warning: Failed to copy image pull output for %s: %v", img, err)
-            }
-            log.Printf("Image %s pulled successfully or already exists.", img)
-        }(lang, imageName)
-    }
-    wg.Wait()
-    close(errChan)
-
-    var pullErrors []error
-    for err := range errChan {
-        pullErrors = append(pullErrors, err)
-    }
-
-    if len(pullErrors) > 0 {
-        log.Printf("Warning: Some images failed to pull: %v", pullErrors)
-    } else {
-        log.Println("All images pulled successfully.")
-    }
-    return nil
+	m.poolsLock.RLock()
+	languages := m.languages
+	m.poolsLock.RUnlock()
+
+	prepared, failed := m.prepareImages(ctx, languages)
+
+	m.poolsLock.Lock()
+	m.languages = prepared
+	m.poolsLock.Unlock()
+
+	var errs []error
+	for name, err := range failed {
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return errors.Join(errs...)
 }
 
-func (m *DockerManager) StartInitialContainers(ctx context.Context, countPerLang int) error {
-    if countPerLang <= 0 {
-        return fmt.Errorf("invalid number of containers per language: %d", countPerLang)
-    }
-
-    log.Printf("Creating and starting %d initial containers *per language*...", countPerLang)
-
-    m.poolsLock.Lock()
-    for lang := range m.languageImages {
-        m.availablePools[lang] = make(chan *model.ContainerInfo, countPerLang)
-    }
-    m.poolsLock.Unlock()
-
-    var wg sync.WaitGroup
-    errChan := make(chan error, len(m.languageImages)*countPerLang)
-    totalContainersToStart := 0
-
-    for lang, imageName := range m.languageImages { 
-        log.Printf("starting ocontainers for %s using image %s", lang, imageName)
-        for i := 0; i < countPerLang; i++ {
-            totalContainersToStart++
-            wg.Add(1)
-            go func(lang, imageName string, containerIndex int) {
-
-                defer wg.Done()
-                log.Printf("Creating container %d for %s...", containerIndex, lang)
-
-                resp, err := m.cli.ContainerCreate(ctx, &container.Config{
-                    Image: imageName,
-                    Cmd:   []string{"sleep", "infinity"},
-                    Tty:   false,
-                }, nil, nil, nil, "")
-                if err != nil {
-                    errChan <- fmt.Errorf("failed to create container %d for %s: %w", containerIndex, lang, err)
-                    return
-                }
-                containerID := resp.ID
-                err = m.cli.ContainerStart(ctx, containerID, container.StartOptions{})
-                if err != nil {
-                    errChan <- fmt.Errorf("failed to start container %d for %s: %w", containerIndex, lang, err)
-                    rmCtx, rmCancel := context.WithTimeout(context.Background(), ContainerCleanupTimeout)
-                    defer rmCancel()
-                    rmErr := m.cli.ContainerRemove(rmCtx, containerID, container.RemoveOptions{Force: true})
-                    if rmErr != nil {
-                        log.Printf("Warning: Failed to remove unstartable container %s: %v", containerID, rmErr)
-                    }
-                    return 
-                }
-
-                log.Printf("Started container %d for %s: %s", containerIndex+1, lang, containerID)
-
-                containInfo := &model.ContainerInfo{ID: containerID}
-
-                m.allContainersLock.Lock()
-                m.allContainers[containerID] = containInfo
-                m.allContainersLock.Unlock()
-
-                m.poolsLock.Lock()
-                poolsChan, ok := m.availablePools[lang]
-                m.poolsLock.Unlock()
-                if ok {
-                    poolsChan <- containInfo
-                    log.Printf("Container %d for %s added to available pool: %s", containerIndex+1, lang, containerID)
-                } else {
-                    log.Printf("Warning: No pool found for language %s. Container %d not added to pool.", lang, containerIndex)
-                }
-            }(lang, imageName, i)
-        }
-    }
-    wg.Wait()
-    close(errChan)
-    var startupErrors []error
-    for err := range errChan {
-        log.Printf("Error during container startup: %v", err)
-        startupErrors = append(startupErrors, err)
-    }
-
-    m.allContainersLock.RLock()
-    numStarted := len(m.allContainers)
-    m.allContainersLock.RUnlock()
-    if numStarted == 0 {
-        log.Fatal("Fatal: No containers were started successfully.")
-    }
-    if len(startupErrors) > 0 {
-        log.Printf("Warning: Some containers failed to start: %v", startupErrors)
-    }
-
-    log.Printf("%d containers started successfully.", numStarted)
-    return nil
+func (m *DockerManager) StartInitialContainers(ctx context.Context) error {
+	total := 0
+	for lang, langConfig := range m.languages {
+		if langConfig.PoolSize <= 0 {
+			return fmt.Errorf("invalid number of containers for %s: %d", lang, langConfig.PoolSize)
+		}
+		total += langConfig.PoolSize
+	}
+
+	slog.InfoContext(ctx, "Creating and starting initial containers", "total", total)
+
+	pools := make(map[string]*pool, len(m.languages))
+	for lang, langConfig := range m.languages {
+		pools[lang] = newPool(langConfig)
+	}
+	m.poolsLock.Lock()
+	m.availablePools = pools
+	m.poolsLock.Unlock()
+
+	var startupErrors []error
+	for lang, p := range pools {
+		slog.InfoContext(ctx, "Starting containers", "language", lang, "image", p.image, "count", p.size)
+		startupErrors = append(startupErrors, m.fill(ctx, lang, p, p.size)...)
+	}
+	for _, err := range startupErrors {
+		slog.ErrorContext(ctx, "Error during container startup", "error", err)
+	}
+
+	m.allContainersLock.RLock()
+	numStarted := len(m.allContainers)
+	m.allContainersLock.RUnlock()
+	if numStarted == 0 {
+		return fmt.Errorf("no containers were started successfully: %w", errors.Join(startupErrors...))
+	}
+	if len(startupErrors) > 0 {
+		slog.WarnContext(ctx, "Some containers failed to start", "failed", len(startupErrors))
+	}
+
+	slog.InfoContext(ctx, "Containers started successfully", "count", numStarted)
+	return nil
 }
 
-func (m *DockerManager) AcquireContainer(ctx context.Context, language string) (*model.ContainerInfo, error) {
-    m.poolsLock.RLock()
-    poolChan, ok := m.availablePools[language]
-    m.poolsLock.RUnlock()
-
-    if !ok {
-        return nil, fmt.Errorf("no container pool available for language: %s", language)
-    }
-
-    log.Printf("Attempting to acquire container for %s...", language)
-    select {
-    case container := <-poolChan:
-        log.Printf("Container %s acquired for %s.", container.ID, language)
-        return container, nil
-    case <-ctx.Done():
-        log.Printf("Context cancelled while waiting for %s container: %v", language, ctx.Err())
-        return nil, fmt.Errorf("failed to acquire %s container: %w", language, ctx.Err())
-    }
+// fill starts n containers for lang concurrently and adds them to p. It
+// returns an error for each container that could not be started.
+func (m *DockerManager) fill(ctx context.Context, lang string, p *pool, n int) []error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(containerIndex int) {
+			defer wg.Done()
+			containInfo, err := m.startContainer(ctx, lang, p, containerIndex)
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			// The read lock keeps Reload and CleanupContainers from closing
+			// p.ch during the send.
+			m.poolsLock.RLock()
+			added := false
+			if !m.shuttingDown.Load() {
+				select {
+				case p.ch <- containInfo:
+					added = true
+				default:
+				}
+			}
+			m.poolsLock.RUnlock()
+			if !added {
+				slog.WarnContext(ctx, "Pool full or closed, removing surplus container", "language", lang, "container_id", containInfo.ID)
+				m.removeContainer(containInfo)
+				return
+			}
+			metrics.PoolIdle.WithLabelValues(lang).Inc()
+			slog.DebugContext(ctx, "Container added to available pool", "language", lang, "container_id", containInfo.ID)
+		}(i)
+	}
+	wg.Wait()
+	close(errChan)
+
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+	return errs
 }
 
-func (m *DockerManager) ReleaseContainer(container *model.ContainerInfo, language string) {
-    if container == nil {
-        log.Println("Warning: Attempted to release a nil container.")
-        return
-    }
-
-    if m.shuttingDown.Load() {
-        log.Printf("Shutdown in progress. Not returning container %s (%s) to pool.", container.ID, language)
-        return
-    }
-
-    m.poolsLock.RLock()
-    poolChan, ok := m.availablePools[language]
-    m.poolsLock.RUnlock()
-
-    if !ok {
-        log.Printf("Warning: No pool found for language %s to release container %s.", language, container.ID)
-        return
-    }
-
-    log.Printf("Releasing container %s for %s", container.ID, language)
-    select {
-    case poolChan <- container:
-        log.Printf("Container %s returned to %s pool.", container.ID, language)
-    default:
-        log.Printf("Warning: Could not return container %s to %s pool (pool might be full or closed).", container.ID, language)
-    }
+// startContainer creates and starts one idle container for p on the host
+// chosen by place, and registers it with the manager.
+func (m *DockerManager) startContainer(ctx context.Context, lang string, p *pool, containerIndex int) (*model.ContainerInfo, error) {
+	n, err := m.place(lang)
+	if err != nil {
+		metrics.ContainerFailures.WithLabelValues(lang, "place").Inc()
+		return nil, fmt.Errorf("failed to place container %d for %s: %w", containerIndex, lang, err)
+	}
+	defer m.unplace(n, lang)
+
+	logger := slog.With("language", lang, "index", containerIndex, "host", n.Name)
+	logger.DebugContext(ctx, "Creating container")
+
+	// Neither submitted code nor the installers, which run as root in the
+	// container, need capabilities; with Docker's defaults root could
+	// ignore file permissions and signal other users' processes.
+	hostConfig := &container.HostConfig{Runtime: p.runtime, SecurityOpt: p.securityOpt, CapDrop: []string{"ALL"}}
+	if m.opts.PackageCache {
+		hostConfig.Mounts = []mount.Mount{packageCacheMount(lang)}
+	}
+	create := func() (container.CreateResponse, error) {
+		return n.cli.ContainerCreate(ctx, &container.Config{
+			Image: p.image,
+			Cmd:   []string{"sleep", "infinity"},
+			Tty:   false,
+		}, hostConfig, nil, nil, "")
+	}
+	resp, err := create()
+	if client.IsErrNotFound(err) {
+		// A host that was down while images were prepared lacks the image.
+		logger.InfoContext(ctx, "Image missing on host, preparing it", "image", p.image)
+		if err = m.ensureImage(ctx, n, lang, p); err == nil {
+			resp, err = create()
+		}
+	}
+	if err != nil {
+		metrics.ContainerFailures.WithLabelValues(lang, "create").Inc()
+		return nil, fmt.Errorf("failed to create container %d for %s on %s: %w", containerIndex, lang, n.Name, err)
+	}
+	containerID := resp.ID
+	logger = logger.With("container_id", containerID)
+	err = n.cli.ContainerStart(ctx, containerID, container.StartOptions{})
+	if err != nil {
+		metrics.ContainerFailures.WithLabelValues(lang, "start").Inc()
+		rmCtx, rmCancel := context.WithTimeout(context.Background(), ContainerCleanupTimeout)
+		defer rmCancel()
+		rmErr := n.cli.ContainerRemove(rmCtx, containerID, container.RemoveOptions{Force: true})
+		if rmErr != nil {
+			logger.WarnContext(ctx, "Failed to remove unstartable container", "error", rmErr)
+		}
+		return nil, fmt.Errorf("failed to start container %d for %s on %s: %w", containerIndex, lang, n.Name, err)
+	}
+
+	logger.InfoContext(ctx, "Started container")
+	metrics.ContainerCreations.WithLabelValues(lang).Inc()
+	metrics.PoolSize.WithLabelValues(lang).Inc()
+
+	containInfo := &model.ContainerInfo{ID: containerID, Language: lang, Image: p.image, Runtime: p.runtime, Security: p.security, Host: n.Name}
+
+	m.allContainersLock.Lock()
+	m.allContainers[containerID] = containInfo
+	m.allContainersLock.Unlock()
+	return containInfo, nil
 }
 
-func (m *DockerManager) GetContainers() []*model.ContainerInfo {
-    m.allContainersLock.RLock()
-    defer m.allContainersLock.RUnlock()
-
-    listCopy := make([]*model.ContainerInfo, 0, len(m.allContainers))
-    for _, c := range m.allContainers {
-        listCopy = append(listCopy, c)
-    }
-    return listCopy
+// removeContainer stops and removes a container that is no longer wanted
+// by any pool.
+func (m *DockerManager) removeContainer(c *model.ContainerInfo) {
+	logger := slog.With("language", c.Language, "container_id", c.ID, "host", c.Host)
+
+	m.allContainersLock.Lock()
+	_, ok := m.allContainers[c.ID]
+	delete(m.allContainers, c.ID)
+	m.allContainersLock.Unlock()
+	if !ok {
+		return
+	}
+	metrics.PoolSize.WithLabelValues(c.Language).Dec()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ContainerCleanupTimeout)
+	defer cancel()
+	if err := m.clientFor(c).ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true}); err != nil {
+		logger.Error("Error removing retired container", "error", err)
+		return
+	}
+	logger.Info("Retired container removed")
 }
 
+// IdleContainers returns the channel of language's idle containers.
+func (m *DockerManager) IdleContainers(language string) (<-chan *model.ContainerInfo, bool) {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	p, ok := m.availablePools[language]
+	if !ok {
+		return nil, false
+	}
+	return p.ch, true
+}
+
+// TakeContainer accepts every idle container: containers leave a pool
+// only by being taken from it, so any still in one may be handed out.
+func (m *DockerManager) TakeContainer(c *model.ContainerInfo) bool {
+	return true
+}
+
+// PutContainer returns a released container to its pool, or retires it if
+// the pool no longer wants it.
+func (m *DockerManager) PutContainer(ctx context.Context, container *model.ContainerInfo, language string) {
+	logger := slog.With("language", language, "container_id", container.ID)
+
+	if m.shuttingDown.Load() {
+		logger.InfoContext(ctx, "Shutdown in progress, not returning container to pool")
+		return
+	}
+
+	m.poolsLock.RLock()
+	p, ok := m.availablePools[language]
+	if ok && p.owns(container) && m.hostUsable(container.Host) {
+		select {
+		case p.ch <- container:
+			metrics.PoolIdle.WithLabelValues(language).Set(float64(len(p.ch)))
+			m.poolsLock.RUnlock()
+			logger.InfoContext(ctx, "Container returned to pool")
+			return
+		default:
+		}
+	}
+	m.poolsLock.RUnlock()
+
+	// The language was removed, its pool moved to another image, runtime or
+	// profile, it shrank, or its host failed or is draining while the
+	// container was in use.
+	logger.InfoContext(ctx, "Container no longer needed by its pool, retiring it")
+	go m.removeContainer(container)
+}
+
+// BusyContainers is the number of containers not sitting idle in a pool.
+func (m *DockerManager) BusyContainers() int {
+	m.allContainersLock.RLock()
+	total := len(m.allContainers)
+	m.allContainersLock.RUnlock()
+
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	for _, p := range m.availablePools {
+		total -= len(p.ch)
+	}
+	return total
+}
+
+func (m *DockerManager) GetContainers() []*model.ContainerInfo {
+	m.allContainersLock.RLock()
+	defer m.allContainersLock.RUnlock()
+
+	listCopy := make([]*model.ContainerInfo, 0, len(m.allContainers))
+	for _, c := range m.allContainers {
+		listCopy = append(listCopy, c)
+	}
+	return listCopy
+}
 
 func (m *DockerManager) CleanupContainers() {
-    m.shuttingDown.Store(true)
-
-    m.poolsLock.Lock()
-    log.Println("Closing all language container pools...")
-    for lang, poolChan := range m.availablePools {
-        close(poolChan)
-        log.Printf("Closed pool for %s.", lang)
-    }
-
-    m.availablePools = make(map[string]chan *model.ContainerInfo)
-    m.poolsLock.Unlock()
-
-    m.allContainersLock.Lock()
-    defer m.allContainersLock.Unlock()
-
-    if len(m.allContainers) == 0 {
-        log.Println("No containers managed by this manager to clean up.")
-        return
-    }
-
-    log.Printf("Stopping and removing %d managed containers...", len(m.allContainers))
-
-    cleanupTimeout := ContainerCleanupTimeout + (time.Second * time.Duration(len(m.allContainers)))
-    cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), cleanupTimeout)
-    defer cancelCleanup()
-
-    var wg sync.WaitGroup
-    for id, c := range m.allContainers {
-        wg.Add(1)
-        go func(containerID string, contInfo *model.ContainerInfo) {
-            defer wg.Done()
-            log.Printf("Stopping container %s...", containerID)
-
-            stopTimeoutSecs := int(ContainerStopTimeout.Seconds())
-            stopOpts := container.StopOptions{Timeout: &stopTimeoutSecs}
-
-            if err := m.cli.ContainerStop(cleanupCtx, containerID, stopOpts); err != nil {
-                if errors.Is(cleanupCtx.Err(), context.DeadlineExceeded) {
-                    log.Printf("Context deadline exceeded before stopping container %s.", containerID)
-                } else if cleanupCtx.Err() != nil {
-                    log.Printf("Context cancelled before stopping container %s: %v", containerID, cleanupCtx.Err())
-                } else {
-                    log.Printf("Error stopping container %s: %v. Attempting removal anyway.", containerID, err)
-                }
-            } else {
-                log.Printf("Container %s stopped.", containerID)
-            }
-
-            if cleanupCtx.Err() != nil {
-                log.Printf("Context done before removing container %s.", containerID)
-                return
-            }
-            log.Printf("Removing container %s...", containerID)
-            removeOpts := container.RemoveOptions{Force: true} // Force remove if stop failed/timed out
-            if err := m.cli.ContainerRemove(cleanupCtx, containerID, removeOpts); err != nil {
-                if errors.Is(cleanupCtx.Err(), context.DeadlineExceeded) {
-                    log.Printf("Context deadline exceeded during removal of container %s.", containerID)
-                } else if cleanupCtx.Err() != nil {
-                    log.Printf("Context cancelled during removal of container %s: %v", containerID, cleanupCtx.Err())
-                } else {
-                    log.Printf("Error removing container %s: %v", containerID, err)
-                }
-            } else {
-                log.Printf("Container %s removed.", containerID)
-            }
-        }(id, c)
-    }
-    wg.Wait()
-
-    if cleanupCtx.Err() == context.DeadlineExceeded {
-        log.Println("Warning: Container cleanup timed out.")
-    } else {
-        log.Println("Finished container cleanup.")
-    }
-    m.allContainers = make(map[string]*model.ContainerInfo)
+	m.shuttingDown.Store(true)
+
+	m.poolsLock.Lock()
+	slog.Info("Closing all language container pools")
+	for lang, p := range m.availablePools {
+		close(p.ch)
+		metrics.PoolIdle.WithLabelValues(lang).Set(0)
+		metrics.PoolSize.WithLabelValues(lang).Set(0)
+		slog.Debug("Closed pool", "language", lang)
+	}
+
+	m.availablePools = make(map[string]*pool)
+	m.poolsLock.Unlock()
+
+	m.allContainersLock.Lock()
+	defer m.allContainersLock.Unlock()
+
+	if len(m.allContainers) == 0 {
+		slog.Info("No containers managed by this manager to clean up")
+		return
+	}
+
+	slog.Info("Stopping and removing managed containers", "count", len(m.allContainers))
+
+	cleanupTimeout := ContainerCleanupTimeout + (time.Second * time.Duration(len(m.allContainers)))
+	cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancelCleanup()
+
+	var wg sync.WaitGroup
+	for id, c := range m.allContainers {
+		wg.Add(1)
+		go func(containerID string, contInfo *model.ContainerInfo) {
+			defer wg.Done()
+			logger := slog.With("container_id", containerID, "host", contInfo.Host)
+			logger.Debug("Stopping container")
+			cli := m.clientFor(contInfo)
+
+			stopTimeoutSecs := int(ContainerStopTimeout.Seconds())
+			stopOpts := container.StopOptions{Timeout: &stopTimeoutSecs}
+
+			if err := cli.ContainerStop(cleanupCtx, containerID, stopOpts); err != nil {
+				if errors.Is(cleanupCtx.Err(), context.DeadlineExceeded) {
+					logger.Warn("Context deadline exceeded before stopping container")
+				} else if cleanupCtx.Err() != nil {
+					logger.Warn("Context cancelled before stopping container", "error", cleanupCtx.Err())
+				} else {
+					logger.Warn("Error stopping container, attempting removal anyway", "error", err)
+				}
+			} else {
+				logger.Debug("Container stopped")
+			}
+
+			if cleanupCtx.Err() != nil {
+				logger.Warn("Context done before removing container")
+				return
+			}
+			removeOpts := container.RemoveOptions{Force: true} // Force remove if stop failed/timed out
+			if err := cli.ContainerRemove(cleanupCtx, containerID, removeOpts); err != nil {
+				if errors.Is(cleanupCtx.Err(), context.DeadlineExceeded) {
+					logger.Warn("Context deadline exceeded during removal of container")
+				} else if cleanupCtx.Err() != nil {
+					logger.Warn("Context cancelled during removal of container", "error", cleanupCtx.Err())
+				} else {
+					logger.Error("Error removing container", "error", err)
+				}
+			} else {
+				logger.Info("Container removed")
+			}
+		}(id, c)
+	}
+	wg.Wait()
+
+	if cleanupCtx.Err() == context.DeadlineExceeded {
+		slog.Warn("Container cleanup timed out")
+	} else {
+		slog.Info("Finished container cleanup")
+	}
+	m.allContainers = make(map[string]*model.ContainerInfo)
 }
 
 func (m *DockerManager) Close() {
-    log.Println("Closing Docker Client...")
-    if err := m.cli.Close(); err != nil {
-        log.Printf("Error closing Docker client: %v", err)
-    } else {
-        log.Println("Docker client closed successfully.")
-    }
+	for _, n := range m.nodes {
+		slog.Info("Closing Docker client", "host", n.Name)
+		if err := n.cli.Close(); err != nil {
+			slog.Error("Error closing Docker client", "host", n.Name, "error", err)
+		} else {
+			slog.Info("Docker client closed successfully", "host", n.Name)
+		}
+	}
+}
+
+// Command returns the argv that code for language is appended to, and
+// whether the language is configured.
+func (m *DockerManager) Command(language string) ([]string, bool) {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	lang, ok := m.languages[language]
+	return lang.Command, ok
+}
+
+// Formatter returns the code formatter configured for language, or "" if
+// it has none.
+func (m *DockerManager) Formatter(language string) string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].Formatter
 }
 
-func (m *DockerManager) GetClient() *client.Client {
-    return m.cli
-}
\ No newline at end of file
+// Linter returns the linter configured for language and the extra
+// arguments it is run with, or "" if it has none.
+func (m *DockerManager) Linter(language string) (string, []string) {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	lang := m.languages[language]
+	return lang.Linter, lang.LinterArgs
+}
+
+// LanguageServer returns the argv of language's LSP server, or nil if it
+// has none.
+func (m *DockerManager) LanguageServer(language string) []string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].LanguageServer
+}
+
+// Kernel returns the REPL kernel configured for language, or "" if it has
+// none.
+func (m *DockerManager) Kernel(language string) string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].Kernel
+}
+
+// Exec runs cmd in c, a container of this manager, on its host.
+func (m *DockerManager) Exec(ctx context.Context, c *model.ContainerInfo, cmd []string, opts model.ExecOptions) (string, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "ExecuteCode",
+		trace.WithAttributes(attribute.String("container.id", c.ID)),
+	)
+	defer span.End()
+
+	output, err := c.ExecuteCode(cmd, opts, tracedExec{m.clientFor(c), c.ID}, ctx)
+	var exitErr *model.ExitError
+	switch {
+	case ctx.Err() != nil:
+		span.SetStatus(codes.Error, "execution timed out")
+	case errors.As(err, &exitErr):
+		span.SetAttributes(attribute.Int("exit_code", exitErr.ExitCode))
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		span.SetAttributes(attribute.Int("exit_code", 0))
+	}
+	return output, err
+}
+
+// StartProcess starts cmd in c, a container of this manager, with its
+// streams attached.
+func (m *DockerManager) StartProcess(ctx context.Context, c *model.ContainerInfo, cmd []string, opts model.ExecOptions) (model.Process, error) {
+	return c.StartProcess(ctx, cmd, opts, tracedExec{m.clientFor(c), c.ID})
+}
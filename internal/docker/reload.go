@@ -0,0 +1,271 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// Reload applies a new language configuration without a restart:
+//
+//   - new languages get a pool, which is filled before it accepts work;
+//   - removed languages stop accepting work at once, idle containers are
+//     removed and busy ones are retired when released;
+//   - a changed image, runtime or security profile is rolled out blue/green: a full new pool
+//     is started next to the old one and swapped in, and old containers are
+//     retired as they go idle, so running executions are never interrupted;
+//   - a changed pool size keeps existing containers, starting or retiring
+//     the difference.
+//
+// Each language must have PoolSize and Command resolved. A language whose
+// new pool cannot start a single container keeps its old configuration and
+// is reported in Failed; the other changes still apply.
+func (m *DockerManager) Reload(ctx context.Context, languages map[string]config.LanguageConfig) (sandbox.ReloadResult, error) {
+	if len(languages) == 0 {
+		return sandbox.ReloadResult{}, errors.New("no languages configured")
+	}
+	for name, lang := range languages {
+		if lang.PoolSize <= 0 {
+			return sandbox.ReloadResult{}, fmt.Errorf("invalid number of containers for %s: %d", name, lang.PoolSize)
+		}
+		if len(lang.Command) == 0 {
+			return sandbox.ReloadResult{}, fmt.Errorf("no command configured for %s", name)
+		}
+	}
+
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	if m.Draining() || m.shuttingDown.Load() {
+		return sandbox.ReloadResult{}, sandbox.ErrShuttingDown
+	}
+
+	m.poolsLock.RLock()
+	current := make(map[string]config.LanguageConfig, len(m.languages))
+	for name, lang := range m.languages {
+		current[name] = lang
+	}
+	m.poolsLock.RUnlock()
+
+	result := sandbox.ReloadResult{Failed: make(map[string]string)}
+
+	// Runtimes, profiles and images are resolved before comparing, so an
+	// edited Dockerfile or seccomp profile or a tag that moved counts as a
+	// change.
+	languages, failed, err := m.resolveRuntimes(ctx, languages)
+	if err != nil {
+		return sandbox.ReloadResult{}, err
+	}
+	languages, securityFailures, err := m.resolveSecurity(ctx, languages)
+	if err != nil {
+		return sandbox.ReloadResult{}, err
+	}
+	maps.Copy(failed, securityFailures)
+	languages, imageFailures := m.prepareImages(ctx, languages)
+	maps.Copy(failed, imageFailures)
+	for name, err := range failed {
+		result.Failed[name] = err.Error()
+		if old, ok := current[name]; ok {
+			languages[name] = old
+		}
+	}
+
+	for name, lang := range languages {
+		if _, ok := failed[name]; ok {
+			continue
+		}
+		old, exists := current[name]
+		logger := slog.With("language", name, "image", lang.Image, "pool_size", lang.PoolSize)
+
+		switch {
+		case !exists:
+			if err := m.addPool(ctx, name, lang); err != nil {
+				logger.ErrorContext(ctx, "Failed to add language", "error", err)
+				result.Failed[name] = err.Error()
+				continue
+			}
+			logger.InfoContext(ctx, "Language added")
+			result.Added = append(result.Added, name)
+		case old.Image != lang.Image || old.Runtime != lang.Runtime || !slices.Equal(old.SecurityOpt, lang.SecurityOpt):
+			logger = logger.With("runtime", lang.Runtime, "previous_image", old.Image, "previous_runtime", old.Runtime)
+			if err := m.replacePool(ctx, name, lang); err != nil {
+				logger.ErrorContext(ctx, "Failed to roll out new image", "error", err)
+				result.Failed[name] = err.Error()
+				continue
+			}
+			logger.InfoContext(ctx, "Language moved to new image")
+			result.Updated = append(result.Updated, name)
+		case old.PoolSize != lang.PoolSize:
+			if err := m.resizePool(ctx, name, lang); err != nil {
+				logger.WarnContext(ctx, "Pool resized but not fully filled", "error", err)
+				result.Failed[name] = err.Error()
+			}
+			logger.InfoContext(ctx, "Language pool resized", "previous_pool_size", old.PoolSize)
+			result.Resized = append(result.Resized, name)
+		case !slices.Equal(old.Command, lang.Command) || old.Installer != lang.Installer || old.PackageIndex != lang.PackageIndex ||
+			old.Formatter != lang.Formatter || old.Linter != lang.Linter || !slices.Equal(old.LinterArgs, lang.LinterArgs) ||
+			!slices.Equal(old.LanguageServer, lang.LanguageServer) || old.Kernel != lang.Kernel:
+			// These apply per execution, so the pool is left alone.
+			m.poolsLock.Lock()
+			m.languages[name] = lang
+			m.poolsLock.Unlock()
+			logger.InfoContext(ctx, "Language settings changed")
+			result.Updated = append(result.Updated, name)
+		default:
+			result.Unchanged = append(result.Unchanged, name)
+		}
+	}
+
+	for name := range current {
+		if _, ok := languages[name]; ok {
+			continue
+		}
+		m.removePool(name)
+		slog.InfoContext(ctx, "Language removed; busy containers will be retired when released", "language", name)
+		result.Removed = append(result.Removed, name)
+	}
+
+	for _, names := range [][]string{result.Added, result.Removed, result.Updated, result.Resized, result.Unchanged} {
+		sort.Strings(names)
+	}
+	if len(result.Failed) == 0 {
+		result.Failed = nil
+	}
+	return result, nil
+}
+
+// addPool starts a pool for a language that has none and installs it.
+func (m *DockerManager) addPool(ctx context.Context, name string, lang config.LanguageConfig) error {
+	p := newPool(lang)
+	if errs := m.fill(ctx, name, p, p.size); len(p.ch) == 0 {
+		return fmt.Errorf("no containers started: %w", errors.Join(errs...))
+	}
+
+	m.poolsLock.Lock()
+	m.languages[name] = lang
+	m.availablePools[name] = p
+	metrics.PoolIdle.WithLabelValues(name).Set(float64(len(p.ch)))
+	m.poolsLock.Unlock()
+	return nil
+}
+
+// replacePool fills a pool on the new image, runtime or profiles and only
+// then swaps it in for the old one. Idle old containers are removed; busy
+// ones are retired by PutContainer because the new pool does not own them.
+func (m *DockerManager) replacePool(ctx context.Context, name string, lang config.LanguageConfig) error {
+	p := newPool(lang)
+	if errs := m.fill(ctx, name, p, p.size); len(p.ch) == 0 {
+		return fmt.Errorf("no containers started on %s: %w", lang.Image, errors.Join(errs...))
+	}
+
+	m.poolsLock.Lock()
+	old := m.availablePools[name]
+	m.languages[name] = lang
+	m.availablePools[name] = p
+	close(old.ch)
+	metrics.PoolIdle.WithLabelValues(name).Set(float64(len(p.ch)))
+	m.poolsLock.Unlock()
+
+	for c := range old.ch {
+		m.removeContainer(c)
+	}
+	return nil
+}
+
+// resizePool swaps in a pool of the new size on the same image, carrying
+// idle containers across, then starts any containers still missing.
+func (m *DockerManager) resizePool(ctx context.Context, name string, lang config.LanguageConfig) error {
+	p := newPool(lang)
+	var surplus []*model.ContainerInfo
+
+	m.poolsLock.Lock()
+	old := m.availablePools[name]
+	m.languages[name] = lang
+	m.availablePools[name] = p
+	close(old.ch)
+	for c := range old.ch {
+		select {
+		case p.ch <- c:
+		default:
+			surplus = append(surplus, c)
+		}
+	}
+	metrics.PoolIdle.WithLabelValues(name).Set(float64(len(p.ch)))
+	m.poolsLock.Unlock()
+
+	for _, c := range surplus {
+		m.removeContainer(c)
+	}
+
+	// Busy containers on this image come back to the new pool when
+	// released, so they count towards its size.
+	missing := p.size - m.countContainers(name, p)
+	if missing <= 0 {
+		return nil
+	}
+	if errs := m.fill(ctx, name, p, missing); len(errs) > 0 {
+		return fmt.Errorf("%d of %d new containers failed to start: %w", len(errs), missing, errors.Join(errs...))
+	}
+	return nil
+}
+
+// removePool stops handing out containers for a language and removes its
+// idle ones.
+func (m *DockerManager) removePool(name string) {
+	m.poolsLock.Lock()
+	old := m.availablePools[name]
+	delete(m.availablePools, name)
+	delete(m.languages, name)
+	close(old.ch)
+	metrics.PoolIdle.WithLabelValues(name).Set(0)
+	m.poolsLock.Unlock()
+
+	for c := range old.ch {
+		m.removeContainer(c)
+	}
+}
+
+// countContainers counts the containers of a language that p owns, idle or
+// busy, on usable hosts.
+func (m *DockerManager) countContainers(language string, p *pool) int {
+	m.allContainersLock.RLock()
+	defer m.allContainersLock.RUnlock()
+	n := 0
+	for _, c := range m.allContainers {
+		if c.Language == language && p.owns(c) && m.hostUsable(c.Host) {
+			n++
+		}
+	}
+	return n
+}
+
+// Pools reports the state of every language's pool, sorted by language.
+func (m *DockerManager) Pools() []sandbox.PoolStatus {
+	m.poolsLock.RLock()
+	statuses := make([]sandbox.PoolStatus, 0, len(m.availablePools))
+	for name, p := range m.availablePools {
+		statuses = append(statuses, sandbox.PoolStatus{Language: name, Image: p.image, Runtime: p.runtime, Security: p.security, Size: p.size, Idle: len(p.ch)})
+	}
+	m.poolsLock.RUnlock()
+
+	m.allContainersLock.RLock()
+	for i := range statuses {
+		for _, c := range m.allContainers {
+			if c.Language == statuses[i].Language {
+				statuses[i].Containers++
+			}
+		}
+	}
+	m.allContainersLock.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Language < statuses[j].Language })
+	return statuses
+}
@@ -0,0 +1,207 @@
+package docker
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// language is the configuration of a language as CheckRuntimes leaves it.
+func language(image string, size int) config.LanguageConfig {
+	return config.LanguageConfig{Image: image, Runtime: "runc", PoolSize: size, Command: []string{"run"}}
+}
+
+// startLanguages starts pools for languages, as at startup.
+func startLanguages(t *testing.T, m *DockerManager, languages map[string]config.LanguageConfig) {
+	t.Helper()
+	m.poolsLock.Lock()
+	m.languages = maps.Clone(languages)
+	m.poolsLock.Unlock()
+	if err := m.StartInitialContainers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkOut(t *testing.T, m *DockerManager, language string) *model.ContainerInfo {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := m.AcquireContainer(ctx, language, sandbox.AcquireOptions{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// poolStatus returns the status of language's pool.
+func poolStatus(t *testing.T, m *DockerManager, language string) sandbox.PoolStatus {
+	t.Helper()
+	for _, status := range m.Pools() {
+		if status.Language == language {
+			return status
+		}
+	}
+	t.Fatalf("no %s pool", language)
+	return sandbox.PoolStatus{}
+}
+
+// waitContainers waits until language has n containers; retired ones are
+// removed in the background.
+func waitContainers(t *testing.T, m *DockerManager, language string, n int) {
+	t.Helper()
+	count := func() int {
+		total := 0
+		for _, c := range m.GetContainers() {
+			if c.Language == language {
+				total++
+			}
+		}
+		return total
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for count() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has %d containers, want %d", language, count(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadResizesPools(t *testing.T) {
+	ctx := context.Background()
+	m, daemons := newTestManager(Host{Name: "a"})
+	startLanguages(t, m, map[string]config.LanguageConfig{"python": language("python:3.11", 2)})
+
+	// The container checked out across the reload counts towards the new
+	// size, so only two are started.
+	busy := checkOut(t, m, "python")
+	result, err := m.Reload(ctx, map[string]config.LanguageConfig{"python": language("python:3.11", 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Resized, []string{"python"}) || result.Failed != nil {
+		t.Fatalf("growing the pool: result = %+v, want python resized", result)
+	}
+	if status := poolStatus(t, m, "python"); status.Size != 4 || status.Idle != 3 || status.Containers != 4 {
+		t.Errorf("after growing, pool = %+v, want size 4 with 3 idle of 4", status)
+	}
+	m.ReleaseContainer(ctx, busy, "python")
+	if status := poolStatus(t, m, "python"); status.Idle != 4 {
+		t.Errorf("after release, %d idle, want 4", status.Idle)
+	}
+
+	// Shrinking removes idle containers at once and retires the checked
+	// out one when it comes back to a full pool.
+	busy = checkOut(t, m, "python")
+	result, err = m.Reload(ctx, map[string]config.LanguageConfig{"python": language("python:3.11", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Resized, []string{"python"}) {
+		t.Fatalf("shrinking the pool: result = %+v, want python resized", result)
+	}
+	if status := poolStatus(t, m, "python"); status.Size != 1 || status.Idle != 1 || status.Containers != 2 {
+		t.Errorf("after shrinking, pool = %+v, want size 1 with 1 idle of 2", status)
+	}
+	m.ReleaseContainer(ctx, busy, "python")
+	waitContainers(t, m, "python", 1)
+	if removed := daemons["a"].removals(); removed != 3 {
+		t.Errorf("%d containers removed, want 3", removed)
+	}
+	if got := checkOut(t, m, "python"); got == busy {
+		t.Error("the retired container was handed out again")
+	}
+}
+
+func TestReloadAddsAndRemovesLanguagesInUse(t *testing.T) {
+	ctx := context.Background()
+	m, daemons := newTestManager(Host{Name: "a"})
+	startLanguages(t, m, map[string]config.LanguageConfig{
+		"python":     language("python:3.11", 1),
+		"javascript": language("node:20", 1),
+	})
+	python := checkOut(t, m, "python")
+	javascript := checkOut(t, m, "javascript")
+
+	result, err := m.Reload(ctx, map[string]config.LanguageConfig{
+		"python": language("python:3.11", 1),
+		"ruby":   language("ruby:3.3", 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Added, []string{"ruby"}) || !slices.Equal(result.Removed, []string{"javascript"}) ||
+		!slices.Equal(result.Unchanged, []string{"python"}) || result.Failed != nil {
+		t.Fatalf("result = %+v, want ruby added, javascript removed and python unchanged", result)
+	}
+	if _, ok := m.Command("javascript"); ok {
+		t.Error("javascript still configured after it was removed")
+	}
+	if _, err := m.AcquireContainer(ctx, "javascript", sandbox.AcquireOptions{User: "bob"}); err == nil {
+		t.Error("acquired a javascript container after the language was removed")
+	}
+	if status := poolStatus(t, m, "ruby"); status.Idle != 1 {
+		t.Errorf("ruby pool = %+v, want 1 idle", status)
+	}
+
+	// The removed language's container lives until it is released, and the
+	// kept language's goes back to its pool.
+	waitContainers(t, m, "javascript", 1)
+	m.ReleaseContainer(ctx, javascript, "javascript")
+	waitContainers(t, m, "javascript", 0)
+	daemons["a"].mu.Lock()
+	removed := slices.Clone(daemons["a"].removed)
+	daemons["a"].mu.Unlock()
+	if !slices.Equal(removed, []string{javascript.ID}) {
+		t.Errorf("removed %v, want only %s", removed, javascript.ID)
+	}
+	m.ReleaseContainer(ctx, python, "python")
+	if status := poolStatus(t, m, "python"); status.Idle != 1 || status.Containers != 1 {
+		t.Errorf("python pool = %+v, want its container back", status)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	ctx := context.Background()
+	m, daemons := newTestManager(Host{Name: "a"})
+	startLanguages(t, m, map[string]config.LanguageConfig{"python": language("python:3.11", 2)})
+	before := m.Pools()
+	created := len(daemons["a"].created)
+
+	noCommand := language("python:3.11", 2)
+	noCommand.Command = nil
+	for name, languages := range map[string]map[string]config.LanguageConfig{
+		"no languages": {},
+		"empty pool":   {"python": language("python:3.11", 0)},
+		"no command":   {"python": noCommand, "ruby": language("ruby:3.3", 1)},
+	} {
+		if _, err := m.Reload(ctx, languages); err == nil {
+			t.Errorf("%s: Reload succeeded, want an error", name)
+		}
+	}
+	if after := m.Pools(); !slices.Equal(after, before) {
+		t.Errorf("pools after rejected reloads = %+v, want %+v", after, before)
+	}
+
+	// A language whose new image is unavailable keeps its running pool.
+	daemons["a"].missing = map[string]bool{"python:3.12": true}
+	result, err := m.Reload(ctx, map[string]config.LanguageConfig{"python": language("python:3.12", 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Failed["python"]; !ok || len(result.Updated) != 0 {
+		t.Errorf("result = %+v, want python failed", result)
+	}
+	if after := m.Pools(); !slices.Equal(after, before) {
+		t.Errorf("pools after a failed image change = %+v, want %+v", after, before)
+	}
+	if n := len(daemons["a"].created); n != created || daemons["a"].removals() != 0 {
+		t.Errorf("containers created or removed by rejected reloads: %d created, %d removed", n-created, daemons["a"].removals())
+	}
+}
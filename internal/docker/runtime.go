@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+)
+
+// resolveRuntimes checks every language's OCI runtime against those
+// registered with every usable host and returns the languages with Runtime set to
+// the runtime their containers will actually use, so it can be reported.
+// An empty runtime resolves to the daemon's default. A runtime the daemon
+// does not know falls back to the default under the "default" policy and
+// is otherwise reported in the error map.
+func (m *DockerManager) resolveRuntimes(ctx context.Context, languages map[string]config.LanguageConfig) (map[string]config.LanguageConfig, map[string]error, error) {
+	f, err := m.features(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying Docker daemon runtimes: %w", err)
+	}
+	registered := make([]string, 0, len(f.runtimes))
+	for name := range f.runtimes {
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+
+	resolved := make(map[string]config.LanguageConfig, len(languages))
+	failed := make(map[string]error)
+	for name, lang := range languages {
+		switch ok := f.runtimes[lang.Runtime]; {
+		case lang.Runtime == "":
+			lang.Runtime = f.defaultRuntime
+		case ok:
+		case m.opts.RuntimeFallback == config.RuntimeFallbackDefault:
+			slog.WarnContext(ctx, "Runtime not registered with the Docker daemon, falling back to the default",
+				"language", name, "runtime", lang.Runtime, "default_runtime", f.defaultRuntime, "registered", registered)
+			lang.Runtime = f.defaultRuntime
+		default:
+			failed[name] = fmt.Errorf("runtime %q is not registered with every Docker host (registered: %s)",
+				lang.Runtime, strings.Join(registered, ", "))
+			continue
+		}
+		resolved[name] = lang
+	}
+	return resolved, failed, nil
+}
+
+// CheckRuntimes resolves the runtime of every configured language; see
+// resolveRuntimes. It fails if any runtime is unavailable under the fail
+// policy, so a misconfigured sandbox is caught at startup rather than
+// silently running code under a weaker runtime.
+func (m *DockerManager) CheckRuntimes(ctx context.Context) error {
+	m.poolsLock.RLock()
+	languages := m.languages
+	m.poolsLock.RUnlock()
+
+	resolved, failed, err := m.resolveRuntimes(ctx, languages)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		var errs []error
+		for name, err := range failed {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		return fmt.Errorf("%w\ninstall the runtime or set exec.runtime_fallback to default", errors.Join(errs...))
+	}
+
+	for name, lang := range resolved {
+		slog.InfoContext(ctx, "Sandbox runtime", "language", name, "runtime", lang.Runtime)
+	}
+	m.poolsLock.Lock()
+	m.languages = resolved
+	m.poolsLock.Unlock()
+	return nil
+}
@@ -0,0 +1,221 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+)
+
+// appArmorProfiles lists the profiles loaded into the kernel.
+const appArmorProfiles = "/sys/kernel/security/apparmor/profiles"
+
+var seccompActions = map[string]bool{
+	"SCMP_ACT_KILL":         true,
+	"SCMP_ACT_KILL_PROCESS": true,
+	"SCMP_ACT_KILL_THREAD":  true,
+	"SCMP_ACT_TRAP":         true,
+	"SCMP_ACT_ERRNO":        true,
+	"SCMP_ACT_TRACE":        true,
+	"SCMP_ACT_ALLOW":        true,
+	"SCMP_ACT_LOG":          true,
+	"SCMP_ACT_NOTIFY":       true,
+}
+
+var seccompOperators = map[string]bool{
+	"SCMP_CMP_NE":        true,
+	"SCMP_CMP_LT":        true,
+	"SCMP_CMP_LE":        true,
+	"SCMP_CMP_EQ":        true,
+	"SCMP_CMP_GE":        true,
+	"SCMP_CMP_GT":        true,
+	"SCMP_CMP_MASKED_EQ": true,
+}
+
+// seccompProfile is the part of Docker's seccomp profile format that is
+// checked before a profile is handed to the daemon.
+type seccompProfile struct {
+	DefaultAction string `json:"defaultAction"`
+	Syscalls      []struct {
+		Name   string   `json:"name"`
+		Names  []string `json:"names"`
+		Action string   `json:"action"`
+		Args   []struct {
+			Index uint   `json:"index"`
+			Op    string `json:"op"`
+		} `json:"args"`
+	} `json:"syscalls"`
+}
+
+// loadSeccompProfile reads and validates the profile at path and returns it
+// compacted, ready to be passed as a "seccomp=" security option.
+func loadSeccompProfile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var profile seccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return "", fmt.Errorf("parsing seccomp profile %s: %w", path, err)
+	}
+
+	var errs []error
+	if !seccompActions[profile.DefaultAction] {
+		errs = append(errs, fmt.Errorf("defaultAction %q is not a seccomp action", profile.DefaultAction))
+	}
+	for i, rule := range profile.Syscalls {
+		if rule.Name == "" && len(rule.Names) == 0 {
+			errs = append(errs, fmt.Errorf("syscalls[%d] names no syscalls", i))
+		}
+		if !seccompActions[rule.Action] {
+			errs = append(errs, fmt.Errorf("syscalls[%d].action %q is not a seccomp action", i, rule.Action))
+		}
+		for j, arg := range rule.Args {
+			if arg.Index > 5 {
+				errs = append(errs, fmt.Errorf("syscalls[%d].args[%d].index %d is out of range", i, j, arg.Index))
+			}
+			if !seccompOperators[arg.Op] {
+				errs = append(errs, fmt.Errorf("syscalls[%d].args[%d].op %q is not a seccomp operator", i, j, arg.Op))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("invalid seccomp profile %s: %w", path, errors.Join(errs...))
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return "", err
+	}
+	return compact.String(), nil
+}
+
+// resolveSecurity loads the seccomp profile and checks the AppArmor profile
+// of every language, and returns the languages with SecurityOpt set to the
+// options their containers are created with. Languages whose profiles are
+// unusable are reported in the error map. Profiles are re-read on every
+// call, so an edited profile is picked up by the next reload.
+func (m *DockerManager) resolveSecurity(ctx context.Context, languages map[string]config.LanguageConfig) (map[string]config.LanguageConfig, map[string]error, error) {
+	f, err := m.features(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying Docker daemon security options: %w", err)
+	}
+
+	// Loaded AppArmor profiles can only be listed for a daemon on this
+	// machine; elsewhere the daemon reports a missing one on create.
+	var loaded map[string]bool
+	if f.apparmor && f.local {
+		loaded, err = loadedAppArmorProfiles()
+		if err != nil {
+			slog.DebugContext(ctx, "Cannot list loaded AppArmor profiles", "error", err)
+		}
+	}
+
+	profiles := make(map[string]string)
+	resolved := make(map[string]config.LanguageConfig, len(languages))
+	failed := make(map[string]error)
+	for name, lang := range languages {
+		lang.SecurityOpt = nil
+		if path := lang.SeccompProfile; path != "" {
+			if !f.seccomp {
+				failed[name] = errors.New("not every Docker host supports seccomp")
+				continue
+			}
+			profile, ok := profiles[path]
+			if !ok {
+				if profile, err = loadSeccompProfile(path); err != nil {
+					failed[name] = err
+					continue
+				}
+				profiles[path] = profile
+			}
+			lang.SecurityOpt = append(lang.SecurityOpt, "seccomp="+profile)
+		}
+		if profile := lang.AppArmorProfile; profile != "" {
+			if !f.apparmor {
+				failed[name] = errors.New("AppArmor is not enabled on every Docker host")
+				continue
+			}
+			if loaded != nil && !loaded[profile] {
+				failed[name] = fmt.Errorf("AppArmor profile %q is not loaded; load it with apparmor_parser", profile)
+				continue
+			}
+			lang.SecurityOpt = append(lang.SecurityOpt, "apparmor="+profile)
+		}
+		resolved[name] = lang
+	}
+	return resolved, failed, nil
+}
+
+// loadedAppArmorProfiles returns the names of the profiles loaded into the
+// kernel.
+func loadedAppArmorProfiles() (map[string]bool, error) {
+	f, err := os.Open(appArmorProfiles)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	loaded := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines read "name (mode)".
+		name, _, _ := strings.Cut(scanner.Text(), " (")
+		loaded[name] = true
+	}
+	return loaded, scanner.Err()
+}
+
+// CheckSecurityProfiles loads and validates the seccomp and AppArmor
+// profiles of every configured language; see resolveSecurity. It fails if
+// any is unusable, so containers never start with weaker confinement than
+// configured.
+func (m *DockerManager) CheckSecurityProfiles(ctx context.Context) error {
+	m.poolsLock.RLock()
+	languages := m.languages
+	m.poolsLock.RUnlock()
+
+	resolved, failed, err := m.resolveSecurity(ctx, languages)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		var errs []error
+		for name, err := range failed {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		return errors.Join(errs...)
+	}
+
+	for name, lang := range resolved {
+		slog.InfoContext(ctx, "Sandbox security profiles", "language", name,
+			"seccomp_profile", lang.SeccompProfile, "apparmor_profile", lang.AppArmorProfile)
+	}
+	m.poolsLock.Lock()
+	m.languages = resolved
+	m.poolsLock.Unlock()
+	return nil
+}
+
+// securityID identifies a set of security options, so a pool can tell
+// containers started under other profiles apart without keeping them.
+func securityID(opts []string) string {
+	if len(opts) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, opt := range opts {
+		h.Write([]byte(opt))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/Aadithya-J/alcaIDE/internal/telemetry"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedExec wraps the exec calls of a Docker client in a span each, so
+// model's exec helpers are traced without depending on telemetry.
+type tracedExec struct {
+	cli         model.ExecClient
+	containerID string
+}
+
+// dockerSpan starts a child span for a single Docker API call.
+func dockerSpan(ctx context.Context, op, containerID string) (context.Context, trace.Span) {
+	return telemetry.Tracer().Start(ctx, "docker."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("container.id", containerID)),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t tracedExec) ContainerExecCreate(ctx context.Context, c string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	ctx, span := dockerSpan(ctx, "ContainerExecCreate", t.containerID)
+	resp, err := t.cli.ContainerExecCreate(ctx, c, options)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t tracedExec) ContainerExecAttach(ctx context.Context, execID string, options container.ExecAttachOptions) (types.HijackedResponse, error) {
+	ctx, span := dockerSpan(ctx, "ContainerExecAttach", t.containerID)
+	resp, err := t.cli.ContainerExecAttach(ctx, execID, options)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t tracedExec) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	ctx, span := dockerSpan(ctx, "ContainerExecInspect", t.containerID)
+	resp, err := t.cli.ContainerExecInspect(ctx, execID)
+	endSpan(span, err)
+	return resp, err
+}
@@ -0,0 +1,183 @@
+// Package format describes the code formatters /format runs inside sandbox
+// containers: how each is invoked on code read from stdin, and how its
+// syntax errors are turned into diagnostics.
+package format
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// Formatter runs one formatter over stdin, writing the formatted code to
+// stdout.
+type Formatter struct {
+	// Name is the formatter's config name, such as "black".
+	Name string
+	// command returns the argv for code in language.
+	command func(language string) []string
+	// Env is set for the run. The container's root filesystem may be
+	// read-only, so caches are pointed at /tmp.
+	Env []string
+	// parse extracts diagnostics from one line of the formatter's output,
+	// reading following lines from rest when an error spans several.
+	parse func(line string, rest []string) (model.Diagnostic, bool)
+}
+
+// Command returns the argv that formats code in language.
+func (f *Formatter) Command(language string) []string {
+	return f.command(language)
+}
+
+// Diagnostics extracts the errors a failed run reported, with 1-based
+// lines and columns. Output is a model.ExitError's, stdout then stderr.
+func (f *Formatter) Diagnostics(output string) []model.Diagnostic {
+	lines := strings.Split(output, "\n")
+	var diagnostics []model.Diagnostic
+	for i, line := range lines {
+		d, ok := f.parse(strings.TrimRight(line, "\r"), lines[i+1:])
+		if !ok {
+			continue
+		}
+		if d.Severity == "" {
+			d.Severity = model.SeverityError
+		}
+		d.Source = f.Name
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+var formatters = map[string]*Formatter{
+	config.FormatterBlack: {
+		Name:    config.FormatterBlack,
+		command: fixed("black", "--quiet", "-"),
+		Env:     []string{"BLACK_CACHE_DIR=/tmp/black-cache"},
+		parse:   parseBlack,
+	},
+	config.FormatterPrettier: {
+		Name: config.FormatterPrettier,
+		// The file name only selects the parser; nothing is read or written.
+		command: func(language string) []string {
+			return []string{"prettier", "--no-config", "--stdin-filepath", "main." + extension(language, "js")}
+		},
+		parse: parsePrettier,
+	},
+	config.FormatterGofmt: {
+		Name:    config.FormatterGofmt,
+		command: fixed("gofmt"),
+		parse:   parseGofmt,
+	},
+	config.FormatterClangFormat: {
+		Name: config.FormatterClangFormat,
+		command: func(language string) []string {
+			return []string{"clang-format", "--assume-filename=main." + extension(language, "c")}
+		},
+		parse: parseCompilerStyle,
+	},
+	config.FormatterRustfmt: {
+		Name:    config.FormatterRustfmt,
+		command: fixed("rustfmt", "--edition", "2021"),
+		parse:   parseRustfmt,
+	},
+}
+
+// Lookup returns the formatter called name.
+func Lookup(name string) (*Formatter, bool) {
+	f, ok := formatters[name]
+	return f, ok
+}
+
+func fixed(cmd ...string) func(string) []string {
+	return func(string) []string { return cmd }
+}
+
+// extension is the file extension formatters expect for language.
+func extension(language, fallback string) string {
+	switch language {
+	case "javascript":
+		return "js"
+	case "typescript":
+		return "ts"
+	case "c":
+		return "c"
+	case "cpp":
+		return "cpp"
+	}
+	return fallback
+}
+
+var (
+	// error: cannot format -: Cannot parse for target version Python 3.12: 2:11: def f(:
+	blackError = regexp.MustCompile(`Cannot parse[^:]*: (\d+):(\d+): (.*)$`)
+	// [error] main.js: SyntaxError: Unexpected token (1:5)
+	prettierError = regexp.MustCompile(`^\[error\] [^:]+: (.*) \((\d+):(\d+)\)$`)
+	// <standard input>:3:9: expected ';', found x
+	gofmtError = regexp.MustCompile(`^<standard input>:(\d+):(\d+): (.*)$`)
+	// main.c:3:9: error: expected ';'
+	compilerError = regexp.MustCompile(`^[^:\s]+:(\d+):(\d+): (?:(error|warning): )?(.*)$`)
+	// error: expected one of `(` or `<`, found `{`
+	rustfmtError = regexp.MustCompile(`^(error|warning)(?:\[\w+\])?: (.*)$`)
+	//  --> <stdin>:1:8
+	rustfmtLocation = regexp.MustCompile(`^\s*--> [^:]+:(\d+):(\d+)$`)
+)
+
+// parseBlack reads black's parse errors. Black reports 0-based columns
+// and echoes the offending line as the message.
+func parseBlack(line string, _ []string) (model.Diagnostic, bool) {
+	m := blackError.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	return model.Diagnostic{
+		Line:    atoi(m[1]),
+		Column:  atoi(m[2]) + 1,
+		Message: "Cannot parse: " + m[3],
+	}, true
+}
+
+func parsePrettier(line string, _ []string) (model.Diagnostic, bool) {
+	m := prettierError.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	return model.Diagnostic{Line: atoi(m[2]), Column: atoi(m[3]), Message: m[1]}, true
+}
+
+func parseGofmt(line string, _ []string) (model.Diagnostic, bool) {
+	m := gofmtError.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	return model.Diagnostic{Line: atoi(m[1]), Column: atoi(m[2]), Message: m[3]}, true
+}
+
+func parseCompilerStyle(line string, _ []string) (model.Diagnostic, bool) {
+	m := compilerError.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	return model.Diagnostic{Line: atoi(m[1]), Column: atoi(m[2]), Severity: m[3], Message: m[4]}, true
+}
+
+// parseRustfmt reads rustc-style errors, whose location follows the
+// message on a "-->" line. Summary lines without one are skipped.
+func parseRustfmt(line string, rest []string) (model.Diagnostic, bool) {
+	m := rustfmtError.FindStringSubmatch(line)
+	if m == nil || len(rest) == 0 {
+		return model.Diagnostic{}, false
+	}
+	loc := rustfmtLocation.FindStringSubmatch(rest[0])
+	if loc == nil {
+		return model.Diagnostic{}, false
+	}
+	return model.Diagnostic{Line: atoi(loc[1]), Column: atoi(loc[2]), Severity: m[1], Message: m[2]}, true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
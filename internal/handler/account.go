@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/mail"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// PASSWORD_RESET_MAIL_TIMEOUT bounds sending a password reset email, which
+// happens after the request has been answered.
+const PASSWORD_RESET_MAIL_TIMEOUT = time.Minute
+
+// issueActionToken records a single-use token for user and returns its
+// signed form.
+func (h *AuthHandler) issueActionToken(ctx context.Context, user model.User, purpose string, ttl time.Duration) (string, error) {
+	record := store.ActionToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := h.Tokens.CreateToken(ctx, record); err != nil {
+		return "", fmt.Errorf("store token: %w", err)
+	}
+	return h.Signer.Sign(purpose, record.ID, record.ExpiresAt)
+}
+
+// consumeActionToken verifies token and marks it used, returning its owner.
+func (h *AuthHandler) consumeActionToken(ctx context.Context, token, purpose string) (uuid.UUID, error) {
+	id, err := h.Signer.Verify(token, purpose, time.Now())
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return h.Tokens.ConsumeToken(ctx, id, purpose)
+}
+
+func (h *AuthHandler) link(path, token string) string {
+	return h.PublicURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, user model.User) error {
+	token, err := h.issueActionToken(ctx, user, auth.PurposeVerifyEmail, h.VerificationTTL)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your alcaIDE email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link:\n\n%s\n\n"+
+			"Or submit this token to /verify-email/confirm:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, h.link("/verify-email", token), token, h.VerificationTTL),
+	})
+}
+
+// RequestVerification emails a fresh verification link to the caller.
+func (h *AuthHandler) RequestVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	user, err := h.Users.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error looking up user", "user_id", principal.UserID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user.EmailVerified {
+		respondJSON(w, map[string]string{"status": "already_verified"})
+		return
+	}
+
+	if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error sending verification email", "user_id", user.ID, "error", err)
+		http.Error(w, "Failed to send verification email", http.StatusBadGateway)
+		return
+	}
+	respondJSONStatus(w, http.StatusAccepted, map[string]string{"status": "sent"})
+}
+
+// ConfirmVerification consumes a verification token and marks the email
+// address as verified.
+func (h *AuthHandler) ConfirmVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "A token is required", map[string]string{"token": "is required"})
+		return
+	}
+
+	userID, err := h.consumeActionToken(r.Context(), req.Token, auth.PurposeVerifyEmail)
+	if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, store.ErrNotFound) {
+		slog.InfoContext(r.Context(), "Rejected verification token", "error", err)
+		respondError(w, http.StatusBadRequest, ErrCodeInvalidToken, "The verification link is invalid, expired or already used", nil)
+		return
+	}
+	if err == nil {
+		err = h.Users.SetEmailVerified(r.Context(), userID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error verifying email", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Email verified", "user_id", userID)
+	respondJSON(w, map[string]string{"status": "verified"})
+}
+
+// RequestPasswordReset emails a reset link if an account uses the given
+// address. It answers the same way either way so it cannot be used to find
+// out which addresses are registered: the email is sent in the background,
+// so neither the response nor how long it takes depends on the account.
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Request body must be a JSON object", nil)
+		return
+	}
+	email := normalizeEmail(req.Email)
+	if msg := validateEmail(email); msg != "" {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Email is invalid", map[string]string{"email": msg})
+		return
+	}
+
+	user, err := h.Users.GetUserByEmail(r.Context(), email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		slog.InfoContext(r.Context(), "Password reset requested for unknown email")
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error looking up user by email", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	default:
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), PASSWORD_RESET_MAIL_TIMEOUT)
+		go func() {
+			defer cancel()
+			if err := h.sendPasswordResetEmail(ctx, user); err != nil {
+				slog.ErrorContext(ctx, "Error sending password reset email", "user_id", user.ID, "error", err)
+			}
+		}()
+	}
+	respondJSONStatus(w, http.StatusAccepted, map[string]string{"status": "sent"})
+}
+
+func (h *AuthHandler) sendPasswordResetEmail(ctx context.Context, user model.User) error {
+	token, err := h.issueActionToken(ctx, user, auth.PurposePasswordReset, h.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your alcaIDE password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, open this link:\n\n%s\n\n"+
+			"Or submit this token to /password-reset/confirm:\n\n%s\n\n"+
+			"The link expires in %s. If you did not ask for a reset, ignore this email.\n",
+			user.Username, h.link("/reset-password", token), token, h.PasswordResetTTL),
+	})
+}
+
+// ConfirmPasswordReset consumes a reset token and sets a new password.
+// Session tokens issued before then stop working.
+func (h *AuthHandler) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Request body must be a JSON object", nil)
+		return
+	}
+	problems := map[string]string{}
+	if req.Token == "" {
+		problems["token"] = "is required"
+	}
+	if msg := validatePassword(req.Password); msg != "" {
+		problems["password"] = msg
+	}
+	if len(problems) > 0 {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Password reset details are invalid", problems)
+		return
+	}
+
+	// Hash before consuming the token so a hashing failure does not burn it.
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := h.consumeActionToken(r.Context(), req.Token, auth.PurposePasswordReset)
+	if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, store.ErrNotFound) {
+		slog.InfoContext(r.Context(), "Rejected password reset token", "error", err)
+		respondError(w, http.StatusBadRequest, ErrCodeInvalidToken, "The reset link is invalid, expired or already used", nil)
+		return
+	}
+	if err == nil {
+		err = h.Users.UpdatePassword(r.Context(), userID, hash)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error resetting password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Password reset", "user_id", userID)
+	respondJSON(w, map[string]string{"status": "password_reset"})
+}
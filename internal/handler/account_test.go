@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/mail"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// fakeMailer hands sent messages to the test, or fails every send.
+type fakeMailer struct {
+	sent chan mail.Message
+	err  error
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg mail.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent <- msg
+	return nil
+}
+
+func newTestAuthHandler(t *testing.T, mailer mail.Mailer) (*AuthHandler, model.User) {
+	t.Helper()
+	hash, err := HashPassword("old password 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com", Password: hash, EmailVerified: true}
+	users := store.NewMemoryUserStore()
+	if err := users.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return &AuthHandler{
+		Users:            users,
+		Tokens:           store.NewMemoryTokenStore(),
+		Signer:           auth.NewActionSigner([]byte("action secret")),
+		Mailer:           mailer,
+		JWTSecret:        []byte("jwt secret"),
+		TokenTTL:         time.Hour,
+		PublicURL:        "https://alcaide.example.com",
+		VerificationTTL:  time.Hour,
+		PasswordResetTTL: time.Hour,
+	}, user
+}
+
+func post(handler http.HandlerFunc, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return rec
+}
+
+func login(t *testing.T, h *AuthHandler, password string) string {
+	t.Helper()
+	rec := post(h.Login, model.LoginRequest{Username: "alice", Password: password})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	var resp model.AuthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Token
+}
+
+func TestRequestPasswordResetAnswersTheSame(t *testing.T) {
+	h, _ := newTestAuthHandler(t, &fakeMailer{err: errors.New("relay down")})
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		rec := post(h.RequestPasswordReset, model.PasswordResetRequest{Email: email})
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("reset for %s: got %d %s, want 202", email, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestPasswordResetEndsSessions(t *testing.T) {
+	mailer := &fakeMailer{sent: make(chan mail.Message, 1)}
+	h, _ := newTestAuthHandler(t, mailer)
+	authn := &Authenticator{JWTSecret: h.JWTSecret, Users: h.Users}
+	authorized := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		authn.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	before := login(t, h, "old password 1")
+	if code := authorized(before); code != http.StatusOK {
+		t.Fatalf("session before reset: got %d", code)
+	}
+
+	if rec := post(h.RequestPasswordReset, model.PasswordResetRequest{Email: "alice@example.com"}); rec.Code != http.StatusAccepted {
+		t.Fatalf("request reset: %d %s", rec.Code, rec.Body)
+	}
+	var msg mail.Message
+	select {
+	case msg = <-mailer.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email sent")
+	}
+	_, rest, _ := strings.Cut(msg.Body, "/password-reset/confirm:\n\n")
+	token, _, _ := strings.Cut(rest, "\n")
+
+	rec := post(h.ConfirmPasswordReset, model.PasswordResetConfirmRequest{Token: token, Password: "new password 2"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm reset: %d %s", rec.Code, rec.Body)
+	}
+
+	if code := authorized(before); code != http.StatusUnauthorized {
+		t.Errorf("session from before reset: got %d, want 401", code)
+	}
+	if code := authorized(login(t, h, "new password 2")); code != http.StatusOK {
+		t.Errorf("session from after reset: got %d, want 200", code)
+	}
+}
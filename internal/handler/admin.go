@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+)
+
+// ErrReloadConfig wraps configuration errors returned by AdminHandler.Reload
+// so they are reported to the operator rather than as a server fault.
+var ErrReloadConfig = errors.New("invalid configuration")
+
+// SandboxAdmin reports on and manages the sandbox pools and the hosts they
+// run on. Backends that cannot drain their hosts return
+// sandbox.ErrUnknownHost from DrainHost and UndrainHost.
+type SandboxAdmin interface {
+	Pools() []sandbox.PoolStatus
+	Hosts() []sandbox.HostStatus
+	DrainHost(ctx context.Context, name string) error
+	UndrainHost(ctx context.Context, name string) error
+}
+
+// AdminHandler serves operator endpoints. Its routes must be wrapped in
+// RequireAdminToken.
+type AdminHandler struct {
+	Sandboxes SandboxAdmin
+	// Reload re-reads the configuration and applies its languages.
+	Reload func(ctx context.Context) (sandbox.ReloadResult, error)
+}
+
+// ReloadLanguages serves POST /admin/reload.
+func (h *AdminHandler) ReloadLanguages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := h.Reload(r.Context())
+	switch {
+	case errors.Is(err, ErrReloadConfig):
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, err.Error(), nil)
+	case errors.Is(err, sandbox.ErrShuttingDown):
+		respondError(w, http.StatusServiceUnavailable, ErrCodeShuttingDown, "The server is shutting down", nil)
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error reloading languages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		respondJSON(w, result)
+	}
+}
+
+// Pools serves GET /admin/pools.
+func (h *AdminHandler) Pools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, map[string][]sandbox.PoolStatus{"pools": h.Sandboxes.Pools()})
+}
+
+// Hosts serves GET /admin/hosts.
+func (h *AdminHandler) Hosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, map[string][]sandbox.HostStatus{"hosts": h.Sandboxes.Hosts()})
+}
+
+// DrainHost serves POST /admin/hosts/{name}/drain.
+func (h *AdminHandler) DrainHost(w http.ResponseWriter, r *http.Request) {
+	h.setHostDraining(w, r, h.Sandboxes.DrainHost)
+}
+
+// UndrainHost serves POST /admin/hosts/{name}/undrain.
+func (h *AdminHandler) UndrainHost(w http.ResponseWriter, r *http.Request) {
+	h.setHostDraining(w, r, h.Sandboxes.UndrainHost)
+}
+
+func (h *AdminHandler) setHostDraining(w http.ResponseWriter, r *http.Request, apply func(context.Context, string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.PathValue("name")
+	if err := apply(r.Context(), name); errors.Is(err, sandbox.ErrUnknownHost) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error(), nil)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "Error changing Docker host", "host", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, status := range h.Sandboxes.Hosts() {
+		if status.Name == name {
+			respondJSON(w, status)
+			return
+		}
+	}
+}
+
+// RequireAdminToken admits requests bearing token as "Authorization: Bearer
+// <token>".
+func RequireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid admin token", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
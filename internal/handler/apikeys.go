@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
+)
+
+const (
+	API_KEY_NAME_MAX_LENGTH = 64
+	// MAX_API_KEYS_PER_USER bounds live (unrevoked) keys per account.
+	MAX_API_KEYS_PER_USER = 25
+)
+
+// APIKeyHandler lets users manage their personal API keys. Its routes must
+// be wrapped in Authenticator.RequireSession.
+type APIKeyHandler struct {
+	Keys store.APIKeyStore
+}
+
+// Collection serves /api-keys: GET lists the caller's keys, POST creates one.
+func (h *APIKeyHandler) Collection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.list(w, r)
+	case http.MethodPost:
+		h.create(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *APIKeyHandler) list(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	keys, err := h.Keys.ListAPIKeys(r.Context(), principal.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing API keys", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := model.APIKeyList{Keys: make([]model.APIKey, 0, len(keys))}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, toAPIKeyResponse(k))
+	}
+	respondJSON(w, resp)
+}
+
+func (h *APIKeyHandler) create(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	var req model.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Request body must be a JSON object", nil)
+		return
+	}
+	ttl, problems := validateAPIKeyRequest(&req)
+	if problems != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "API key details are invalid", problems)
+		return
+	}
+
+	existing, err := h.Keys.ListAPIKeys(r.Context(), principal.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing API keys", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= MAX_API_KEYS_PER_USER {
+		respondError(w, http.StatusConflict, ErrCodeConflict,
+			fmt.Sprintf("An account may have at most %d API keys; revoke one first", MAX_API_KEYS_PER_USER), nil)
+		return
+	}
+
+	raw, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating API key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	key := store.APIKey{
+		ID:        uuid.New(),
+		UserID:    principal.UserID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if ttl > 0 {
+		expires := key.CreatedAt.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	if err := h.Keys.CreateAPIKey(r.Context(), key); err != nil {
+		slog.ErrorContext(r.Context(), "Error storing API key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key created", "api_key_id", key.ID, "scopes", key.Scopes)
+	respondJSONStatus(w, http.StatusCreated, model.CreateAPIKeyResponse{
+		APIKey: toAPIKeyResponse(key),
+		Key:    raw,
+	})
+}
+
+// Revoke serves DELETE /api-keys/{id}.
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "API key not found", nil)
+		return
+	}
+	err = h.Keys.RevokeAPIKey(r.Context(), principal.UserID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "API key not found", nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking API key", "api_key_id", id, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key revoked", "api_key_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateAPIKeyRequest normalises req in place and returns the requested
+// lifetime (zero for none) and a message per invalid field.
+func validateAPIKeyRequest(req *model.CreateAPIKeyRequest) (time.Duration, map[string]string) {
+	problems := make(map[string]string)
+
+	req.Name = strings.TrimSpace(req.Name)
+	switch {
+	case req.Name == "":
+		problems["name"] = "is required"
+	case len(req.Name) > API_KEY_NAME_MAX_LENGTH:
+		problems["name"] = "must be at most 64 characters"
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = append([]string(nil), auth.Scopes...)
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			problems["scopes"] = fmt.Sprintf("unknown scope %q; valid scopes are %s", scope, strings.Join(auth.Scopes, ", "))
+			break
+		}
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			problems["expires_in"] = "must be a positive duration such as \"720h\""
+		}
+	}
+
+	if len(problems) == 0 {
+		return ttl, nil
+	}
+	return 0, problems
+}
+
+func toAPIKeyResponse(k store.APIKey) model.APIKey {
+	return model.APIKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
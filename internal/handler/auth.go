@@ -1,51 +1,98 @@
 package handler
 
 import (
-	"log"
+	"log/slog"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
-	"github.com/Aadithya-J/alcaIDE/internal/db"
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/mail"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
 	"github.com/Aadithya-J/alcaIDE/model"
 
 	"github.com/google/uuid"
 )
 
+// AuthHandler serves registration, login, email verification and password
+// resets against a UserStore.
+type AuthHandler struct {
+	Users     store.UserStore
+	Tokens    store.TokenStore
+	Signer    *auth.ActionSigner
+	Mailer    mail.Mailer
+	JWTSecret []byte
+	TokenTTL  time.Duration
+
+	// PublicURL is prefixed to links in outgoing email.
+	PublicURL        string
+	VerificationTTL  time.Duration
+	PasswordResetTTL time.Duration
+	UnverifiedPolicy string
+}
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	var err error
-	var user model.User
-	
-	if err = json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	var req model.RegisterRequest
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Request body must be a JSON object", nil)
+		return
+	}
+
+	if problems := validateRegistration(&req); problems != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Registration details are invalid", problems)
 		return
 	}
 
-	user.ID = uuid.New()
-	user.Password, err = HashPassword(user.Password)
+	user := model.User{
+		ID:       uuid.New(),
+		Username: req.Username,
+		Email:    req.Email,
+	}
+	user.Password, err = HashPassword(req.Password)
 	if err != nil {
-		log.Println("Error hashing password:", err)
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = db.Conn.Exec(r.Context(),
-		"INSERT INTO USERS (id, username, email, password) VALUES ($1, $2, $3, $4)",
-		user.ID, user.Username, user.Email, user.Password,
-	)
+	err = h.Users.CreateUser(r.Context(), user)
+	var conflict *store.ConflictError
+	if errors.As(err, &conflict) {
+		slog.InfoContext(r.Context(), "Registration conflict", "field", conflict.Field)
+		respondError(w, http.StatusConflict, ErrCodeConflict,
+			fmt.Sprintf("An account with this %s already exists", conflict.Field),
+			map[string]string{conflict.Field: "is already taken"})
+		return
+	}
 	if err != nil {
-		log.Println("Error inserting user:", err)
+		slog.ErrorContext(r.Context(), "Error inserting user", "username", user.Username, "error", err)
 		http.Error(w, "Failed to register user", http.StatusInternalServerError)
 		return
 	}
 
-	token, err := generateJWT(user)
+	// The account exists at this point, so a mail failure is logged rather
+	// than failing the registration; the user can ask for another link.
+	if err := h.sendVerificationEmail(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error sending verification email", "user_id", user.ID, "error", err)
+	}
+
+	if h.UnverifiedPolicy == config.UnverifiedBlockLogin {
+		respondJSONStatus(w, http.StatusCreated, toUserResponse(user))
+		return
+	}
+
+	token, err := auth.NewSessionToken(user, h.JWTSecret, h.TokenTTL)
 	if err != nil {
-		log.Println("Error Signing jwt.")
+		slog.ErrorContext(r.Context(), "Error signing JWT", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -57,7 +104,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -66,33 +113,40 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var req model.LoginRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Request body must be a JSON object", nil)
 		return
 	}
+	req.Username = normalizeUsername(req.Username)
 
-	var user model.User
-
-	err = db.Conn.QueryRow(r.Context(),
-		"SELECT id, username, email, password FROM users WHERE username = $1",
-		req.Username,
-	).Scan(&user.ID,&user.Username,&user.Email,&user.Password)
-
+	user, err := h.Users.GetUserByUsername(r.Context(), req.Username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Error looking up user", "username", req.Username, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 	if err != nil{
 		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
-		log.Println("Incorrect Credentials",err)
+		slog.InfoContext(r.Context(), "Login failed: unknown user", "username", req.Username, "error", err)
 		return
 	}
 
 	if !ComparePasswordHash(req.Password, user.Password){
 		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
-		log.Println("Incorrect Credentials")
+		slog.InfoContext(r.Context(), "Login failed: wrong password", "username", req.Username)
 		return
 	}
 
-	token, err := generateJWT(user)
+	if !user.EmailVerified && h.UnverifiedPolicy == config.UnverifiedBlockLogin {
+		slog.InfoContext(r.Context(), "Login refused: email not verified", "username", req.Username)
+		respondError(w, http.StatusForbidden, ErrCodeEmailUnverified, "Verify your email address before logging in", nil)
+		return
+	}
+
+	token, err := auth.NewSessionToken(user, h.JWTSecret, h.TokenTTL)
 
 	if err != nil {
-		log.Println("Error Signing jwt.")
+		slog.ErrorContext(r.Context(), "Error signing JWT", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
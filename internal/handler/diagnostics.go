@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/lint"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// Outcomes of a diagnostics request, as counted by metrics.DiagnosticsRuns.
+const (
+	LINT_SUCCESS     = "success"
+	LINT_UNAVAILABLE = "unavailable"
+	LINT_TIMEOUT     = "timeout"
+	LINT_ABORTED     = "aborted"
+	LINT_ERROR       = "error"
+)
+
+// Diagnostics serves POST /diagnostics: it runs the language's linter or
+// type checker over the submitted code in a pooled container, without
+// running the code, and returns what it found. Problems in the code are
+// not an error; the response is 200 with them listed.
+func (h *ExecHandler) Diagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.DiagnosticsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload", nil)
+		return
+	}
+	if _, ok := h.Sandboxes.Command(req.Language); !ok {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported language: %s", req.Language),
+			map[string]string{"language": "is not supported"})
+		return
+	}
+	name, args := h.Sandboxes.Linter(req.Language)
+	linter, ok := lint.Lookup(name)
+	if !ok {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("No linter is configured for %s", req.Language),
+			map[string]string{"language": "has no linter"})
+		return
+	}
+	if req.Code == "" {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Code is required",
+			map[string]string{"code": "is required"})
+		return
+	}
+
+	// Like formatting, diagnostics are requested from an editor.
+	priority, _ := h.priority(r.Context(), PRIORITY_INTERACTIVE)
+	logger := slog.With("language", req.Language, "linter", linter.Name)
+	acquiredContainer, queueReport, ok := h.acquire(w, r, req.Language, priority, logger)
+	if !ok {
+		return
+	}
+	logger = logger.With("container_id", acquiredContainer.ID)
+	defer h.Sandboxes.ReleaseContainer(r.Context(), acquiredContainer, req.Language)
+
+	execCtx, cancelExec := context.WithTimeout(r.Context(), h.ExecutionTimeout)
+	defer cancelExec()
+	execCtx, cancelOnAbort := h.Sandboxes.AbortOnShutdown(execCtx)
+	defer cancelOnAbort()
+
+	start := time.Now()
+	stdout, err := h.Sandboxes.Exec(execCtx, acquiredContainer, linter.Command(req.Language, args), model.ExecOptions{
+		Stdin: req.Code,
+		Env:   linter.Env,
+		User:  h.Sandboxes.User(),
+	})
+	duration := time.Since(start)
+
+	resp := model.DiagnosticsResponse{
+		Language: req.Language,
+		Linter:   linter.Name,
+		Queue:    queueReport,
+	}
+	outcome := LINT_SUCCESS
+
+	// Most linters exit non-zero when they find problems, so an exit
+	// error is only a failure if nothing could be parsed from it.
+	var exitErr *model.ExitError
+	switch {
+	case errors.Is(context.Cause(execCtx), sandbox.ErrShuttingDown):
+		outcome = LINT_ABORTED
+		metrics.DiagnosticsRuns.WithLabelValues(req.Language, outcome).Inc()
+		w.Header().Set("Retry-After", "30")
+		respondError(w, http.StatusServiceUnavailable, ErrCodeShuttingDown,
+			"Checking aborted: the server shut down before it finished", nil)
+		return
+	case errors.Is(execCtx.Err(), context.DeadlineExceeded):
+		outcome = LINT_TIMEOUT
+		logger.WarnContext(r.Context(), "Linter timed out", "timeout", h.ExecutionTimeout)
+		metrics.DiagnosticsRuns.WithLabelValues(req.Language, outcome).Inc()
+		http.Error(w, fmt.Sprintf("Checking timed out after %s", h.ExecutionTimeout), http.StatusRequestTimeout)
+		return
+	// 126 and 127 mean the command could not be started: the image lacks
+	// the linter.
+	case errors.As(err, &exitErr) && (exitErr.ExitCode == 126 || exitErr.ExitCode == 127):
+		outcome = LINT_UNAVAILABLE
+		logger.WarnContext(r.Context(), "Linter missing from image", "image", acquiredContainer.Image, "exit_code", exitErr.ExitCode)
+		metrics.DiagnosticsRuns.WithLabelValues(req.Language, outcome).Inc()
+		respondError(w, http.StatusNotImplemented, ErrCodeNoLinter,
+			fmt.Sprintf("%s is not installed in the %s image", linter.Name, req.Language), nil)
+		return
+	case errors.As(err, &exitErr):
+		resp.Diagnostics = linter.Diagnostics(req.Language, stdout, exitErr.Output)
+		if len(resp.Diagnostics) == 0 {
+			outcome = LINT_ERROR
+			// The output can echo the submitted source, so only the
+			// caller sees it.
+			logger.ErrorContext(r.Context(), "Linter failed without reporting problems", "exit_code", exitErr.ExitCode)
+			metrics.DiagnosticsRuns.WithLabelValues(req.Language, outcome).Inc()
+			respondError(w, http.StatusInternalServerError, ErrCodeLinterFailed,
+				fmt.Sprintf("%s failed (exit %d):\n%s", linter.Name, exitErr.ExitCode, exitErr.Output), nil)
+			return
+		}
+	case err != nil:
+		outcome = LINT_ERROR
+		logger.ErrorContext(r.Context(), "Error running linter", "error", err)
+		metrics.DiagnosticsRuns.WithLabelValues(req.Language, outcome).Inc()
+		http.Error(w, fmt.Sprintf("Failed to run %s", linter.Name), http.StatusInternalServerError)
+		return
+	default:
+		resp.Diagnostics = linter.Diagnostics(req.Language, stdout, stdout)
+	}
+	if resp.Diagnostics == nil {
+		resp.Diagnostics = []model.Diagnostic{}
+	}
+	metrics.DiagnosticsRuns.WithLabelValues(req.Language, outcome).Inc()
+	logger.InfoContext(r.Context(), "Diagnostics finished", "problems", len(resp.Diagnostics), "duration", duration)
+	respondJSON(w, resp)
+}
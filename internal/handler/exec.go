@@ -2,102 +2,469 @@ package handler
 
 import (
 	"context"
-	"net/http"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"log"
-	"time"
-	"fmt"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
 
-	"github.com/Aadithya-J/alcaIDE/internal/docker"
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
 	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
 )
 
-const ACQUIRE_TIMEOUT = 10 * time.Second
-const EXECUTION_TIMEOUT = 10 * time.Second
+const (
+	PRIORITY_INTERACTIVE = "interactive"
+	PRIORITY_BATCH       = "batch"
+	// QUEUE_FULL_RETRY_AFTER is the Retry-After, in seconds, sent when an
+	// execution is turned away because too many are waiting.
+	QUEUE_FULL_RETRY_AFTER = "5"
+)
+
+const (
+	MAX_DEPENDENCIES       = 50
+	DEPENDENCY_MAX_LENGTH  = 200
+	PACKAGE_JSON_MAX_BYTES = 64 << 10
+)
 
-func ExecCodeHandler(w http.ResponseWriter, r* http.Request, parentCtx context.Context, dockerManager *docker.DockerManager) {
+// Sandboxes hands out pooled sandbox containers and runs commands in them.
+// It is implemented by docker.DockerManager and kube.PodManager.
+type Sandboxes interface {
+	// Command returns the argv code for language is appended to, and
+	// whether the language is configured.
+	Command(language string) ([]string, bool)
+	// Installer returns the language's dependency installer, or "" if
+	// dependencies are not supported.
+	Installer(language string) string
+	// Formatter returns the language's code formatter, or "" if it has
+	// none.
+	Formatter(language string) string
+	// Linter returns the language's linter and its extra arguments, or ""
+	// if it has none.
+	Linter(language string) (string, []string)
+	// LanguageServer returns the argv of the language's LSP server, or nil
+	// if it has none.
+	LanguageServer(language string) []string
+	// Kernel returns the language's REPL kernel, or "" if it has none.
+	Kernel(language string) string
+	// User is the user submitted code runs as.
+	User() string
+	AcquireContainer(ctx context.Context, language string, opts sandbox.AcquireOptions) (*model.ContainerInfo, error)
+	ReleaseContainer(ctx context.Context, c *model.ContainerInfo, language string)
+	// AbortOnShutdown derives a context that is cancelled with
+	// sandbox.ErrShuttingDown if running executions are aborted.
+	AbortOnShutdown(ctx context.Context) (context.Context, context.CancelFunc)
+	Exec(ctx context.Context, c *model.ContainerInfo, cmd []string, opts model.ExecOptions) (string, error)
+	// StartProcess starts a long-running command, such as a language
+	// server, with its streams attached.
+	StartProcess(ctx context.Context, c *model.ContainerInfo, cmd []string, opts model.ExecOptions) (model.Process, error)
+	InstallDependencies(ctx context.Context, c *model.ContainerInfo, deps sandbox.Dependencies) (sandbox.Installation, error)
+	RemoveDependencies(ctx context.Context, c *model.ContainerInfo, installation sandbox.Installation)
+	Queued(user string) []sandbox.QueuedExecution
+}
+
+// ExecHandler runs submitted code in a pooled container.
+type ExecHandler struct {
+	Sandboxes        Sandboxes
+	AcquireTimeout   time.Duration
+	ExecutionTimeout time.Duration
+	// InstallTimeout bounds installing declared dependencies, separately
+	// from ExecutionTimeout.
+	InstallTimeout time.Duration
+
+	// History records runs by authenticated callers; nil disables it.
+	History store.ExecutionStore
+	// StoreCode keeps code and stdin in history rather than only a hash.
+	StoreCode bool
+
+	// PremiumUsers are served ahead of other callers of the same priority
+	// when containers are scarce.
+	PremiumUsers map[uuid.UUID]bool
+}
+
+func (h *ExecHandler) Exec(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var requestData struct {
-		Code string `json:"code"`
-		Language string `json:"language"`
+	var requestData model.ExecRequest
+
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload", nil)
+		return
 	}
+	h.run(w, r, requestData)
+}
 
-	cli := dockerManager.GetClient()
+// run executes requestData and writes the response. It backs both /exec
+// and re-runs from history.
+func (h *ExecHandler) run(w http.ResponseWriter, r *http.Request, requestData model.ExecRequest) {
+	command, ok := h.Sandboxes.Command(requestData.Language)
+	if !ok {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported language: %s", requestData.Language),
+			map[string]string{"language": "is not supported"})
+		return
+	}
+	execCmd := append(slices.Clip(command), requestData.Code)
+	if problems := validateDependencies(&requestData, h.Sandboxes.Installer(requestData.Language)); problems != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Dependencies are invalid", problems)
+		return
+	}
+	deps := sandbox.Dependencies{Packages: requestData.Dependencies, PackageJSON: requestData.PackageJSON}
+	priority, ok := h.priority(r.Context(), requestData.Priority)
+	if !ok {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported priority: %s", requestData.Priority),
+			map[string]string{"priority": "must be interactive or batch"})
+		return
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&requestData);
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	logger := slog.With("language", requestData.Language)
+	acquiredContainer, queueReport, ok := h.acquire(w, r, requestData.Language, priority, logger)
+	if !ok {
 		return
 	}
+	logger = logger.With("container_id", acquiredContainer.ID)
+
+	defer h.Sandboxes.ReleaseContainer(r.Context(), acquiredContainer, requestData.Language)
+
+	var env []string
+	var installReport *model.InstallReport
+	if !deps.Empty() {
+		installation, report, outcome, status := h.install(r.Context(), acquiredContainer, deps)
+		// Runs before the release above, so the next user of the container
+		// does not see these packages.
+		defer h.Sandboxes.RemoveDependencies(context.WithoutCancel(r.Context()), acquiredContainer, installation)
+		if outcome != "" {
+			resp := model.ExecResponse{
+				Code:     requestData.Code,
+				Language: requestData.Language,
+				Runtime:  acquiredContainer.Runtime,
+				Error:    "Dependency installation failed; the code was not run",
+				Install:  report,
+				Queue:    queueReport,
+			}
+			metrics.Executions.WithLabelValues(requestData.Language, outcome, acquiredContainer.Runtime).Inc()
+			resp.ExecutionID = h.record(r.Context(), requestData, store.Execution{
+				Error:    resp.Error,
+				Status:   outcome,
+				Duration: time.Duration(report.DurationMS) * time.Millisecond,
+				Image:    acquiredContainer.Image,
+			})
+			respondJSONStatus(w, status, resp)
+			return
+		}
+		env, installReport = installation.Env, report
+	}
+
+	execCtx, cancelExec := context.WithTimeout(r.Context(), h.ExecutionTimeout)
+	defer cancelExec()
+	execCtx, cancelOnAbort := h.Sandboxes.AbortOnShutdown(execCtx)
+	defer cancelOnAbort()
+
+	logger.DebugContext(r.Context(), "Executing code", "code_bytes", len(requestData.Code), "stdin_bytes", len(requestData.Stdin))
+
+	execStart := time.Now()
+	output, err := h.Sandboxes.Exec(execCtx, acquiredContainer, execCmd, model.ExecOptions{
+		Stdin: requestData.Stdin,
+		Env:   env,
+		User:  h.Sandboxes.User(),
+	})
+	duration := time.Since(execStart)
+	metrics.ExecutionDuration.WithLabelValues(requestData.Language, acquiredContainer.Runtime).Observe(duration.Seconds())
+
+	resp := model.ExecResponse{
+		Code:     requestData.Code,
+		Language: requestData.Language,
+		Runtime:  acquiredContainer.Runtime,
+		Output:   output,
+		Install:  installReport,
+		Queue:    queueReport,
+	}
+	status := http.StatusOK
+	outcome := store.ExecutionSuccess
+	var exitCode *int
 
-	var execCmd []string
-    switch requestData.Language {
-    case "python":
-        execCmd = []string{"python", "-c", requestData.Code}
-    case "javascript":
-        execCmd = []string{"node", "-e", requestData.Code}
-    default:
-        http.Error(w, fmt.Sprintf("Unsupported language: %s", requestData.Language), http.StatusBadRequest)
-        return
-    }
+	var exitErr *model.ExitError
+	switch {
+	case errors.Is(context.Cause(execCtx), sandbox.ErrShuttingDown):
+		outcome = store.ExecutionAborted
+		resp.Output = ""
+		resp.Error = "Execution aborted: the server shut down before it finished"
+		status = http.StatusServiceUnavailable
+		logger.WarnContext(r.Context(), "Execution aborted by shutdown", "duration", duration)
+	case errors.Is(execCtx.Err(), context.DeadlineExceeded):
+		outcome = store.ExecutionTimeout
+		resp.Output = ""
+		resp.Error = fmt.Sprintf("Execution timed out after %s", h.ExecutionTimeout)
+		status = http.StatusRequestTimeout
+		logger.WarnContext(r.Context(), "Execution timed out", "timeout", h.ExecutionTimeout)
+	case errors.As(err, &exitErr) && exitErr.ExitCode == sandbox.BlockedSyscallExitCode:
+		outcome = store.ExecutionBlocked
+		resp.Error = "Execution stopped: the program made a system call the sandbox does not allow\n" + exitErr.Output
+		status = http.StatusBadRequest
+		exitCode = &exitErr.ExitCode
+		logger.WarnContext(r.Context(), "Execution killed by seccomp profile", "duration", duration)
+	case err != nil:
+		outcome = store.ExecutionError
+		resp.Error = err.Error()
+		status = http.StatusBadRequest
+		if errors.As(err, &exitErr) {
+			exitCode = &exitErr.ExitCode
+		}
+		// err carries the program's output, which can echo the submitted
+		// source back (e.g. Node's syntax errors), so it is not logged.
+		logger.InfoContext(r.Context(), "Execution failed", "duration", duration)
+	default:
+		exitCode = new(int)
+		logger.InfoContext(r.Context(), "Execution successful", "duration", duration)
+	}
+	metrics.Executions.WithLabelValues(requestData.Language, outcome, acquiredContainer.Runtime).Inc()
 
+	resp.ExecutionID = h.record(r.Context(), requestData, store.Execution{
+		Output:   resp.Output,
+		Error:    resp.Error,
+		Status:   outcome,
+		ExitCode: exitCode,
+		Duration: duration,
+		// During a rollout this may still be the previous image.
+		Image: acquiredContainer.Image,
+	})
+	respondJSONStatus(w, status, resp)
+}
+
+// acquire waits for a container of language on behalf of the caller. If
+// none can be had it writes the error response and returns false. The
+// queue report is nil unless the caller had to wait.
+func (h *ExecHandler) acquire(w http.ResponseWriter, r *http.Request, language string, priority sandbox.Priority, logger *slog.Logger) (*model.ContainerInfo, *model.QueueReport, bool) {
+	acquiredContainer, queueReport, err := h.acquireContainer(r, language, priority, logger)
+	if errors.Is(err, sandbox.ErrQueueFull) {
+		w.Header().Set("Retry-After", QUEUE_FULL_RETRY_AFTER)
+		respondError(w, http.StatusTooManyRequests, ErrCodeQueueFull,
+			fmt.Sprintf("Too many executions are waiting for a %s container; retry shortly", language), nil)
+		return nil, nil, false
+	}
+	if errors.Is(err, sandbox.ErrShuttingDown) {
+		w.Header().Set("Retry-After", "30")
+		respondError(w, http.StatusServiceUnavailable, ErrCodeShuttingDown, "The server is shutting down; retry shortly", nil)
+		return nil, nil, false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, fmt.Sprintf("Container acquisition timed out for %s", language), http.StatusRequestTimeout)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to acquire container for %s", language), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return acquiredContainer, queueReport, true
+}
 
-	var acquiredContainer *model.ContainerInfo
-	acquireCtx, cancel := context.WithTimeout(parentCtx, ACQUIRE_TIMEOUT)
+// acquireContainer is acquire for callers that report failures themselves.
+// Running out of AcquireTimeout is reported as context.DeadlineExceeded.
+func (h *ExecHandler) acquireContainer(r *http.Request, language string, priority sandbox.Priority, logger *slog.Logger) (*model.ContainerInfo, *model.QueueReport, error) {
+	acquireCtx, cancel := context.WithTimeout(r.Context(), h.AcquireTimeout)
 	defer cancel()
 
-	log.Println("Acquiring container...")
-	acquiredContainer, err = dockerManager.AcquireContainer(acquireCtx,requestData.Language)
+	logger.DebugContext(r.Context(), "Acquiring container")
+	var queueReport *model.QueueReport
+	acquireStart := time.Now()
+	acquiredContainer, err := h.Sandboxes.AcquireContainer(acquireCtx, language, sandbox.AcquireOptions{
+		User:     callerKey(r),
+		Priority: priority,
+		OnQueued: func(position int) {
+			queueReport = &model.QueueReport{Position: position}
+		},
+	})
+	if queueReport != nil {
+		queueReport.WaitMS = time.Since(acquireStart).Milliseconds()
+	}
+	switch {
+	case err == nil, errors.Is(err, sandbox.ErrQueueFull):
+	case errors.Is(err, sandbox.ErrShuttingDown):
+		logger.InfoContext(r.Context(), "Execution refused: draining")
+	default:
+		logger.ErrorContext(r.Context(), "Error acquiring container", "error", err)
+		if !errors.Is(err, context.DeadlineExceeded) && errors.Is(acquireCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+		}
+	}
+	return acquiredContainer, queueReport, err
+}
+
+// priority maps a requested priority to the class the run waits in.
+func (h *ExecHandler) priority(ctx context.Context, requested string) (sandbox.Priority, bool) {
+	principal, ok := auth.PrincipalFrom(ctx)
+	premium := ok && h.PremiumUsers[principal.UserID]
+	switch requested {
+	case "", PRIORITY_INTERACTIVE:
+		if premium {
+			return sandbox.PriorityPremiumInteractive, true
+		}
+		return sandbox.PriorityInteractive, true
+	case PRIORITY_BATCH:
+		if premium {
+			return sandbox.PriorityPremiumBatch, true
+		}
+		return sandbox.PriorityBatch, true
+	default:
+		return 0, false
+	}
+}
+
+// callerKey identifies the caller for fair queuing: the user if
+// authenticated, otherwise the client address.
+func callerKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return "user:" + principal.UserID.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		log.Println("Error acquiring container:", err)
-
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(acquireCtx.Err(), context.DeadlineExceeded) {
-            http.Error(w, fmt.Sprintf("Container acquisition timed out for %s", requestData.Language), http.StatusRequestTimeout)
-        } else {
-            http.Error(w, fmt.Sprintf("Failed to acquire container for %s", requestData.Language), http.StatusInternalServerError)
-        }
-        return
-	}
-	log.Printf("Acquired container: %s\n", acquiredContainer.ID)
-
-	defer dockerManager.ReleaseContainer(acquiredContainer, requestData.Language)
-
-    execCtx, cancelExec := context.WithTimeout(parentCtx, EXECUTION_TIMEOUT)
-    defer cancelExec()
-
-    log.Printf("Executing %s code in container %s...", requestData.Language, acquiredContainer.ID)
-
-    output, err := acquiredContainer.ExecuteCode(execCmd, cli, execCtx)
-    var errMsg string
-
-    if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
-        errMsg = fmt.Sprintf("Execution timed out after %s", EXECUTION_TIMEOUT)
-        log.Printf("Execution timed out for container %s (%s)", acquiredContainer.ID, requestData.Language)
-        w.WriteHeader(http.StatusRequestTimeout)
-        respondJSON(w, model.ExecResponse{
-            Code:     requestData.Code,
-            Language: requestData.Language,
-            Output:   "",
-            Error:    errMsg,
-        })
-        return
-    }
-    if err != nil {
-        errMsg = err.Error()
-        log.Printf("Execution error in container %s (%s): %s", acquiredContainer.ID, requestData.Language, errMsg)
-		w.WriteHeader(http.StatusBadRequest)
-    } else {
-        log.Printf("Execution successful in container %s (%s)", acquiredContainer.ID, requestData.Language)
-    }
-
-    respondJSON(w, model.ExecResponse{
-        Code:     requestData.Code,
-        Language: requestData.Language,
-        Output:   output,
-        Error:    errMsg,
-    })
-}
\ No newline at end of file
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// Queue serves GET /exec/queue: the caller's executions waiting for a
+// container, with their positions.
+func (h *ExecHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, map[string][]sandbox.QueuedExecution{"queued": h.Sandboxes.Queued(callerKey(r))})
+}
+
+// record saves a run to the caller's history and returns its ID, or nil if
+// history is off, the caller is anonymous or saving failed. A failure to
+// save is logged but does not fail the request; the code has already run.
+func (h *ExecHandler) record(ctx context.Context, req model.ExecRequest, e store.Execution) *uuid.UUID {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if h.History == nil || !ok {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(req.Code))
+	e.ID = uuid.New()
+	e.UserID = principal.UserID
+	e.APIKeyID = uuid.NullUUID{UUID: principal.APIKeyID, Valid: principal.ViaAPIKey()}
+	e.Language = req.Language
+	e.CodeSHA256 = hex.EncodeToString(sum[:])
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if h.StoreCode {
+		e.Code, e.Stdin, e.CodeRetained = req.Code, req.Stdin, true
+		e.Dependencies, e.PackageJSON = req.Dependencies, req.PackageJSON
+	}
+
+	// The run is recorded even if the client has gone away meanwhile.
+	if err := h.History.CreateExecution(context.WithoutCancel(ctx), e); err != nil {
+		slog.ErrorContext(ctx, "Error recording execution", "error", err)
+		return nil
+	}
+	return &e.ID
+}
+
+// install installs deps for one run. It returns an empty outcome on
+// success; otherwise the history outcome and HTTP status to report.
+func (h *ExecHandler) install(ctx context.Context, c *model.ContainerInfo, deps sandbox.Dependencies) (sandbox.Installation, *model.InstallReport, string, int) {
+	logger := slog.With("language", c.Language, "container_id", c.ID)
+
+	installCtx, cancel := context.WithTimeout(ctx, h.InstallTimeout)
+	defer cancel()
+	installCtx, cancelOnAbort := h.Sandboxes.AbortOnShutdown(installCtx)
+	defer cancelOnAbort()
+
+	start := time.Now()
+	installation, err := h.Sandboxes.InstallDependencies(installCtx, c, deps)
+	duration := time.Since(start)
+	report := &model.InstallReport{Output: installation.Output, DurationMS: duration.Milliseconds()}
+
+	outcome, status := "", http.StatusOK
+	var exitErr *model.ExitError
+	switch {
+	case errors.Is(context.Cause(installCtx), sandbox.ErrShuttingDown):
+		outcome, status = store.ExecutionAborted, http.StatusServiceUnavailable
+		report.Error = "Installation aborted: the server shut down before it finished"
+	case errors.Is(installCtx.Err(), context.DeadlineExceeded):
+		outcome, status = store.ExecutionInstallFailed, http.StatusRequestTimeout
+		report.Error = fmt.Sprintf("Installation timed out after %s", h.InstallTimeout)
+	case errors.As(err, &exitErr):
+		outcome, status = store.ExecutionInstallFailed, http.StatusBadRequest
+		report.Output, report.ExitCode = exitErr.Output, &exitErr.ExitCode
+		report.Error = fmt.Sprintf("Installer exited with status %d", exitErr.ExitCode)
+	case err != nil:
+		outcome, status = store.ExecutionInstallFailed, http.StatusInternalServerError
+		report.Error = "Dependencies could not be installed"
+		logger.ErrorContext(ctx, "Error installing dependencies", "error", err)
+	}
+
+	metricOutcome := "success"
+	if outcome != "" {
+		metricOutcome = outcome
+	}
+	metrics.InstallDuration.WithLabelValues(c.Language, metricOutcome).Observe(duration.Seconds())
+	logger.InfoContext(ctx, "Dependencies installed", "outcome", metricOutcome, "packages", len(deps.Packages),
+		"package_json", len(deps.PackageJSON) > 0, "duration", duration)
+	return installation, report, outcome, status
+}
+
+// validateDependencies normalises the dependencies in req and returns a
+// message per invalid field, or nil. installer is the language's installer,
+// empty if it has none.
+func validateDependencies(req *model.ExecRequest, installer string) map[string]string {
+	if len(req.Dependencies) == 0 && len(req.PackageJSON) == 0 {
+		return nil
+	}
+	if installer == "" {
+		return map[string]string{"dependencies": fmt.Sprintf("are not supported for %s", req.Language)}
+	}
+
+	problems := make(map[string]string)
+	if len(req.Dependencies) > MAX_DEPENDENCIES {
+		problems["dependencies"] = fmt.Sprintf("must list at most %d packages", MAX_DEPENDENCIES)
+	}
+	for i, dep := range req.Dependencies {
+		dep = strings.TrimSpace(dep)
+		req.Dependencies[i] = dep
+		switch {
+		case dep == "":
+			problems["dependencies"] = "must not contain empty entries"
+		case len(dep) > DEPENDENCY_MAX_LENGTH:
+			problems["dependencies"] = fmt.Sprintf("entries must be at most %d characters", DEPENDENCY_MAX_LENGTH)
+		// A leading dash would be taken as an installer option.
+		case dep[0] == '-' || strings.ContainsFunc(dep, unicode.IsControl):
+			problems["dependencies"] = fmt.Sprintf("%q is not a package specifier", dep)
+		}
+	}
+
+	if len(req.PackageJSON) > 0 {
+		var manifest map[string]json.RawMessage
+		switch {
+		case installer != config.InstallerNpm:
+			problems["package_json"] = fmt.Sprintf("is not supported for %s", req.Language)
+		case len(req.PackageJSON) > PACKAGE_JSON_MAX_BYTES:
+			problems["package_json"] = "must be at most 64 KiB"
+		case json.Unmarshal(req.PackageJSON, &manifest) != nil || manifest == nil:
+			problems["package_json"] = "must be a JSON object"
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
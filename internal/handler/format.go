@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/format"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// Outcomes of a format request, as counted by metrics.Formats.
+const (
+	FORMAT_SUCCESS      = "success"
+	FORMAT_SYNTAX_ERROR = "syntax_error"
+	FORMAT_UNAVAILABLE  = "unavailable"
+	FORMAT_TIMEOUT      = "timeout"
+	FORMAT_ABORTED      = "aborted"
+	FORMAT_ERROR        = "error"
+)
+
+// Format serves POST /format: it runs the language's formatter over the
+// submitted code in a pooled container and returns the result. A formatter
+// that rejects the code yields 400 with diagnostics locating the errors.
+func (h *ExecHandler) Format(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req model.FormatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload", nil)
+		return
+	}
+	if _, ok := h.Sandboxes.Command(req.Language); !ok {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported language: %s", req.Language),
+			map[string]string{"language": "is not supported"})
+		return
+	}
+	formatter, ok := format.Lookup(h.Sandboxes.Formatter(req.Language))
+	if !ok {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("No formatter is configured for %s", req.Language),
+			map[string]string{"language": "has no formatter"})
+		return
+	}
+	if req.Code == "" {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Code is required",
+			map[string]string{"code": "is required"})
+		return
+	}
+
+	// Formatting is always interactive: someone is waiting in an editor.
+	priority, _ := h.priority(r.Context(), PRIORITY_INTERACTIVE)
+	logger := slog.With("language", req.Language, "formatter", formatter.Name)
+	acquiredContainer, queueReport, ok := h.acquire(w, r, req.Language, priority, logger)
+	if !ok {
+		return
+	}
+	logger = logger.With("container_id", acquiredContainer.ID)
+	defer h.Sandboxes.ReleaseContainer(r.Context(), acquiredContainer, req.Language)
+
+	execCtx, cancelExec := context.WithTimeout(r.Context(), h.ExecutionTimeout)
+	defer cancelExec()
+	execCtx, cancelOnAbort := h.Sandboxes.AbortOnShutdown(execCtx)
+	defer cancelOnAbort()
+
+	start := time.Now()
+	output, err := h.Sandboxes.Exec(execCtx, acquiredContainer, formatter.Command(req.Language), model.ExecOptions{
+		Stdin: req.Code,
+		Env:   formatter.Env,
+		User:  h.Sandboxes.User(),
+	})
+	duration := time.Since(start)
+
+	resp := model.FormatResponse{
+		Language:  req.Language,
+		Formatter: formatter.Name,
+		Queue:     queueReport,
+	}
+	status := http.StatusOK
+	outcome := FORMAT_SUCCESS
+
+	var exitErr *model.ExitError
+	switch {
+	case errors.Is(context.Cause(execCtx), sandbox.ErrShuttingDown):
+		outcome = FORMAT_ABORTED
+		resp.Error = "Formatting aborted: the server shut down before it finished"
+		status = http.StatusServiceUnavailable
+	case errors.Is(execCtx.Err(), context.DeadlineExceeded):
+		outcome = FORMAT_TIMEOUT
+		resp.Error = fmt.Sprintf("Formatting timed out after %s", h.ExecutionTimeout)
+		status = http.StatusRequestTimeout
+	// 126 and 127 mean the command could not be started: the image lacks
+	// the formatter.
+	case errors.As(err, &exitErr) && (exitErr.ExitCode == 126 || exitErr.ExitCode == 127):
+		outcome = FORMAT_UNAVAILABLE
+		logger.WarnContext(r.Context(), "Formatter missing from image", "image", acquiredContainer.Image, "exit_code", exitErr.ExitCode)
+		metrics.Formats.WithLabelValues(req.Language, outcome).Inc()
+		respondError(w, http.StatusNotImplemented, ErrCodeNoFormatter,
+			fmt.Sprintf("%s is not installed in the %s image", formatter.Name, req.Language), nil)
+		return
+	case errors.As(err, &exitErr):
+		outcome = FORMAT_SYNTAX_ERROR
+		resp.Error = exitErr.Output
+		resp.Diagnostics = formatter.Diagnostics(exitErr.Output)
+		status = http.StatusBadRequest
+	case err != nil:
+		outcome = FORMAT_ERROR
+		logger.ErrorContext(r.Context(), "Error running formatter", "error", err)
+		metrics.Formats.WithLabelValues(req.Language, outcome).Inc()
+		http.Error(w, fmt.Sprintf("Failed to run %s", formatter.Name), http.StatusInternalServerError)
+		return
+	default:
+		resp.Formatted = output
+		resp.Changed = output != req.Code
+	}
+	metrics.Formats.WithLabelValues(req.Language, outcome).Inc()
+	// The output can echo the submitted source, so it is not logged.
+	logger.InfoContext(r.Context(), "Format finished", "outcome", outcome, "duration", duration)
+	respondJSONStatus(w, status, resp)
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+// HealthHandler reports whether the server's dependencies are reachable.
+// check is typically the database pool's Ping.
+func HealthHandler(w http.ResponseWriter, r *http.Request, check func(context.Context) error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	if err := check(ctx); err != nil {
+		slog.WarnContext(r.Context(), "Health check failed", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		respondJSON(w, map[string]string{"status": "unavailable"})
+		return
+	}
+	respondJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
+)
+
+const (
+	HISTORY_DEFAULT_PAGE_SIZE = 20
+	HISTORY_MAX_PAGE_SIZE     = 100
+)
+
+// HistoryHandler serves a user's execution history.
+type HistoryHandler struct {
+	Executions store.ExecutionStore
+	// Exec performs re-runs.
+	Exec *ExecHandler
+}
+
+// List serves GET /executions. It filters on language, status and a
+// created_at range given as RFC 3339 from/to, and pages with limit and the
+// opaque cursor returned in the previous page.
+func (h *HistoryHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	filter, problems := parseHistoryFilter(r)
+	if problems != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Invalid history query", problems)
+		return
+	}
+	filter.UserID = principal.UserID
+	// Fetch one extra row to learn whether another page follows.
+	pageSize := filter.Limit
+	filter.Limit++
+
+	executions, err := h.Executions.ListExecutions(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing executions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := model.ExecutionList{Executions: make([]model.ExecutionSummary, 0, len(executions))}
+	if len(executions) > pageSize {
+		executions = executions[:pageSize]
+		last := executions[len(executions)-1]
+		resp.NextCursor = encodeCursor(store.ExecutionCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	for _, e := range executions {
+		resp.Executions = append(resp.Executions, toExecutionSummary(e))
+	}
+	respondJSON(w, resp)
+}
+
+// Get serves GET /executions/{id}.
+func (h *HistoryHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	e, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, model.Execution{
+		ExecutionSummary: toExecutionSummary(e),
+		Code:             e.Code,
+		Stdin:            e.Stdin,
+		Dependencies:     e.Dependencies,
+		PackageJSON:      e.PackageJSON,
+		Output:           e.Output,
+		Error:            e.Error,
+	})
+}
+
+// Rerun serves POST /executions/{id}/rerun. It runs the stored code and
+// stdin again and responds exactly as /exec does; the new run is recorded
+// as a history entry of its own.
+func (h *HistoryHandler) Rerun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	e, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	if !e.CodeRetained {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "The source of this execution was not retained", nil)
+		return
+	}
+	h.Exec.run(w, r, model.ExecRequest{
+		Code:         e.Code,
+		Language:     e.Language,
+		Stdin:        e.Stdin,
+		Dependencies: e.Dependencies,
+		PackageJSON:  e.PackageJSON,
+	})
+}
+
+// lookup loads the caller's execution named in the path, writing an error
+// response if there is none.
+func (h *HistoryHandler) lookup(w http.ResponseWriter, r *http.Request) (store.Execution, bool) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "Execution not found", nil)
+		return store.Execution{}, false
+	}
+	e, err := h.Executions.GetExecution(r.Context(), principal.UserID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "Execution not found", nil)
+		return store.Execution{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading execution", "execution_id", id, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return store.Execution{}, false
+	}
+	return e, true
+}
+
+func parseHistoryFilter(r *http.Request) (store.ExecutionFilter, map[string]string) {
+	q := r.URL.Query()
+	problems := make(map[string]string)
+	filter := store.ExecutionFilter{
+		Language: q.Get("language"),
+		Status:   q.Get("status"),
+		Limit:    HISTORY_DEFAULT_PAGE_SIZE,
+	}
+
+	switch filter.Status {
+	case "", store.ExecutionSuccess, store.ExecutionError, store.ExecutionTimeout, store.ExecutionAborted, store.ExecutionInstallFailed, store.ExecutionBlocked:
+	default:
+		problems["status"] = "must be one of success, error, timeout, aborted, install_failed, blocked"
+	}
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if raw := q.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				problems[name] = "must be an RFC 3339 timestamp"
+			}
+			*target = t
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > HISTORY_MAX_PAGE_SIZE {
+			problems["limit"] = "must be between 1 and 100"
+		}
+		filter.Limit = n
+	}
+	if raw := q.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil {
+			problems["cursor"] = "is not valid"
+		}
+		filter.After = &cursor
+	}
+
+	if len(problems) > 0 {
+		return filter, problems
+	}
+	return filter, nil
+}
+
+// Cursors are "<unix nanoseconds>.<id>", base64 encoded so clients treat
+// them as opaque.
+func encodeCursor(c store.ExecutionCursor) string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + "." + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (store.ExecutionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return store.ExecutionCursor{}, err
+	}
+	nanos, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return store.ExecutionCursor{}, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return store.ExecutionCursor{}, err
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return store.ExecutionCursor{}, err
+	}
+	return store.ExecutionCursor{CreatedAt: time.Unix(0, n).UTC(), ID: parsed}, nil
+}
+
+func toExecutionSummary(e store.Execution) model.ExecutionSummary {
+	return model.ExecutionSummary{
+		ID:         e.ID,
+		Language:   e.Language,
+		Status:     e.Status,
+		ExitCode:   e.ExitCode,
+		DurationMS: e.Duration.Milliseconds(),
+		Image:      e.Image,
+		CodeSHA256: e.CodeSHA256,
+		Rerunnable: e.CodeRetained,
+		CreatedAt:  e.CreatedAt,
+	}
+}
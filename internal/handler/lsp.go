@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/lsp"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// LSP_WORKSPACE_ROOT holds one workspace directory per session inside
+	// the sandbox.
+	LSP_WORKSPACE_ROOT = "/tmp/alcaide-lsp"
+	// LSP_SHUTDOWN_GRACE is how long a language server has to exit once
+	// its stdin is closed.
+	LSP_SHUTDOWN_GRACE = 5 * time.Second
+	LSP_WRITE_TIMEOUT  = 10 * time.Second
+)
+
+// lspScript creates the session's workspace, $0, and runs the language
+// server in it. HOME points there too so caches are removed with it when
+// the server exits; the container goes back to the pool afterwards.
+const lspScript = `d=$0
+mkdir -p "$d" && cd "$d" || exit 1
+HOME="$d" XDG_CACHE_HOME="$d/.cache" "$@"
+s=$?
+cd / && rm -rf "$d"
+exit $s`
+
+// The ticket, not ambient credentials such as cookies, authorises a
+// connection, so requests from other origins gain nothing.
+var lspUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// LSPHandler runs language servers in pooled containers and proxies LSP
+// between them and WebSocket clients. Collection and Close must be
+// wrapped in authentication; Connect is authorised by a session's ticket.
+type LSPHandler struct {
+	// Exec supplies the sandboxes and acquires containers the way /exec
+	// does.
+	Exec     *ExecHandler
+	Sessions *lsp.Sessions
+	// IdleTimeout closes a connection whose client has sent nothing for
+	// this long.
+	IdleTimeout time.Duration
+}
+
+// Collection serves /lsp/sessions: GET lists the caller's sessions, POST
+// creates one.
+func (h *LSPHandler) Collection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		principal, _ := auth.PrincipalFrom(r.Context())
+		respondJSON(w, model.LSPSessionList{Sessions: h.Sessions.List(principal.UserID)})
+	case http.MethodPost:
+		h.create(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *LSPHandler) create(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	var req model.CreateLSPSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload", nil)
+		return
+	}
+	if _, ok := h.Exec.Sandboxes.Command(req.Language); !ok {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported language: %s", req.Language),
+			map[string]string{"language": "is not supported"})
+		return
+	}
+	if len(h.Exec.Sandboxes.LanguageServer(req.Language)) == 0 {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("No language server is configured for %s", req.Language),
+			map[string]string{"language": "has no language server"})
+		return
+	}
+	if req.RootURI != "" {
+		if u, err := url.Parse(req.RootURI); err != nil || u.Scheme != "file" || !path.IsAbs(u.Path) {
+			respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Session details are invalid",
+				map[string]string{"root_uri": "must be an absolute file:// URI"})
+			return
+		}
+	}
+
+	session, ticket, err := h.Sessions.Create(principal, req.Language, req.RootURI)
+	if errors.Is(err, lsp.ErrTooManySessions) {
+		respondError(w, http.StatusConflict, ErrCodeConflict,
+			"Too many language server sessions are open; close one first", nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating LSP session", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "LSP session created", "session_id", session.ID, "language", session.Language)
+	respondJSONStatus(w, http.StatusCreated, model.CreateLSPSessionResponse{
+		LSPSession:      session.Status(),
+		Ticket:          ticket,
+		TicketExpiresAt: session.TicketExpires,
+		ConnectURL:      "/lsp/sessions/" + session.ID.String() + "/connect",
+		WorkspaceURI:    lsp.NewWorkspace("", workspaceDir(session.ID)).SandboxURI,
+	})
+}
+
+// Close serves DELETE /lsp/sessions/{id}, disconnecting the session's
+// client if it is connected.
+func (h *LSPHandler) Close(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal, _ := auth.PrincipalFrom(r.Context())
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err == nil {
+		err = h.Sessions.Close(id, principal.UserID)
+	}
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "LSP session not found", nil)
+		return
+	}
+	slog.InfoContext(r.Context(), "LSP session closed", "session_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Connect serves GET /lsp/sessions/{id}/connect?ticket=...: it redeems the
+// session's ticket, upgrades to a WebSocket carrying one JSON-RPC message
+// per frame and starts the language server in a container of the session's
+// language, or says in the close frame why it cannot. The session ends when
+// either side goes away, the client is idle for IdleTimeout, or the
+// session is closed.
+func (h *LSPHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Expected a WebSocket upgrade", nil)
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "LSP session not found", nil)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session, err := h.Sessions.Connect(id, r.URL.Query().Get("ticket"), cancel)
+	if errors.Is(err, lsp.ErrNoSession) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "LSP session not found", nil)
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeInvalidToken, "Invalid, expired or already used ticket", nil)
+		return
+	}
+	defer h.Sessions.End(id)
+
+	// The connection acts for the session's creator when queuing for a
+	// container.
+	ctx = auth.WithPrincipal(ctx, session.Principal)
+	r = r.WithContext(ctx)
+	logger := slog.With("session_id", id, "language", session.Language, "user_id", session.Principal.UserID)
+
+	server := h.Exec.Sandboxes.LanguageServer(session.Language)
+	if len(server) == 0 {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("No language server is configured for %s", session.Language), nil)
+		return
+	}
+
+	// Upgrade before taking a container, so the client sees the connection
+	// open while it waits and failures arrive as close frames it can read.
+	conn, err := lspUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded.
+		logger.InfoContext(ctx, "WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	priority, _ := h.Exec.priority(ctx, PRIORITY_INTERACTIVE)
+	acquiredContainer, _, err := h.Exec.acquireContainer(r, session.Language, priority, logger)
+	if err != nil {
+		code, reason := acquireCloseReason(err, session.Language)
+		closeWith(conn, code, reason)
+		return
+	}
+	logger = logger.With("container_id", acquiredContainer.ID)
+	defer h.Exec.Sandboxes.ReleaseContainer(context.WithoutCancel(ctx), acquiredContainer, session.Language)
+
+	procCtx, cancelOnAbort := h.Exec.Sandboxes.AbortOnShutdown(ctx)
+	defer cancelOnAbort()
+	dir := workspaceDir(id)
+	cmd := append([]string{"sh", "-c", lspScript, dir}, server...)
+	proc, err := h.Exec.Sandboxes.StartProcess(procCtx, acquiredContainer, cmd, model.ExecOptions{User: h.Exec.Sandboxes.User()})
+	if err != nil {
+		logger.ErrorContext(ctx, "Error starting language server", "error", err)
+		closeWith(conn, websocket.CloseInternalServerErr, "Failed to start the language server")
+		return
+	}
+	defer proc.Close()
+
+	metrics.LSPSessions.WithLabelValues(session.Language).Inc()
+	defer metrics.LSPSessions.WithLabelValues(session.Language).Dec()
+	logger.InfoContext(ctx, "LSP session connected")
+	start := time.Now()
+	reason := h.proxy(procCtx, conn, proc, lsp.NewWorkspace(session.ClientRoot, dir))
+	logger.InfoContext(ctx, "LSP session ended", "reason", reason, "duration", time.Since(start))
+}
+
+// acquireCloseReason is the close frame telling an LSP client why no
+// container could be had for language; see ExecHandler.acquire.
+func acquireCloseReason(err error, language string) (int, string) {
+	switch {
+	case errors.Is(err, sandbox.ErrQueueFull):
+		return websocket.CloseTryAgainLater, fmt.Sprintf("Too many executions are waiting for a %s container; retry shortly", language)
+	case errors.Is(err, sandbox.ErrShuttingDown):
+		return websocket.CloseServiceRestart, "The server is shutting down; retry shortly"
+	case errors.Is(err, context.DeadlineExceeded):
+		return websocket.CloseTryAgainLater, fmt.Sprintf("Container acquisition timed out for %s", language)
+	default:
+		return websocket.CloseInternalServerErr, fmt.Sprintf("Failed to acquire container for %s", language)
+	}
+}
+
+// closeWith sends a close frame with code and reason; the caller still
+// closes conn.
+func closeWith(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+func workspaceDir(id uuid.UUID) string {
+	return path.Join(LSP_WORKSPACE_ROOT, id.String())
+}
+
+// proxy relays messages between conn and the language server until either
+// side stops or ctx is cancelled, then shuts both down and returns why.
+func (h *LSPHandler) proxy(ctx context.Context, conn *websocket.Conn, proc model.Process, workspace lsp.Workspace) string {
+	conn.SetReadLimit(lsp.MaxMessageBytes)
+	fromClient := make(chan error, 1)
+	go func() { fromClient <- h.readClient(conn, proc, workspace) }()
+	fromServer := make(chan error, 1)
+	go func() { fromServer <- h.readServer(conn, proc, workspace) }()
+
+	var code int
+	var reason string
+	serverDone := false
+	var netErr net.Error
+	select {
+	case err := <-fromClient:
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			code, reason = websocket.CloseGoingAway, "idle timeout"
+		case errors.Is(err, errInvalidLSPMessage):
+			code, reason = websocket.CloseInvalidFramePayloadData, "invalid JSON-RPC message"
+		default:
+			code, reason = websocket.CloseNormalClosure, "client disconnected"
+		}
+	case <-fromServer:
+		serverDone = true
+		code, reason = websocket.CloseInternalServerErr, "language server exited"
+	case <-ctx.Done():
+		code, reason = websocket.CloseGoingAway, "session closed"
+	}
+
+	// Language servers exit when their input ends, which also removes the
+	// workspace; give the server a moment to do so.
+	proc.Stdin().Close()
+	if !serverDone {
+		select {
+		case <-fromServer:
+		case <-time.After(LSP_SHUTDOWN_GRACE):
+		}
+	}
+	closeWith(conn, code, reason)
+	return reason
+}
+
+var errInvalidLSPMessage = errors.New("invalid LSP message")
+
+// readClient forwards the client's messages to the language server. Each
+// read must arrive within IdleTimeout.
+func (h *LSPHandler) readClient(conn *websocket.Conn, proc model.Process, workspace lsp.Workspace) error {
+	stdin := proc.Stdin()
+	for {
+		conn.SetReadDeadline(time.Now().Add(h.IdleTimeout))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		msg, err = workspace.ToSandbox(msg)
+		if err != nil {
+			return errInvalidLSPMessage
+		}
+		if err := lsp.WriteMessage(stdin, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// readServer forwards the language server's messages to the client. It is
+// the only writer of data frames on conn.
+func (h *LSPHandler) readServer(conn *websocket.Conn, proc model.Process, workspace lsp.Workspace) error {
+	stdout := bufio.NewReader(proc.Stdout())
+	for {
+		msg, err := lsp.ReadMessage(stdout)
+		if err != nil {
+			return err
+		}
+		msg, err = workspace.ToClient(msg)
+		if err != nil {
+			slog.Warn("Dropping invalid message from language server", "error", err)
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(LSP_WRITE_TIMEOUT))
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return err
+		}
+	}
+}
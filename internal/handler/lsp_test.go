@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/lsp"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// fakeSandboxes has a language server for every language and fails to
+// acquire or start with the given errors. Other calls are not implemented.
+type fakeSandboxes struct {
+	Sandboxes
+	acquireErr error
+	startErr   error
+	released   chan struct{}
+}
+
+func (s *fakeSandboxes) LanguageServer(string) []string { return []string{"pylsp"} }
+
+func (s *fakeSandboxes) User() string { return "" }
+
+func (s *fakeSandboxes) AcquireContainer(context.Context, string, sandbox.AcquireOptions) (*model.ContainerInfo, error) {
+	if s.acquireErr != nil {
+		return nil, s.acquireErr
+	}
+	return &model.ContainerInfo{ID: "c1"}, nil
+}
+
+func (s *fakeSandboxes) ReleaseContainer(context.Context, *model.ContainerInfo, string) {
+	close(s.released)
+}
+
+func (s *fakeSandboxes) AbortOnShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(ctx)
+}
+
+func (s *fakeSandboxes) StartProcess(context.Context, *model.ContainerInfo, []string, model.ExecOptions) (model.Process, error) {
+	return nil, s.startErr
+}
+
+func TestLSPConnectReportsFailuresInCloseFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		sandboxes  *fakeSandboxes
+		wantCode   int
+		wantReason string
+	}{
+		{"queue full", &fakeSandboxes{acquireErr: sandbox.ErrQueueFull}, websocket.CloseTryAgainLater, "Too many executions"},
+		{"draining", &fakeSandboxes{acquireErr: sandbox.ErrShuttingDown}, websocket.CloseServiceRestart, "shutting down"},
+		{"timeout", &fakeSandboxes{acquireErr: context.DeadlineExceeded}, websocket.CloseTryAgainLater, "timed out"},
+		{"start fails", &fakeSandboxes{startErr: errors.New("exec create failed"), released: make(chan struct{})}, websocket.CloseInternalServerErr, "Failed to start the language server"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &LSPHandler{
+				Exec:        &ExecHandler{Sandboxes: tc.sandboxes, AcquireTimeout: time.Second},
+				Sessions:    lsp.NewSessions(1),
+				IdleTimeout: time.Minute,
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("/lsp/sessions/{id}/connect", h.Connect)
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			session, ticket, err := h.Sessions.Create(auth.Principal{UserID: uuid.New()}, "python", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/lsp/sessions/" + session.ID.String() + "/connect?ticket=" + ticket
+			conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("dial: %v (response %v)", err, resp)
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("read: got %v, want a close frame", err)
+			}
+			if closeErr.Code != tc.wantCode || !strings.Contains(closeErr.Text, tc.wantReason) {
+				t.Errorf("close frame = %d %q, want %d containing %q", closeErr.Code, closeErr.Text, tc.wantCode, tc.wantReason)
+			}
+			if tc.sandboxes.released != nil {
+				select {
+				case <-tc.sandboxes.released:
+				case <-time.After(5 * time.Second):
+					t.Error("container not released after the language server failed to start")
+				}
+			}
+		})
+	}
+}
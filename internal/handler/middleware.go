@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/logging"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+)
+
+// APIKeyHeader carries a personal API key as an alternative to
+// "Authorization: Bearer <key>".
+const APIKeyHeader = "X-API-Key"
+
+// Authenticator resolves the caller of a request from its bearer token or
+// API key.
+type Authenticator struct {
+	JWTSecret []byte
+	Users     store.UserStore
+	// Keys resolves API keys; nil disables them.
+	Keys store.APIKeyStore
+}
+
+// Authenticate attaches the caller's Principal to the request context when
+// a valid bearer token or API key is present. Requests without credentials
+// pass through anonymously; requests with bad credentials are rejected.
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			a.serveWithAPIKey(w, r, key, next)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Authorization header must be a bearer token", nil)
+			return
+		}
+		if auth.IsAPIKey(token) {
+			a.serveWithAPIKey(w, r, token, next)
+			return
+		}
+		principal, err := auth.ParseSessionToken(token, a.JWTSecret)
+		if err != nil {
+			slog.InfoContext(r.Context(), "Rejected session token", "error", err)
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid or expired token", nil)
+			return
+		}
+		user, err := a.Users.GetUserByID(r.Context(), principal.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Account no longer exists", nil)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error looking up user", "user_id", principal.UserID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// Changing the password ends every session from before the change.
+		if principal.IssuedAt.Before(user.PasswordChangedAt.Truncate(time.Millisecond)) {
+			slog.InfoContext(r.Context(), "Rejected session token issued before password change", "user_id", user.ID)
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "The password was changed since this session began; log in again", nil)
+			return
+		}
+		ctx := logging.WithAttrs(r.Context(), slog.String("user_id", principal.UserID.String()))
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(ctx, principal)))
+	})
+}
+
+func (a *Authenticator) serveWithAPIKey(w http.ResponseWriter, r *http.Request, raw string, next http.Handler) {
+	if a.Keys == nil || !auth.IsAPIKey(raw) {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid API key", nil)
+		return
+	}
+	key, err := a.Keys.UseAPIKey(r.Context(), auth.HashAPIKey(raw))
+	if errors.Is(err, store.ErrNotFound) {
+		slog.InfoContext(r.Context(), "Rejected API key")
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid, expired or revoked API key", nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error looking up API key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	user, err := a.Users.GetUserByID(r.Context(), key.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Account no longer exists", nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error looking up user", "user_id", key.UserID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	principal := auth.Principal{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}
+	ctx := logging.WithAttrs(r.Context(),
+		slog.String("user_id", user.ID.String()),
+		slog.String("api_key_id", key.ID.String()))
+	next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(ctx, principal)))
+}
+
+// RequireAuth rejects anonymous requests.
+func (a *Authenticator) RequireAuth(next http.Handler) http.Handler {
+	return a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.PrincipalFrom(r.Context()); !ok {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Authentication required", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RequireVerified rejects anonymous requests and users whose email address
+// has not been verified. The flag is read from the store rather than the
+// token so verifying takes effect without logging in again.
+func (a *Authenticator) RequireVerified(next http.Handler) http.Handler {
+	return a.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+		user, err := a.Users.GetUserByID(r.Context(), principal.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Account no longer exists", nil)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error looking up user", "user_id", principal.UserID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !user.EmailVerified {
+			respondError(w, http.StatusForbidden, ErrCodeEmailUnverified, "Verify your email address to use this feature", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RequireScope rejects API-key callers whose key was not granted scope.
+// Session and anonymous callers pass; combine with RequireAuth to exclude
+// the latter.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := auth.PrincipalFrom(r.Context()); ok && !principal.Allows(scope) {
+			respondError(w, http.StatusForbidden, ErrCodeInsufficientScope,
+				fmt.Sprintf("This API key does not have the %q scope", scope), nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSession rejects requests not made with a session token, so an API
+// key cannot be used to manage API keys.
+func (a *Authenticator) RequireSession(next http.Handler) http.Handler {
+	return a.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, _ := auth.PrincipalFrom(r.Context()); principal.ViaAPIKey() {
+			respondError(w, http.StatusForbidden, ErrCodeInsufficientScope, "This endpoint requires logging in; API keys are not accepted", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
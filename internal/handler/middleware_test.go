@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// apiKeyTest is an Authenticator over one user, guarding routes wrapped
+// the way the router wraps them.
+type apiKeyTest struct {
+	authn *Authenticator
+	user  model.User
+	keys  *store.MemoryAPIKeyStore
+	mux   *http.ServeMux
+}
+
+func newAPIKeyTest(t *testing.T) *apiKeyTest {
+	t.Helper()
+	user := model.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com", EmailVerified: true}
+	users := store.NewMemoryUserStore()
+	if err := users.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	keys := store.NewMemoryAPIKeyStore()
+	authn := &Authenticator{JWTSecret: []byte("jwt secret"), Users: users, Keys: keys}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/exec", authn.Authenticate(RequireScope(auth.ScopeExec, ok)))
+	mux.Handle("/repl/sessions", authn.RequireAuth(RequireScope(auth.ScopeExec, ok)))
+	mux.Handle("/executions", authn.RequireAuth(RequireScope(auth.ScopeHistory, ok)))
+	mux.Handle("/executions/{id}/rerun", authn.RequireAuth(RequireScope(auth.ScopeHistory, RequireScope(auth.ScopeExec, ok))))
+	mux.Handle("/api-keys", authn.RequireSession(ok))
+	return &apiKeyTest{authn: authn, user: user, keys: keys, mux: mux}
+}
+
+// newKey stores a key for the user with scopes and returns it.
+func (a *apiKeyTest) newKey(t *testing.T, scopes ...string) (string, uuid.UUID) {
+	t.Helper()
+	raw, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	key := store.APIKey{ID: id, UserID: a.user.ID, Name: "ci", Prefix: prefix, Hash: hash, Scopes: scopes, CreatedAt: time.Now()}
+	if err := a.keys.CreateAPIKey(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	return raw, id
+}
+
+// do requests path with the given header and returns the status and error
+// code of the response.
+func (a *apiKeyTest) do(path, header, value string) (int, string) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	a.mux.ServeHTTP(rec, req)
+	var resp model.ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp.Error.Code
+}
+
+func TestAPIKeyRejectsUnknownAndRevokedKeys(t *testing.T) {
+	a := newAPIKeyTest(t)
+	raw, id := a.newKey(t, auth.Scopes...)
+	if code, _ := a.do("/exec", APIKeyHeader, raw); code != http.StatusOK {
+		t.Fatalf("valid key: got %d, want 200", code)
+	}
+
+	unknown, _, _, _ := auth.NewAPIKey()
+	if err := a.keys.RevokeAPIKey(context.Background(), a.user.ID, id); err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string]string{"unknown": unknown, "revoked": raw, "malformed": "not-a-key"} {
+		for _, header := range []string{APIKeyHeader, "Authorization"} {
+			value := key
+			if header == "Authorization" {
+				value = "Bearer " + key
+			}
+			if code, errCode := a.do("/exec", header, value); code != http.StatusUnauthorized || errCode != ErrCodeUnauthorized {
+				t.Errorf("%s key in %s: got %d %q, want 401", name, header, code, errCode)
+			}
+		}
+	}
+
+	// With API keys disabled every key is refused.
+	a.authn.Keys = nil
+	if code, _ := a.do("/exec", APIKeyHeader, unknown); code != http.StatusUnauthorized {
+		t.Errorf("key with API keys disabled: got %d, want 401", code)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	a := newAPIKeyTest(t)
+	execKey, _ := a.newKey(t, auth.ScopeExec)
+	historyKey, _ := a.newKey(t, auth.ScopeHistory)
+	allKey, _ := a.newKey(t, auth.Scopes...)
+	session, err := auth.NewSessionToken(a.user, a.authn.JWTSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, path, header, value string
+		want                      int
+	}{
+		{"exec key", "/exec", APIKeyHeader, execKey, http.StatusOK},
+		{"history key", "/exec", APIKeyHeader, historyKey, http.StatusForbidden},
+		{"history key", "/repl/sessions", "Authorization", "Bearer " + historyKey, http.StatusForbidden},
+		{"history key", "/executions", APIKeyHeader, historyKey, http.StatusOK},
+		{"history key", "/executions/1/rerun", APIKeyHeader, historyKey, http.StatusForbidden},
+		{"exec key", "/executions", APIKeyHeader, execKey, http.StatusForbidden},
+		{"exec key", "/executions/1/rerun", APIKeyHeader, execKey, http.StatusForbidden},
+		{"key with every scope", "/executions/1/rerun", APIKeyHeader, allKey, http.StatusOK},
+		// Session tokens carry every scope.
+		{"session", "/exec", "Authorization", "Bearer " + session, http.StatusOK},
+		{"session", "/executions/1/rerun", "Authorization", "Bearer " + session, http.StatusOK},
+		{"anonymous", "/exec", "", "", http.StatusOK},
+		{"anonymous", "/executions", "", "", http.StatusUnauthorized},
+	} {
+		code, errCode := a.do(tc.path, tc.header, tc.value)
+		if code != tc.want {
+			t.Errorf("%s on %s: got %d, want %d", tc.name, tc.path, code, tc.want)
+		}
+		if code == http.StatusForbidden && errCode != ErrCodeInsufficientScope {
+			t.Errorf("%s on %s: error code %q, want %q", tc.name, tc.path, errCode, ErrCodeInsufficientScope)
+		}
+	}
+}
+
+func TestRequireSessionRefusesAPIKeys(t *testing.T) {
+	a := newAPIKeyTest(t)
+	key, _ := a.newKey(t, auth.Scopes...)
+	session, err := auth.NewSessionToken(a.user, a.authn.JWTSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Even a key with every scope cannot manage API keys.
+	for _, tc := range []struct{ header, value string }{
+		{APIKeyHeader, key},
+		{"Authorization", "Bearer " + key},
+	} {
+		if code, errCode := a.do("/api-keys", tc.header, tc.value); code != http.StatusForbidden || errCode != ErrCodeInsufficientScope {
+			t.Errorf("API key in %s: got %d %q, want 403", tc.header, code, errCode)
+		}
+	}
+	if code, _ := a.do("/api-keys", "Authorization", "Bearer "+session); code != http.StatusOK {
+		t.Errorf("session: got %d, want 200", code)
+	}
+	if code, _ := a.do("/api-keys", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d, want 401", code)
+	}
+}
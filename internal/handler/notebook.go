@@ -0,0 +1,323 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/notebook"
+	"github.com/Aadithya-J/alcaIDE/internal/repl"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// NOTEBOOK_MAX_BYTES bounds an uploaded notebook.
+	NOTEBOOK_MAX_BYTES = 16 << 20
+	// NOTEBOOK_WORKSPACE_ROOT holds one working directory per run inside
+	// the sandbox.
+	NOTEBOOK_WORKSPACE_ROOT = "/tmp/alcaide-notebook"
+)
+
+// How a notebook was run, as recorded in metrics.
+const (
+	NOTEBOOK_SYNC  = "sync"
+	NOTEBOOK_ASYNC = "async"
+)
+
+// NotebookHandler runs Jupyter notebooks top to bottom in their language's
+// REPL kernel, either while the request waits or as a background job.
+type NotebookHandler struct {
+	// Exec supplies the sandboxes and acquires containers the way /exec
+	// does.
+	Exec *ExecHandler
+	Jobs *notebook.Jobs
+	// CellTimeout is the default limit on one cell.
+	CellTimeout time.Duration
+	// MaxRuntime bounds a whole run, including waiting for a container
+	// for a background one.
+	MaxRuntime time.Duration
+}
+
+// notebookRun is a validated request to run a notebook.
+type notebookRun struct {
+	nb        *notebook.Notebook
+	language  string
+	kernelCmd []string
+	opts      notebook.Options
+}
+
+// Run serves POST /notebooks/run: it runs the notebook in the body and
+// responds with it executed. The body is the .ipynb document, or a
+// multipart form with it as the "notebook" file. Query parameters:
+// cell_timeout, a duration such as 30s; stop_on_error, true by default.
+// A notebook whose code raised is still a 200; see the result's status.
+func (h *NotebookHandler) Run(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	run, ok := h.parse(w, r)
+	if !ok {
+		return
+	}
+
+	logger := slog.With("language", run.language, "cells", len(run.nb.Cells))
+	priority, _ := h.Exec.priority(r.Context(), PRIORITY_INTERACTIVE)
+	acquiredContainer, _, ok := h.Exec.acquire(w, r, run.language, priority, logger)
+	if !ok {
+		return
+	}
+	logger = logger.With("container_id", acquiredContainer.ID)
+	defer h.Exec.Sandboxes.ReleaseContainer(context.WithoutCancel(r.Context()), acquiredContainer, run.language)
+
+	result, err := h.execute(r.Context(), acquiredContainer, run, nil)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Error starting notebook kernel", "error", err)
+		http.Error(w, "Failed to start the notebook's kernel", http.StatusInternalServerError)
+		return
+	}
+	metrics.NotebookRuns.WithLabelValues(run.language, NOTEBOOK_SYNC, result.Status).Inc()
+	logger.InfoContext(r.Context(), "Notebook run finished", "status", result.Status,
+		"executed_cells", result.ExecutedCells, "duration", time.Duration(result.DurationMS)*time.Millisecond)
+	respondJSON(w, result)
+}
+
+// Collection serves /notebooks/jobs: GET lists the caller's background
+// runs, POST starts one from a notebook submitted as for /notebooks/run
+// and responds 202 with the job to poll.
+func (h *NotebookHandler) Collection(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, model.NotebookJobList{Jobs: h.Jobs.List(principal.UserID)})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, ok := h.parse(w, r)
+	if !ok {
+		return
+	}
+	priority, _ := h.Exec.priority(r.Context(), PRIORITY_BATCH)
+	user := callerKey(r)
+	job, err := h.Jobs.Submit(principal.UserID, run.nb.CodeCells(), func(ctx context.Context, job *notebook.Job) model.NotebookResult {
+		logger := slog.With("job_id", job.ID, "language", run.language, "cells", len(run.nb.Cells))
+		result := h.runJob(ctx, job, run, priority, user, logger)
+		metrics.NotebookRuns.WithLabelValues(run.language, NOTEBOOK_ASYNC, result.Status).Inc()
+		logger.Info("Notebook job finished", "status", result.Status, "executed_cells", result.ExecutedCells, "error", result.Error)
+		return result
+	})
+	if errors.Is(err, notebook.ErrTooManyJobs) {
+		respondError(w, http.StatusConflict, ErrCodeConflict,
+			"Too many notebook jobs are unfinished; wait for one or cancel it", nil)
+		return
+	}
+	if err != nil {
+		w.Header().Set("Retry-After", "30")
+		respondError(w, http.StatusServiceUnavailable, ErrCodeShuttingDown, "The server is shutting down; retry shortly", nil)
+		return
+	}
+	w.Header().Set("Location", "/notebooks/jobs/"+job.ID.String())
+	respondJSONStatus(w, http.StatusAccepted, job.Status())
+}
+
+// runJob waits for a container, at batch priority, and runs the job's
+// notebook in it.
+func (h *NotebookHandler) runJob(ctx context.Context, job *notebook.Job, run notebookRun, priority sandbox.Priority, user string, logger *slog.Logger) model.NotebookResult {
+	start := time.Now()
+	// The wait for a container counts against the job's runtime.
+	ctx, cancel := context.WithTimeout(ctx, h.MaxRuntime)
+	defer cancel()
+	acquiredContainer, err := h.Exec.Sandboxes.AcquireContainer(ctx, run.language, sandbox.AcquireOptions{User: user, Priority: priority})
+	if err != nil {
+		result := model.NotebookResult{Status: model.NotebookError, DurationMS: time.Since(start).Milliseconds()}
+		switch {
+		case errors.Is(err, sandbox.ErrShuttingDown) || errors.Is(ctx.Err(), context.Canceled):
+			result.Status = model.NotebookCancelled
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			result.Status = model.NotebookTimedOut
+			result.Error = "Timed out waiting for a container"
+		case errors.Is(err, sandbox.ErrQueueFull):
+			result.Error = fmt.Sprintf("Too many executions are waiting for a %s container", run.language)
+		default:
+			logger.Error("Error acquiring container", "error", err)
+			result.Error = fmt.Sprintf("Failed to acquire container for %s", run.language)
+		}
+		return result
+	}
+	defer h.Exec.Sandboxes.ReleaseContainer(context.Background(), acquiredContainer, run.language)
+
+	result, err := h.execute(ctx, acquiredContainer, run, job)
+	if err != nil {
+		logger.Error("Error starting notebook kernel", "container_id", acquiredContainer.ID, "error", err)
+		return model.NotebookResult{
+			Status:     model.NotebookError,
+			Error:      "Failed to start the notebook's kernel",
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+	}
+	result.DurationMS = time.Since(start).Milliseconds()
+	return result
+}
+
+// Job serves /notebooks/jobs/{id}: GET describes the job, with its result
+// once finished; DELETE cancels it if it is unfinished and forgets it.
+func (h *NotebookHandler) Job(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	id, err := uuid.Parse(r.PathValue("id"))
+	var job *notebook.Job
+	if err == nil {
+		job, err = h.Jobs.Get(id, principal.UserID)
+	}
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "Notebook job not found", nil)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, job.Status())
+	case http.MethodDelete:
+		if err := h.Jobs.Delete(id, principal.UserID); err != nil {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, "Notebook job not found", nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parse reads and validates a notebook run request, responding with the
+// problem if it is invalid.
+func (h *NotebookHandler) parse(w http.ResponseWriter, r *http.Request) (notebookRun, bool) {
+	opts := notebook.Options{CellTimeout: h.CellTimeout, StopOnError: true}
+	query := r.URL.Query()
+	if v := query.Get("cell_timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > h.MaxRuntime {
+			respondError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Run options are invalid",
+				map[string]string{"cell_timeout": fmt.Sprintf("must be a duration such as 30s, at most %s", h.MaxRuntime)})
+			return notebookRun{}, false
+		}
+		opts.CellTimeout = d
+	}
+	if v := query.Get("stop_on_error"); v != "" {
+		stop, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, ErrCodeValidationFailed, "Run options are invalid",
+				map[string]string{"stop_on_error": "must be true or false"})
+			return notebookRun{}, false
+		}
+		opts.StopOnError = stop
+	}
+
+	data, err := readNotebook(w, r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondError(w, http.StatusRequestEntityTooLarge, ErrCodeBadRequest,
+			fmt.Sprintf("Notebooks are limited to %d bytes", NOTEBOOK_MAX_BYTES), nil)
+		return notebookRun{}, false
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload: "+err.Error(), nil)
+		return notebookRun{}, false
+	}
+	nb, err := notebook.Parse(data)
+	if err != nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed, err.Error(), nil)
+		return notebookRun{}, false
+	}
+
+	language := nb.Language()
+	command, ok := h.Exec.Sandboxes.Command(language)
+	if !ok {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported notebook language: %s", language), nil)
+		return notebookRun{}, false
+	}
+	kernelCmd := repl.Command(h.Exec.Sandboxes.Kernel(language), command)
+	if kernelCmd == nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("Notebooks cannot be run for %s", language), nil)
+		return notebookRun{}, false
+	}
+	return notebookRun{nb: nb, language: language, kernelCmd: kernelCmd, opts: opts}, true
+}
+
+// readNotebook returns the request's notebook document: the body, or the
+// "notebook" file of a multipart form.
+func readNotebook(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, NOTEBOOK_MAX_BYTES)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+	if err := r.ParseMultipartForm(NOTEBOOK_MAX_BYTES); err != nil {
+		return nil, err
+	}
+	file, _, err := r.FormFile("notebook")
+	if err != nil {
+		return nil, errors.New(`expected the notebook as the "notebook" file`)
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// execute runs run's notebook in c, which is held for the duration, and
+// reports progress to job if it is not nil. An error means the kernel
+// could not be started.
+func (h *NotebookHandler) execute(ctx context.Context, c *model.ContainerInfo, run notebookRun, job *notebook.Job) (model.NotebookResult, error) {
+	start := time.Now()
+	dir := path.Join(NOTEBOOK_WORKSPACE_ROOT, uuid.NewString())
+	kernel, detach, err := h.Exec.startKernel(ctx, c, run.kernelCmd, dir)
+	if err != nil {
+		return model.NotebookResult{}, err
+	}
+	defer detach()
+	defer kernel.Close()
+
+	var progress func(int)
+	if job != nil {
+		job.Started()
+		progress = job.Progress
+	}
+	runCtx, cancel := context.WithTimeout(ctx, h.MaxRuntime)
+	defer cancel()
+	runCtx, cancelOnAbort := h.Exec.Sandboxes.AbortOnShutdown(runCtx)
+	defer cancelOnAbort()
+	summary := notebook.Execute(runCtx, kernel, run.nb, run.opts, progress)
+
+	result := model.NotebookResult{
+		Status:        summary.Status,
+		ExecutedCells: summary.ExecutedCells,
+		FailedCells:   summary.FailedCells,
+		Error:         summary.Error,
+	}
+	switch {
+	case errors.Is(context.Cause(runCtx), sandbox.ErrShuttingDown):
+		result.Error = "Run aborted: the server shut down before it finished"
+	case result.Status == model.NotebookTimedOut && result.Error == "" && runCtx.Err() != nil:
+		result.Error = fmt.Sprintf("The notebook did not finish within %s", h.MaxRuntime)
+	}
+	result.Notebook, err = run.nb.Marshal()
+	if err != nil {
+		return model.NotebookResult{}, err
+	}
+	result.DurationMS = time.Since(start).Milliseconds()
+	return result, nil
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
+)
+
+const oidcStateCookie = "alcaide_oidc"
+
+// OIDCHandler signs users in through an OpenID Connect identity provider.
+// A successful callback returns the same AuthResponse as /login.
+type OIDCHandler struct {
+	Provider   *auth.OIDCProvider
+	Users      store.UserStore
+	Identities store.IdentityStore
+	JWTSecret  []byte
+	TokenTTL   time.Duration
+
+	UnverifiedPolicy string
+	// SecureCookie marks the login state cookie Secure; set it when the
+	// public URL is https.
+	SecureCookie bool
+}
+
+// errSSO is a callback failure the user can be told about.
+type errSSO struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *errSSO) Error() string { return e.message }
+
+// Login redirects the browser to the identity provider.
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authURL, sealed, err := h.Provider.Begin()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting OIDC login", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, h.stateCookie(sealed, int(h.Provider.LoginTTL().Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback completes the login the identity provider redirected back from.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The state is single use whatever the outcome.
+	http.SetCookie(w, h.stateCookie("", -1))
+
+	query := r.URL.Query()
+	if idpErr := query.Get("error"); idpErr != "" {
+		slog.InfoContext(r.Context(), "Identity provider refused login", "error", idpErr, "description", query.Get("error_description"))
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "The identity provider did not sign you in", nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeInvalidToken, "Login session is missing or has expired; start again", nil)
+		return
+	}
+	identity, err := h.Provider.Complete(r.Context(), cookie.Value, query.Get("state"), query.Get("code"))
+	if errors.Is(err, auth.ErrLoginState) {
+		slog.InfoContext(r.Context(), "Rejected OIDC callback", "error", err)
+		respondError(w, http.StatusBadRequest, ErrCodeInvalidToken, "Login session is missing or has expired; start again", nil)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "OIDC login failed", "error", err)
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Could not complete sign-in with the identity provider", nil)
+		return
+	}
+
+	user, err := h.resolveUser(r.Context(), identity)
+	var ssoErr *errSSO
+	if errors.As(err, &ssoErr) {
+		slog.InfoContext(r.Context(), "OIDC login refused", "subject", identity.Subject, "reason", ssoErr.message)
+		respondError(w, ssoErr.status, ssoErr.code, ssoErr.message, nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error resolving OIDC user", "subject", identity.Subject, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.EmailVerified && h.UnverifiedPolicy == config.UnverifiedBlockLogin {
+		respondError(w, http.StatusForbidden, ErrCodeEmailUnverified, "Verify your email address before logging in", nil)
+		return
+	}
+
+	token, err := auth.NewSessionToken(user, h.JWTSecret, h.TokenTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error signing JWT", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "OIDC login", "user_id", user.ID)
+	respondJSON(w, model.AuthResponse{
+		UserResponse: toUserResponse(user),
+		Token:        token,
+	})
+}
+
+func (h *OIDCHandler) stateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.SecureCookie,
+		// Lax lets the cookie ride along on the top-level redirect back
+		// from the identity provider.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// resolveUser finds the local account for identity. An identity seen
+// before maps to its linked user. Otherwise an existing account with the
+// same email is linked, but only if both the provider and the account
+// have verified that email, and if there is no such account a new one is
+// created. Accounts created here have no password and can only sign in
+// through the provider until one is set with a password reset.
+func (h *OIDCHandler) resolveUser(ctx context.Context, identity auth.OIDCIdentity) (model.User, error) {
+	user, err := h.Identities.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+	if !errors.Is(err, store.ErrNotFound) {
+		return user, err
+	}
+
+	email := normalizeEmail(identity.Email)
+	if validateEmail(email) != "" {
+		return model.User{}, &errSSO{http.StatusForbidden, ErrCodeUnauthorized,
+			"The identity provider did not share a usable email address"}
+	}
+
+	user, err = h.Users.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil:
+		if !identity.EmailVerified {
+			return model.User{}, &errSSO{http.StatusConflict, ErrCodeConflict,
+				"An account with this email already exists; log in with your password"}
+		}
+		// Anyone can register an address they do not own and leave it
+		// unverified. Linking such an account would hand its owner's
+		// provider login to whoever set the account's password.
+		if !user.EmailVerified {
+			return model.User{}, &errSSO{http.StatusConflict, ErrCodeConflict,
+				"An account with this email exists but has not verified it; log in with its password or reset it, and verify the email first"}
+		}
+	case errors.Is(err, store.ErrNotFound):
+		user, err = h.createUser(ctx, identity, email)
+		if err != nil {
+			return model.User{}, err
+		}
+	default:
+		return model.User{}, err
+	}
+
+	err = h.Identities.LinkIdentity(ctx, store.Identity{
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+		UserID:  user.ID,
+	})
+	if errors.Is(err, store.ErrConflict) {
+		// A concurrent callback for the same identity linked it first.
+		return h.Identities.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+	}
+	return user, err
+}
+
+// maxUsernameAttempts bounds the search for a free username for a new SSO
+// account.
+const maxUsernameAttempts = 5
+
+func (h *OIDCHandler) createUser(ctx context.Context, identity auth.OIDCIdentity, email string) (model.User, error) {
+	hint := identity.PreferredUsername
+	if hint == "" {
+		hint, _, _ = strings.Cut(email, "@")
+	}
+	base := usernameFromHint(hint)
+
+	user := model.User{
+		ID:            uuid.New(),
+		Email:         email,
+		EmailVerified: identity.EmailVerified,
+	}
+	for attempt := 0; attempt < maxUsernameAttempts; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return model.User{}, err
+			}
+			user.Username = base + "-" + hex.EncodeToString(suffix)
+		}
+
+		err := h.Users.CreateUser(ctx, user)
+		var conflict *store.ConflictError
+		if errors.As(err, &conflict) && conflict.Field == "username" {
+			continue
+		}
+		if err != nil {
+			return model.User{}, err
+		}
+		return user, nil
+	}
+	return model.User{}, fmt.Errorf("no free username derived from %q", base)
+}
+
+// usernameFromHint turns a provider-supplied name into one that passes
+// validateUsername, leaving room for a uniqueness suffix.
+func usernameFromHint(hint string) string {
+	var b strings.Builder
+	for _, r := range normalizeUsername(hint) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' {
+			b.WriteRune(r)
+		}
+	}
+	name := strings.TrimLeft(b.String(), "_-.")
+	if limit := USERNAME_MAX_LENGTH - 7; len(name) > limit {
+		name = name[:limit]
+	}
+	if len(name) < USERNAME_MIN_LENGTH {
+		name = "user" + name
+	}
+	return name
+}
@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/google/uuid"
+)
+
+const testClientID = "alcaide-test"
+
+// mockIssuer is an OpenID Connect provider serving discovery, keys, an
+// authorization endpoint that signs the user in immediately and a token
+// endpoint that enforces PKCE.
+type mockIssuer struct {
+	*httptest.Server
+	signer jose.Signer
+
+	mu sync.Mutex
+	// The user the next authorization signs in as.
+	subject       string
+	email         string
+	emailVerified bool
+	codes         map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIssuer{signer: signer, codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIssuer) signInAs(subject, email string, verified bool) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.subject, idp.email, idp.emailVerified = subject, email, verified
+}
+
+func (idp *mockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	code := uuid.NewString()
+	idp.codes[code] = mockGrant{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		claims: map[string]any{
+			"sub":            idp.subject,
+			"email":          idp.email,
+			"email_verified": idp.emailVerified,
+		},
+	}
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.Form.Get("code")]
+	delete(idp.codes, r.Form.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   idp.URL,
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	idToken, err := jwt.Signed(idp.signer).Claims(claims).Serialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]any{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+}
+
+// oidcTest runs an OIDCHandler behind a test server, signing in through a
+// mockIssuer.
+type oidcTest struct {
+	idp   *mockIssuer
+	users *store.MemoryUserStore
+	app   *httptest.Server
+}
+
+func newOIDCTest(t *testing.T, identities func(*store.MemoryUserStore) store.IdentityStore) *oidcTest {
+	t.Helper()
+	idp := newMockIssuer(t)
+	users := store.NewMemoryUserStore()
+	h := &OIDCHandler{Users: users, Identities: users, JWTSecret: []byte("jwt secret"), TokenTTL: time.Hour}
+	if identities != nil {
+		h.Identities = identities(users)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/oidc/login", h.Login)
+	mux.HandleFunc(config.OIDCCallbackPath, h.Callback)
+	app := httptest.NewServer(mux)
+	t.Cleanup(app.Close)
+
+	provider, err := auth.NewOIDCProvider(context.Background(), auth.OIDCOptions{
+		Issuer:      idp.URL,
+		ClientID:    testClientID,
+		RedirectURL: app.URL + config.OIDCCallbackPath,
+		Scopes:      []string{"openid", "email"},
+	}, []byte("state secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Provider = provider
+	return &oidcTest{idp: idp, users: users, app: app}
+}
+
+// begin starts a login and returns a client holding its state cookie,
+// which does not follow redirects, and the code and state the provider
+// sent back.
+func (o *oidcTest) begin(t *testing.T) (client *http.Client, code, state string) {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client = &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	location := func(path string) *url.URL {
+		resp, err := client.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("GET %s: got %d, want a redirect", path, resp.StatusCode)
+		}
+		u, err := resp.Location()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	callback := location(location(o.app.URL + "/auth/oidc/login").String())
+	return client, callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func (o *oidcTest) callback(t *testing.T, client *http.Client, code, state string) (int, model.AuthResponse, model.ErrorBody) {
+	t.Helper()
+	resp, err := client.Get(o.app.URL + config.OIDCCallbackPath + "?" + url.Values{"code": {code}, "state": {state}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		model.AuthResponse
+		Error model.ErrorBody `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body.AuthResponse, body.Error
+}
+
+func (o *oidcTest) login(t *testing.T) (int, model.AuthResponse, model.ErrorBody) {
+	t.Helper()
+	client, code, state := o.begin(t)
+	return o.callback(t, client, code, state)
+}
+
+func TestOIDCLoginCreatesAndReusesAccount(t *testing.T) {
+	o := newOIDCTest(t, nil)
+	o.idp.signInAs("subject-1", "Alice@Example.com", true)
+
+	status, first, _ := o.login(t)
+	if status != http.StatusOK || first.Token == "" {
+		t.Fatalf("first login: got %d %+v", status, first)
+	}
+	if first.Email != "alice@example.com" || !first.EmailVerified {
+		t.Fatalf("created %+v", first.UserResponse)
+	}
+	status, second, _ := o.login(t)
+	if status != http.StatusOK || second.ID != first.ID {
+		t.Fatalf("second login: got %d for %s, want 200 for %s", status, second.ID, first.ID)
+	}
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	o := newOIDCTest(t, nil)
+	o.idp.signInAs("subject-1", "alice@example.com", true)
+
+	client, code, state := o.begin(t)
+	status, _, body := o.callback(t, client, code, "forged state")
+	if status != http.StatusBadRequest || body.Code != ErrCodeInvalidToken {
+		t.Fatalf("got %d %+v, want 400 %s", status, body, ErrCodeInvalidToken)
+	}
+
+	// The state cookie is cleared by the first callback whatever its
+	// outcome, so the right state cannot be tried afterwards.
+	status, _, _ = o.callback(t, client, code, state)
+	if status != http.StatusBadRequest {
+		t.Fatalf("callback without a state cookie: got %d, want 400", status)
+	}
+}
+
+func TestOIDCCallbackRejectsCodeFromAnotherLogin(t *testing.T) {
+	o := newOIDCTest(t, nil)
+	o.idp.signInAs("subject-1", "alice@example.com", true)
+
+	// A code injected into someone else's login fails PKCE: the victim's
+	// verifier does not match the challenge the code was issued for.
+	victim, _, victimState := o.begin(t)
+	_, attackerCode, _ := o.begin(t)
+	status, _, _ := o.callback(t, victim, attackerCode, victimState)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("got %d, want 401", status)
+	}
+	if _, err := o.users.GetUserByEmail(context.Background(), "alice@example.com"); err == nil {
+		t.Fatal("an account was created from a rejected login")
+	}
+}
+
+func TestOIDCLinksOnlyVerifiedAccounts(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		localVerified bool
+		idpVerified   bool
+		wantStatus    int
+	}{
+		{"both verified", true, true, http.StatusOK},
+		{"local account unverified", false, true, http.StatusConflict},
+		{"provider email unverified", true, false, http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := newOIDCTest(t, nil)
+			local := model.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com", Password: "hash", EmailVerified: tc.localVerified}
+			if err := o.users.CreateUser(context.Background(), local); err != nil {
+				t.Fatal(err)
+			}
+			o.idp.signInAs("subject-1", "alice@example.com", tc.idpVerified)
+
+			status, resp, body := o.login(t)
+			if status != tc.wantStatus {
+				t.Fatalf("got %d %+v, want %d", status, body, tc.wantStatus)
+			}
+			linked, err := o.users.GetUserByIdentity(context.Background(), o.idp.URL, "subject-1")
+			if tc.wantStatus != http.StatusOK {
+				if err == nil {
+					t.Fatal("identity was linked")
+				}
+				if user, _ := o.users.GetUserByID(context.Background(), local.ID); user != local {
+					t.Fatalf("local account changed to %+v", user)
+				}
+				return
+			}
+			if err != nil || linked.ID != local.ID || resp.ID != local.ID {
+				t.Fatalf("signed in as %s with identity linked to %s (%v), want %s", resp.ID, linked.ID, err, local.ID)
+			}
+		})
+	}
+}
+
+// racingIdentities behaves as if another callback for the same identity
+// linked it between the lookup and the link.
+type racingIdentities struct {
+	*store.MemoryUserStore
+	once sync.Once
+}
+
+func (r *racingIdentities) GetUserByIdentity(ctx context.Context, issuer, subject string) (model.User, error) {
+	var first bool
+	r.once.Do(func() { first = true })
+	if first {
+		return model.User{}, fmt.Errorf("user by identity: %w", store.ErrNotFound)
+	}
+	return r.MemoryUserStore.GetUserByIdentity(ctx, issuer, subject)
+}
+
+func TestOIDCLinkConflict(t *testing.T) {
+	o := newOIDCTest(t, func(users *store.MemoryUserStore) store.IdentityStore {
+		return &racingIdentities{MemoryUserStore: users}
+	})
+	ctx := context.Background()
+	// The other callback linked the identity to an account under an older
+	// email, so this one goes on to create an account before its link
+	// conflicts.
+	winner := model.User{ID: uuid.New(), Username: "alice", Email: "alice@old.example.com", EmailVerified: true}
+	if err := o.users.CreateUser(ctx, winner); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.users.LinkIdentity(ctx, store.Identity{Issuer: o.idp.URL, Subject: "subject-1", UserID: winner.ID}); err != nil {
+		t.Fatal(err)
+	}
+	o.idp.signInAs("subject-1", "alice@example.com", true)
+
+	status, resp, body := o.login(t)
+	if status != http.StatusOK || resp.ID != winner.ID {
+		t.Fatalf("got %d %+v as %s, want 200 as the account linked first, %s", status, body, resp.ID, winner.ID)
+	}
+}
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/repl"
+	"github.com/Aadithya-J/alcaIDE/model"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// REPL_WORKSPACE_ROOT holds one working directory per session inside
+	// the sandbox.
+	REPL_WORKSPACE_ROOT = "/tmp/alcaide-repl"
+	// REPL_START_TIMEOUT bounds starting a session's interpreter.
+	REPL_START_TIMEOUT = 30 * time.Second
+)
+
+// Cell outcomes, as recorded in metrics.
+const (
+	CELL_SUCCESS     = "success"
+	CELL_ERROR       = "error"
+	CELL_TIMEOUT     = "timeout"
+	CELL_KERNEL_DIED = "kernel_died"
+)
+
+// replScript runs a kernel in its own working directory, $0, which is
+// removed when it exits; the container goes back to the pool afterwards.
+const replScript = `d=$0
+mkdir -p "$d" && cd "$d" || exit 1
+HOME="$d" "$@"
+s=$?
+cd / && rm -rf "$d"
+exit $s`
+
+// REPLHandler serves stateful REPL sessions: each holds a container and
+// an interpreter in it that keeps its state between cells.
+type REPLHandler struct {
+	// Exec supplies the sandboxes and acquires containers the way /exec
+	// does.
+	Exec     *ExecHandler
+	Sessions *repl.Sessions
+	// CellTimeout interrupts a cell that runs for longer.
+	CellTimeout time.Duration
+}
+
+// Collection serves /repl/sessions: GET lists the caller's sessions, POST
+// starts one.
+func (h *REPLHandler) Collection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		principal, _ := auth.PrincipalFrom(r.Context())
+		respondJSON(w, model.REPLSessionList{Sessions: h.Sessions.List(principal.UserID)})
+	case http.MethodPost:
+		h.create(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *REPLHandler) create(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+
+	var req model.CreateREPLSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload", nil)
+		return
+	}
+	command, ok := h.Exec.Sandboxes.Command(req.Language)
+	if !ok {
+		respondError(w, http.StatusBadRequest, ErrCodeValidationFailed,
+			fmt.Sprintf("Unsupported language: %s", req.Language),
+			map[string]string{"language": "is not supported"})
+		return
+	}
+	kernelCmd := repl.Command(h.Exec.Sandboxes.Kernel(req.Language), command)
+	if kernelCmd == nil {
+		respondError(w, http.StatusUnprocessableEntity, ErrCodeValidationFailed,
+			fmt.Sprintf("REPL sessions are not available for %s", req.Language),
+			map[string]string{"language": "has no REPL kernel"})
+		return
+	}
+
+	session, err := h.Sessions.Reserve(principal.UserID, req.Language)
+	if err != nil {
+		respondError(w, http.StatusConflict, ErrCodeConflict,
+			"Too many REPL sessions are open; end one first", nil)
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			h.Sessions.Discard(session)
+		}
+	}()
+
+	logger := slog.With("session_id", session.ID, "language", req.Language)
+	priority, _ := h.Exec.priority(r.Context(), PRIORITY_INTERACTIVE)
+	acquiredContainer, _, ok := h.Exec.acquire(w, r, req.Language, priority, logger)
+	if !ok {
+		return
+	}
+	logger = logger.With("container_id", acquiredContainer.ID)
+
+	dir := path.Join(REPL_WORKSPACE_ROOT, session.ID.String())
+	kernel, detach, err := h.Exec.startKernel(r.Context(), acquiredContainer, kernelCmd, dir)
+	if err != nil {
+		h.Exec.Sandboxes.ReleaseContainer(r.Context(), acquiredContainer, req.Language)
+		logger.ErrorContext(r.Context(), "Error starting REPL kernel", "error", err)
+		http.Error(w, "Failed to start the REPL session", http.StatusInternalServerError)
+		return
+	}
+
+	metrics.REPLSessions.WithLabelValues(req.Language).Inc()
+	h.Sessions.Start(session, kernel, func() {
+		detach()
+		h.Exec.Sandboxes.ReleaseContainer(context.Background(), acquiredContainer, req.Language)
+		metrics.REPLSessions.WithLabelValues(req.Language).Dec()
+	})
+	started = true
+	logger.InfoContext(r.Context(), "REPL session started")
+	respondJSONStatus(w, http.StatusCreated, h.Sessions.Status(session))
+}
+
+// startKernel starts a REPL kernel with kernelCmd in c, working in dir.
+// The kernel outlives ctx; it is detached by the returned function once it
+// has been closed, or when running work is aborted on shutdown.
+func (h *ExecHandler) startKernel(ctx context.Context, c *model.ContainerInfo, kernelCmd []string, dir string) (*repl.Kernel, context.CancelFunc, error) {
+	procCtx, detach := h.Sandboxes.AbortOnShutdown(context.WithoutCancel(ctx))
+	user := h.Sandboxes.User()
+	cmd := append([]string{"sh", "-c", replScript, dir}, kernelCmd...)
+	proc, err := h.Sandboxes.StartProcess(procCtx, c, cmd, model.ExecOptions{User: user})
+	if err != nil {
+		detach()
+		return nil, nil, err
+	}
+	// kill is a shell builtin; slim images lack the standalone command.
+	signal := func(ctx context.Context, pid int, sig string) error {
+		_, err := h.Sandboxes.Exec(ctx, c,
+			[]string{"sh", "-c", "kill -" + sig + " " + strconv.Itoa(pid)}, model.ExecOptions{User: user})
+		return err
+	}
+	startCtx, cancel := context.WithTimeout(ctx, REPL_START_TIMEOUT)
+	defer cancel()
+	kernel, err := repl.Start(startCtx, proc, signal)
+	if err != nil {
+		detach()
+		return nil, nil, err
+	}
+	return kernel, detach, nil
+}
+
+// Session serves /repl/sessions/{id}: GET describes the session, DELETE
+// ends it, stopping any running cell.
+func (h *REPLHandler) Session(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, h.Sessions.Status(session))
+	case http.MethodDelete:
+		if err := h.Sessions.End(session.ID, session.UserID); err != nil {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, "REPL session not found", nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Cells serves POST /repl/sessions/{id}/cells: it runs a cell in the
+// session and responds once it has finished. A cell that raises is still
+// a 200, with the exception in the result.
+func (h *REPLHandler) Cells(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+	var req model.RunCellRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request payload", nil)
+		return
+	}
+
+	logger := slog.With("session_id", session.ID, "language", session.Language)
+	result, err := h.Sessions.Run(r.Context(), session, req.Code, h.CellTimeout)
+	switch {
+	case errors.Is(err, repl.ErrBusy):
+		respondError(w, http.StatusConflict, ErrCodeConflict, "A cell is already running in this session", nil)
+		return
+	case errors.Is(err, repl.ErrKernelDied):
+		metrics.REPLCells.WithLabelValues(session.Language, CELL_KERNEL_DIED).Inc()
+		logger.WarnContext(r.Context(), "REPL kernel died")
+		respondError(w, http.StatusGone, ErrCodeSessionEnded,
+			"The interpreter exited or stopped responding; the session has ended", nil)
+		return
+	case err != nil:
+		logger.ErrorContext(r.Context(), "Error running cell", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	outcome := CELL_SUCCESS
+	switch {
+	case result.TimedOut:
+		outcome = CELL_TIMEOUT
+	case result.Error != nil:
+		outcome = CELL_ERROR
+	}
+	metrics.REPLCells.WithLabelValues(session.Language, outcome).Inc()
+	logger.InfoContext(r.Context(), "Cell finished", "outcome", outcome,
+		"execution_count", result.ExecutionCount, "duration", time.Duration(result.DurationMS)*time.Millisecond)
+	respondJSON(w, result)
+}
+
+// Interrupt serves POST /repl/sessions/{id}/interrupt: the running cell
+// stops with a KeyboardInterrupt error. The session's state is kept.
+func (h *REPLHandler) Interrupt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+	err := h.Sessions.Interrupt(r.Context(), session)
+	if errors.Is(err, repl.ErrIdle) {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "No cell is running in this session", nil)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error interrupting cell", "session_id", session.ID, "error", err)
+		http.Error(w, "Failed to interrupt the cell", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// session looks up the caller's session named in the path, responding 404
+// if there is none.
+func (h *REPLHandler) session(w http.ResponseWriter, r *http.Request) (*repl.Session, bool) {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err == nil {
+		var session *repl.Session
+		if session, err = h.Sessions.Get(id, principal.UserID); err == nil {
+			return session, true
+		}
+	}
+	respondError(w, http.StatusNotFound, ErrCodeNotFound, "REPL session not found", nil)
+	return nil, false
+}
@@ -3,14 +3,48 @@ package handler
 import (
 	"net/http"
 	"encoding/json"
+
+	"github.com/Aadithya-J/alcaIDE/model"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	ErrCodeBadRequest        = "bad_request"
+	ErrCodeValidationFailed  = "validation_failed"
+	ErrCodeConflict          = "conflict"
+	ErrCodeUnauthorized      = "unauthorized"
+	ErrCodeEmailUnverified   = "email_unverified"
+	ErrCodeInvalidToken      = "invalid_token"
+	ErrCodeInsufficientScope = "insufficient_scope"
+	ErrCodeNotFound          = "not_found"
+	ErrCodeShuttingDown      = "shutting_down"
+	ErrCodeQueueFull         = "queue_full"
+	ErrCodeNoFormatter       = "formatter_unavailable"
+	ErrCodeNoLinter          = "linter_unavailable"
+	ErrCodeLinterFailed      = "linter_failed"
+	ErrCodeSessionEnded      = "session_ended"
+)
+
 func respondJSON(w http.ResponseWriter, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
 
+func respondJSONStatus(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// respondError writes the standard JSON error envelope.
+func respondError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	respondJSONStatus(w, status, model.ErrorResponse{Error: model.ErrorBody{
+		Code:    code,
+		Message: message,
+		Fields:  fields,
+	}})
+}
+
 func HashPassword(password string) (string, error) {
     bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
     return string(bytes), err
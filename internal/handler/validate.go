@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+const (
+	USERNAME_MIN_LENGTH = 3
+	USERNAME_MAX_LENGTH = 32
+	EMAIL_MAX_LENGTH    = 254
+	PASSWORD_MIN_LENGTH = 8
+	// bcrypt ignores everything past 72 bytes, so longer passwords would
+	// silently match any string sharing their prefix.
+	PASSWORD_MAX_BYTES = 72
+)
+
+// normalizeUsername folds usernames to a canonical form so "Alice" and
+// "alice" cannot both be registered.
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateRegistration normalises req in place and returns a message per
+// invalid field, or nil if the request is acceptable.
+func validateRegistration(req *model.RegisterRequest) map[string]string {
+	req.Username = normalizeUsername(req.Username)
+	req.Email = normalizeEmail(req.Email)
+
+	problems := make(map[string]string)
+	if msg := validateUsername(req.Username); msg != "" {
+		problems["username"] = msg
+	}
+	if msg := validateEmail(req.Email); msg != "" {
+		problems["email"] = msg
+	}
+	if msg := validatePassword(req.Password); msg != "" {
+		problems["password"] = msg
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+func validateUsername(username string) string {
+	if username == "" {
+		return "is required"
+	}
+	if len(username) < USERNAME_MIN_LENGTH || len(username) > USERNAME_MAX_LENGTH {
+		return "must be between 3 and 32 characters"
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return "may only contain letters, digits, '_', '-' and '.'"
+		}
+	}
+	first := username[0]
+	if !(first >= 'a' && first <= 'z' || first >= '0' && first <= '9') {
+		return "must start with a letter or digit"
+	}
+	return ""
+}
+
+func validateEmail(email string) string {
+	if email == "" {
+		return "is required"
+	}
+	if len(email) > EMAIL_MAX_LENGTH {
+		return "is too long"
+	}
+	addr, err := mail.ParseAddress(email)
+	// ParseAddress also accepts "Name <addr>" forms; only a bare address is
+	// acceptable here.
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "is not a valid email address"
+	}
+	at := strings.LastIndexByte(email, '@')
+	if domain := email[at+1:]; !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "is not a valid email address"
+	}
+	return ""
+}
+
+func validatePassword(password string) string {
+	if password == "" {
+		return "is required"
+	}
+	if len([]rune(password)) < PASSWORD_MIN_LENGTH {
+		return "must be at least 8 characters"
+	}
+	if len(password) > PASSWORD_MAX_BYTES {
+		return "must be at most 72 bytes"
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return "must contain at least one letter and one digit"
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Aadithya-J/alcaIDE/internal/telemetry"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// execStreamer runs an exec in the named pod, connecting streams to it.
+type execStreamer func(ctx context.Context, pod string, opts *corev1.PodExecOptions, streams remotecommand.StreamOptions) error
+
+// Exec runs cmd in c's pod through the pods/exec subresource. opts.Env is
+// passed through env(1). opts.User is ignored: an exec always runs as the
+// pod's user.
+func (m *PodManager) Exec(ctx context.Context, c *model.ContainerInfo, cmd []string, opts model.ExecOptions) (string, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "ExecuteCode",
+		trace.WithAttributes(attribute.String("container.id", c.ID)),
+	)
+	defer span.End()
+
+	if len(opts.Env) > 0 {
+		cmd = append(append([]string{"env"}, opts.Env...), cmd...)
+	}
+	var stdout, stderr bytes.Buffer
+	streams := remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}
+	if opts.Stdin != "" {
+		streams.Stdin = strings.NewReader(opts.Stdin)
+	}
+	execOpts := &corev1.PodExecOptions{
+		Container: containerName,
+		Command:   cmd,
+		Stdin:     opts.Stdin != "",
+		Stdout:    true,
+		Stderr:    true,
+	}
+
+	slog.DebugContext(ctx, "Executing code in pod", "pod", c.ID)
+	err := m.stream(ctx, c.ID, execOpts, streams)
+
+	var exitErr utilexec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		span.SetStatus(codes.Error, "execution timed out")
+		return "", fmt.Errorf("execution timed out: %w", ctx.Err())
+	case errors.As(err, &exitErr):
+		span.SetAttributes(attribute.Int("exit_code", exitErr.ExitStatus()))
+		return stdout.String(), model.NewExitError(exitErr.ExitStatus(), stdout.String(), stderr.String())
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", fmt.Errorf("exec failed: %w", err)
+	}
+	span.SetAttributes(attribute.Int("exit_code", 0))
+	return stdout.String(), nil
+}
+
+// streamExec runs an exec against the API server over WebSockets, falling
+// back to SPDY for servers that do not support them.
+func (m *PodManager) streamExec(ctx context.Context, pod string, opts *corev1.PodExecOptions, streams remotecommand.StreamOptions) error {
+	req := m.client.CoreV1().RESTClient().Post().
+		Namespace(m.opts.Namespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(opts, scheme.ParameterCodec)
+
+	spdy, err := remotecommand.NewSPDYExecutor(m.config, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+	websocket, err := remotecommand.NewWebSocketExecutor(m.config, http.MethodGet, req.URL().String())
+	if err != nil {
+		return err
+	}
+	executor, err := remotecommand.NewFallbackExecutor(websocket, spdy, httpstream.IsUpgradeFailure)
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, streams)
+}
+
+type podProcess struct {
+	stdin  *io.PipeWriter
+	stdout *io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// StartProcess starts cmd in c's pod and returns with its streams
+// attached; see Exec for how opts apply. Cancelling ctx detaches from it.
+func (m *PodManager) StartProcess(ctx context.Context, c *model.ContainerInfo, cmd []string, opts model.ExecOptions) (model.Process, error) {
+	if len(opts.Env) > 0 {
+		cmd = append(append([]string{"env"}, opts.Env...), cmd...)
+	}
+	stdin, stdinW := io.Pipe()
+	stdout, stdoutW := io.Pipe()
+	ctx, cancel := context.WithCancel(ctx)
+	p := &podProcess{stdin: stdinW, stdout: stdout, cancel: cancel, done: make(chan struct{})}
+	execOpts := &corev1.PodExecOptions{
+		Container: containerName,
+		Command:   cmd,
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    true,
+	}
+	go func() {
+		defer close(p.done)
+		err := m.stream(ctx, c.ID, execOpts, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdoutW, Stderr: io.Discard})
+		stdoutW.Close()
+		stdin.Close()
+
+		var exitErr utilexec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			p.err = model.NewExitError(exitErr.ExitStatus(), "", "")
+		case err != nil && ctx.Err() == nil:
+			p.err = fmt.Errorf("exec failed: %w", err)
+		}
+	}()
+	return p, nil
+}
+
+func (p *podProcess) Stdin() io.WriteCloser { return p.stdin }
+func (p *podProcess) Stdout() io.Reader     { return p.stdout }
+
+func (p *podProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+func (p *podProcess) Close() error {
+	p.cancel()
+	return nil
+}
@@ -0,0 +1,646 @@
+// Package kube runs sandboxes as Kubernetes pods. PodManager keeps warm pods
+// per language the way docker.DockerManager keeps containers, runs code
+// through the pods/exec subresource, and uses the sandbox package's
+// acquisition options, queue and reporting types so the handlers can use
+// either.
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	PodDeleteTimeout = 10 * time.Second
+	// podPollInterval is how often a starting pod's phase is checked.
+	podPollInterval = 250 * time.Millisecond
+	// containerName is the container code runs in.
+	containerName = "sandbox"
+	// tmpDir is the only writable path in a sandbox.
+	tmpDir = "/tmp"
+)
+
+// Options configure the sandbox pods.
+type Options struct {
+	// Namespace holds the pods and their network policy.
+	Namespace string
+	// Instance is the value of LabelInstance on this manager's pods. Empty
+	// uses the host name.
+	Instance string
+	// User is the "uid[:gid]" sandboxes run as. Kubernetes needs numeric
+	// IDs, and an exec cannot switch user, so every command in a pod runs
+	// as this user.
+	User string
+	// Resource quantities of every sandbox pod; empty leaves one unset.
+	CPURequest            string
+	CPULimit              string
+	MemoryRequest         string
+	MemoryLimit           string
+	EphemeralStorageLimit string
+	// AllowEgress opens outbound traffic in the sandbox network policy.
+	AllowEgress bool
+	// SeccompProfile is a Localhost profile; empty uses RuntimeDefault.
+	SeccompProfile string
+	// RuntimeFallback is config.RuntimeFallbackFail or
+	// config.RuntimeFallbackDefault; see CheckRuntimes.
+	RuntimeFallback string
+	// PodStartTimeout bounds how long a new pod may take to run.
+	PodStartTimeout time.Duration
+	// QueueMaxPerUser and QueueMaxPerLanguage bound the executions waiting
+	// for a pod, as for docker.DockerManager.
+	QueueMaxPerUser     int
+	QueueMaxPerLanguage int
+}
+
+// pool holds the idle pods of one language. As with Docker pools, a pool
+// is replaced rather than changed in place, and its channel is closed when
+// it is.
+type pool struct {
+	image    string
+	runtime  string
+	apparmor string
+	security string
+	size     int
+	ch       chan *model.ContainerInfo
+}
+
+func newPool(lang config.LanguageConfig, seccomp string) *pool {
+	return &pool{
+		image:    lang.Image,
+		runtime:  lang.Runtime,
+		apparmor: lang.AppArmorProfile,
+		security: securityID(seccomp, lang.AppArmorProfile),
+		size:     lang.PoolSize,
+		ch:       make(chan *model.ContainerInfo, lang.PoolSize),
+	}
+}
+
+// owns reports whether c was started for p's image, runtime class and
+// security profiles.
+func (p *pool) owns(c *model.ContainerInfo) bool {
+	return c.Image == p.image && c.Runtime == p.runtime && c.Security == p.security
+}
+
+// PodManager keeps warm sandbox pods per language. Acquired pods are
+// reported as model.ContainerInfo with the pod name as ID and its node as
+// Host.
+type PodManager struct {
+	client kubernetes.Interface
+	config *rest.Config
+	opts   Options
+	// podSecurity and resources are applied to every pod.
+	podSecurity *corev1.PodSecurityContext
+	resources   corev1.ResourceRequirements
+	tmpLimit    *resource.Quantity
+	// stream runs an exec; tests replace it, since the fake clientset
+	// cannot serve pods/exec.
+	stream execStreamer
+
+	languages map[string]config.LanguageConfig
+	pools     map[string]*pool
+	// poolsLock guards languages and pools.
+	poolsLock sync.RWMutex
+	pods      map[string]*model.ContainerInfo
+	podsLock  sync.RWMutex
+	// reloadLock serialises changes to the set of pods: startup, Reload
+	// and Reconcile, so Reconcile never mistakes a starting pod for a
+	// stray one.
+	reloadLock   sync.Mutex
+	shuttingDown atomic.Bool
+
+	// Lifecycle hands out, queues for and drains the pooled pods.
+	*sandbox.Lifecycle
+}
+
+// RESTConfig loads the client configuration from a kubeconfig file, or
+// from the pod's service account if path is empty.
+func RESTConfig(path string) (*rest.Config, error) {
+	if path == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", path)
+}
+
+// NewManager creates a manager for the given languages. Each language's
+// PoolSize must already be resolved to a positive value. restConfig is
+// used for pods/exec and may be nil where exec is not needed.
+func NewManager(client kubernetes.Interface, restConfig *rest.Config, languages map[string]config.LanguageConfig, opts Options) (*PodManager, error) {
+	if len(languages) == 0 {
+		return nil, errors.New("No language images provided")
+	}
+	if opts.Instance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("naming this instance: %w", err)
+		}
+		opts.Instance = host
+	}
+	podSecurity, err := podSecurityContext(opts.User, opts.SeccompProfile)
+	if err != nil {
+		return nil, err
+	}
+	resources, tmpLimit, err := podResources(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &PodManager{
+		client:      client,
+		config:      restConfig,
+		opts:        opts,
+		podSecurity: podSecurity,
+		resources:   resources,
+		tmpLimit:    tmpLimit,
+		languages:   languages,
+		pools:       make(map[string]*pool),
+		pods:        make(map[string]*model.ContainerInfo),
+	}
+	m.stream = m.streamExec
+	m.Lifecycle = sandbox.NewLifecycle("kube", m, opts.QueueMaxPerUser, opts.QueueMaxPerLanguage)
+	return m, nil
+}
+
+// podSecurityContext runs sandboxes as user, which must be numeric, under
+// the given seccomp profile.
+func podSecurityContext(user, seccomp string) (*corev1.PodSecurityContext, error) {
+	sc := &corev1.PodSecurityContext{
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if seccomp != "" {
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: ptr.To(seccomp)}
+	}
+	if user == "" {
+		return sc, nil
+	}
+	uid, gid, hasGID := strings.Cut(user, ":")
+	id, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox user %q must be a numeric uid[:gid] for Kubernetes", user)
+	}
+	sc.RunAsUser = ptr.To(id)
+	sc.RunAsNonRoot = ptr.To(id != 0)
+	if hasGID {
+		group, err := strconv.ParseInt(gid, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("sandbox user %q must be a numeric uid[:gid] for Kubernetes", user)
+		}
+		sc.RunAsGroup = ptr.To(group)
+		sc.FSGroup = ptr.To(group)
+	}
+	return sc, nil
+}
+
+// podResources parses the configured quantities. The ephemeral storage
+// limit also bounds the /tmp volume.
+func podResources(opts Options) (corev1.ResourceRequirements, *resource.Quantity, error) {
+	req := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	var errs []error
+	set := func(list corev1.ResourceList, name corev1.ResourceName, value, field string) {
+		if value == "" {
+			return
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", field, value, err))
+			return
+		}
+		list[name] = q
+	}
+	set(req.Requests, corev1.ResourceCPU, opts.CPURequest, "cpu_request")
+	set(req.Limits, corev1.ResourceCPU, opts.CPULimit, "cpu_limit")
+	set(req.Requests, corev1.ResourceMemory, opts.MemoryRequest, "memory_request")
+	set(req.Limits, corev1.ResourceMemory, opts.MemoryLimit, "memory_limit")
+	set(req.Limits, corev1.ResourceEphemeralStorage, opts.EphemeralStorageLimit, "ephemeral_storage_limit")
+	if err := errors.Join(errs...); err != nil {
+		return req, nil, fmt.Errorf("invalid sandbox resources: %w", err)
+	}
+	var tmpLimit *resource.Quantity
+	if q, ok := req.Limits[corev1.ResourceEphemeralStorage]; ok {
+		tmpLimit = &q
+	}
+	return req, tmpLimit, nil
+}
+
+func (m *PodManager) StartInitialContainers(ctx context.Context) error {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	m.poolsLock.Lock()
+	pools := make(map[string]*pool, len(m.languages))
+	total := 0
+	for lang, langConfig := range m.languages {
+		if langConfig.PoolSize <= 0 {
+			m.poolsLock.Unlock()
+			return fmt.Errorf("invalid number of pods for %s: %d", lang, langConfig.PoolSize)
+		}
+		pools[lang] = newPool(langConfig, m.opts.SeccompProfile)
+		total += langConfig.PoolSize
+	}
+	m.pools = pools
+	m.poolsLock.Unlock()
+
+	slog.InfoContext(ctx, "Creating initial sandbox pods", "namespace", m.opts.Namespace, "total", total)
+	var startupErrors []error
+	for lang, p := range pools {
+		slog.InfoContext(ctx, "Starting pods", "language", lang, "image", p.image, "count", p.size)
+		startupErrors = append(startupErrors, m.fill(ctx, lang, p, p.size)...)
+	}
+	for _, err := range startupErrors {
+		slog.ErrorContext(ctx, "Error during pod startup", "error", err)
+	}
+
+	m.podsLock.RLock()
+	numStarted := len(m.pods)
+	m.podsLock.RUnlock()
+	if numStarted == 0 {
+		return fmt.Errorf("no pods were started successfully: %w", errors.Join(startupErrors...))
+	}
+	if len(startupErrors) > 0 {
+		slog.WarnContext(ctx, "Some pods failed to start", "failed", len(startupErrors))
+	}
+	slog.InfoContext(ctx, "Sandbox pods started successfully", "count", numStarted)
+	return nil
+}
+
+// fill starts n pods for lang concurrently and adds them to p. It returns
+// an error for each pod that could not be started.
+func (m *PodManager) fill(ctx context.Context, lang string, p *pool, n int) []error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			c, err := m.startPod(ctx, lang, p, index)
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			m.poolsLock.RLock()
+			added := false
+			if !m.shuttingDown.Load() {
+				select {
+				case p.ch <- c:
+					added = true
+				default:
+				}
+			}
+			m.poolsLock.RUnlock()
+			if !added {
+				slog.WarnContext(ctx, "Pool full or closed, removing surplus pod", "language", lang, "pod", c.ID)
+				m.removePod(c)
+				return
+			}
+			metrics.PoolIdle.WithLabelValues(lang).Inc()
+		}(i)
+	}
+	wg.Wait()
+	close(errChan)
+
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// startPod creates one idle pod for p, waits for it to run and registers
+// it with the manager.
+func (m *PodManager) startPod(ctx context.Context, lang string, p *pool, index int) (*model.ContainerInfo, error) {
+	pods := m.client.CoreV1().Pods(m.opts.Namespace)
+	created, err := pods.Create(ctx, m.podFor(lang, p), metav1.CreateOptions{})
+	if err != nil {
+		metrics.ContainerFailures.WithLabelValues(lang, "create").Inc()
+		return nil, fmt.Errorf("failed to create pod %d for %s: %w", index, lang, err)
+	}
+	logger := slog.With("language", lang, "index", index, "pod", created.Name)
+
+	running, err := m.waitRunning(ctx, created.Name)
+	if err != nil {
+		metrics.ContainerFailures.WithLabelValues(lang, "start").Inc()
+		m.deletePod(created.Name)
+		return nil, fmt.Errorf("pod %s for %s did not start: %w", created.Name, lang, err)
+	}
+
+	logger.InfoContext(ctx, "Started pod", "node", running.Spec.NodeName)
+	metrics.ContainerCreations.WithLabelValues(lang).Inc()
+	metrics.PoolSize.WithLabelValues(lang).Inc()
+
+	c := &model.ContainerInfo{
+		ID:       created.Name,
+		Language: lang,
+		Image:    p.image,
+		Runtime:  p.runtime,
+		Security: p.security,
+		Host:     running.Spec.NodeName,
+	}
+	m.podsLock.Lock()
+	m.pods[c.ID] = c
+	m.podsLock.Unlock()
+	return c, nil
+}
+
+// podFor is the spec of a sandbox pod for p: an idle container with no
+// service account token, no privileges and a read-only root apart from
+// /tmp.
+func (m *PodManager) podFor(lang string, p *pool) *corev1.Pod {
+	security := m.podSecurity.DeepCopy()
+	if p.apparmor != "" {
+		security.AppArmorProfile = &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeLocalhost, LocalhostProfile: ptr.To(p.apparmor)}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName(lang),
+			Namespace: m.opts.Namespace,
+			Labels:    m.podLabels(lang),
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			AutomountServiceAccountToken:  ptr.To(false),
+			EnableServiceLinks:            ptr.To(false),
+			TerminationGracePeriodSeconds: ptr.To[int64](0),
+			SecurityContext:               security,
+			Containers: []corev1.Container{{
+				Name:      containerName,
+				Image:     p.image,
+				Command:   []string{"sleep", "infinity"},
+				Resources: *m.resources.DeepCopy(),
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: ptr.To(false),
+					Privileged:               ptr.To(false),
+					ReadOnlyRootFilesystem:   ptr.To(true),
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+				VolumeMounts: []corev1.VolumeMount{{Name: "tmp", MountPath: tmpDir}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "tmp",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: m.tmpLimit}},
+			}},
+		},
+	}
+	if p.runtime != "" {
+		pod.Spec.RuntimeClassName = ptr.To(p.runtime)
+	}
+	return pod
+}
+
+// waitRunning waits for the named pod to run, failing early if it cannot.
+func (m *PodManager) waitRunning(ctx context.Context, name string) (*corev1.Pod, error) {
+	pods := m.client.CoreV1().Pods(m.opts.Namespace)
+	var pod *corev1.Pod
+	err := wait.PollUntilContextTimeout(ctx, podPollInterval, m.opts.PodStartTimeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		pod, err = pods.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			return true, nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return false, fmt.Errorf("pod exited: %s %s", pod.Status.Reason, pod.Status.Message)
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if w := status.State.Waiting; w != nil && unstartable[w.Reason] {
+				return false, fmt.Errorf("%s: %s", w.Reason, w.Message)
+			}
+		}
+		return false, nil
+	})
+	return pod, err
+}
+
+// unstartable are container waiting reasons that will not clear by
+// themselves.
+var unstartable = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// removePod forgets a pod that is no longer wanted by any pool and deletes
+// it.
+func (m *PodManager) removePod(c *model.ContainerInfo) {
+	if !m.forget(c) {
+		return
+	}
+	if err := m.deletePod(c.ID); err != nil {
+		slog.Error("Error removing retired pod", "language", c.Language, "pod", c.ID, "error", err)
+		return
+	}
+	slog.Info("Retired pod removed", "language", c.Language, "pod", c.ID)
+}
+
+// forget stops tracking c, reporting whether it was tracked.
+func (m *PodManager) forget(c *model.ContainerInfo) bool {
+	m.podsLock.Lock()
+	_, ok := m.pods[c.ID]
+	delete(m.pods, c.ID)
+	m.podsLock.Unlock()
+	if ok {
+		metrics.PoolSize.WithLabelValues(c.Language).Dec()
+	}
+	return ok
+}
+
+// deletePod deletes the named pod at once. A pod that is already gone is
+// not an error.
+func (m *PodManager) deletePod(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), PodDeleteTimeout)
+	defer cancel()
+	err := m.client.CoreV1().Pods(m.opts.Namespace).Delete(ctx, name, metav1.DeleteOptions{GracePeriodSeconds: ptr.To[int64](0)})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// tracked reports whether c is still one of the manager's pods.
+func (m *PodManager) tracked(c *model.ContainerInfo) bool {
+	m.podsLock.RLock()
+	defer m.podsLock.RUnlock()
+	_, ok := m.pods[c.ID]
+	return ok
+}
+
+// IdleContainers returns the channel of language's idle pods.
+func (m *PodManager) IdleContainers(language string) (<-chan *model.ContainerInfo, bool) {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	p, ok := m.pools[language]
+	if !ok {
+		return nil, false
+	}
+	return p.ch, true
+}
+
+// TakeContainer refuses a pod that Reconcile found gone while it sat idle.
+func (m *PodManager) TakeContainer(c *model.ContainerInfo) bool {
+	return m.tracked(c)
+}
+
+// PutContainer returns a released pod to its pool, or retires it if the
+// pool no longer wants it.
+func (m *PodManager) PutContainer(ctx context.Context, c *model.ContainerInfo, language string) {
+	logger := slog.With("language", language, "pod", c.ID)
+	if m.shuttingDown.Load() {
+		return
+	}
+	if !m.tracked(c) {
+		logger.InfoContext(ctx, "Pod disappeared while in use, not returning it")
+		return
+	}
+
+	m.poolsLock.RLock()
+	p, ok := m.pools[language]
+	if ok && p.owns(c) {
+		select {
+		case p.ch <- c:
+			metrics.PoolIdle.WithLabelValues(language).Set(float64(len(p.ch)))
+			m.poolsLock.RUnlock()
+			logger.InfoContext(ctx, "Pod returned to pool")
+			return
+		default:
+		}
+	}
+	m.poolsLock.RUnlock()
+
+	logger.InfoContext(ctx, "Pod no longer needed by its pool, retiring it")
+	go m.removePod(c)
+}
+
+// BusyContainers is the number of pods not sitting idle in a pool.
+func (m *PodManager) BusyContainers() int {
+	m.podsLock.RLock()
+	total := len(m.pods)
+	m.podsLock.RUnlock()
+
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	for _, p := range m.pools {
+		total -= len(p.ch)
+	}
+	return total
+}
+
+// CleanupContainers closes every pool and deletes every pod.
+func (m *PodManager) CleanupContainers() {
+	m.shuttingDown.Store(true)
+
+	m.poolsLock.Lock()
+	slog.Info("Closing all language pod pools")
+	for lang, p := range m.pools {
+		close(p.ch)
+		metrics.PoolIdle.WithLabelValues(lang).Set(0)
+		metrics.PoolSize.WithLabelValues(lang).Set(0)
+	}
+	m.pools = make(map[string]*pool)
+	m.poolsLock.Unlock()
+
+	m.podsLock.Lock()
+	pods := m.pods
+	m.pods = make(map[string]*model.ContainerInfo)
+	m.podsLock.Unlock()
+
+	slog.Info("Deleting sandbox pods", "count", len(pods))
+	var wg sync.WaitGroup
+	for name := range pods {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.deletePod(name); err != nil {
+				slog.Error("Error deleting pod", "pod", name, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Info("Finished pod cleanup")
+}
+
+// Command returns the argv that code for language is appended to, and
+// whether the language is configured.
+func (m *PodManager) Command(language string) ([]string, bool) {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	lang, ok := m.languages[language]
+	return lang.Command, ok
+}
+
+// Installer always returns "": dependency installs need root, and an exec
+// runs as the pod's user.
+func (m *PodManager) Installer(language string) string {
+	return ""
+}
+
+// Formatter returns the code formatter configured for language, or "" if
+// it has none.
+func (m *PodManager) Formatter(language string) string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].Formatter
+}
+
+// Linter returns the linter configured for language and the extra
+// arguments it is run with, or "" if it has none.
+func (m *PodManager) Linter(language string) (string, []string) {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	lang := m.languages[language]
+	return lang.Linter, lang.LinterArgs
+}
+
+// LanguageServer returns the argv of language's LSP server, or nil if it
+// has none.
+func (m *PodManager) LanguageServer(language string) []string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].LanguageServer
+}
+
+// Kernel returns the REPL kernel configured for language, or "" if it has
+// none.
+func (m *PodManager) Kernel(language string) string {
+	m.poolsLock.RLock()
+	defer m.poolsLock.RUnlock()
+	return m.languages[language].Kernel
+}
+
+// User is the user submitted code runs as.
+func (m *PodManager) User() string {
+	return m.opts.User
+}
+
+// InstallDependencies is not supported for pods; see Installer.
+func (m *PodManager) InstallDependencies(ctx context.Context, c *model.ContainerInfo, deps sandbox.Dependencies) (sandbox.Installation, error) {
+	return sandbox.Installation{}, sandbox.ErrNoInstaller
+}
+
+// RemoveDependencies does nothing, as nothing is ever installed.
+func (m *PodManager) RemoveDependencies(ctx context.Context, c *model.ContainerInfo, installation sandbox.Installation) {
+}
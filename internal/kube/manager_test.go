@@ -0,0 +1,266 @@
+package kube
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "sandboxes"
+
+// newTestManager returns a manager on a fake cluster holding objects,
+// where every created pod runs at once on node-1.
+func newTestManager(t *testing.T, opts Options, languages map[string]config.LanguageConfig, objects ...runtime.Object) (*PodManager, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Spec.NodeName = "node-1"
+		pod.Status.Phase = corev1.PodRunning
+		return false, nil, nil
+	})
+
+	opts.Namespace = testNamespace
+	opts.Instance = "test"
+	opts.PodStartTimeout = 5 * time.Second
+	m, err := NewManager(client, nil, languages, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, client
+}
+
+// podNames lists the names of the pods in the test namespace.
+func podNames(t *testing.T, client *fake.Clientset) []string {
+	t.Helper()
+	list, err := client.CoreV1().Pods(testNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range list.Items {
+		names = append(names, pod.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestPodSpec(t *testing.T) {
+	m, _ := newTestManager(t, Options{
+		User:                  "1000:2000",
+		SeccompProfile:        "profiles/sandbox.json",
+		CPURequest:            "250m",
+		CPULimit:              "1",
+		MemoryLimit:           "256Mi",
+		EphemeralStorageLimit: "100Mi",
+	}, map[string]config.LanguageConfig{"python": {Image: "python:3.11", PoolSize: 1}})
+	p := newPool(config.LanguageConfig{Image: "python:3.11", Runtime: "gvisor", AppArmorProfile: "alcaide-sandbox", PoolSize: 1}, m.opts.SeccompProfile)
+	pod := m.podFor("Python", p)
+
+	if got := pod.Labels; got[LabelInstance] != "test" || got[LabelLanguage] != "Python" || got[LabelManagedBy] != managedBy {
+		t.Errorf("labels = %v", got)
+	}
+	spec := pod.Spec
+	if spec.RuntimeClassName == nil || *spec.RuntimeClassName != "gvisor" {
+		t.Errorf("RuntimeClassName = %v, want gvisor", spec.RuntimeClassName)
+	}
+	if spec.AutomountServiceAccountToken == nil || *spec.AutomountServiceAccountToken {
+		t.Error("service account token is mounted")
+	}
+
+	sc := spec.SecurityContext
+	if sc.RunAsUser == nil || *sc.RunAsUser != 1000 || sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		t.Errorf("RunAsUser = %v, RunAsNonRoot = %v, want 1000 and true", sc.RunAsUser, sc.RunAsNonRoot)
+	}
+	if sc.RunAsGroup == nil || *sc.RunAsGroup != 2000 || sc.FSGroup == nil || *sc.FSGroup != 2000 {
+		t.Errorf("RunAsGroup = %v, FSGroup = %v, want 2000", sc.RunAsGroup, sc.FSGroup)
+	}
+	if s := sc.SeccompProfile; s.Type != corev1.SeccompProfileTypeLocalhost || *s.LocalhostProfile != "profiles/sandbox.json" {
+		t.Errorf("seccomp profile = %+v", s)
+	}
+	if a := sc.AppArmorProfile; a == nil || a.Type != corev1.AppArmorProfileTypeLocalhost || *a.LocalhostProfile != "alcaide-sandbox" {
+		t.Errorf("AppArmor profile = %+v", a)
+	}
+
+	if len(spec.Containers) != 1 {
+		t.Fatalf("%d containers, want 1", len(spec.Containers))
+	}
+	c := spec.Containers[0]
+	csc := c.SecurityContext
+	if csc.AllowPrivilegeEscalation == nil || *csc.AllowPrivilegeEscalation ||
+		csc.Privileged == nil || *csc.Privileged ||
+		csc.ReadOnlyRootFilesystem == nil || !*csc.ReadOnlyRootFilesystem {
+		t.Errorf("container security context = %+v", csc)
+	}
+	if csc.Capabilities == nil || !slices.Equal(csc.Capabilities.Drop, []corev1.Capability{"ALL"}) || len(csc.Capabilities.Add) > 0 {
+		t.Errorf("capabilities = %+v, want all dropped", csc.Capabilities)
+	}
+
+	for name, want := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:              "1",
+		corev1.ResourceMemory:           "256Mi",
+		corev1.ResourceEphemeralStorage: "100Mi",
+	} {
+		if got := c.Resources.Limits[name]; got.Cmp(resource.MustParse(want)) != 0 {
+			t.Errorf("%s limit = %s, want %s", name, got.String(), want)
+		}
+	}
+	if got := c.Resources.Requests[corev1.ResourceCPU]; got.Cmp(resource.MustParse("250m")) != 0 {
+		t.Errorf("cpu request = %s, want 250m", got.String())
+	}
+	if _, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+		t.Error("memory request set though none was configured")
+	}
+	if len(spec.Volumes) != 1 || spec.Volumes[0].EmptyDir == nil || spec.Volumes[0].EmptyDir.SizeLimit.Cmp(resource.MustParse("100Mi")) != 0 {
+		t.Errorf("volumes = %+v, want a /tmp emptyDir limited to 100Mi", spec.Volumes)
+	}
+}
+
+func TestPodSpecDefaults(t *testing.T) {
+	m, _ := newTestManager(t, Options{}, map[string]config.LanguageConfig{"python": {Image: "python:3.11", PoolSize: 1}})
+	pod := m.podFor("python", newPool(config.LanguageConfig{Image: "python:3.11", PoolSize: 1}, ""))
+
+	if pod.Spec.RuntimeClassName != nil {
+		t.Errorf("RuntimeClassName = %q, want unset", *pod.Spec.RuntimeClassName)
+	}
+	sc := pod.Spec.SecurityContext
+	if sc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("seccomp profile = %+v, want RuntimeDefault", sc.SeccompProfile)
+	}
+	if sc.AppArmorProfile != nil || sc.RunAsUser != nil {
+		t.Errorf("security context = %+v, want no AppArmor profile or user", sc)
+	}
+	if limits := pod.Spec.Containers[0].Resources.Limits; len(limits) != 0 {
+		t.Errorf("limits = %v, want none", limits)
+	}
+}
+
+func TestNewManagerRejectsNamedUser(t *testing.T) {
+	_, err := NewManager(fake.NewSimpleClientset(), nil,
+		map[string]config.LanguageConfig{"python": {Image: "python:3.11", PoolSize: 1}},
+		Options{Instance: "test", User: "nobody"})
+	if err == nil {
+		t.Error("NewManager accepted a user name, want a numeric uid")
+	}
+}
+
+func TestReconcileDeletesStrayPods(t *testing.T) {
+	ctx := context.Background()
+	pod := func(name string, labels map[string]string) runtime.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: labels},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	other := sandboxLabels()
+	other[LabelInstance] = "other"
+	stray := sandboxLabels()
+	stray[LabelInstance] = "test"
+	stray[LabelLanguage] = "python"
+
+	m, client := newTestManager(t, Options{},
+		map[string]config.LanguageConfig{"python": {Image: "python:3.11", PoolSize: 1}},
+		pod("stray", stray),
+		pod("other-instance", other),
+		pod("unrelated", map[string]string{"app": "web"}),
+	)
+
+	if err := m.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := podNames(t, client), []string{"other-instance", "unrelated"}; !slices.Equal(got, want) {
+		t.Fatalf("pods after reconcile = %v, want %v", got, want)
+	}
+
+	if err := m.StartInitialContainers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	names := podNames(t, client)
+	if len(names) != 3 {
+		t.Fatalf("pods after start = %v, want one sandbox pod added", names)
+	}
+	var started string
+	for name := range m.pods {
+		started = name
+	}
+
+	// The started pod survives a reconcile; one evicted behind the
+	// manager's back is replaced.
+	if err := m.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.pods[started]; !ok || len(m.pods) != 1 {
+		t.Fatalf("tracked pods after reconcile = %v, want only %s", m.pods, started)
+	}
+	if err := client.CoreV1().Pods(testNamespace).Delete(ctx, started, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.pods[started]; ok || len(m.pods) != 1 {
+		t.Fatalf("tracked pods after eviction = %v, want one replacing %s", m.pods, started)
+	}
+	if p := m.pools["python"]; len(p.ch) != 1 {
+		t.Errorf("%d idle python pods, want 1", len(p.ch))
+	}
+}
+
+func TestEnsureNetworkPolicy(t *testing.T) {
+	ctx := context.Background()
+	m, client := newTestManager(t, Options{}, map[string]config.LanguageConfig{"python": {Image: "python:3.11", PoolSize: 1}})
+	policies := client.NetworkingV1().NetworkPolicies(testNamespace)
+
+	if err := m.EnsureNetworkPolicy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := policies.Get(ctx, networkPolicyName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := policy.Spec
+	for k, v := range sandboxLabels() {
+		if spec.PodSelector.MatchLabels[k] != v {
+			t.Errorf("pod selector = %v, want %v", spec.PodSelector.MatchLabels, sandboxLabels())
+		}
+	}
+	if len(spec.PolicyTypes) != 2 || len(spec.Ingress) != 0 || len(spec.Egress) != 0 {
+		t.Errorf("created policy = %+v, want ingress and egress denied", spec)
+	}
+
+	// An operator's labels on the policy survive an update.
+	policy.Labels["team"] = "platform"
+	if _, err := policies.Update(ctx, policy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.opts.AllowEgress = true
+	if err := m.EnsureNetworkPolicy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	policy, err = policies.Get(ctx, networkPolicyName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Spec.Egress) != 1 || len(policy.Spec.Ingress) != 0 {
+		t.Errorf("updated policy = %+v, want all egress allowed and ingress denied", policy.Spec)
+	}
+	if policy.Labels["team"] != "platform" {
+		t.Errorf("labels after update = %v, want team kept", policy.Labels)
+	}
+	list, err := policies.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("%d network policies, want 1", len(list.Items))
+	}
+}
@@ -0,0 +1,257 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// Labels on sandbox pods. Reconcile finds this instance's pods by them, so
+// pods left by a crashed or restarted server are cleaned up.
+const (
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	LabelComponent = "app.kubernetes.io/component"
+	LabelInstance  = "alcaide.dev/instance"
+	LabelLanguage  = "alcaide.dev/language"
+
+	managedBy         = "alcaide"
+	component         = "sandbox"
+	networkPolicyName = "alcaide-sandbox"
+)
+
+// sandboxLabels select every sandbox pod in the namespace, whichever
+// instance owns it.
+func sandboxLabels() map[string]string {
+	return map[string]string{LabelManagedBy: managedBy, LabelComponent: component}
+}
+
+// instanceLabels select this manager's pods.
+func (m *PodManager) instanceLabels() map[string]string {
+	l := sandboxLabels()
+	l[LabelInstance] = m.opts.Instance
+	return l
+}
+
+func (m *PodManager) podLabels(language string) map[string]string {
+	l := m.instanceLabels()
+	l[LabelLanguage] = language
+	return l
+}
+
+// podName is a unique name for a new pod of language.
+func podName(language string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, language)
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return "alcaide-" + strings.Trim(name, "-") + "-" + rand.String(8)
+}
+
+// securityID identifies a pod's seccomp and AppArmor profiles, so a pool
+// can tell pods started under other profiles apart.
+func securityID(seccomp, apparmor string) string {
+	if seccomp == "" && apparmor == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("seccomp=" + seccomp + "\x00apparmor=" + apparmor))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// EnsureNetworkPolicy creates or updates the policy isolating sandbox pods:
+// no ingress at all, and no egress unless AllowEgress is set. It only takes
+// effect with a network plugin that enforces NetworkPolicy.
+func (m *PodManager) EnsureNetworkPolicy(ctx context.Context) error {
+	spec := networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: sandboxLabels()},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+	}
+	if m.opts.AllowEgress {
+		spec.Egress = []networkingv1.NetworkPolicyEgressRule{{}}
+	}
+
+	policies := m.client.NetworkingV1().NetworkPolicies(m.opts.Namespace)
+	existing, err := policies.Get(ctx, networkPolicyName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = policies.Create(ctx, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      networkPolicyName,
+				Namespace: m.opts.Namespace,
+				Labels:    map[string]string{LabelManagedBy: managedBy},
+			},
+			Spec: spec,
+		}, metav1.CreateOptions{})
+	case err == nil:
+		existing.Spec = spec
+		_, err = policies.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("applying network policy %s/%s: %w", m.opts.Namespace, networkPolicyName, err)
+	}
+	slog.InfoContext(ctx, "Sandbox network policy applied", "namespace", m.opts.Namespace, "allow_egress", m.opts.AllowEgress)
+	return nil
+}
+
+// Reconcile compares this instance's pods with the cluster. Pods the
+// manager tracks that are gone or no longer running are forgotten and
+// replaced; pods labelled as this instance's that no pool knows, such as
+// those left by a previous run, are deleted. Run before
+// StartInitialContainers, it clears out everything a crashed predecessor
+// left behind.
+func (m *PodManager) Reconcile(ctx context.Context) error {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	if m.shuttingDown.Load() {
+		return nil
+	}
+
+	list, err := m.client.CoreV1().Pods(m.opts.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(m.instanceLabels()).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("listing sandbox pods: %w", err)
+	}
+
+	m.podsLock.RLock()
+	tracked := maps.Clone(m.pods)
+	m.podsLock.RUnlock()
+
+	running := make(map[string]bool, len(list.Items))
+	for _, pod := range list.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := tracked[pod.Name]; ok {
+			running[pod.Name] = pod.Status.Phase == corev1.PodRunning
+			continue
+		}
+		slog.WarnContext(ctx, "Deleting sandbox pod no pool knows", "pod", pod.Name, "language", pod.Labels[LabelLanguage])
+		if err := m.deletePod(pod.Name); err != nil {
+			slog.ErrorContext(ctx, "Error deleting stray pod", "pod", pod.Name, "error", err)
+		}
+	}
+
+	lost := 0
+	for name, c := range tracked {
+		if running[name] {
+			continue
+		}
+		slog.WarnContext(ctx, "Sandbox pod lost, replacing it", "language", c.Language, "pod", name, "node", c.Host)
+		metrics.ContainerFailures.WithLabelValues(c.Language, "lost").Inc()
+		m.forget(c)
+		if err := m.deletePod(name); err != nil {
+			slog.ErrorContext(ctx, "Error deleting lost pod", "pod", name, "error", err)
+		}
+		lost++
+	}
+
+	m.poolsLock.Lock()
+	if lost > 0 {
+		for lang, p := range m.pools {
+			m.purge(p)
+			metrics.PoolIdle.WithLabelValues(lang).Set(float64(len(p.ch)))
+		}
+	}
+	pools := maps.Clone(m.pools)
+	m.poolsLock.Unlock()
+
+	var errs []error
+	for name, p := range pools {
+		if err := m.refill(ctx, name, p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purge drops pods the manager no longer tracks from p's idle pods. The
+// caller must hold poolsLock for writing.
+func (m *PodManager) purge(p *pool) {
+	idle := make([]*model.ContainerInfo, 0, len(p.ch))
+	for len(p.ch) > 0 {
+		idle = append(idle, <-p.ch)
+	}
+	for _, c := range idle {
+		if m.tracked(c) {
+			p.ch <- c
+		}
+	}
+}
+
+// MonitorPods reconciles every interval until ctx is cancelled.
+func (m *PodManager) MonitorPods(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error reconciling sandbox pods", "error", err)
+		}
+	}
+}
+
+// Hosts reports the nodes this manager's pods run on.
+func (m *PodManager) Hosts() []sandbox.HostStatus {
+	byNode := make(map[string]*sandbox.HostStatus)
+	m.podsLock.RLock()
+	for _, c := range m.pods {
+		status, ok := byNode[c.Host]
+		if !ok {
+			status = &sandbox.HostStatus{Name: c.Host, Healthy: true, Languages: make(map[string]int)}
+			byNode[c.Host] = status
+		}
+		status.Containers++
+		status.Languages[c.Language]++
+	}
+	m.podsLock.RUnlock()
+
+	statuses := make([]sandbox.HostStatus, 0, len(byNode))
+	for _, status := range byNode {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// errNodeDrain is returned by DrainHost and UndrainHost: nodes belong to
+// the cluster, and "kubectl drain" evicts sandbox pods, which Reconcile
+// then replaces on other nodes.
+var errNodeDrain = fmt.Errorf("%w: drain Kubernetes nodes with kubectl drain", sandbox.ErrUnknownHost)
+
+func (m *PodManager) DrainHost(ctx context.Context, name string) error {
+	return errNodeDrain
+}
+
+func (m *PodManager) UndrainHost(ctx context.Context, name string) error {
+	return errNodeDrain
+}
@@ -0,0 +1,313 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/sandbox"
+	"github.com/Aadithya-J/alcaIDE/model"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// resolveRuntimes checks that the RuntimeClass of every language exists.
+// A missing one falls back to the cluster default under the "default"
+// policy and is otherwise reported in the error map.
+func (m *PodManager) resolveRuntimes(ctx context.Context, languages map[string]config.LanguageConfig) (map[string]config.LanguageConfig, map[string]error, error) {
+	exists := make(map[string]bool)
+	resolved := make(map[string]config.LanguageConfig, len(languages))
+	failed := make(map[string]error)
+	for name, lang := range languages {
+		if lang.Runtime != "" {
+			ok, seen := exists[lang.Runtime]
+			if !seen {
+				_, err := m.client.NodeV1().RuntimeClasses().Get(ctx, lang.Runtime, metav1.GetOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return nil, nil, fmt.Errorf("looking up RuntimeClass %s: %w", lang.Runtime, err)
+				}
+				ok = err == nil
+				exists[lang.Runtime] = ok
+			}
+			switch {
+			case ok:
+			case m.opts.RuntimeFallback == config.RuntimeFallbackDefault:
+				slog.WarnContext(ctx, "RuntimeClass does not exist, falling back to the cluster default",
+					"language", name, "runtime", lang.Runtime)
+				lang.Runtime = ""
+			default:
+				failed[name] = fmt.Errorf("RuntimeClass %q does not exist", lang.Runtime)
+				continue
+			}
+		}
+		resolved[name] = lang
+	}
+	return resolved, failed, nil
+}
+
+// CheckRuntimes resolves the RuntimeClass of every configured language; see
+// resolveRuntimes. It fails if any is missing under the fail policy.
+func (m *PodManager) CheckRuntimes(ctx context.Context) error {
+	m.poolsLock.RLock()
+	languages := m.languages
+	m.poolsLock.RUnlock()
+
+	resolved, failed, err := m.resolveRuntimes(ctx, languages)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		var errs []error
+		for name, err := range failed {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		return fmt.Errorf("%w\ncreate the RuntimeClass or set exec.runtime_fallback to default", errors.Join(errs...))
+	}
+
+	for name, lang := range resolved {
+		slog.InfoContext(ctx, "Sandbox runtime class", "language", name, "runtime", lang.Runtime)
+	}
+	m.poolsLock.Lock()
+	m.languages = resolved
+	m.poolsLock.Unlock()
+	return nil
+}
+
+// Reload applies a new language configuration without a restart, the way
+// docker.DockerManager.Reload does: new languages get a filled pool, a
+// changed image, RuntimeClass or AppArmor profile is rolled out blue/green,
+// a changed size keeps existing pods, and removed languages stop accepting
+// work at once while busy pods are retired when released.
+func (m *PodManager) Reload(ctx context.Context, languages map[string]config.LanguageConfig) (sandbox.ReloadResult, error) {
+	if len(languages) == 0 {
+		return sandbox.ReloadResult{}, errors.New("no languages configured")
+	}
+	for name, lang := range languages {
+		if lang.PoolSize <= 0 {
+			return sandbox.ReloadResult{}, fmt.Errorf("invalid number of pods for %s: %d", name, lang.PoolSize)
+		}
+		if len(lang.Command) == 0 {
+			return sandbox.ReloadResult{}, fmt.Errorf("no command configured for %s", name)
+		}
+	}
+
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	if m.Draining() || m.shuttingDown.Load() {
+		return sandbox.ReloadResult{}, sandbox.ErrShuttingDown
+	}
+
+	m.poolsLock.RLock()
+	current := maps.Clone(m.languages)
+	m.poolsLock.RUnlock()
+
+	result := sandbox.ReloadResult{Failed: make(map[string]string)}
+	languages, failed, err := m.resolveRuntimes(ctx, languages)
+	if err != nil {
+		return sandbox.ReloadResult{}, err
+	}
+	for name, err := range failed {
+		result.Failed[name] = err.Error()
+		if old, ok := current[name]; ok {
+			languages[name] = old
+		}
+	}
+
+	for name, lang := range languages {
+		if _, ok := failed[name]; ok {
+			continue
+		}
+		old, exists := current[name]
+		logger := slog.With("language", name, "image", lang.Image, "pool_size", lang.PoolSize)
+
+		switch {
+		case !exists:
+			if err := m.addPool(ctx, name, lang); err != nil {
+				logger.ErrorContext(ctx, "Failed to add language", "error", err)
+				result.Failed[name] = err.Error()
+				continue
+			}
+			logger.InfoContext(ctx, "Language added")
+			result.Added = append(result.Added, name)
+		case old.Image != lang.Image || old.Runtime != lang.Runtime || old.AppArmorProfile != lang.AppArmorProfile:
+			logger = logger.With("runtime", lang.Runtime, "previous_image", old.Image, "previous_runtime", old.Runtime)
+			if err := m.replacePool(ctx, name, lang); err != nil {
+				logger.ErrorContext(ctx, "Failed to roll out new image", "error", err)
+				result.Failed[name] = err.Error()
+				continue
+			}
+			logger.InfoContext(ctx, "Language moved to new image")
+			result.Updated = append(result.Updated, name)
+		case old.PoolSize != lang.PoolSize:
+			if err := m.resizePool(ctx, name, lang); err != nil {
+				logger.WarnContext(ctx, "Pool resized but not fully filled", "error", err)
+				result.Failed[name] = err.Error()
+			}
+			logger.InfoContext(ctx, "Language pool resized", "previous_pool_size", old.PoolSize)
+			result.Resized = append(result.Resized, name)
+		case !slices.Equal(old.Command, lang.Command) || old.Formatter != lang.Formatter ||
+			old.Linter != lang.Linter || !slices.Equal(old.LinterArgs, lang.LinterArgs) ||
+			!slices.Equal(old.LanguageServer, lang.LanguageServer) || old.Kernel != lang.Kernel:
+			m.poolsLock.Lock()
+			m.languages[name] = lang
+			m.poolsLock.Unlock()
+			logger.InfoContext(ctx, "Language settings changed")
+			result.Updated = append(result.Updated, name)
+		default:
+			result.Unchanged = append(result.Unchanged, name)
+		}
+	}
+
+	for name := range current {
+		if _, ok := languages[name]; ok {
+			continue
+		}
+		m.removePool(name)
+		slog.InfoContext(ctx, "Language removed; busy pods will be retired when released", "language", name)
+		result.Removed = append(result.Removed, name)
+	}
+
+	for _, names := range [][]string{result.Added, result.Removed, result.Updated, result.Resized, result.Unchanged} {
+		sort.Strings(names)
+	}
+	if len(result.Failed) == 0 {
+		result.Failed = nil
+	}
+	return result, nil
+}
+
+// addPool starts a pool for a language that has none and installs it.
+func (m *PodManager) addPool(ctx context.Context, name string, lang config.LanguageConfig) error {
+	p := newPool(lang, m.opts.SeccompProfile)
+	if errs := m.fill(ctx, name, p, p.size); len(p.ch) == 0 {
+		return fmt.Errorf("no pods started: %w", errors.Join(errs...))
+	}
+
+	m.poolsLock.Lock()
+	m.languages[name] = lang
+	m.pools[name] = p
+	metrics.PoolIdle.WithLabelValues(name).Set(float64(len(p.ch)))
+	m.poolsLock.Unlock()
+	return nil
+}
+
+// replacePool fills a pool on the new image, RuntimeClass or profile and
+// only then swaps it in. Idle old pods are deleted; busy ones are retired
+// by PutContainer because the new pool does not own them.
+func (m *PodManager) replacePool(ctx context.Context, name string, lang config.LanguageConfig) error {
+	p := newPool(lang, m.opts.SeccompProfile)
+	if errs := m.fill(ctx, name, p, p.size); len(p.ch) == 0 {
+		return fmt.Errorf("no pods started on %s: %w", lang.Image, errors.Join(errs...))
+	}
+
+	m.poolsLock.Lock()
+	old := m.pools[name]
+	m.languages[name] = lang
+	m.pools[name] = p
+	close(old.ch)
+	metrics.PoolIdle.WithLabelValues(name).Set(float64(len(p.ch)))
+	m.poolsLock.Unlock()
+
+	for c := range old.ch {
+		m.removePod(c)
+	}
+	return nil
+}
+
+// resizePool swaps in a pool of the new size, carrying idle pods across,
+// then starts any pods still missing.
+func (m *PodManager) resizePool(ctx context.Context, name string, lang config.LanguageConfig) error {
+	p := newPool(lang, m.opts.SeccompProfile)
+	var surplus []*model.ContainerInfo
+
+	m.poolsLock.Lock()
+	old := m.pools[name]
+	m.languages[name] = lang
+	m.pools[name] = p
+	close(old.ch)
+	for c := range old.ch {
+		select {
+		case p.ch <- c:
+		default:
+			surplus = append(surplus, c)
+		}
+	}
+	metrics.PoolIdle.WithLabelValues(name).Set(float64(len(p.ch)))
+	m.poolsLock.Unlock()
+
+	for _, c := range surplus {
+		m.removePod(c)
+	}
+	return m.refill(ctx, name, p)
+}
+
+// refill starts the pods p is missing. Busy pods p owns come back to it
+// when released, so they count towards its size.
+func (m *PodManager) refill(ctx context.Context, name string, p *pool) error {
+	missing := p.size - m.countPods(name, p)
+	if missing <= 0 {
+		return nil
+	}
+	if errs := m.fill(ctx, name, p, missing); len(errs) > 0 {
+		return fmt.Errorf("%d of %d new pods failed to start: %w", len(errs), missing, errors.Join(errs...))
+	}
+	return nil
+}
+
+// removePool stops handing out pods for a language and deletes its idle
+// ones.
+func (m *PodManager) removePool(name string) {
+	m.poolsLock.Lock()
+	old := m.pools[name]
+	delete(m.pools, name)
+	delete(m.languages, name)
+	close(old.ch)
+	metrics.PoolIdle.WithLabelValues(name).Set(0)
+	m.poolsLock.Unlock()
+
+	for c := range old.ch {
+		m.removePod(c)
+	}
+}
+
+// countPods counts the pods of a language that p owns, idle or busy.
+func (m *PodManager) countPods(language string, p *pool) int {
+	m.podsLock.RLock()
+	defer m.podsLock.RUnlock()
+	n := 0
+	for _, c := range m.pods {
+		if c.Language == language && p.owns(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// Pools reports the state of every language's pool, sorted by language.
+func (m *PodManager) Pools() []sandbox.PoolStatus {
+	m.poolsLock.RLock()
+	statuses := make([]sandbox.PoolStatus, 0, len(m.pools))
+	for name, p := range m.pools {
+		statuses = append(statuses, sandbox.PoolStatus{Language: name, Image: p.image, Runtime: p.runtime, Security: p.security, Size: p.size, Idle: len(p.ch)})
+	}
+	m.poolsLock.RUnlock()
+
+	m.podsLock.RLock()
+	for i := range statuses {
+		for _, c := range m.pods {
+			if c.Language == statuses[i].Language {
+				statuses[i].Containers++
+			}
+		}
+	}
+	m.podsLock.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Language < statuses[j].Language })
+	return statuses
+}
@@ -0,0 +1,274 @@
+// Package lint describes the linters and type checkers /diagnostics runs
+// inside sandbox containers, and normalises what each reports into
+// model.Diagnostic values.
+package lint
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Aadithya-J/alcaIDE/internal/config"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// Linter checks code without running it. Tools that read stdin get the
+// code there; the others get it in a file in a fresh directory under /tmp
+// that is removed when they finish.
+type Linter struct {
+	// Name is the linter's config name, such as "ruff".
+	Name string
+	// command returns the argv that checks file, with args appended to
+	// the tool's own options.
+	command func(file string, args []string) []string
+	// file is set for tools that cannot read stdin.
+	file bool
+	// Env is set for the run. The container's root filesystem may be
+	// read-only, so caches are pointed at /tmp.
+	Env []string
+	// parse extracts diagnostics from the tool's stdout and its combined
+	// output, which is a model.ExitError's if the tool failed.
+	parse func(stdout, output string) []model.Diagnostic
+}
+
+// fileScript writes stdin to $0 in a new directory and runs the tool there.
+// The directory is removed afterwards because the container is reused.
+const fileScript = `d=$(mktemp -d) || exit 1
+cat > "$d/$0" || exit 1
+cd "$d" && "$@"
+s=$?
+cd / && rm -rf "$d"
+exit $s`
+
+// Command returns the argv that checks code in language, given on stdin.
+func (l *Linter) Command(language string, args []string) []string {
+	file := FileName(language)
+	cmd := l.command(file, args)
+	if l.file {
+		cmd = append([]string{"sh", "-c", fileScript, file}, cmd...)
+	}
+	return cmd
+}
+
+// Diagnostics extracts what a run reported, with 1-based lines and
+// columns, attributed to the file the code was checked as.
+func (l *Linter) Diagnostics(language, stdout, output string) []model.Diagnostic {
+	diagnostics := l.parse(stdout, output)
+	for i := range diagnostics {
+		diagnostics[i].File = FileName(language)
+		diagnostics[i].Source = l.Name
+		if diagnostics[i].Severity == "" {
+			diagnostics[i].Severity = model.SeverityWarning
+		}
+	}
+	return diagnostics
+}
+
+var linters = map[string]*Linter{
+	config.LinterRuff: {
+		Name: config.LinterRuff,
+		command: func(file string, args []string) []string {
+			cmd := []string{"ruff", "check", "--isolated", "--no-cache", "--exit-zero",
+				"--output-format", "json", "--stdin-filename", file}
+			return append(append(cmd, args...), "-")
+		},
+		parse: parseRuff,
+	},
+	config.LinterPyflakes: {
+		Name: config.LinterPyflakes,
+		command: func(_ string, args []string) []string {
+			return append([]string{"pyflakes"}, args...)
+		},
+		parse: eachLine(parsePyflakes),
+	},
+	// Without a config file only the rules given in args, and parse
+	// errors, are reported.
+	config.LinterESLint: {
+		Name: config.LinterESLint,
+		command: func(file string, args []string) []string {
+			cmd := []string{"eslint", "--no-config-lookup", "--format", "json",
+				"--rule", "no-unused-vars: warn", "--rule", "no-unreachable: warn",
+				"--rule", "no-dupe-keys: error", "--rule", "no-const-assign: error"}
+			return append(append(cmd, args...), "--stdin", "--stdin-filename", file)
+		},
+		parse: parseESLint,
+	},
+	config.LinterGoVet: {
+		Name: config.LinterGoVet,
+		command: func(file string, args []string) []string {
+			return append(append([]string{"go", "vet"}, args...), file)
+		},
+		file:  true,
+		Env:   []string{"GOCACHE=/tmp/go-cache", "GOPATH=/tmp/go", "GOTOOLCHAIN=local"},
+		parse: eachLine(parseGoVet),
+	},
+	config.LinterTsc: {
+		Name: config.LinterTsc,
+		command: func(file string, args []string) []string {
+			cmd := []string{"tsc", "--noEmit", "--pretty", "false", "--strict", "--target", "es2022"}
+			return append(append(cmd, args...), file)
+		},
+		file:  true,
+		parse: eachLine(parseTsc),
+	},
+}
+
+// Lookup returns the linter called name.
+func Lookup(name string) (*Linter, bool) {
+	l, ok := linters[name]
+	return l, ok
+}
+
+// FileName is the name code in language is checked under; tools pick
+// their parser by its extension.
+func FileName(language string) string {
+	switch language {
+	case "python":
+		return "main.py"
+	case "javascript":
+		return "main.js"
+	case "typescript":
+		return "main.ts"
+	case "go":
+		return "main.go"
+	}
+	return "main"
+}
+
+// eachLine adapts a parser of single output lines.
+func eachLine(parse func(line string, rest []string) (model.Diagnostic, bool)) func(string, string) []model.Diagnostic {
+	return func(_, output string) []model.Diagnostic {
+		lines := strings.Split(output, "\n")
+		var diagnostics []model.Diagnostic
+		for i, line := range lines {
+			if d, ok := parse(strings.TrimRight(line, "\r"), lines[i+1:]); ok {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		return diagnostics
+	}
+}
+
+type ruffLocation struct {
+	Row    int `json:"row"`
+	Column int `json:"column"`
+}
+
+type ruffMessage struct {
+	Code     *string      `json:"code"`
+	Message  string       `json:"message"`
+	Location ruffLocation `json:"location"`
+}
+
+// parseRuff reads ruff's JSON output. Syntax errors have no rule code, or
+// E999 in older releases; everything else is a warning.
+func parseRuff(stdout, _ string) []model.Diagnostic {
+	var messages []ruffMessage
+	if err := json.Unmarshal([]byte(stdout), &messages); err != nil {
+		return nil
+	}
+	diagnostics := make([]model.Diagnostic, 0, len(messages))
+	for _, m := range messages {
+		d := model.Diagnostic{Line: m.Location.Row, Column: m.Location.Column, Message: m.Message}
+		if m.Code != nil {
+			d.Code = *m.Code
+		}
+		if d.Code == "" || d.Code == "E999" {
+			d.Severity = model.SeverityError
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+type eslintResult struct {
+	Messages []struct {
+		RuleID   *string `json:"ruleId"`
+		Severity int     `json:"severity"`
+		Message  string  `json:"message"`
+		Line     int     `json:"line"`
+		Column   int     `json:"column"`
+	} `json:"messages"`
+}
+
+// parseESLint reads ESLint's JSON formatter. Severity 2 is an error, 1 a
+// warning; parse errors have no rule.
+func parseESLint(stdout, _ string) []model.Diagnostic {
+	var results []eslintResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		return nil
+	}
+	var diagnostics []model.Diagnostic
+	for _, r := range results {
+		for _, m := range r.Messages {
+			d := model.Diagnostic{Line: m.Line, Column: m.Column, Message: m.Message}
+			if m.RuleID != nil {
+				d.Code = *m.RuleID
+			}
+			if m.Severity == 2 {
+				d.Severity = model.SeverityError
+			}
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+var (
+	// <stdin>:3:5: undefined name 'x'
+	pyflakesLine = regexp.MustCompile(`^<stdin>:(\d+):(?:(\d+):)? (.*)$`)
+	// vet: ./main.go:3:2: undefined: x
+	// ./main.go:5:2: fmt.Printf format %d has arg s of wrong type string
+	goVetLine = regexp.MustCompile(`^(vet: )?(?:\./)?[^:\s]+\.go:(\d+):(\d+): (.*)$`)
+	// main.ts(1,7): error TS2322: Type 'string' is not assignable to type 'number'.
+	tscLine = regexp.MustCompile(`^[^()\s]+\((\d+),(\d+)\): (error|warning|message) (TS\d+): (.*)$`)
+)
+
+// parsePyflakes reads pyflakes' report. A syntax error is followed by the
+// offending line and a caret under the error.
+func parsePyflakes(line string, rest []string) (model.Diagnostic, bool) {
+	m := pyflakesLine.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	d := model.Diagnostic{Line: atoi(m[1]), Column: atoi(m[2]), Message: m[3]}
+	if d.Column == 0 {
+		d.Column = 1
+	}
+	if len(rest) >= 2 && strings.TrimSpace(rest[1]) == "^" {
+		d.Severity = model.SeverityError
+	}
+	return d, true
+}
+
+// parseGoVet reads go vet's report. Type errors, which stop the analysis,
+// are prefixed with "vet: "; analyzer findings are warnings.
+func parseGoVet(line string, _ []string) (model.Diagnostic, bool) {
+	m := goVetLine.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	d := model.Diagnostic{Line: atoi(m[2]), Column: atoi(m[3]), Message: m[4]}
+	if m[1] != "" {
+		d.Severity = model.SeverityError
+	}
+	return d, true
+}
+
+func parseTsc(line string, _ []string) (model.Diagnostic, bool) {
+	m := tscLine.FindStringSubmatch(line)
+	if m == nil {
+		return model.Diagnostic{}, false
+	}
+	severity := m[3]
+	if severity == "message" {
+		severity = model.SeverityInfo
+	}
+	return model.Diagnostic{Line: atoi(m[1]), Column: atoi(m[2]), Severity: severity, Code: m[4], Message: m[5]}, true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,55 @@
+// Package lsp supports proxying Language Server Protocol sessions between
+// a WebSocket client and a language server in a sandbox: base protocol
+// framing, rewriting file URIs between the two workspaces, and the
+// registry of open sessions.
+package lsp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// MaxMessageBytes bounds a single message read from a language server.
+const MaxMessageBytes = 16 << 20
+
+// ErrMessageTooLarge is returned by ReadMessage for a message over
+// MaxMessageBytes.
+var ErrMessageTooLarge = errors.New("lsp: message too large")
+
+// ReadMessage reads one base protocol message, a Content-Length header and
+// a JSON-RPC body, and returns the body.
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("lsp: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	if length > MaxMessageBytes {
+		return nil, ErrMessageTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// WriteMessage writes body as one base protocol message.
+func WriteMessage(w io.Writer, body []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
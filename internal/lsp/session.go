@@ -0,0 +1,194 @@
+package lsp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// TicketTTL is how long the ticket of a new session may be used to
+// connect to it.
+const TicketTTL = time.Minute
+
+var (
+	ErrTooManySessions = errors.New("lsp: too many open sessions")
+	ErrNoSession       = errors.New("lsp: no such session")
+	// ErrInvalidTicket is returned for a wrong, expired or already used
+	// ticket.
+	ErrInvalidTicket = errors.New("lsp: invalid or expired ticket")
+)
+
+// Session is a language server session. It is created by an authenticated
+// request, which receives a one-time ticket; browsers cannot authenticate
+// WebSocket requests with headers, so the ticket authorises connecting.
+// A session serves one connection and ends with it.
+type Session struct {
+	ID       uuid.UUID
+	Language string
+	// ClientRoot is the client's workspace root URI, or "" if the client
+	// uses sandbox URIs.
+	ClientRoot string
+	// Principal created the session; the connection acts on its behalf.
+	Principal auth.Principal
+	Created   time.Time
+	// TicketExpires is when the ticket can no longer be used to connect.
+	TicketExpires time.Time
+
+	ticket    []byte
+	connected bool
+	cancel    context.CancelFunc
+}
+
+// Sessions tracks open sessions and limits how many each user has.
+type Sessions struct {
+	maxPerUser int
+
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*Session
+}
+
+func NewSessions(maxPerUser int) *Sessions {
+	return &Sessions{maxPerUser: maxPerUser, sessions: make(map[uuid.UUID]*Session)}
+}
+
+// Create opens a session for p and returns it with its ticket.
+func (s *Sessions) Create(p auth.Principal, language, clientRoot string) (*Session, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(ticket))
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	n := 0
+	for _, session := range s.sessions {
+		if session.Principal.UserID == p.UserID {
+			n++
+		}
+	}
+	if n >= s.maxPerUser {
+		return nil, "", ErrTooManySessions
+	}
+	session := &Session{
+		ID:            uuid.New(),
+		Language:      language,
+		ClientRoot:    clientRoot,
+		Principal:     p,
+		Created:       now,
+		TicketExpires: now.Add(TicketTTL),
+		ticket:        sum[:],
+	}
+	s.sessions[session.ID] = session
+	return session, ticket, nil
+}
+
+// Connect redeems the ticket of session id. cancel is called if the
+// session is closed while connected.
+func (s *Sessions) Connect(id uuid.UUID, ticket string, cancel context.CancelFunc) (*Session, error) {
+	sum := sha256.Sum256([]byte(ticket))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	if session.connected || subtle.ConstantTimeCompare(session.ticket, sum[:]) != 1 {
+		return nil, ErrInvalidTicket
+	}
+	session.connected = true
+	session.cancel = cancel
+	return session, nil
+}
+
+// End forgets session id once its connection has finished.
+func (s *Sessions) End(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// Close ends session id of user, disconnecting its client if connected.
+func (s *Sessions) Close(id, user uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.Principal.UserID != user {
+		return ErrNoSession
+	}
+	s.close(session)
+	return nil
+}
+
+// CloseAll ends every session, as on shutdown.
+func (s *Sessions) CloseAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.sessions)
+	for _, session := range s.sessions {
+		s.close(session)
+	}
+	return n
+}
+
+func (s *Sessions) close(session *Session) {
+	if session.connected {
+		// The connection calls End when it has shut down.
+		session.cancel()
+		return
+	}
+	delete(s.sessions, session.ID)
+}
+
+// List returns user's sessions, oldest first.
+func (s *Sessions) List(user uuid.UUID) []model.LSPSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	statuses := []model.LSPSession{}
+	for _, session := range s.sessions {
+		if session.Principal.UserID != user {
+			continue
+		}
+		statuses = append(statuses, session.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].CreatedAt.Before(statuses[j].CreatedAt) })
+	return statuses
+}
+
+// Status describes the session. Sessions other than one just created must
+// be read through Sessions.List.
+func (s *Session) Status() model.LSPSession {
+	return model.LSPSession{
+		ID:        s.ID,
+		Language:  s.Language,
+		RootURI:   s.ClientRoot,
+		Connected: s.connected,
+		CreatedAt: s.Created,
+	}
+}
+
+// sweep drops sessions whose ticket expired unused. The caller must hold
+// mu.
+func (s *Sessions) sweep(now time.Time) {
+	for id, session := range s.sessions {
+		if !session.connected && now.After(session.TicketExpires) {
+			delete(s.sessions, id)
+		}
+	}
+}
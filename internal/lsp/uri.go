@@ -0,0 +1,106 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Workspace maps between the client's workspace root and the directory
+// the language server runs in. Every JSON string in a message that is a
+// root, or a path under it, is rewritten; object keys are too, since
+// workspace edits are keyed by document URI.
+type Workspace struct {
+	// ClientURI and SandboxURI are file:// URIs without a trailing slash.
+	ClientURI  string
+	SandboxURI string
+}
+
+// NewWorkspace maps clientRoot, a file:// URI, to the sandbox directory
+// dir. With no client root, the client uses sandbox URIs and nothing is
+// rewritten.
+func NewWorkspace(clientRoot, dir string) Workspace {
+	sandbox := (&url.URL{Scheme: "file", Path: dir}).String()
+	if clientRoot == "" {
+		clientRoot = sandbox
+	}
+	return Workspace{
+		ClientURI:  strings.TrimSuffix(clientRoot, "/"),
+		SandboxURI: strings.TrimSuffix(sandbox, "/"),
+	}
+}
+
+// ToSandbox rewrites a message from the client for the language server.
+func (w Workspace) ToSandbox(msg []byte) ([]byte, error) {
+	return rewrite(msg, w.pairs(false))
+}
+
+// ToClient rewrites a message from the language server for the client.
+func (w Workspace) ToClient(msg []byte) ([]byte, error) {
+	return rewrite(msg, w.pairs(true))
+}
+
+// pairs lists the prefixes to replace: the URIs, and the bare paths they
+// name for fields such as initialize's rootPath.
+func (w Workspace) pairs(toClient bool) [][2]string {
+	from, to := w.ClientURI, w.SandboxURI
+	if toClient {
+		from, to = to, from
+	}
+	if from == to {
+		return nil
+	}
+	pairs := [][2]string{{from, to}}
+	fromURL, err1 := url.Parse(from)
+	toURL, err2 := url.Parse(to)
+	if err1 == nil && err2 == nil && fromURL.Path != "" && toURL.Path != "" && fromURL.Path != "/" {
+		pairs = append(pairs, [2]string{fromURL.Path, toURL.Path})
+	}
+	return pairs
+}
+
+func rewrite(msg []byte, pairs [][2]string) ([]byte, error) {
+	if len(pairs) == 0 {
+		return msg, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	// Numbers are kept as written so request IDs round-trip exactly.
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(walk(v, pairs))
+}
+
+func walk(v any, pairs [][2]string) any {
+	switch v := v.(type) {
+	case string:
+		return replacePrefix(v, pairs)
+	case []any:
+		for i := range v {
+			v[i] = walk(v[i], pairs)
+		}
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[replacePrefix(k, pairs)] = walk(e, pairs)
+		}
+		return out
+	}
+	return v
+}
+
+func replacePrefix(s string, pairs [][2]string) string {
+	for _, p := range pairs {
+		if s == p[0] {
+			return p[1]
+		}
+		if rest, ok := strings.CutPrefix(s, p[0]+"/"); ok {
+			return p[1] + "/" + rest
+		}
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "alcaide"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	Executions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "executions_total",
+		Help:      "Code executions, by language, outcome (success, error, timeout, aborted, install_failed, blocked) and OCI runtime.",
+	}, []string{"language", "outcome", "runtime"})
+
+	ExecutionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "execution_duration_seconds",
+		Help:      "Time spent running code inside a container, by language and OCI runtime.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20},
+	}, []string{"language", "runtime"})
+
+	InstallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dependency_install_duration_seconds",
+		Help:      "Time spent installing declared dependencies, by language and outcome.",
+		Buckets:   []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80, 120},
+	}, []string{"language", "outcome"})
+
+	Formats = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "formats_total",
+		Help:      "Format requests run in a container, by language and outcome (success, syntax_error, unavailable, timeout, aborted, error).",
+	}, []string{"language", "outcome"})
+
+	DiagnosticsRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "diagnostics_runs_total",
+		Help:      "Linter runs for /diagnostics, by language and outcome (success, unavailable, timeout, aborted, error).",
+	}, []string{"language", "outcome"})
+
+	LSPSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "lsp_sessions",
+		Help:      "Language server sessions currently connected, by language.",
+	}, []string{"language"})
+
+	REPLSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "repl_sessions",
+		Help:      "REPL sessions currently open, by language.",
+	}, []string{"language"})
+
+	REPLCells = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repl_cells_total",
+		Help:      "Cells run in REPL sessions, by language and outcome (success, error, timeout, kernel_died).",
+	}, []string{"language", "outcome"})
+
+	NotebookRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notebook_runs_total",
+		Help:      "Notebooks run, by language, mode (sync, async) and status (completed, failed, timed_out, cancelled, error).",
+	}, []string{"language", "mode", "status"})
+
+	AcquireDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "container_acquire_duration_seconds",
+		Help:      "Time spent waiting for a pooled container, by language and outcome.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10},
+	}, []string{"language", "outcome"})
+
+	PoolSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_containers",
+		Help:      "Containers managed by the pool, by language.",
+	}, []string{"language"})
+
+	PoolIdle = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_idle_containers",
+		Help:      "Containers sitting idle in the pool, by language.",
+	}, []string{"language"})
+
+	PoolWaiters = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_waiters",
+		Help:      "Requests currently waiting to acquire a container, by language.",
+	}, []string{"language"})
+
+	HostHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "docker_host_healthy",
+		Help:      "1 if the Docker host answers health checks, by host.",
+	}, []string{"host"})
+
+	HostDraining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "docker_host_draining",
+		Help:      "1 if the Docker host is drained and takes no new containers, by host.",
+	}, []string{"host"})
+
+	QueueRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_rejections_total",
+		Help:      "Executions turned away because too many were already waiting for a container, by language.",
+	}, []string{"language"})
+
+	ContainerCreations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "container_creations_total",
+		Help:      "Containers created and started successfully, by language.",
+	}, []string{"language"})
+
+	ContainerFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "container_failures_total",
+		Help:      "Container lifecycle failures, by language and stage (create, start).",
+	}, []string{"language", "stage"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency, by SQL operation and status.",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"operation", "status"})
+)
+
+// Handler serves the registered metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Hijack lets WebSocket handlers take over the connection; the request is
+// recorded as 101 Switching Protocols.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware records request counts and latency for every request served by
+// next. The route label is the ServeMux pattern that matched, so paths with
+// arbitrary suffixes do not blow up label cardinality.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		HTTPRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,253 @@
+package notebook
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// SweepInterval is how often finished jobs past their retention are
+// dropped.
+const SweepInterval = time.Minute
+
+var (
+	ErrTooManyJobs = errors.New("notebook: too many unfinished jobs")
+	ErrNoJob       = errors.New("notebook: no such job")
+)
+
+// RunFunc runs job's notebook and returns its result, reporting through
+// job's Started and Progress as it goes. It must return soon after ctx is
+// cancelled.
+type RunFunc func(ctx context.Context, job *Job) model.NotebookResult
+
+// Job is a notebook run in the background.
+type Job struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	status   model.NotebookJob
+	finished time.Time
+	// deleted hides a job its owner deleted while it still runs; it is
+	// forgotten once its run returns.
+	deleted bool
+}
+
+// Jobs runs notebooks in the background for their owners to collect, and
+// keeps finished ones for a retention period.
+type Jobs struct {
+	maxPerUser int
+	retention  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*Job
+}
+
+func NewJobs(maxPerUser int, retention time.Duration) *Jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Jobs{
+		maxPerUser: maxPerUser,
+		retention:  retention,
+		ctx:        ctx,
+		cancel:     cancel,
+		jobs:       make(map[uuid.UUID]*Job),
+	}
+}
+
+// Submit starts run in the background for user. totalCells is reported as
+// the job's progress total.
+func (j *Jobs) Submit(user uuid.UUID, totalCells int, run RunFunc) (*Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.ctx.Err() != nil {
+		return nil, context.Canceled
+	}
+	n := 0
+	for _, job := range j.jobs {
+		if job.UserID == user && !job.done() {
+			n++
+		}
+	}
+	if n >= j.maxPerUser {
+		return nil, ErrTooManyJobs
+	}
+
+	ctx, cancel := context.WithCancel(j.ctx)
+	job := &Job{
+		ID:     uuid.New(),
+		UserID: user,
+		cancel: cancel,
+		status: model.NotebookJob{Status: model.NotebookQueued, CreatedAt: time.Now(), TotalCells: totalCells},
+	}
+	job.status.ID = job.ID
+	j.jobs[job.ID] = job
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		defer cancel()
+		result := run(ctx, job)
+		if job.finish(result) {
+			j.mu.Lock()
+			delete(j.jobs, job.ID)
+			j.mu.Unlock()
+		}
+	}()
+	return job, nil
+}
+
+// Started marks the job as running, once it has a kernel.
+func (job *Job) Started() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	now := time.Now()
+	job.status.Status = model.NotebookRunning
+	job.status.StartedAt = &now
+}
+
+// Progress records how many code cells have run.
+func (job *Job) Progress(executed int) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.ExecutedCells = executed
+}
+
+// finish records the job's result and reports whether it was deleted while
+// running.
+func (job *Job) finish(result model.NotebookResult) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.finished = time.Now()
+	job.status.Status = result.Status
+	job.status.FinishedAt = &job.finished
+	job.status.ExecutedCells = result.ExecutedCells
+	job.status.Result = &result
+	return job.deleted
+}
+
+func (job *Job) isDeleted() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.deleted
+}
+
+func (job *Job) done() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return !job.finished.IsZero()
+}
+
+// Status describes the job; the result is included once it has finished.
+func (job *Job) Status() model.NotebookJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.status
+}
+
+// Get returns user's job id.
+func (j *Jobs) Get(id, user uuid.UUID) (*Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok || job.UserID != user || job.isDeleted() {
+		return nil, ErrNoJob
+	}
+	return job, nil
+}
+
+// List returns user's jobs, oldest first, without their results.
+func (j *Jobs) List(user uuid.UUID) []model.NotebookJob {
+	j.mu.Lock()
+	var jobs []*Job
+	for _, job := range j.jobs {
+		if job.UserID == user && !job.isDeleted() {
+			jobs = append(jobs, job)
+		}
+	}
+	j.mu.Unlock()
+
+	statuses := []model.NotebookJob{}
+	for _, job := range jobs {
+		status := job.Status()
+		status.Result = nil
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].CreatedAt.Before(statuses[b].CreatedAt) })
+	return statuses
+}
+
+// Delete cancels user's job id if it is unfinished, and forgets it. An
+// unfinished job is hidden at once but counts towards its owner's limit
+// until its run has returned.
+func (j *Jobs) Delete(id, user uuid.UUID) error {
+	job, err := j.Get(id, user)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	finished := !job.finished.IsZero()
+	job.deleted = true
+	job.mu.Unlock()
+	job.cancel()
+	if finished {
+		j.mu.Lock()
+		delete(j.jobs, id)
+		j.mu.Unlock()
+	}
+	return nil
+}
+
+// CancelAll cancels every unfinished job, as on shutdown, and waits for
+// them to stop. It returns how many were running.
+func (j *Jobs) CancelAll() int {
+	j.mu.Lock()
+	n := 0
+	for _, job := range j.jobs {
+		if !job.done() {
+			n++
+		}
+	}
+	j.cancel()
+	j.mu.Unlock()
+	j.wg.Wait()
+	return n
+}
+
+// Sweep drops finished jobs once they are older than the retention period,
+// every SweepInterval until ctx is cancelled.
+func (j *Jobs) Sweep(ctx context.Context) {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			j.sweep(now)
+		}
+	}
+}
+
+func (j *Jobs) sweep(now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id, job := range j.jobs {
+		job.mu.Lock()
+		expired := !job.finished.IsZero() && now.Sub(job.finished) > j.retention
+		job.mu.Unlock()
+		if expired {
+			delete(j.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package notebook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+func TestDeletedJobHoldsSlotUntilItStops(t *testing.T) {
+	jobs := NewJobs(1, time.Hour)
+	user := uuid.New()
+
+	// The run ignores cancellation until released, like a cell that is
+	// slow to stop.
+	release := make(chan struct{})
+	stopped := make(chan struct{})
+	job, err := jobs.Submit(user, 1, func(ctx context.Context, job *Job) model.NotebookResult {
+		defer close(stopped)
+		<-ctx.Done()
+		<-release
+		return model.NotebookResult{Status: model.NotebookCancelled}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jobs.Delete(job.ID, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.Get(job.ID, user); !errors.Is(err, ErrNoJob) {
+		t.Errorf("Get after Delete: got %v, want ErrNoJob", err)
+	}
+	if list := jobs.List(user); len(list) != 0 {
+		t.Errorf("List after Delete = %v, want none", list)
+	}
+	if err := jobs.Delete(job.ID, user); !errors.Is(err, ErrNoJob) {
+		t.Errorf("second Delete: got %v, want ErrNoJob", err)
+	}
+	if _, err := jobs.Submit(user, 1, nil); !errors.Is(err, ErrTooManyJobs) {
+		t.Fatalf("Submit while the deleted job still runs: got %v, want ErrTooManyJobs", err)
+	}
+
+	close(release)
+	<-stopped
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs.mu.Lock()
+		n := len(jobs.jobs)
+		jobs.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("deleted job not forgotten after its run returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	next, err := jobs.Submit(user, 1, func(context.Context, *Job) model.NotebookResult {
+		return model.NotebookResult{Status: model.NotebookCompleted}
+	})
+	if err != nil {
+		t.Fatalf("Submit after the deleted job stopped: %v", err)
+	}
+	jobs.CancelAll()
+	if err := jobs.Delete(next.ID, user); err != nil {
+		t.Fatal(err)
+	}
+	if list := jobs.List(user); len(list) != 0 {
+		t.Errorf("List after deleting a finished job = %v, want none", list)
+	}
+}
@@ -0,0 +1,140 @@
+// Package notebook executes Jupyter notebooks in REPL kernels and tracks
+// notebooks run in the background.
+package notebook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalid wraps the reason a document is not a notebook that can be
+// run.
+var ErrInvalid = errors.New("invalid notebook")
+
+// Notebook is an nbformat 4 notebook. Cells keep every field as
+// submitted, other than the outputs written when they are run.
+type Notebook struct {
+	Cells         []Cell          `json:"cells"`
+	Metadata      json.RawMessage `json:"metadata"`
+	NBFormat      int             `json:"nbformat"`
+	NBFormatMinor int             `json:"nbformat_minor"`
+}
+
+// Cell is a notebook cell by field name.
+type Cell map[string]json.RawMessage
+
+// Parse decodes an .ipynb document.
+func Parse(data []byte) (*Notebook, error) {
+	var nb Notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if nb.NBFormat != 4 {
+		return nil, fmt.Errorf("%w: nbformat %d is not supported; convert it to version 4", ErrInvalid, nb.NBFormat)
+	}
+	if len(nb.Metadata) == 0 {
+		nb.Metadata = json.RawMessage("{}")
+	}
+	for i, cell := range nb.Cells {
+		if cell.Type() == "" {
+			return nil, fmt.Errorf("%w: cell %d has no cell_type", ErrInvalid, i)
+		}
+		if _, err := cell.Source(); err != nil {
+			return nil, fmt.Errorf("%w: cell %d: %v", ErrInvalid, i, err)
+		}
+	}
+	return &nb, nil
+}
+
+// Language is the notebook's kernel language, python if it names none.
+func (nb *Notebook) Language() string {
+	var metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	}
+	json.Unmarshal(nb.Metadata, &metadata)
+	switch {
+	case metadata.KernelSpec.Language != "":
+		return strings.ToLower(metadata.KernelSpec.Language)
+	case metadata.LanguageInfo.Name != "":
+		return strings.ToLower(metadata.LanguageInfo.Name)
+	}
+	return "python"
+}
+
+// CodeCells counts the cells that are run.
+func (nb *Notebook) CodeCells() int {
+	n := 0
+	for _, cell := range nb.Cells {
+		if cell.Type() == "code" {
+			n++
+		}
+	}
+	return n
+}
+
+// Marshal encodes the notebook as an .ipynb document.
+func (nb *Notebook) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(nb); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Cell) Type() string {
+	var t string
+	json.Unmarshal(c["cell_type"], &t)
+	return t
+}
+
+// Source returns the cell's source, which nbformat allows to be split
+// into a list of lines.
+func (c Cell) Source() (string, error) {
+	raw := c["source"]
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return "", errors.New("source must be a string or a list of strings")
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// tagged reports whether the cell's metadata carries tag.
+func (c Cell) tagged(tag string) bool {
+	var metadata struct {
+		Tags []string `json:"tags"`
+	}
+	json.Unmarshal(c["metadata"], &metadata)
+	return slices.Contains(metadata.Tags, tag)
+}
+
+// setOutputs records the outputs of running the cell as execution count.
+// A count of 0 clears it.
+func (c Cell) setOutputs(executionCount int, outputs []map[string]any) {
+	if outputs == nil {
+		outputs = []map[string]any{}
+	}
+	c["outputs"] = marshal(outputs)
+	c["execution_count"] = json.RawMessage("null")
+	if executionCount > 0 {
+		c["execution_count"] = marshal(executionCount)
+	}
+}
@@ -0,0 +1,174 @@
+package notebook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/repl"
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// raisesException marks a cell expected to raise, as in nbclient: the run
+// goes on past it even when stopping on errors.
+const raisesException = "raises-exception"
+
+type Options struct {
+	// CellTimeout interrupts a cell that runs for longer.
+	CellTimeout time.Duration
+	// StopOnError leaves the cells after one that raised unrun.
+	StopOnError bool
+}
+
+// Summary describes how far a run got. Its status is one of the
+// model.Notebook* results.
+type Summary struct {
+	Status        string
+	ExecutedCells int
+	FailedCells   []int
+	Error         string
+}
+
+// Execute runs nb's code cells in order in kernel, writing each one's
+// outputs into it as it finishes. Code cells that are not reached are
+// left without outputs. progress, if not nil, is called after each code
+// cell. Cancelling ctx interrupts the running cell; the run then ends as
+// timed out if ctx's deadline passed, or cancelled otherwise.
+func Execute(ctx context.Context, kernel *repl.Kernel, nb *Notebook, opts Options, progress func(executed int)) Summary {
+	for _, cell := range nb.Cells {
+		if cell.Type() == "code" {
+			cell.setOutputs(0, nil)
+		}
+	}
+
+	summary := Summary{Status: model.NotebookCompleted}
+	for i, cell := range nb.Cells {
+		if cell.Type() != "code" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			summary.Status = stoppedStatus(ctx)
+			return summary
+		}
+		source, _ := cell.Source()
+		if strings.TrimSpace(source) == "" {
+			summary.ExecutedCells++
+			if progress != nil {
+				progress(summary.ExecutedCells)
+			}
+			continue
+		}
+
+		result, err := kernel.Run(ctx, source, opts.CellTimeout)
+		if errors.Is(err, repl.ErrKernelDied) {
+			cell.setOutputs(0, []map[string]any{{
+				"output_type": "error",
+				"ename":       "DeadKernelError",
+				"evalue":      "The kernel exited or stopped responding",
+				"traceback":   []string{},
+			}})
+			summary.Status = model.NotebookError
+			summary.Error = fmt.Sprintf("The kernel died while running cell %d", i)
+			summary.FailedCells = append(summary.FailedCells, i)
+			return summary
+		}
+		if err != nil {
+			summary.Status = model.NotebookError
+			summary.Error = err.Error()
+			return summary
+		}
+
+		cell.setOutputs(result.ExecutionCount, outputs(result, opts.CellTimeout))
+		summary.ExecutedCells++
+		if progress != nil {
+			progress(summary.ExecutedCells)
+		}
+		if ctx.Err() != nil {
+			summary.Status = stoppedStatus(ctx)
+			return summary
+		}
+		switch {
+		case result.Error == nil:
+		case result.TimedOut:
+			summary.FailedCells = append(summary.FailedCells, i)
+			summary.Status = model.NotebookTimedOut
+			return summary
+		case cell.tagged(raisesException):
+		default:
+			summary.FailedCells = append(summary.FailedCells, i)
+			if opts.StopOnError {
+				summary.Status = model.NotebookFailed
+				return summary
+			}
+		}
+	}
+	return summary
+}
+
+func stoppedStatus(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return model.NotebookTimedOut
+	}
+	return model.NotebookCancelled
+}
+
+// outputs converts a cell's result to nbformat outputs: its streams, then
+// displayed values, its value and any exception.
+func outputs(result model.CellResult, cellTimeout time.Duration) []map[string]any {
+	var outs []map[string]any
+	if result.Stdout != "" {
+		outs = append(outs, stream("stdout", result.Stdout))
+	}
+	stderr := result.Stderr
+	if result.Truncated {
+		stderr += fmt.Sprintf("\n[output truncated at %d bytes]\n", repl.MaxOutputBytes)
+	}
+	if stderr != "" {
+		outs = append(outs, stream("stderr", stderr))
+	}
+	for _, data := range result.Display {
+		outs = append(outs, map[string]any{"output_type": "display_data", "data": data, "metadata": map[string]any{}})
+	}
+	if result.Result != nil {
+		outs = append(outs, map[string]any{
+			"output_type":     "execute_result",
+			"execution_count": result.ExecutionCount,
+			"data":            result.Result,
+			"metadata":        map[string]any{},
+		})
+	}
+	if e := result.Error; e != nil {
+		name, value := e.Name, e.Value
+		if result.TimedOut {
+			name, value = "CellTimeoutError", fmt.Sprintf("Cell execution timed out after %s", cellTimeout)
+		}
+		traceback := e.Traceback
+		if traceback == nil {
+			traceback = []string{}
+		}
+		outs = append(outs, map[string]any{
+			"output_type": "error",
+			"ename":       name,
+			"evalue":      value,
+			"traceback":   traceback,
+		})
+	}
+	return outs
+}
+
+func stream(name, text string) map[string]any {
+	return map[string]any{"output_type": "stream", "name": name, "text": text}
+}
+
+// marshal encodes v without escaping HTML, which outputs are full of.
+func marshal(v any) json.RawMessage {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,255 @@
+// Package repl runs code cells in long-lived interpreters inside sandbox
+// containers, keeping state between cells, and tracks the REPL sessions
+// that own them.
+package repl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+const (
+	// MaxOutputBytes bounds each of a cell's stdout and stderr.
+	MaxOutputBytes = 1 << 20
+	// maxReplyBytes bounds a reply line, which also carries rich outputs.
+	maxReplyBytes = 32 << 20
+	// InterruptGrace is how long a cell has to stop after an interrupt
+	// before its kernel is given up on.
+	InterruptGrace = 5 * time.Second
+	// exitGrace is how long a kernel has to exit once its input is closed.
+	exitGrace = 5 * time.Second
+)
+
+var (
+	// ErrBusy is returned by Run while another cell is running.
+	ErrBusy = errors.New("repl: a cell is already running")
+	// ErrIdle is returned by Interrupt when no cell is running.
+	ErrIdle = errors.New("repl: no cell is running")
+	// ErrKernelDied is returned once the interpreter has exited or stopped
+	// responding; the kernel cannot be used again.
+	ErrKernelDied = errors.New("repl: kernel died")
+)
+
+// SignalFunc sends signal sig, by name such as "INT", to process pid in
+// the kernel's sandbox.
+type SignalFunc func(ctx context.Context, pid int, sig string) error
+
+// Command returns the argv that starts kernel, a config kernel name, with
+// a language whose command is command, or nil if kernel is unknown.
+func Command(kernel string, command []string) []string {
+	switch kernel {
+	case "python":
+		return append(slices.Clip(command), pythonKernel, strconv.Itoa(MaxOutputBytes))
+	}
+	return nil
+}
+
+type request struct {
+	ID   int    `json:"id"`
+	Code string `json:"code"`
+}
+
+type reply struct {
+	ID    int  `json:"id"`
+	Ready bool `json:"ready"`
+	PID   int  `json:"pid"`
+	model.CellResult
+}
+
+// Kernel is an interpreter running one cell at a time.
+type Kernel struct {
+	proc   model.Process
+	signal SignalFunc
+	pid    int
+
+	replies chan reply
+	// dead is closed when the interpreter's output ends.
+	dead      chan struct{}
+	closing   chan struct{}
+	closeOnce sync.Once
+	busy      atomic.Bool
+	nextID    int
+}
+
+// Start waits for the kernel started as proc to report it is ready.
+// signal is used to interrupt cells and kill a kernel that will not exit.
+func Start(ctx context.Context, proc model.Process, signal SignalFunc) (*Kernel, error) {
+	k := &Kernel{
+		proc:    proc,
+		signal:  signal,
+		replies: make(chan reply),
+		dead:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	go k.read()
+	select {
+	case r := <-k.replies:
+		if !r.Ready {
+			k.Close()
+			return nil, fmt.Errorf("repl: unexpected message from kernel before it was ready")
+		}
+		k.pid = r.PID
+		return k, nil
+	case <-k.dead:
+		k.Close()
+		if err := proc.Wait(); err != nil {
+			return nil, fmt.Errorf("repl: kernel exited during startup: %w", err)
+		}
+		return nil, errors.New("repl: kernel exited during startup")
+	case <-ctx.Done():
+		k.Close()
+		return nil, fmt.Errorf("repl: waiting for kernel: %w", ctx.Err())
+	}
+}
+
+func (k *Kernel) read() {
+	defer close(k.dead)
+	scanner := bufio.NewScanner(k.proc.Stdout())
+	scanner.Buffer(make([]byte, 64<<10), maxReplyBytes)
+	for scanner.Scan() {
+		var r reply
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return
+		}
+		select {
+		case k.replies <- r:
+		case <-k.closing:
+			return
+		}
+	}
+	// Unblock the stdout writer if the scanner gave up early.
+	io.Copy(io.Discard, k.proc.Stdout())
+}
+
+// Run executes code and returns its result. If the cell runs for longer
+// than timeout, or ctx is cancelled, it is interrupted; the result then
+// reports TimedOut or the interrupt as its error. ErrKernelDied is
+// returned if the kernel exits or does not stop after an interrupt, in
+// which case it has been closed.
+func (k *Kernel) Run(ctx context.Context, code string, timeout time.Duration) (model.CellResult, error) {
+	if !k.busy.CompareAndSwap(false, true) {
+		return model.CellResult{}, ErrBusy
+	}
+	defer k.busy.Store(false)
+
+	k.nextID++
+	id := k.nextID
+	line, err := json.Marshal(request{ID: id, Code: code})
+	if err != nil {
+		return model.CellResult{}, err
+	}
+	start := time.Now()
+	if _, err := k.proc.Stdin().Write(append(line, '\n')); err != nil {
+		k.Close()
+		return model.CellResult{}, ErrKernelDied
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	expired, cancelled := timer.C, ctx.Done()
+	timedOut := false
+	var grace <-chan time.Time
+	for {
+		select {
+		case r := <-k.replies:
+			if r.ID != id {
+				continue
+			}
+			result := r.CellResult
+			result.TimedOut = timedOut
+			result.DurationMS = time.Since(start).Milliseconds()
+			return result, nil
+		case <-k.dead:
+			k.Close()
+			return model.CellResult{}, ErrKernelDied
+		case <-expired:
+			timedOut = true
+			expired, cancelled = nil, nil
+			grace = k.stop(context.WithoutCancel(ctx))
+		case <-cancelled:
+			expired, cancelled = nil, nil
+			grace = k.stop(context.WithoutCancel(ctx))
+		case <-grace:
+			k.Close()
+			return model.CellResult{}, ErrKernelDied
+		}
+	}
+}
+
+// stop interrupts the running cell and returns when to give up waiting for
+// it.
+func (k *Kernel) stop(ctx context.Context) <-chan time.Time {
+	ctx, cancel := context.WithTimeout(ctx, InterruptGrace)
+	defer cancel()
+	k.signal(ctx, k.pid, "INT")
+	return time.After(InterruptGrace)
+}
+
+// Interrupt stops the running cell, which then returns with a
+// KeyboardInterrupt error.
+func (k *Kernel) Interrupt(ctx context.Context) error {
+	if !k.busy.Load() {
+		return ErrIdle
+	}
+	return k.signal(ctx, k.pid, "INT")
+}
+
+// Busy reports whether a cell is running.
+func (k *Kernel) Busy() bool {
+	return k.busy.Load()
+}
+
+func (k *Kernel) exited() bool {
+	select {
+	case <-k.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+// Dead is closed once the kernel has exited.
+func (k *Kernel) Dead() <-chan struct{} {
+	return k.dead
+}
+
+// Close ends the kernel: a running cell is interrupted, and the kernel's
+// input is closed so it exits and cleans up. One that has not exited
+// within a grace period is killed. It is safe to call more than once.
+func (k *Kernel) Close() {
+	k.closeOnce.Do(func() {
+		close(k.closing)
+		ctx, cancel := context.WithTimeout(context.Background(), exitGrace)
+		defer cancel()
+		// A kernel that never became ready has no pid to signal.
+		if k.pid != 0 && k.busy.Load() && !k.exited() {
+			k.signal(ctx, k.pid, "INT")
+		}
+		k.proc.Stdin().Close()
+		select {
+		case <-k.dead:
+		case <-ctx.Done():
+			if k.pid == 0 {
+				break
+			}
+			killCtx, cancel := context.WithTimeout(context.Background(), exitGrace)
+			defer cancel()
+			if err := k.signal(killCtx, k.pid, "KILL"); err != nil {
+				slog.Warn("Failed to kill unresponsive REPL kernel", "pid", k.pid, "error", err)
+			}
+		}
+		k.proc.Close()
+	})
+}
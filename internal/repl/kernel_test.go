@@ -0,0 +1,285 @@
+package repl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// fakeProcess stands in for a kernel driver, played by the test: requests
+// written to its stdin arrive on requests, and send writes a line of its
+// output. Like the real driver it exits once its input is closed.
+type fakeProcess struct {
+	stdin    *io.PipeWriter
+	stdout   *io.PipeReader
+	out      *io.PipeWriter
+	requests chan request
+
+	exitOnce sync.Once
+	exited   chan struct{}
+	exitErr  error
+	closed   atomic.Bool
+}
+
+func newFakeProcess() *fakeProcess {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	p := &fakeProcess{
+		stdin:    inW,
+		stdout:   outR,
+		out:      outW,
+		requests: make(chan request, 16),
+		exited:   make(chan struct{}),
+	}
+	go func() {
+		scanner := bufio.NewScanner(inR)
+		for scanner.Scan() {
+			var r request
+			if err := json.Unmarshal(scanner.Bytes(), &r); err == nil {
+				p.requests <- r
+			}
+		}
+		close(p.requests)
+		p.exit(nil)
+	}()
+	return p
+}
+
+func (p *fakeProcess) Stdin() io.WriteCloser { return p.stdin }
+
+func (p *fakeProcess) Stdout() io.Reader { return p.stdout }
+
+func (p *fakeProcess) Wait() error {
+	<-p.exited
+	return p.exitErr
+}
+
+func (p *fakeProcess) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+// send writes line as the driver's output.
+func (p *fakeProcess) send(line string) {
+	p.out.Write([]byte(line + "\n"))
+}
+
+// exit ends the driver's output, as when the interpreter exits with err.
+func (p *fakeProcess) exit(err error) {
+	p.exitOnce.Do(func() {
+		p.exitErr = err
+		p.out.Close()
+		close(p.exited)
+	})
+}
+
+// serve answers each request with the lines respond returns.
+func (p *fakeProcess) serve(respond func(r request) []string) {
+	go func() {
+		for r := range p.requests {
+			for _, line := range respond(r) {
+				p.send(line)
+			}
+		}
+	}()
+}
+
+// signals records the signals sent to a kernel as "SIG pid".
+type signals chan string
+
+func (s signals) send(_ context.Context, pid int, sig string) error {
+	s <- fmt.Sprintf("%s %d", sig, pid)
+	return nil
+}
+
+// startKernel starts a kernel on p, whose driver reports ready with pid 42.
+func startKernel(t *testing.T, p *fakeProcess, sig signals) *Kernel {
+	t.Helper()
+	go p.send(`{"ready":true,"pid":42}`)
+	k, err := Start(context.Background(), p, sig.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// interruptible answers each request once the kernel is sent SIGINT, the
+// way a running cell ends with KeyboardInterrupt.
+func interruptible(sig signals, seen chan<- string) func(r request) []string {
+	return func(r request) []string {
+		s := <-sig
+		if seen != nil {
+			seen <- s
+		}
+		return []string{fmt.Sprintf(`{"id":%d,"execution_count":%d,"error":{"ename":"KeyboardInterrupt","evalue":"","traceback":[]}}`, r.ID, r.ID)}
+	}
+}
+
+func waitBusy(t *testing.T, k *Kernel) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !k.Busy() {
+		if time.Now().After(deadline) {
+			t.Fatal("kernel never started running the cell")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKernelRun(t *testing.T) {
+	p := newFakeProcess()
+	k := startKernel(t, p, make(signals, 1))
+	// A reply to an earlier, abandoned cell is skipped.
+	p.serve(func(r request) []string {
+		return []string{
+			`{"id":99,"stdout":"stale"}`,
+			fmt.Sprintf(`{"id":%d,"execution_count":%d,"stdout":%q,"result":{"text/plain":"2"}}`, r.ID, r.ID, r.Code+"\n"),
+		}
+	})
+
+	for i, code := range []string{"1+1", "x = 2"} {
+		result, err := k.Run(context.Background(), code, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExecutionCount != i+1 || result.Stdout != code+"\n" || result.Result["text/plain"] != "2" || result.TimedOut {
+			t.Errorf("cell %d: result = %+v", i+1, result)
+		}
+	}
+	if k.Busy() {
+		t.Error("kernel busy after its cells finished")
+	}
+
+	k.Close()
+	select {
+	case <-k.Dead():
+	default:
+		t.Error("kernel not dead after Close")
+	}
+	if !p.closed.Load() {
+		t.Error("process not closed with the kernel")
+	}
+}
+
+func TestKernelInterrupt(t *testing.T) {
+	p := newFakeProcess()
+	sig := make(signals, 1)
+	k := startKernel(t, p, sig)
+	defer k.Close()
+	seen := make(chan string, 1)
+	p.serve(interruptible(sig, seen))
+
+	if err := k.Interrupt(context.Background()); !errors.Is(err, ErrIdle) {
+		t.Errorf("Interrupt while idle: got %v, want ErrIdle", err)
+	}
+
+	done := make(chan error, 1)
+	var result model.CellResult
+	go func() {
+		var err error
+		result, err = k.Run(context.Background(), "while True: pass", time.Minute)
+		done <- err
+	}()
+	waitBusy(t, k)
+	if _, err := k.Run(context.Background(), "1", time.Minute); !errors.Is(err, ErrBusy) {
+		t.Errorf("Run while busy: got %v, want ErrBusy", err)
+	}
+	if err := k.Interrupt(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if result.Error == nil || result.Error.Name != "KeyboardInterrupt" || result.TimedOut {
+		t.Errorf("interrupted cell = %+v, want a KeyboardInterrupt", result)
+	}
+	if s := <-seen; s != "INT 42" {
+		t.Errorf("signal = %q, want INT to the kernel's pid", s)
+	}
+}
+
+func TestKernelRunTimesOut(t *testing.T) {
+	p := newFakeProcess()
+	sig := make(signals, 1)
+	k := startKernel(t, p, sig)
+	defer k.Close()
+	p.serve(interruptible(sig, nil))
+
+	result, err := k.Run(context.Background(), "while True: pass", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TimedOut || result.Error == nil {
+		t.Errorf("result = %+v, want a timed out, interrupted cell", result)
+	}
+}
+
+func TestKernelDiesDuringCell(t *testing.T) {
+	for name, die := range map[string]func(p *fakeProcess){
+		"exits":          func(p *fakeProcess) { p.exit(&model.ExitError{ExitCode: 137}) },
+		"garbled output": func(p *fakeProcess) { p.send("Segmentation fault (core dumped)") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProcess()
+			k := startKernel(t, p, make(signals, 1))
+			p.serve(func(request) []string {
+				die(p)
+				return nil
+			})
+
+			if _, err := k.Run(context.Background(), "import os; os.abort()", time.Minute); !errors.Is(err, ErrKernelDied) {
+				t.Fatalf("Run: got %v, want ErrKernelDied", err)
+			}
+			select {
+			case <-k.Dead():
+			case <-time.After(5 * time.Second):
+				t.Fatal("kernel not dead")
+			}
+			if !p.closed.Load() {
+				t.Error("process of a dead kernel not closed")
+			}
+			if _, err := k.Run(context.Background(), "1", time.Minute); !errors.Is(err, ErrKernelDied) {
+				t.Errorf("Run after death: got %v, want ErrKernelDied", err)
+			}
+		})
+	}
+}
+
+func TestKernelStartFailures(t *testing.T) {
+	t.Run("exits", func(t *testing.T) {
+		p := newFakeProcess()
+		p.exit(&model.ExitError{ExitCode: 1, Output: "SyntaxError"})
+		_, err := Start(context.Background(), p, make(signals, 1).send)
+		var exitErr *model.ExitError
+		if !errors.As(err, &exitErr) || !strings.Contains(err.Error(), "exited during startup") {
+			t.Errorf("Start: got %v, want the exit status", err)
+		}
+	})
+	t.Run("not ready", func(t *testing.T) {
+		p := newFakeProcess()
+		go p.send(`{"id":1,"stdout":"hello"}`)
+		if _, err := Start(context.Background(), p, make(signals, 1).send); err == nil {
+			t.Error("Start succeeded on a reply before ready")
+		}
+		if !p.closed.Load() {
+			t.Error("process not closed after a failed start")
+		}
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := Start(ctx, newFakeProcess(), make(signals, 1).send); !errors.Is(err, context.Canceled) {
+			t.Errorf("Start: got %v, want context.Canceled", err)
+		}
+	})
+}
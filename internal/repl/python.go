@@ -0,0 +1,149 @@
+package repl
+
+// pythonKernel is the driver run with a Python language's command. It
+// executes cells in one namespace and speaks the kernel protocol on the
+// stdin and stdout it was started with; while a cell runs, file
+// descriptors 1 and 2 are redirected to files so output from child
+// processes is captured too. A trailing expression's value is returned as
+// a MIME bundle, like a notebook's Out[n], and display() adds more.
+const pythonKernel = `
+import ast, base64, json, linecache, os, signal, sys, tempfile, traceback
+
+proto_in = os.fdopen(os.dup(0), "r", encoding="utf-8")
+proto_out = os.fdopen(os.dup(1), "w", encoding="utf-8")
+null = os.open(os.devnull, os.O_RDWR)
+os.dup2(null, 0)
+os.dup2(null, 1)
+
+LIMIT = int(sys.argv[1]) if len(sys.argv) > 1 else 1 << 20
+REPRS = (
+    ("text/html", "_repr_html_"),
+    ("text/markdown", "_repr_markdown_"),
+    ("text/latex", "_repr_latex_"),
+    ("image/svg+xml", "_repr_svg_"),
+    ("image/png", "_repr_png_"),
+    ("image/jpeg", "_repr_jpeg_"),
+    ("application/json", "_repr_json_"),
+)
+
+running = False
+displayed = []
+count = 0
+
+
+def on_interrupt(signum, frame):
+    if running:
+        raise KeyboardInterrupt
+
+
+signal.signal(signal.SIGINT, on_interrupt)
+
+
+def bundle(obj):
+    try:
+        data = {"text/plain": repr(obj)}
+    except Exception as e:
+        data = {"text/plain": "<repr failed: %s>" % type(e).__name__}
+    if isinstance(obj, type):
+        return data
+    for mime, method in REPRS:
+        f = getattr(obj, method, None)
+        if not callable(f):
+            continue
+        try:
+            v = f()
+        except Exception:
+            continue
+        if isinstance(v, tuple):
+            v = v[0]
+        if v is None:
+            continue
+        if isinstance(v, bytes):
+            v = base64.b64encode(v).decode()
+        data[mime] = v
+    return data
+
+
+def display(*objs):
+    for obj in objs:
+        displayed.append(bundle(obj))
+
+
+namespace = {"__name__": "__main__", "__builtins__": __builtins__, "display": display}
+
+
+def read(f):
+    f.flush()
+    f.seek(0)
+    data = f.read(LIMIT + 1)
+    return data[:LIMIT].decode("utf-8", "replace"), len(data) > LIMIT
+
+
+def failure(e, tb):
+    lines = []
+    # Frames of this driver, such as the interrupt handler, are left out.
+    frames = [f for f in traceback.extract_tb(tb) if f.filename != "<string>"]
+    if frames and not isinstance(e, SyntaxError):
+        lines = ["Traceback (most recent call last):\n"] + traceback.format_list(frames)
+    lines += traceback.format_exception_only(type(e), e)
+    return {"ename": type(e).__name__, "evalue": str(e), "traceback": lines}
+
+
+def run(code):
+    global running, count
+    count += 1
+    name = "<cell-%d>" % count
+    linecache.cache[name] = (len(code), None, code.splitlines(True), name)
+    del displayed[:]
+    reply = {"execution_count": count}
+    out, err = tempfile.TemporaryFile(), tempfile.TemporaryFile()
+    sys.stdout.flush()
+    sys.stderr.flush()
+    os.dup2(out.fileno(), 1)
+    os.dup2(err.fileno(), 2)
+    try:
+        running = True
+        try:
+            tree = ast.parse(code, name, "exec")
+            last = None
+            if tree.body and isinstance(tree.body[-1], ast.Expr):
+                last = ast.Expression(tree.body.pop().value)
+            exec(compile(tree, name, "exec"), namespace)
+            if last is not None:
+                value = eval(compile(last, name, "eval"), namespace)
+                if value is not None:
+                    namespace["_"] = value
+                    reply["result"] = bundle(value)
+        except BaseException as e:
+            running = False
+            reply["error"] = failure(e, e.__traceback__)
+        finally:
+            running = False
+    except KeyboardInterrupt as e:
+        reply["error"] = failure(e, None)
+    sys.stdout.flush()
+    sys.stderr.flush()
+    os.dup2(null, 1)
+    os.dup2(null, 2)
+    reply["stdout"], t1 = read(out)
+    reply["stderr"], t2 = read(err)
+    reply["truncated"] = t1 or t2
+    out.close()
+    err.close()
+    if displayed:
+        reply["display"] = list(displayed)
+    return reply
+
+
+def send(msg):
+    proto_out.write(json.dumps(msg, default=str) + "\n")
+    proto_out.flush()
+
+
+send({"ready": True, "pid": os.getpid()})
+for line in proto_in:
+    request = json.loads(line)
+    reply = run(request["code"])
+    reply["id"] = request["id"]
+    send(reply)
+`
@@ -0,0 +1,277 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// SweepInterval is how often sessions are checked against their idle and
+// lifetime limits.
+const SweepInterval = 15 * time.Second
+
+var (
+	ErrTooManySessions = errors.New("repl: too many open sessions")
+	ErrNoSession       = errors.New("repl: no such session")
+)
+
+// Session is a kernel owned by one user, with the container it runs in.
+type Session struct {
+	ID       uuid.UUID
+	Language string
+	UserID   uuid.UUID
+	Created  time.Time
+	// Expires is when the session ends regardless of use.
+	Expires time.Time
+
+	kernel  *Kernel
+	release func()
+	endOnce sync.Once
+
+	mu             sync.Mutex
+	lastActive     time.Time
+	executionCount int
+}
+
+// Sessions tracks open sessions, limits how many each user has and ends
+// those left idle or past their lifetime.
+type Sessions struct {
+	maxPerUser  int
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	// now is the clock sessions are timed by; tests replace it.
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*Session
+}
+
+func NewSessions(maxPerUser int, idleTimeout, maxLifetime time.Duration) *Sessions {
+	return &Sessions{
+		maxPerUser:  maxPerUser,
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+		now:         time.Now,
+		sessions:    make(map[uuid.UUID]*Session),
+	}
+}
+
+// Reserve claims one of user's sessions for one about to be started, so
+// the limit is enforced before a container is taken for it. The session
+// must then be passed to Start or Discard.
+func (s *Sessions) Reserve(user uuid.UUID, language string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, session := range s.sessions {
+		if session.UserID == user {
+			n++
+		}
+	}
+	if n >= s.maxPerUser {
+		return nil, ErrTooManySessions
+	}
+	now := s.now()
+	session := &Session{
+		ID:         uuid.New(),
+		Language:   language,
+		UserID:     user,
+		Created:    now,
+		Expires:    now.Add(s.maxLifetime),
+		lastActive: now,
+	}
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+// Start makes a reserved session usable. release is called once the
+// session has ended and kernel has been closed.
+func (s *Sessions) Start(session *Session, kernel *Kernel, release func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.kernel = kernel
+	session.release = release
+	session.lastActive = s.now()
+}
+
+// Discard drops a reserved session that could not be started.
+func (s *Sessions) Discard(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session.ID)
+}
+
+// Get returns user's started session id.
+func (s *Sessions) Get(id, user uuid.UUID) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.UserID != user || session.kernel == nil {
+		return nil, ErrNoSession
+	}
+	return session, nil
+}
+
+// End ends user's session id, stopping any cell it is running.
+func (s *Sessions) End(id, user uuid.UUID) error {
+	session, err := s.Get(id, user)
+	if err != nil {
+		return err
+	}
+	s.end(session, "closed")
+	return nil
+}
+
+// CloseAll ends every session, as on shutdown.
+func (s *Sessions) CloseAll() int {
+	s.mu.Lock()
+	var started []*Session
+	for _, session := range s.sessions {
+		if session.kernel != nil {
+			started = append(started, session)
+		}
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, session := range started {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.end(session, "shutdown")
+		}()
+	}
+	wg.Wait()
+	return len(started)
+}
+
+// List returns user's started sessions, oldest first.
+func (s *Sessions) List(user uuid.UUID) []model.REPLSession {
+	s.mu.Lock()
+	var sessions []*Session
+	for _, session := range s.sessions {
+		if session.UserID == user && session.kernel != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.Unlock()
+
+	statuses := []model.REPLSession{}
+	for _, session := range sessions {
+		statuses = append(statuses, s.Status(session))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].CreatedAt.Before(statuses[j].CreatedAt) })
+	return statuses
+}
+
+// Status describes session.
+func (s *Sessions) Status(session *Session) model.REPLSession {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	idleExpires := session.lastActive.Add(s.idleTimeout)
+	if idleExpires.After(session.Expires) {
+		idleExpires = session.Expires
+	}
+	return model.REPLSession{
+		ID:             session.ID,
+		Language:       session.Language,
+		Busy:           session.kernel.Busy(),
+		ExecutionCount: session.executionCount,
+		CreatedAt:      session.Created,
+		LastActiveAt:   session.lastActive,
+		IdleExpiresAt:  idleExpires,
+		ExpiresAt:      session.Expires,
+	}
+}
+
+// Run runs code in session's kernel; see Kernel.Run. If the kernel dies
+// the session ends.
+func (s *Sessions) Run(ctx context.Context, session *Session, code string, timeout time.Duration) (model.CellResult, error) {
+	session.touch(s.now(), 0)
+	result, err := session.kernel.Run(ctx, code, timeout)
+	if errors.Is(err, ErrKernelDied) {
+		s.end(session, "kernel died")
+		return result, err
+	}
+	session.touch(s.now(), result.ExecutionCount)
+	return result, err
+}
+
+// Interrupt stops the cell session is running.
+func (s *Sessions) Interrupt(ctx context.Context, session *Session) error {
+	return session.kernel.Interrupt(ctx)
+}
+
+func (session *Session) touch(now time.Time, executionCount int) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.lastActive = now
+	if executionCount > 0 {
+		session.executionCount = executionCount
+	}
+}
+
+// Sweep ends sessions that have been idle for too long or outlived their
+// lifetime, every SweepInterval until ctx is cancelled.
+func (s *Sessions) Sweep(ctx context.Context) {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(s.now())
+		}
+	}
+}
+
+func (s *Sessions) sweep(now time.Time) {
+	type expired struct {
+		session *Session
+		reason  string
+	}
+	var ended []expired
+	s.mu.Lock()
+	for _, session := range s.sessions {
+		if session.kernel == nil {
+			continue
+		}
+		session.mu.Lock()
+		idle := !session.kernel.Busy() && now.Sub(session.lastActive) > s.idleTimeout
+		session.mu.Unlock()
+		switch {
+		case session.kernel.exited():
+			ended = append(ended, expired{session, "kernel died"})
+		case now.After(session.Expires):
+			ended = append(ended, expired{session, "lifetime exceeded"})
+		case idle:
+			ended = append(ended, expired{session, "idle timeout"})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, e := range ended {
+		go s.end(e.session, e.reason)
+	}
+}
+
+// end closes session's kernel and releases its container, once.
+func (s *Sessions) end(session *Session, reason string) {
+	session.endOnce.Do(func() {
+		s.mu.Lock()
+		delete(s.sessions, session.ID)
+		s.mu.Unlock()
+
+		session.kernel.Close()
+		session.release()
+		slog.Info("REPL session ended", "session_id", session.ID, "language", session.Language,
+			"reason", reason, "duration", s.now().Sub(session.Created))
+	})
+}
@@ -0,0 +1,209 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeClock is a clock the test moves by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestSessions returns a registry timed by the returned clock, with a
+// one minute idle timeout and a one hour lifetime.
+func newTestSessions(maxPerUser int) (*Sessions, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	s := NewSessions(maxPerUser, time.Minute, time.Hour)
+	s.now = clock.Now
+	return s, clock
+}
+
+// startSession starts a reserved session on a fake kernel. The returned
+// channel is closed once its container is released.
+func startSession(t *testing.T, s *Sessions, session *Session) (*fakeProcess, <-chan struct{}) {
+	t.Helper()
+	p := newFakeProcess()
+	k := startKernel(t, p, make(signals, 1))
+	released := make(chan struct{})
+	s.Start(session, k, func() { close(released) })
+	return p, released
+}
+
+func openSession(t *testing.T, s *Sessions, user uuid.UUID) (*Session, *fakeProcess, <-chan struct{}) {
+	t.Helper()
+	session, err := s.Reserve(user, "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, released := startSession(t, s, session)
+	return session, p, released
+}
+
+func TestSessionsReserveCountsTowardsLimit(t *testing.T) {
+	s, _ := newTestSessions(2)
+	user := uuid.New()
+	reserved, err := s.Reserve(user, "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, _, _ := openSession(t, s, user)
+
+	if _, err := s.Reserve(user, "python"); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("third session: got %v, want ErrTooManySessions", err)
+	}
+	if _, err := s.Reserve(uuid.New(), "python"); err != nil {
+		t.Errorf("another user's session: %v", err)
+	}
+
+	// A session is only visible once its kernel has started.
+	if _, err := s.Get(reserved.ID, user); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Get of a reserved session: got %v, want ErrNoSession", err)
+	}
+	if got, err := s.Get(started.ID, user); err != nil || got != started {
+		t.Errorf("Get of a started session = %v, %v", got, err)
+	}
+	if _, err := s.Get(started.ID, uuid.New()); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Get of another user's session: got %v, want ErrNoSession", err)
+	}
+	if list := s.List(user); len(list) != 1 || list[0].ID != started.ID {
+		t.Errorf("List = %+v, want only the started session", list)
+	}
+
+	s.Discard(reserved)
+	if _, err := s.Reserve(user, "python"); err != nil {
+		t.Errorf("Reserve after Discard: %v", err)
+	}
+}
+
+func TestSessionsSweep(t *testing.T) {
+	s, clock := newTestSessions(3)
+	user := uuid.New()
+	idle, _, idleReleased := openSession(t, s, user)
+	busy, busyProc, busyReleased := openSession(t, s, user)
+	crashed, crashedProc, crashedReleased := openSession(t, s, user)
+
+	// busy runs a cell that never finishes.
+	ran := make(chan error, 1)
+	go func() {
+		_, err := s.Run(context.Background(), busy, "while True: pass", 2*time.Hour)
+		ran <- err
+	}()
+	waitBusy(t, busy.kernel)
+	<-busyProc.requests
+
+	// A kernel that died is ended at the next sweep, however recently used.
+	crashedProc.exit(errors.New("killed"))
+	<-crashed.kernel.Dead()
+	clock.advance(30 * time.Second)
+	s.sweep(clock.Now())
+	await(t, crashedReleased, "the crashed session's release")
+	if _, err := s.Get(crashed.ID, user); !errors.Is(err, ErrNoSession) {
+		t.Errorf("crashed session still open: %v", err)
+	}
+	if _, err := s.Get(idle.ID, user); err != nil {
+		t.Errorf("session idle for 30s ended: %v", err)
+	}
+
+	// Past the idle timeout the idle session ends, but a running cell keeps
+	// its session open.
+	clock.advance(31 * time.Second)
+	s.sweep(clock.Now())
+	await(t, idleReleased, "the idle session's release")
+	select {
+	case <-idle.kernel.Dead():
+	default:
+		t.Error("idle session's kernel not closed")
+	}
+	if list := s.List(user); len(list) != 1 || list[0].ID != busy.ID || !list[0].Busy {
+		t.Errorf("List = %+v, want only the busy session", list)
+	}
+
+	// Past its lifetime even a busy session ends, stopping its cell.
+	clock.advance(time.Hour)
+	s.sweep(clock.Now())
+	await(t, busyReleased, "the busy session's release")
+	if err := await(t, ran, "the busy cell to stop"); !errors.Is(err, ErrKernelDied) {
+		t.Errorf("busy cell: got %v, want ErrKernelDied", err)
+	}
+	if list := s.List(user); len(list) != 0 {
+		t.Errorf("List = %+v, want no sessions", list)
+	}
+}
+
+func TestSessionsReservedSessionExpiresBeforeStart(t *testing.T) {
+	s, clock := newTestSessions(1)
+	user := uuid.New()
+	session, err := s.Reserve(user, "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The container takes longer to start than the session may live. A
+	// sweep in the meantime leaves the reservation alone: it has no kernel
+	// to close and still holds the user's slot.
+	clock.advance(2 * time.Hour)
+	s.sweep(clock.Now())
+	if _, err := s.Reserve(user, "python"); !errors.Is(err, ErrTooManySessions) {
+		t.Fatalf("Reserve while a reservation is pending: got %v, want ErrTooManySessions", err)
+	}
+
+	// Once started it is past its lifetime and the next sweep ends it.
+	_, released := startSession(t, s, session)
+	if status := s.Status(session); !status.ExpiresAt.Before(clock.Now()) {
+		t.Errorf("started session expires at %v, want before %v", status.ExpiresAt, clock.Now())
+	}
+	s.sweep(clock.Now())
+	await(t, released, "the expired session's release")
+	if _, err := s.Reserve(user, "python"); err != nil {
+		t.Errorf("Reserve after the expired session ended: %v", err)
+	}
+}
+
+func TestSessionsDiscardExpiredReservation(t *testing.T) {
+	s, clock := newTestSessions(1)
+	user := uuid.New()
+	session, err := s.Reserve(user, "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(2 * time.Hour)
+	s.sweep(clock.Now())
+
+	// The kernel failed to start; discarding frees the slot.
+	s.Discard(session)
+	if _, err := s.Reserve(user, "python"); err != nil {
+		t.Errorf("Reserve after Discard: %v", err)
+	}
+}
+
+// await waits for a value from ch or fails the test.
+func await[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	var zero T
+	return zero
+}
@@ -1,25 +1,133 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
-	"github.com/Aadithya-J/alcaIDE/internal/docker"
+	"github.com/Aadithya-J/alcaIDE/internal/auth"
+	"github.com/Aadithya-J/alcaIDE/internal/config"
 	"github.com/Aadithya-J/alcaIDE/internal/handler"
+	"github.com/Aadithya-J/alcaIDE/internal/logging"
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/telemetry"
 )
 
-func Setup(dockerManager *docker.DockerManager) http.Handler {
-	// containers := dockerManager.GetContainers()
-	// for _, c := range containers {
-	// 	fmt.Printf("Container ID: %s\n", c.ID)
-	// }
+type Deps struct {
+	Auth          *handler.AuthHandler
+	APIKeys       *handler.APIKeyHandler
+	Exec          *handler.ExecHandler
+	LSP           *handler.LSPHandler
+	REPL          *handler.REPLHandler
+	Notebooks     *handler.NotebookHandler
+	Authenticator *handler.Authenticator
+	// OIDC is nil unless single sign-on is configured.
+	OIDC *handler.OIDCHandler
+	// History is nil when execution history is disabled.
+	History *handler.HistoryHandler
+	// Admin is nil unless an admin token is configured.
+	Admin      *handler.AdminHandler
+	AdminToken string
+	// ExecRequiresVerified limits /exec to logged-in users with a verified
+	// email address.
+	ExecRequiresVerified bool
+	// HealthCheck reports whether backing services (the database) are up.
+	HealthCheck func(context.Context) error
+}
+
+func Setup(deps Deps) http.Handler {
+	accounts := deps.Auth
+	authn := deps.Authenticator
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ping", handler.PingHandler)
-	mux.HandleFunc("/register", handler.RegisterHandler)
-	mux.HandleFunc("/login", handler.LoginHandler)
-
-	mux.HandleFunc("/exec", func(w http.ResponseWriter, r *http.Request) {
-		handler.ExecCodeHandler(w, r, r.Context(), dockerManager)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handler.HealthHandler(w, r, deps.HealthCheck)
 	})
-	return mux
+	mux.HandleFunc("/register", accounts.Register)
+	mux.HandleFunc("/login", accounts.Login)
+	mux.Handle("/verify-email/request", authn.RequireAuth(http.HandlerFunc(accounts.RequestVerification)))
+	mux.HandleFunc("/verify-email/confirm", accounts.ConfirmVerification)
+	mux.HandleFunc("/password-reset/request", accounts.RequestPasswordReset)
+	mux.HandleFunc("/password-reset/confirm", accounts.ConfirmPasswordReset)
+	if deps.OIDC != nil {
+		mux.HandleFunc("/auth/oidc/login", deps.OIDC.Login)
+		mux.HandleFunc(config.OIDCCallbackPath, deps.OIDC.Callback)
+	}
+	mux.Handle("/api-keys", authn.RequireSession(http.HandlerFunc(deps.APIKeys.Collection)))
+	mux.Handle("/api-keys/{id}", authn.RequireSession(http.HandlerFunc(deps.APIKeys.Revoke)))
+	mux.Handle("/metrics", metrics.Handler())
+
+	exec := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.Exec.Exec))
+	queue := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.Exec.Queue))
+	// Formatting and diagnostics run in the same containers as /exec, so
+	// they are guarded the same way.
+	format := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.Exec.Format))
+	diagnostics := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.Exec.Diagnostics))
+	runNotebook := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.Notebooks.Run))
+	if deps.ExecRequiresVerified {
+		mux.Handle("/exec", authn.RequireVerified(exec))
+		mux.Handle("/exec/queue", authn.RequireVerified(queue))
+		mux.Handle("/format", authn.RequireVerified(format))
+		mux.Handle("/diagnostics", authn.RequireVerified(diagnostics))
+		mux.Handle("/notebooks/run", authn.RequireVerified(runNotebook))
+	} else {
+		mux.Handle("/exec", authn.Authenticate(exec))
+		mux.Handle("/exec/queue", authn.Authenticate(queue))
+		mux.Handle("/format", authn.Authenticate(format))
+		mux.Handle("/diagnostics", authn.Authenticate(diagnostics))
+		mux.Handle("/notebooks/run", authn.Authenticate(runNotebook))
+	}
+
+	// Language server sessions belong to a user, so unlike /exec they
+	// always need one. Connecting is authorised by the session's ticket.
+	lspSessions := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.LSP.Collection))
+	lspSession := handler.RequireScope(auth.ScopeExec, http.HandlerFunc(deps.LSP.Close))
+	if deps.ExecRequiresVerified {
+		mux.Handle("/lsp/sessions", authn.RequireVerified(lspSessions))
+		mux.Handle("/lsp/sessions/{id}", authn.RequireVerified(lspSession))
+	} else {
+		mux.Handle("/lsp/sessions", authn.RequireAuth(lspSessions))
+		mux.Handle("/lsp/sessions/{id}", authn.RequireAuth(lspSession))
+	}
+	mux.HandleFunc("/lsp/sessions/{id}/connect", deps.LSP.Connect)
+
+	// REPL sessions and background notebook runs likewise belong to a
+	// user.
+	replSession := func(h http.HandlerFunc) http.Handler {
+		scoped := handler.RequireScope(auth.ScopeExec, h)
+		if deps.ExecRequiresVerified {
+			return authn.RequireVerified(scoped)
+		}
+		return authn.RequireAuth(scoped)
+	}
+	mux.Handle("/repl/sessions", replSession(deps.REPL.Collection))
+	mux.Handle("/repl/sessions/{id}", replSession(deps.REPL.Session))
+	mux.Handle("/repl/sessions/{id}/cells", replSession(deps.REPL.Cells))
+	mux.Handle("/repl/sessions/{id}/interrupt", replSession(deps.REPL.Interrupt))
+	mux.Handle("/notebooks/jobs", replSession(deps.Notebooks.Collection))
+	mux.Handle("/notebooks/jobs/{id}", replSession(deps.Notebooks.Job))
+
+	if history := deps.History; history != nil {
+		readHistory := func(h http.HandlerFunc) http.Handler {
+			return authn.RequireAuth(handler.RequireScope(auth.ScopeHistory, h))
+		}
+		mux.Handle("/executions", readHistory(history.List))
+		mux.Handle("/executions/{id}", readHistory(history.Get))
+
+		rerun := handler.RequireScope(auth.ScopeHistory, handler.RequireScope(auth.ScopeExec, http.HandlerFunc(history.Rerun)))
+		if deps.ExecRequiresVerified {
+			mux.Handle("/executions/{id}/rerun", authn.RequireVerified(rerun))
+		} else {
+			mux.Handle("/executions/{id}/rerun", authn.RequireAuth(rerun))
+		}
+	}
+
+	if admin := deps.Admin; admin != nil {
+		mux.Handle("/admin/reload", handler.RequireAdminToken(deps.AdminToken, http.HandlerFunc(admin.ReloadLanguages)))
+		mux.Handle("/admin/pools", handler.RequireAdminToken(deps.AdminToken, http.HandlerFunc(admin.Pools)))
+		mux.Handle("/admin/hosts", handler.RequireAdminToken(deps.AdminToken, http.HandlerFunc(admin.Hosts)))
+		mux.Handle("/admin/hosts/{name}/drain", handler.RequireAdminToken(deps.AdminToken, http.HandlerFunc(admin.DrainHost)))
+		mux.Handle("/admin/hosts/{name}/undrain", handler.RequireAdminToken(deps.AdminToken, http.HandlerFunc(admin.UndrainHost)))
+	}
+	return logging.Middleware(telemetry.Middleware(metrics.Middleware(mux)))
 }
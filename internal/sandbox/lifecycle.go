@@ -0,0 +1,312 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/metrics"
+	"github.com/Aadithya-J/alcaIDE/internal/telemetry"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// abortGracePeriod is how long Drain waits for aborted executions to hand
+// their containers back.
+const abortGracePeriod = 5 * time.Second
+
+// Pools is the part of a backend that a Lifecycle hands containers out of.
+type Pools interface {
+	// IdleContainers returns the channel language's idle containers wait
+	// on, or false if the language has no pool. A pool that is replaced
+	// has its channel closed.
+	IdleContainers(language string) (<-chan *model.ContainerInfo, bool)
+	// TakeContainer reports whether c, received from IdleContainers, may
+	// be handed out. A container that is refused is dropped.
+	TakeContainer(c *model.ContainerInfo) bool
+	// PutContainer hands c back to language's pool, or retires it if the
+	// pool no longer wants it.
+	PutContainer(ctx context.Context, c *model.ContainerInfo, language string)
+	// BusyContainers counts the containers not sitting idle in a pool.
+	BusyContainers() int
+}
+
+// Lifecycle hands out a backend's pooled containers: it queues callers
+// fairly once a pool is exhausted, counts the containers in use, and
+// drains them on shutdown. Backends embed it.
+type Lifecycle struct {
+	pools Pools
+	// backend names the backend in spans, such as "docker".
+	backend        string
+	maxPerUser     int
+	maxPerLanguage int
+
+	// queues order acquisitions per language once its pool is exhausted.
+	queues     map[string]*Queue
+	queuesLock sync.Mutex
+
+	// drainLock orders BeginDrain against new acquisitions so inFlight is
+	// never incremented once Drain may be waiting on it.
+	drainLock sync.Mutex
+	draining  chan struct{}
+	inFlight  sync.WaitGroup
+	// abortCtx is cancelled with ErrShuttingDown when the drain deadline
+	// passes; executions derive their context from it via AbortOnShutdown.
+	abortCtx context.Context
+	abort    context.CancelCauseFunc
+}
+
+// NewLifecycle hands out the containers of pools. maxPerUser and
+// maxPerLanguage bound the executions waiting for a container; 0 means no
+// limit.
+func NewLifecycle(backend string, pools Pools, maxPerUser, maxPerLanguage int) *Lifecycle {
+	abortCtx, abort := context.WithCancelCause(context.Background())
+	return &Lifecycle{
+		pools:          pools,
+		backend:        backend,
+		maxPerUser:     maxPerUser,
+		maxPerLanguage: maxPerLanguage,
+		queues:         make(map[string]*Queue),
+		draining:       make(chan struct{}),
+		abortCtx:       abortCtx,
+		abort:          abort,
+	}
+}
+
+// AcquireContainer takes an idle container of language, waiting for one if
+// the pool is exhausted. Waiting callers are served in priority order and
+// round-robin across users within a priority; if opts.User or the language
+// already has as many waiting as the queue limits allow, ErrQueueFull is
+// returned at once.
+func (l *Lifecycle) AcquireContainer(ctx context.Context, language string, opts AcquireOptions) (*model.ContainerInfo, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, l.backend+".AcquireContainer")
+	defer span.End()
+	span.SetAttributes(attribute.String("language", language), attribute.String("priority", opts.Priority.String()))
+
+	if l.Draining() {
+		span.SetStatus(codes.Error, "draining")
+		return nil, ErrShuttingDown
+	}
+
+	logger := slog.With("language", language, "priority", opts.Priority.String())
+	logger.DebugContext(ctx, "Attempting to acquire container")
+	start := time.Now()
+	fail := func(outcome string, err error) (*model.ContainerInfo, error) {
+		metrics.AcquireDuration.WithLabelValues(language, outcome).Observe(time.Since(start).Seconds())
+		span.SetStatus(codes.Error, outcome)
+		return nil, err
+	}
+
+	q := l.queueFor(language)
+	w, position, err := q.Enter(opts.User, opts.Priority, l.maxPerUser, l.maxPerLanguage)
+	if err != nil {
+		metrics.QueueRejections.WithLabelValues(language).Inc()
+		logger.WarnContext(ctx, "Execution rejected: queue full")
+		return fail("rejected", err)
+	}
+
+	waiters := metrics.PoolWaiters.WithLabelValues(language)
+	waiters.Inc()
+	defer waiters.Dec()
+
+	if w != nil {
+		span.SetAttributes(attribute.Int("queue.position", position))
+		logger.InfoContext(ctx, "Pool exhausted, queued for a container", "position", position)
+		if opts.OnQueued != nil {
+			opts.OnQueued(position)
+		}
+		select {
+		case <-w.Turn():
+		case <-ctx.Done():
+			q.Cancel(w)
+			logger.WarnContext(ctx, "Context cancelled while queued for container", "error", ctx.Err())
+			return fail(ctxOutcome(ctx), fmt.Errorf("failed to acquire %s container: %w", language, ctx.Err()))
+		case <-l.draining:
+			q.Cancel(w)
+			return fail("draining", ErrShuttingDown)
+		}
+	}
+	// Only the holder of the turn receives from the pool, so containers go
+	// to waiters in queue order.
+	defer q.Leave()
+
+	for {
+		idle, ok := l.pools.IdleContainers(language)
+		if !ok {
+			span.SetStatus(codes.Error, "no pool for language")
+			return nil, fmt.Errorf("no container pool available for language: %s", language)
+		}
+
+		select {
+		case c, ok := <-idle:
+			if !ok {
+				// Reload replaced the pool while we waited; wait on its
+				// successor instead.
+				continue
+			}
+			if !l.pools.TakeContainer(c) {
+				continue
+			}
+			if !l.track() {
+				// Draining began while we waited; put the container back
+				// for cleanup and turn the caller away.
+				l.pools.PutContainer(ctx, c, language)
+				return fail("draining", ErrShuttingDown)
+			}
+			metrics.AcquireDuration.WithLabelValues(language, "acquired").Observe(time.Since(start).Seconds())
+			metrics.PoolIdle.WithLabelValues(language).Set(float64(len(idle)))
+			span.SetAttributes(attribute.String("container.id", c.ID))
+			logger.InfoContext(ctx, "Container acquired", "container_id", c.ID, "wait", time.Since(start))
+			return c, nil
+		case <-ctx.Done():
+			logger.WarnContext(ctx, "Context cancelled while waiting for container", "error", ctx.Err())
+			return fail(ctxOutcome(ctx), fmt.Errorf("failed to acquire %s container: %w", language, ctx.Err()))
+		case <-l.draining:
+			return fail("draining", ErrShuttingDown)
+		}
+	}
+}
+
+// ctxOutcome names why ctx ended, for the acquire duration metric.
+func ctxOutcome(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "cancelled"
+}
+
+// queueFor returns the queue of a language, creating it on first use.
+func (l *Lifecycle) queueFor(language string) *Queue {
+	l.queuesLock.Lock()
+	defer l.queuesLock.Unlock()
+	q, ok := l.queues[language]
+	if !ok {
+		q = NewQueue()
+		l.queues[language] = q
+	}
+	return q
+}
+
+// Queued lists the executions user has waiting for a container, by
+// language and position.
+func (l *Lifecycle) Queued(user string) []QueuedExecution {
+	l.queuesLock.Lock()
+	queues := make(map[string]*Queue, len(l.queues))
+	for language, q := range l.queues {
+		queues[language] = q
+	}
+	l.queuesLock.Unlock()
+
+	queued := []QueuedExecution{}
+	for language, q := range queues {
+		for _, e := range q.Waiting(user) {
+			e.Language = language
+			queued = append(queued, e)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		if queued[i].Language != queued[j].Language {
+			return queued[i].Language < queued[j].Language
+		}
+		return queued[i].Position < queued[j].Position
+	})
+	return queued
+}
+
+// track counts an acquired container as in flight unless draining has
+// begun.
+func (l *Lifecycle) track() bool {
+	l.drainLock.Lock()
+	defer l.drainLock.Unlock()
+	select {
+	case <-l.draining:
+		return false
+	default:
+		l.inFlight.Add(1)
+		return true
+	}
+}
+
+// ReleaseContainer hands back a container obtained from AcquireContainer.
+func (l *Lifecycle) ReleaseContainer(ctx context.Context, c *model.ContainerInfo, language string) {
+	if c == nil {
+		slog.WarnContext(ctx, "Attempted to release a nil container")
+		return
+	}
+	defer l.inFlight.Done()
+	l.pools.PutContainer(ctx, c, language)
+}
+
+// BeginDrain stops handing out containers. Callers waiting in
+// AcquireContainer, and all later ones, get ErrShuttingDown. It is safe to
+// call more than once.
+func (l *Lifecycle) BeginDrain() {
+	l.drainLock.Lock()
+	defer l.drainLock.Unlock()
+	select {
+	case <-l.draining:
+	default:
+		close(l.draining)
+		slog.Info("Draining: no new executions will be started")
+	}
+}
+
+// Draining reports whether BeginDrain has been called.
+func (l *Lifecycle) Draining() bool {
+	select {
+	case <-l.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drain begins draining and waits for running executions to release their
+// containers. If ctx expires first, the remaining executions are aborted
+// with ErrShuttingDown and given a short grace period to return; the
+// number that were aborted is returned.
+func (l *Lifecycle) Drain(ctx context.Context) (aborted int, err error) {
+	l.BeginDrain()
+
+	done := make(chan struct{})
+	go func() {
+		l.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("All executions finished")
+		return 0, nil
+	case <-ctx.Done():
+	}
+
+	aborted = l.pools.BusyContainers()
+	slog.Warn("Drain deadline passed, aborting running executions", "running", aborted)
+	l.abort(ErrShuttingDown)
+
+	select {
+	case <-done:
+		return aborted, nil
+	case <-time.After(abortGracePeriod):
+		return aborted, fmt.Errorf("%d aborted executions did not release their containers", l.pools.BusyContainers())
+	}
+}
+
+// AbortOnShutdown returns a context that is additionally cancelled, with
+// cause ErrShuttingDown, if Drain gives up waiting for executions.
+func (l *Lifecycle) AbortOnShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(l.abortCtx, func() {
+		cancel(context.Cause(l.abortCtx))
+	})
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
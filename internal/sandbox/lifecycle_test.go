@@ -0,0 +1,234 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+)
+
+// fakePools is a single "python" pool of the containers it was given.
+type fakePools struct {
+	ch    chan *model.ContainerInfo
+	total int
+}
+
+func newFakePools(n int) *fakePools {
+	p := &fakePools{ch: make(chan *model.ContainerInfo, n), total: n}
+	for i := 0; i < n; i++ {
+		p.ch <- &model.ContainerInfo{ID: string(rune('a' + i)), Language: "python"}
+	}
+	return p
+}
+
+func (p *fakePools) IdleContainers(language string) (<-chan *model.ContainerInfo, bool) {
+	return p.ch, language == "python"
+}
+
+func (p *fakePools) TakeContainer(*model.ContainerInfo) bool { return true }
+
+func (p *fakePools) PutContainer(_ context.Context, c *model.ContainerInfo, _ string) {
+	p.ch <- c
+}
+
+func (p *fakePools) BusyContainers() int { return p.total - len(p.ch) }
+
+type acquired struct {
+	c   *model.ContainerInfo
+	err error
+}
+
+// acquire calls AcquireContainer in the background. queued, if not nil,
+// is closed once the caller is waiting in the queue.
+func acquire(ctx context.Context, l *Lifecycle, user string, queued chan struct{}) <-chan acquired {
+	result := make(chan acquired, 1)
+	opts := AcquireOptions{User: user, Priority: PriorityInteractive}
+	if queued != nil {
+		opts.OnQueued = func(int) { close(queued) }
+	}
+	go func() {
+		c, err := l.AcquireContainer(ctx, "python", opts)
+		result <- acquired{c, err}
+	}()
+	return result
+}
+
+// await waits for a signal or fails the test.
+func await[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	var zero T
+	return zero
+}
+
+// holdTurn starts an acquisition of an empty pool and waits until it holds
+// the queue's turn.
+func holdTurn(t *testing.T, ctx context.Context, l *Lifecycle) <-chan acquired {
+	t.Helper()
+	result := acquire(ctx, l, "holder", nil)
+	q := l.queueFor("python")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mu.Lock()
+		held := q.held
+		q.mu.Unlock()
+		if held {
+			return result
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("acquisition never took the turn")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAcquireExpiredTurnHolderPassesTurnOn(t *testing.T) {
+	ctx := context.Background()
+	pools := newFakePools(1)
+	l := NewLifecycle("test", pools, 0, 0)
+	busy, err := l.AcquireContainer(ctx, "python", AcquireOptions{User: "owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	holderCtx, expire := context.WithCancel(ctx)
+	holder := holdTurn(t, holderCtx, l)
+	aliceQueued, bobQueued := make(chan struct{}), make(chan struct{})
+	alice := acquire(ctx, l, "alice", aliceQueued)
+	await(t, aliceQueued, "alice to queue")
+	bob := acquire(ctx, l, "bob", bobQueued)
+	await(t, bobQueued, "bob to queue")
+
+	// The holder gives up while the pool is empty; alice takes the turn
+	// and the container that comes back.
+	expire()
+	if r := await(t, holder, "the holder to give up"); !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("holder: got %v, want context.Canceled", r.err)
+	}
+	l.ReleaseContainer(ctx, busy, "python")
+	r := await(t, alice, "alice's container")
+	if r.err != nil || r.c != busy {
+		t.Fatalf("alice got %v, %v; want the released container", r.c, r.err)
+	}
+
+	// bob is served next, with the same container.
+	l.ReleaseContainer(ctx, r.c, "python")
+	if r := await(t, bob, "bob's container"); r.err != nil || r.c != busy {
+		t.Fatalf("bob got %v, %v; want the released container", r.c, r.err)
+	}
+	if queued := l.Queued("bob"); len(queued) != 0 {
+		t.Errorf("bob still queued after being served: %v", queued)
+	}
+}
+
+func TestBeginDrainTurnsAcquisitionsAway(t *testing.T) {
+	ctx := context.Background()
+	pools := newFakePools(1)
+	l := NewLifecycle("test", pools, 0, 0)
+	busy, err := l.AcquireContainer(ctx, "python", AcquireOptions{User: "owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := holdTurn(t, ctx, l)
+	queued := make(chan struct{})
+	waiter := acquire(ctx, l, "alice", queued)
+	await(t, queued, "alice to queue")
+
+	l.BeginDrain()
+	if !l.Draining() {
+		t.Error("Draining() = false after BeginDrain")
+	}
+	for name, result := range map[string]<-chan acquired{"holder of the turn": holder, "queued waiter": waiter} {
+		if r := await(t, result, name); !errors.Is(r.err, ErrShuttingDown) {
+			t.Errorf("%s: got %v, want ErrShuttingDown", name, r.err)
+		}
+	}
+	if _, err := l.AcquireContainer(ctx, "python", AcquireOptions{User: "bob"}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("acquire after BeginDrain: got %v, want ErrShuttingDown", err)
+	}
+
+	// A running execution still hands its container back.
+	l.ReleaseContainer(ctx, busy, "python")
+	if len(pools.ch) != 1 {
+		t.Error("container released during the drain was not returned to its pool")
+	}
+	// Calling it again is harmless.
+	l.BeginDrain()
+}
+
+func TestDrainWaitsForRunningExecutions(t *testing.T) {
+	ctx := context.Background()
+	l := NewLifecycle("test", newFakePools(2), 0, 0)
+	c, err := l.AcquireContainer(ctx, "python", AcquireOptions{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type drained struct {
+		aborted int
+		err     error
+	}
+	done := make(chan drained, 1)
+	go func() {
+		aborted, err := l.Drain(ctx)
+		done <- drained{aborted, err}
+	}()
+	select {
+	case r := <-done:
+		t.Fatalf("Drain returned %+v with an execution still running", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.ReleaseContainer(ctx, c, "python")
+	if r := await(t, done, "Drain to return"); r.aborted != 0 || r.err != nil {
+		t.Errorf("Drain = %d, %v; want 0, nil", r.aborted, r.err)
+	}
+}
+
+func TestDrainAbortsExecutionsAtDeadline(t *testing.T) {
+	ctx := context.Background()
+	l := NewLifecycle("test", newFakePools(2), 0, 0)
+
+	// Each execution runs until its context is cancelled, then records why
+	// and releases its container.
+	causes := make(chan error, 2)
+	for _, user := range []string{"alice", "bob"} {
+		c, err := l.AcquireContainer(ctx, "python", AcquireOptions{User: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runCtx, cancel := l.AbortOnShutdown(ctx)
+		go func() {
+			defer cancel()
+			<-runCtx.Done()
+			causes <- context.Cause(runCtx)
+			l.ReleaseContainer(ctx, c, "python")
+		}()
+	}
+
+	// An execution that finished before the drain is not aborted.
+	finishedCtx, finish := l.AbortOnShutdown(ctx)
+	finish()
+
+	deadline, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	aborted, err := l.Drain(deadline)
+	if aborted != 2 || err != nil {
+		t.Errorf("Drain = %d, %v; want 2, nil", aborted, err)
+	}
+	for i := 0; i < 2; i++ {
+		if cause := await(t, causes, "an aborted execution"); !errors.Is(cause, ErrShuttingDown) {
+			t.Errorf("aborted execution's cause = %v, want ErrShuttingDown", cause)
+		}
+	}
+	if cause := context.Cause(finishedCtx); errors.Is(cause, ErrShuttingDown) {
+		t.Errorf("finished execution's cause = %v, want context.Canceled", cause)
+	}
+}
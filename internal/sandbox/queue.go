@@ -0,0 +1,272 @@
+package sandbox
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by AcquireContainer when no container is free and
+// the caller, or the language as a whole, already has as many executions
+// waiting as allowed.
+var ErrQueueFull = errors.New("too many executions waiting for a container")
+
+// Priority is the class an execution waits in when a pool is exhausted.
+// Higher classes are always served first; within a class callers take
+// turns, so one caller with many waiting executions cannot starve others.
+type Priority int
+
+// Priority classes, lowest first: interactive executions go ahead of batch
+// ones, and premium callers ahead of others of the same kind.
+const (
+	PriorityBatch Priority = iota
+	PriorityPremiumBatch
+	PriorityInteractive
+	PriorityPremiumInteractive
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBatch:
+		return "batch"
+	case PriorityPremiumBatch:
+		return "premium-batch"
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityPremiumInteractive:
+		return "premium-interactive"
+	default:
+		return "unknown"
+	}
+}
+
+// AcquireOptions describe who is asking for a container.
+type AcquireOptions struct {
+	// User identifies the caller for fairness and per-caller limits.
+	User     string
+	Priority Priority
+	// OnQueued is called with the caller's position, starting at 1, if no
+	// container is free and it has to wait.
+	OnQueued func(position int)
+}
+
+// QueuedExecution describes an execution waiting for a container.
+type QueuedExecution struct {
+	Language  string `json:"language"`
+	Priority  string `json:"priority"`
+	Position  int    `json:"position"`
+	WaitingMS int64  `json:"waiting_ms"`
+}
+
+// Waiter is one execution queued for a turn.
+type Waiter struct {
+	user     string
+	priority Priority
+	since    time.Time
+	// turn is closed when the waiter may take the next container.
+	turn chan struct{}
+}
+
+// Turn is closed when the waiter may take the next container.
+func (w *Waiter) Turn() <-chan struct{} {
+	return w.turn
+}
+
+// class holds the waiters of one priority. users is the round-robin order:
+// the front user is served next and, if it still has waiters, moves to the
+// back.
+type class struct {
+	users   []string
+	waiting map[string][]*Waiter
+}
+
+// Queue orders the executions waiting for one language's pool. At most one
+// of them, the holder of the turn, receives from the pool channel at a time,
+// so containers are handed out in queue order rather than to whichever
+// receiver the runtime picks.
+type Queue struct {
+	mu      sync.Mutex
+	held    bool
+	classes map[Priority]*class
+	perUser map[string]int
+	size    int
+}
+
+func NewQueue() *Queue {
+	return &Queue{classes: make(map[Priority]*class), perUser: make(map[string]int)}
+}
+
+// Enter takes the turn if nobody holds it or waits for it, and otherwise
+// queues a waiter, which must wait for its Turn, and returns its position.
+// It returns ErrQueueFull if the waiter would exceed a positive limit. The
+// holder of the turn must call Leave once it has a container or gives up.
+func (q *Queue) Enter(user string, priority Priority, maxPerUser, maxTotal int) (*Waiter, int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.held && q.size == 0 {
+		q.held = true
+		return nil, 0, nil
+	}
+	if (maxPerUser > 0 && q.perUser[user] >= maxPerUser) || (maxTotal > 0 && q.size >= maxTotal) {
+		return nil, 0, ErrQueueFull
+	}
+
+	w := &Waiter{user: user, priority: priority, since: time.Now(), turn: make(chan struct{})}
+	c, ok := q.classes[priority]
+	if !ok {
+		c = &class{waiting: make(map[string][]*Waiter)}
+		q.classes[priority] = c
+	}
+	if len(c.waiting[user]) == 0 {
+		c.users = append(c.users, user)
+	}
+	c.waiting[user] = append(c.waiting[user], w)
+	q.perUser[user]++
+	q.size++
+	return w, q.position(w), nil
+}
+
+// Leave gives up the turn, handing it to the next waiter if there is one.
+func (q *Queue) Leave() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	next := q.pop()
+	if next == nil {
+		q.held = false
+		return
+	}
+	close(next.turn)
+}
+
+// Cancel removes a waiter that stopped waiting. If it was granted the turn
+// in the meantime it passes the turn on.
+func (q *Queue) Cancel(w *Waiter) {
+	q.mu.Lock()
+	select {
+	case <-w.turn:
+		q.mu.Unlock()
+		q.Leave()
+		return
+	default:
+	}
+	defer q.mu.Unlock()
+
+	c := q.classes[w.priority]
+	waiting := c.waiting[w.user]
+	for i, other := range waiting {
+		if other == w {
+			waiting = append(waiting[:i:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(waiting) == 0 {
+		delete(c.waiting, w.user)
+		c.users = removeUser(c.users, w.user)
+	} else {
+		c.waiting[w.user] = waiting
+	}
+	q.forget(w)
+}
+
+// pop removes and returns the waiter to serve next: the front user of the
+// highest non-empty class.
+func (q *Queue) pop() *Waiter {
+	for _, priority := range q.priorities() {
+		c := q.classes[priority]
+		if len(c.users) == 0 {
+			continue
+		}
+		user := c.users[0]
+		w := c.waiting[user][0]
+		c.waiting[user] = c.waiting[user][1:]
+		c.users = c.users[1:]
+		if len(c.waiting[user]) > 0 {
+			c.users = append(c.users, user)
+		} else {
+			delete(c.waiting, user)
+		}
+		q.forget(w)
+		return w
+	}
+	return nil
+}
+
+func (q *Queue) forget(w *Waiter) {
+	q.size--
+	if q.perUser[w.user]--; q.perUser[w.user] == 0 {
+		delete(q.perUser, w.user)
+	}
+}
+
+// order lists the waiters in the order they will be served if nobody else
+// joins or leaves.
+func (q *Queue) order() []*Waiter {
+	order := make([]*Waiter, 0, q.size)
+	for _, priority := range q.priorities() {
+		c := q.classes[priority]
+		for round := 0; ; round++ {
+			served := false
+			for _, user := range c.users {
+				if round < len(c.waiting[user]) {
+					order = append(order, c.waiting[user][round])
+					served = true
+				}
+			}
+			if !served {
+				break
+			}
+		}
+	}
+	return order
+}
+
+// position is w's place in order, starting at 1.
+func (q *Queue) position(w *Waiter) int {
+	for i, other := range q.order() {
+		if other == w {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// priorities returns the classes in use, highest first.
+func (q *Queue) priorities() []Priority {
+	priorities := make([]Priority, 0, len(q.classes))
+	for priority := range q.classes {
+		priorities = append(priorities, priority)
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+	return priorities
+}
+
+// Waiting lists user's queued executions. Language is left empty.
+func (q *Queue) Waiting(user string) []QueuedExecution {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var queued []QueuedExecution
+	for i, w := range q.order() {
+		if w.user == user {
+			queued = append(queued, QueuedExecution{
+				Priority:  w.priority.String(),
+				Position:  i + 1,
+				WaitingMS: time.Since(w.since).Milliseconds(),
+			})
+		}
+	}
+	return queued
+}
+
+func removeUser(users []string, user string) []string {
+	for i, u := range users {
+		if u == user {
+			return append(users[:i:i], users[i+1:]...)
+		}
+	}
+	return users
+}
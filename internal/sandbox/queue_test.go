@@ -0,0 +1,203 @@
+package sandbox
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// enter queues a waiter behind the current holder of the turn.
+func enter(t *testing.T, q *Queue, user string, priority Priority) (*Waiter, int) {
+	t.Helper()
+	w, position, err := q.Enter(user, priority, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == nil {
+		t.Fatalf("%s took the turn, want it queued", user)
+	}
+	return w, position
+}
+
+// granted reports whether w has been given the turn.
+func granted(w *Waiter) bool {
+	select {
+	case <-w.Turn():
+		return true
+	default:
+		return false
+	}
+}
+
+// serve passes the turn on once per waiter and returns the names of the
+// waiters in the order they were granted it.
+func serve(t *testing.T, q *Queue, names map[*Waiter]string) []string {
+	t.Helper()
+	var order []string
+	for range names {
+		q.Leave()
+		var next []string
+		for w, name := range names {
+			if granted(w) && !slices.Contains(order, name) {
+				next = append(next, name)
+			}
+		}
+		if len(next) != 1 {
+			t.Fatalf("after %v, Leave granted the turn to %v, want one waiter", order, next)
+		}
+		order = append(order, next[0])
+	}
+	return order
+}
+
+func TestQueueTakesFreeTurn(t *testing.T) {
+	q := NewQueue()
+	w, position, err := q.Enter("alice", PriorityBatch, 1, 1)
+	if w != nil || position != 0 || err != nil {
+		t.Fatalf("Enter on an idle queue = %v, %d, %v; want the turn", w, position, err)
+	}
+	q.Leave()
+	if w, _, _ := q.Enter("alice", PriorityBatch, 1, 1); w != nil {
+		t.Error("turn still held after Leave with nobody waiting")
+	}
+}
+
+func TestQueueRoundRobinWithinPriority(t *testing.T) {
+	q := NewQueue()
+	q.Enter("holder", PriorityInteractive, 0, 0)
+
+	names := make(map[*Waiter]string)
+	positions := make(map[string]int)
+	for _, name := range []string{"alice-1", "alice-2", "alice-3", "bob-1", "bob-2", "carol-1"} {
+		w, position := enter(t, q, name[:len(name)-2], PriorityInteractive)
+		names[w] = name
+		positions[name] = position
+	}
+
+	// Each position is where the waiter stood when it joined: bob-1 goes
+	// straight behind alice's first execution, not behind all of hers.
+	for name, want := range map[string]int{"alice-1": 1, "alice-2": 2, "alice-3": 3, "bob-1": 2, "bob-2": 4, "carol-1": 3} {
+		if positions[name] != want {
+			t.Errorf("%s entered at position %d, want %d", name, positions[name], want)
+		}
+	}
+
+	want := []string{"alice-1", "bob-1", "carol-1", "alice-2", "bob-2", "alice-3"}
+	if got := serve(t, q, names); !slices.Equal(got, want) {
+		t.Errorf("served %v, want %v", got, want)
+	}
+}
+
+func TestQueueServesHigherPriorityFirst(t *testing.T) {
+	q := NewQueue()
+	q.Enter("holder", PriorityBatch, 0, 0)
+
+	names := make(map[*Waiter]string)
+	for _, tc := range []struct {
+		name     string
+		priority Priority
+	}{
+		{"batch", PriorityBatch},
+		{"premium-batch", PriorityPremiumBatch},
+		{"interactive", PriorityInteractive},
+		{"premium-interactive", PriorityPremiumInteractive},
+	} {
+		// Every waiter outranks the ones before it, so each goes first.
+		w, position := enter(t, q, tc.name, tc.priority)
+		if position != 1 {
+			t.Errorf("%s entered at position %d, want 1", tc.name, position)
+		}
+		names[w] = tc.name
+	}
+
+	want := []string{"premium-interactive", "interactive", "premium-batch", "batch"}
+	if got := serve(t, q, names); !slices.Equal(got, want) {
+		t.Errorf("served %v, want %v", got, want)
+	}
+}
+
+func TestQueueWaitingReportsPositions(t *testing.T) {
+	q := NewQueue()
+	q.Enter("holder", PriorityBatch, 0, 0)
+	enter(t, q, "alice", PriorityBatch)
+	enter(t, q, "bob", PriorityBatch)
+	enter(t, q, "alice", PriorityBatch)
+	enter(t, q, "bob", PriorityInteractive)
+
+	waiting := q.Waiting("alice")
+	if len(waiting) != 2 {
+		t.Fatalf("alice has %d executions waiting, want 2", len(waiting))
+	}
+	// bob's interactive execution goes first, then the batch class takes
+	// turns: alice, bob, alice.
+	for i, want := range []int{2, 4} {
+		if waiting[i].Position != want || waiting[i].Priority != "batch" {
+			t.Errorf("alice's execution %d = %+v, want batch at position %d", i, waiting[i], want)
+		}
+	}
+	if waiting := q.Waiting("carol"); len(waiting) != 0 {
+		t.Errorf("carol has %v waiting, want none", waiting)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	q := NewQueue()
+	// The holder of the turn is not waiting, so it counts towards neither
+	// limit.
+	if w, _, err := q.Enter("alice", PriorityBatch, 2, 3); w != nil || err != nil {
+		t.Fatalf("first Enter = %v, %v; want the turn", w, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := q.Enter("alice", PriorityBatch, 2, 3); err != nil {
+			t.Fatalf("alice's waiter %d: %v", i+1, err)
+		}
+	}
+	if _, _, err := q.Enter("alice", PriorityPremiumInteractive, 2, 3); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("alice's third waiter: got %v, want ErrQueueFull", err)
+	}
+	if _, _, err := q.Enter("bob", PriorityBatch, 2, 3); err != nil {
+		t.Fatalf("bob's waiter: %v", err)
+	}
+	if _, _, err := q.Enter("carol", PriorityBatch, 2, 3); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("waiter over the language limit: got %v, want ErrQueueFull", err)
+	}
+
+	// A served waiter frees its place.
+	q.Leave()
+	if _, _, err := q.Enter("alice", PriorityBatch, 2, 3); err != nil {
+		t.Errorf("alice's waiter after one was served: %v", err)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	q := NewQueue()
+	q.Enter("holder", PriorityBatch, 0, 0)
+	alice, _ := enter(t, q, "alice", PriorityBatch)
+	bob, _ := enter(t, q, "bob", PriorityBatch)
+	carol, _ := enter(t, q, "carol", PriorityBatch)
+
+	// A waiter that gives up before its turn leaves the queue.
+	q.Cancel(alice)
+	if waiting := q.Waiting("bob"); len(waiting) != 1 || waiting[0].Position != 1 {
+		t.Errorf("bob's place after alice cancelled = %v, want position 1", waiting)
+	}
+	if waiting := q.Waiting("alice"); len(waiting) != 0 {
+		t.Errorf("alice still waiting after cancelling: %v", waiting)
+	}
+
+	// One granted the turn as it gave up, such as when its context expired
+	// at the same moment, passes the turn on rather than keeping it.
+	q.Leave()
+	if !granted(bob) || granted(carol) {
+		t.Fatal("Leave did not grant bob the turn")
+	}
+	q.Cancel(bob)
+	if !granted(carol) {
+		t.Fatal("bob cancelled after being granted the turn, but carol was not granted it")
+	}
+
+	q.Leave()
+	if w, _, _ := q.Enter("dave", PriorityBatch, 0, 0); w != nil {
+		t.Error("turn still held after every waiter was served or cancelled")
+	}
+}
@@ -0,0 +1,90 @@
+// Package sandbox holds what the sandbox backends have in common: the
+// acquisition options and fair queue, dependency descriptions, and the
+// status and reload reports the handlers serve. docker.DockerManager and
+// kube.PodManager both build on it, so neither depends on the other.
+package sandbox
+
+import "errors"
+
+var (
+	// ErrShuttingDown is returned by AcquireContainer once draining has
+	// begun, and is the cancellation cause of executions aborted by Drain.
+	ErrShuttingDown = errors.New("server is shutting down")
+	// ErrUnknownHost is returned by DrainHost and UndrainHost for a host
+	// the backend cannot drain.
+	ErrUnknownHost = errors.New("unknown sandbox host")
+	// ErrNoInstaller is returned by InstallDependencies for a language
+	// without a dependency installer.
+	ErrNoInstaller = errors.New("language does not support dependencies")
+)
+
+// BlockedSyscallExitCode is the exit status of a process killed by SIGSYS,
+// which is how a seccomp rule with SCMP_ACT_KILL_PROCESS or SCMP_ACT_KILL
+// stops it. Docker's default profile fails blocked calls with EPERM instead,
+// so only profiles using a kill action make blocked syscalls detectable.
+const BlockedSyscallExitCode = 128 + 31
+
+// Dependencies are the packages a run declares.
+type Dependencies struct {
+	// Packages are installer-specific specifiers, such as "requests==2.32.3"
+	// for pip or "lodash@4" for npm.
+	Packages []string
+	// PackageJSON is a package.json to install from; npm only.
+	PackageJSON []byte
+}
+
+func (d Dependencies) Empty() bool {
+	return len(d.Packages) == 0 && len(d.PackageJSON) == 0
+}
+
+// Installation is a set of dependencies installed for one run.
+type Installation struct {
+	// Output is what the installer printed.
+	Output string
+	// Env makes the installed packages visible to the run.
+	Env []string
+	// Dir is where the backend installed the packages, for removing them;
+	// empty if nothing was installed.
+	Dir string
+}
+
+// ReloadResult reports what Reload did to each language.
+type ReloadResult struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Updated   []string `json:"updated"`
+	Resized   []string `json:"resized"`
+	Unchanged []string `json:"unchanged"`
+	// Failed maps a language to why its change was not applied. The
+	// language keeps its previous configuration, if it had one.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// PoolStatus describes one language's pool.
+type PoolStatus struct {
+	Language string `json:"language"`
+	Image    string `json:"image"`
+	Runtime  string `json:"runtime"`
+	// Security identifies the pool's seccomp and AppArmor profiles; it
+	// changes whenever they do.
+	Security string `json:"security,omitempty"`
+	Size     int    `json:"size"`
+	Idle     int    `json:"idle"`
+	// Containers counts every container of the language, including busy
+	// ones and ones left on a previous image that will be retired once
+	// they are released.
+	Containers int `json:"containers"`
+}
+
+// HostStatus describes one host sandboxes run on.
+type HostStatus struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Healthy  bool   `json:"healthy"`
+	Draining bool   `json:"draining"`
+	// LastError is why the most recent health check failed, if it did.
+	LastError     string         `json:"last_error,omitempty"`
+	Containers    int            `json:"containers"`
+	MaxContainers int            `json:"max_containers,omitempty"`
+	Languages     map[string]int `json:"languages"`
+}
@@ -0,0 +1,335 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+// MemoryUserStore keeps users in process memory. It is meant for tests and
+// local experiments; nothing survives a restart.
+type MemoryUserStore struct {
+	mu         sync.RWMutex
+	users      map[uuid.UUID]model.User
+	identities map[[2]string]uuid.UUID
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:      make(map[uuid.UUID]model.User),
+		identities: make(map[[2]string]uuid.UUID),
+	}
+}
+
+func (s *MemoryUserStore) CreateUser(_ context.Context, user model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		switch {
+		case u.Username == user.Username:
+			return &ConflictError{Field: "username"}
+		case u.Email == user.Email:
+			return &ConflictError{Field: "email"}
+		case u.ID == user.ID:
+			return &ConflictError{Field: "id"}
+		}
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) find(match func(model.User) bool) (model.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if match(u) {
+			return u, true
+		}
+	}
+	return model.User{}, false
+}
+
+func (s *MemoryUserStore) GetUserByID(_ context.Context, id uuid.UUID) (model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return model.User{}, fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) GetUserByUsername(_ context.Context, username string) (model.User, error) {
+	user, ok := s.find(func(u model.User) bool { return u.Username == username })
+	if !ok {
+		return model.User{}, fmt.Errorf("user %q: %w", username, ErrNotFound)
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) GetUserByEmail(_ context.Context, email string) (model.User, error) {
+	user, ok := s.find(func(u model.User) bool { return u.Email == email })
+	if !ok {
+		return model.User{}, fmt.Errorf("user by email: %w", ErrNotFound)
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) update(id uuid.UUID, fn func(*model.User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	fn(&user)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryUserStore) SetEmailVerified(_ context.Context, id uuid.UUID) error {
+	return s.update(id, func(u *model.User) { u.EmailVerified = true })
+}
+
+func (s *MemoryUserStore) UpdatePassword(_ context.Context, id uuid.UUID, passwordHash string) error {
+	return s.update(id, func(u *model.User) {
+		u.Password = passwordHash
+		u.PasswordChangedAt = time.Now()
+	})
+}
+
+func (s *MemoryUserStore) GetUserByIdentity(_ context.Context, issuer, subject string) (model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[s.identities[[2]string{issuer, subject}]]
+	if !ok {
+		return model.User{}, fmt.Errorf("user by identity: %w", ErrNotFound)
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) LinkIdentity(_ context.Context, identity Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{identity.Issuer, identity.Subject}
+	if _, ok := s.identities[key]; ok {
+		return &ConflictError{Field: "identity"}
+	}
+	if _, ok := s.users[identity.UserID]; !ok {
+		return fmt.Errorf("user %s: %w", identity.UserID, ErrNotFound)
+	}
+	s.identities[key] = identity.UserID
+	return nil
+}
+
+type memoryToken struct {
+	ActionToken
+	used bool
+}
+
+// MemoryTokenStore is the in-memory counterpart of PostgresTokenStore.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[uuid.UUID]*memoryToken
+	now    func() time.Time
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[uuid.UUID]*memoryToken), now: time.Now}
+}
+
+func (s *MemoryTokenStore) CreateToken(_ context.Context, token ActionToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.ID]; ok {
+		return &ConflictError{Field: "id"}
+	}
+	s.tokens[token.ID] = &memoryToken{ActionToken: token}
+	return nil
+}
+
+func (s *MemoryTokenStore) ConsumeToken(_ context.Context, id uuid.UUID, purpose string) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok || t.used || t.Purpose != purpose || !s.now().Before(t.ExpiresAt) {
+		return uuid.Nil, fmt.Errorf("token %s: %w", id, ErrNotFound)
+	}
+	t.used = true
+	return t.UserID, nil
+}
+
+type memoryAPIKey struct {
+	APIKey
+	revoked bool
+}
+
+// MemoryAPIKeyStore is the in-memory counterpart of PostgresAPIKeyStore.
+type MemoryAPIKeyStore struct {
+	mu   sync.Mutex
+	keys map[uuid.UUID]*memoryAPIKey
+	now  func() time.Time
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[uuid.UUID]*memoryAPIKey), now: time.Now}
+}
+
+func (s *MemoryAPIKeyStore) CreateAPIKey(_ context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.keys {
+		switch {
+		case k.ID == key.ID:
+			return &ConflictError{Field: "id"}
+		case bytes.Equal(k.Hash, key.Hash):
+			return &ConflictError{Field: "key"}
+		}
+	}
+	s.keys[key.ID] = &memoryAPIKey{APIKey: key}
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) ListAPIKeys(_ context.Context, userID uuid.UUID) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []APIKey
+	for _, k := range s.keys {
+		if k.UserID == userID && !k.revoked {
+			keys = append(keys, k.APIKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) RevokeAPIKey(_ context.Context, userID, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok || k.UserID != userID || k.revoked {
+		return fmt.Errorf("api key %s: %w", id, ErrNotFound)
+	}
+	k.revoked = true
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) UseAPIKey(_ context.Context, hash []byte) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, k := range s.keys {
+		if !bytes.Equal(k.Hash, hash) {
+			continue
+		}
+		if k.revoked || k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+			break
+		}
+		k.LastUsedAt = &now
+		return k.APIKey, nil
+	}
+	return APIKey{}, fmt.Errorf("api key: %w", ErrNotFound)
+}
+
+// MemoryExecutionStore is the in-memory counterpart of
+// PostgresExecutionStore.
+type MemoryExecutionStore struct {
+	mu         sync.RWMutex
+	executions []Execution
+}
+
+func NewMemoryExecutionStore() *MemoryExecutionStore {
+	return &MemoryExecutionStore{}
+}
+
+func (s *MemoryExecutionStore) CreateExecution(_ context.Context, e Execution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !e.CodeRetained {
+		e.Code, e.Stdin = "", ""
+		e.Dependencies, e.PackageJSON = nil, nil
+	}
+	s.executions = append(s.executions, e)
+	return nil
+}
+
+func (s *MemoryExecutionStore) GetExecution(_ context.Context, userID, id uuid.UUID) (Execution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.executions {
+		if e.ID == id && e.UserID == userID {
+			return e, nil
+		}
+	}
+	return Execution{}, fmt.Errorf("execution %s: %w", id, ErrNotFound)
+}
+
+func (s *MemoryExecutionStore) ListExecutions(_ context.Context, f ExecutionFilter) ([]Execution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []Execution
+	for _, e := range s.executions {
+		switch {
+		case e.UserID != f.UserID,
+			f.Language != "" && e.Language != f.Language,
+			f.Status != "" && e.Status != f.Status,
+			!f.From.IsZero() && e.CreatedAt.Before(f.From),
+			!f.To.IsZero() && !e.CreatedAt.Before(f.To),
+			f.After != nil && !executionBefore(e, *f.After):
+			continue
+		}
+		matched = append(matched, e)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return executionBefore(matched[j], ExecutionCursor{matched[i].CreatedAt, matched[i].ID})
+	})
+	if f.Limit > 0 && len(matched) > f.Limit {
+		matched = matched[:f.Limit]
+	}
+	return matched, nil
+}
+
+// executionBefore reports whether e is older than the position c, that is
+// whether it comes after c in a newest-first listing.
+func executionBefore(e Execution, c ExecutionCursor) bool {
+	if !e.CreatedAt.Equal(c.CreatedAt) {
+		return e.CreatedAt.Before(c.CreatedAt)
+	}
+	return bytes.Compare(e.ID[:], c.ID[:]) < 0
+}
+
+func (s *MemoryExecutionStore) DeleteExecutionsBefore(_ context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.executions[:0]
+	for _, e := range s.executions {
+		if !e.CreatedAt.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	removed := int64(len(s.executions) - len(kept))
+	s.executions = kept
+	return removed, nil
+}
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// constraintFields maps unique constraints to the request field they guard.
+var constraintFields = map[string]string{
+	"users_pkey":            "id",
+	"user_tokens_pkey":      "id",
+	"api_keys_pkey":         "id",
+	"users_username_key":    "username",
+	"users_email_key":       "email",
+	"user_identities_pkey":  "identity",
+	"api_keys_key_hash_key": "key",
+}
+
+// conflictFromPg turns a unique-constraint violation into a *ConflictError
+// and returns every other error unchanged.
+func conflictFromPg(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return err
+	}
+	field, ok := constraintFields[pgErr.ConstraintName]
+	if !ok {
+		field = pgErr.ConstraintName
+	}
+	return &ConflictError{Field: field}
+}
+
+type PostgresUserStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresUserStore(pool *pgxpool.Pool) *PostgresUserStore {
+	return &PostgresUserStore{pool: pool}
+}
+
+func (s *PostgresUserStore) CreateUser(ctx context.Context, user model.User) error {
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO users (id, username, email, password, email_verified_at)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5 THEN now() END)`,
+		user.ID, user.Username, user.Email, user.Password, user.EmailVerified,
+	)
+	return conflictFromPg(err)
+}
+
+const userColumns = "id, username, email, password, email_verified_at IS NOT NULL, password_changed_at"
+
+const selectUser = "SELECT " + userColumns + " FROM users"
+
+func scanUser(row pgx.Row) (model.User, error) {
+	var user model.User
+	var passwordChangedAt *time.Time
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.EmailVerified, &passwordChangedAt)
+	if passwordChangedAt != nil {
+		user.PasswordChangedAt = *passwordChangedAt
+	}
+	return user, err
+}
+
+func (s *PostgresUserStore) getUser(ctx context.Context, what string, query string, arg any) (model.User, error) {
+	user, err := scanUser(s.pool.QueryRow(ctx, query, arg))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.User{}, fmt.Errorf("user %s: %w", what, ErrNotFound)
+	}
+	return user, err
+}
+
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	return s.getUser(ctx, id.String(), selectUser+" WHERE id = $1", id)
+}
+
+func (s *PostgresUserStore) GetUserByUsername(ctx context.Context, username string) (model.User, error) {
+	return s.getUser(ctx, fmt.Sprintf("%q", username), selectUser+" WHERE username = $1", username)
+}
+
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	return s.getUser(ctx, "by email", selectUser+" WHERE email = $1", email)
+}
+
+func (s *PostgresUserStore) SetEmailVerified(ctx context.Context, id uuid.UUID) error {
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1", id)
+	if err == nil && tag.RowsAffected() == 0 {
+		return fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	return err
+}
+
+func (s *PostgresUserStore) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	// The time comes from this process rather than the database, as it is
+	// compared with when session tokens were issued.
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE users SET password = $2, password_changed_at = $3 WHERE id = $1", id, passwordHash, time.Now())
+	if err == nil && tag.RowsAffected() == 0 {
+		return fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	return err
+}
+
+func (s *PostgresUserStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (model.User, error) {
+	user, err := scanUser(s.pool.QueryRow(ctx,
+		"SELECT "+userColumns+` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)`,
+		issuer, subject,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.User{}, fmt.Errorf("user by identity: %w", ErrNotFound)
+	}
+	return user, err
+}
+
+func (s *PostgresUserStore) LinkIdentity(ctx context.Context, identity Identity) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)",
+		identity.Issuer, identity.Subject, identity.UserID,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return fmt.Errorf("user %s: %w", identity.UserID, ErrNotFound)
+	}
+	return conflictFromPg(err)
+}
+
+type PostgresTokenStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresTokenStore(pool *pgxpool.Pool) *PostgresTokenStore {
+	return &PostgresTokenStore{pool: pool}
+}
+
+func (s *PostgresTokenStore) CreateToken(ctx context.Context, token ActionToken) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO user_tokens (id, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4)",
+		token.ID, token.UserID, token.Purpose, token.ExpiresAt,
+	)
+	return conflictFromPg(err)
+}
+
+func (s *PostgresTokenStore) ConsumeToken(ctx context.Context, id uuid.UUID, purpose string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := s.pool.QueryRow(ctx,
+		`UPDATE user_tokens SET used_at = now()
+		WHERE id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`,
+		id, purpose,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("token %s: %w", id, ErrNotFound)
+	}
+	return userID, err
+}
+
+type PostgresAPIKeyStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresAPIKeyStore(pool *pgxpool.Pool) *PostgresAPIKeyStore {
+	return &PostgresAPIKeyStore{pool: pool}
+}
+
+func (s *PostgresAPIKeyStore) CreateAPIKey(ctx context.Context, key APIKey) error {
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID, key.UserID, key.Name, key.Prefix, key.Hash, key.Scopes, key.ExpiresAt, key.CreatedAt,
+	)
+	return conflictFromPg(err)
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.Scopes,
+		&key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt)
+	return key, err
+}
+
+func (s *PostgresAPIKeyStore) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresAPIKeyStore) RevokeAPIKey(ctx context.Context, userID, id uuid.UUID) error {
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, userID)
+	if err == nil && tag.RowsAffected() == 0 {
+		return fmt.Errorf("api key %s: %w", id, ErrNotFound)
+	}
+	return err
+}
+
+func (s *PostgresAPIKeyStore) UseAPIKey(ctx context.Context, hash []byte) (APIKey, error) {
+	key, err := scanAPIKey(s.pool.QueryRow(ctx,
+		`UPDATE api_keys SET last_used_at = now()
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+		RETURNING `+apiKeyColumns,
+		hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, fmt.Errorf("api key: %w", ErrNotFound)
+	}
+	return key, err
+}
+
+type PostgresExecutionStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresExecutionStore(pool *pgxpool.Pool) *PostgresExecutionStore {
+	return &PostgresExecutionStore{pool: pool}
+}
+
+func (s *PostgresExecutionStore) CreateExecution(ctx context.Context, e Execution) error {
+	var code, stdin *string
+	var dependencies []string
+	var packageJSON []byte
+	if e.CodeRetained {
+		code, stdin = &e.Code, &e.Stdin
+		dependencies, packageJSON = e.Dependencies, e.PackageJSON
+	}
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO executions (id, user_id, api_key_id, language, code, code_sha256, stdin,
+			dependencies, package_json, output, error, status, exit_code, duration_ms, image, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		e.ID, e.UserID, e.APIKeyID, e.Language, code, e.CodeSHA256, stdin,
+		dependencies, packageJSON, e.Output, e.Error, e.Status, e.ExitCode, e.Duration.Milliseconds(), e.Image, e.CreatedAt,
+	)
+	return err
+}
+
+const executionColumns = `id, user_id, api_key_id, language, COALESCE(code, ''), code IS NOT NULL, code_sha256,
+	COALESCE(stdin, ''), dependencies, package_json, output, error, status, exit_code, duration_ms, image, created_at`
+
+func scanExecution(row pgx.Row) (Execution, error) {
+	var e Execution
+	var durationMS int64
+	err := row.Scan(&e.ID, &e.UserID, &e.APIKeyID, &e.Language, &e.Code, &e.CodeRetained, &e.CodeSHA256,
+		&e.Stdin, &e.Dependencies, &e.PackageJSON, &e.Output, &e.Error, &e.Status, &e.ExitCode, &durationMS, &e.Image, &e.CreatedAt)
+	e.Duration = time.Duration(durationMS) * time.Millisecond
+	return e, err
+}
+
+func (s *PostgresExecutionStore) GetExecution(ctx context.Context, userID, id uuid.UUID) (Execution, error) {
+	e, err := scanExecution(s.pool.QueryRow(ctx,
+		"SELECT "+executionColumns+" FROM executions WHERE id = $1 AND user_id = $2", id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Execution{}, fmt.Errorf("execution %s: %w", id, ErrNotFound)
+	}
+	return e, err
+}
+
+func (s *PostgresExecutionStore) ListExecutions(ctx context.Context, f ExecutionFilter) ([]Execution, error) {
+	where := []string{"user_id = $1"}
+	args := []any{f.UserID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Language != "" {
+		add("language = $%d", f.Language)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.After != nil {
+		args = append(args, f.After.CreatedAt, f.After.ID)
+		where = append(where, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	// LIMIT NULL is no limit, as a zero Limit means.
+	var limit *int
+	if f.Limit > 0 {
+		limit = &f.Limit
+	}
+	args = append(args, limit)
+
+	rows, err := s.pool.Query(ctx,
+		"SELECT "+executionColumns+" FROM executions WHERE "+strings.Join(where, " AND ")+
+			fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args)),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var executions []Execution
+	for rows.Next() {
+		e, err := scanExecution(rows)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, e)
+	}
+	return executions, rows.Err()
+}
+
+func (s *PostgresExecutionStore) DeleteExecutionsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM executions WHERE created_at < $1", cutoff)
+	return tag.RowsAffected(), err
+}
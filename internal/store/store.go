@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+// ConflictError reports which unique field a write collided on. It matches
+// ErrConflict with errors.Is.
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, ErrConflict)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// UserStore persists user accounts. Implementations must be safe for
+// concurrent use by HTTP handlers.
+type UserStore interface {
+	CreateUser(ctx context.Context, user model.User) error
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	GetUserByUsername(ctx context.Context, username string) (model.User, error)
+	GetUserByEmail(ctx context.Context, email string) (model.User, error)
+	SetEmailVerified(ctx context.Context, id uuid.UUID) error
+	// UpdatePassword sets the password and records when it changed.
+	UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+}
+
+// Identity links a user to an account at an external identity provider.
+type Identity struct {
+	Issuer  string
+	Subject string
+	UserID  uuid.UUID
+}
+
+// IdentityStore maps identity provider accounts to local users.
+type IdentityStore interface {
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (model.User, error)
+	LinkIdentity(ctx context.Context, identity Identity) error
+}
+
+// ActionToken is the server-side record behind an emailed token.
+type ActionToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+type TokenStore interface {
+	CreateToken(ctx context.Context, token ActionToken) error
+	// ConsumeToken marks an unused, unexpired token as used and returns the
+	// user it belongs to. It returns ErrNotFound if the token does not
+	// exist, has the wrong purpose, has expired or was already used.
+	ConsumeToken(ctx context.Context, id uuid.UUID, purpose string) (uuid.UUID, error)
+}
+
+// APIKey is a stored personal API key. The key itself is never stored.
+type APIKey struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	Prefix     string
+	Hash       []byte
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// ListAPIKeys returns the user's keys that have not been revoked, newest
+	// first.
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error)
+	// RevokeAPIKey returns ErrNotFound unless the key exists, belongs to
+	// userID and is not already revoked.
+	RevokeAPIKey(ctx context.Context, userID, id uuid.UUID) error
+	// UseAPIKey looks up a live key by hash and records that it was used.
+	// Revoked and expired keys are reported as ErrNotFound.
+	UseAPIKey(ctx context.Context, hash []byte) (APIKey, error)
+}
+
+// Outcomes of an execution.
+const (
+	ExecutionSuccess = "success"
+	ExecutionError   = "error"
+	ExecutionTimeout = "timeout"
+	// ExecutionAborted is a run cut short by server shutdown.
+	ExecutionAborted = "aborted"
+	// ExecutionInstallFailed is a run whose dependencies could not be
+	// installed; the code itself did not run.
+	ExecutionInstallFailed = "install_failed"
+	// ExecutionBlocked is a run killed by the seccomp profile for making
+	// a system call the sandbox forbids.
+	ExecutionBlocked = "blocked"
+)
+
+// Execution is one recorded run of submitted code.
+type Execution struct {
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	APIKeyID uuid.NullUUID
+	Language string
+	// Code, Stdin, Dependencies and PackageJSON are empty unless
+	// CodeRetained.
+	Code         string
+	Stdin        string
+	Dependencies []string
+	PackageJSON  []byte
+	CodeRetained bool
+	CodeSHA256   string
+	Output       string
+	Error        string
+	Status       string
+	// ExitCode is nil when the program did not run to completion.
+	ExitCode  *int
+	Duration  time.Duration
+	Image     string
+	CreatedAt time.Time
+}
+
+// ExecutionCursor marks a position in a user's history, which is ordered
+// newest first.
+type ExecutionCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// ExecutionFilter selects from one user's history. Zero values do not
+// filter.
+type ExecutionFilter struct {
+	UserID   uuid.UUID
+	Language string
+	Status   string
+	From     time.Time
+	To       time.Time
+	// After continues a listing past the given entry.
+	After *ExecutionCursor
+	Limit int
+}
+
+type ExecutionStore interface {
+	CreateExecution(ctx context.Context, e Execution) error
+	// GetExecution returns ErrNotFound unless the execution belongs to
+	// userID.
+	GetExecution(ctx context.Context, userID, id uuid.UUID) (Execution, error)
+	ListExecutions(ctx context.Context, filter ExecutionFilter) ([]Execution, error)
+	// DeleteExecutionsBefore removes records created before cutoff and
+	// returns how many were removed.
+	DeleteExecutionsBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
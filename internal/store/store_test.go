@@ -0,0 +1,389 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Aadithya-J/alcaIDE/internal/db"
+	"github.com/Aadithya-J/alcaIDE/internal/store"
+	"github.com/Aadithya-J/alcaIDE/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// stores is one implementation of every store, so the same cases can run
+// against the in-memory stores and Postgres.
+type stores struct {
+	users interface {
+		store.UserStore
+		store.IdentityStore
+	}
+	tokens     store.TokenStore
+	keys       store.APIKeyStore
+	executions store.ExecutionStore
+}
+
+// forEachStore runs test against the in-memory stores and, when
+// TEST_DATABASE_URL names a scratch database, against Postgres. The
+// database is migrated and emptied first.
+func forEachStore(t *testing.T, test func(t *testing.T, s stores)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, stores{
+			users:      store.NewMemoryUserStore(),
+			tokens:     store.NewMemoryTokenStore(),
+			keys:       store.NewMemoryAPIKeyStore(),
+			executions: store.NewMemoryExecutionStore(),
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("TEST_DATABASE_URL")
+		if url == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		ctx := context.Background()
+		pool, err := pgxpool.New(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(pool.Close)
+		migrator, err := db.NewMigrator(pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, "TRUNCATE users CASCADE"); err != nil {
+			t.Fatal(err)
+		}
+		test(t, stores{
+			users:      store.NewPostgresUserStore(pool),
+			tokens:     store.NewPostgresTokenStore(pool),
+			keys:       store.NewPostgresAPIKeyStore(pool),
+			executions: store.NewPostgresExecutionStore(pool),
+		})
+	})
+}
+
+// now is truncated to what Postgres stores, so times read back compare
+// equal.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+func createUser(t *testing.T, s stores, name string) model.User {
+	t.Helper()
+	user := model.User{ID: uuid.New(), Username: name, Email: name + "@example.com", Password: "hash"}
+	if err := s.users.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("create user %s: %v", name, err)
+	}
+	return user
+}
+
+func wantConflict(t *testing.T, err error, field string) {
+	t.Helper()
+	var conflict *store.ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("got error %v, want a conflict on %s", err, field)
+	}
+	if conflict.Field != field {
+		t.Fatalf("conflict on %q, want %q", conflict.Field, field)
+	}
+}
+
+func wantNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		alice := createUser(t, s, "alice")
+
+		for name, get := range map[string]func() (model.User, error){
+			"id":       func() (model.User, error) { return s.users.GetUserByID(ctx, alice.ID) },
+			"username": func() (model.User, error) { return s.users.GetUserByUsername(ctx, "alice") },
+			"email":    func() (model.User, error) { return s.users.GetUserByEmail(ctx, "alice@example.com") },
+		} {
+			got, err := get()
+			if err != nil {
+				t.Fatalf("by %s: %v", name, err)
+			}
+			if got != alice {
+				t.Fatalf("by %s: got %+v, want %+v", name, got, alice)
+			}
+		}
+
+		_, err := s.users.GetUserByID(ctx, uuid.New())
+		wantNotFound(t, err)
+		_, err = s.users.GetUserByUsername(ctx, "bob")
+		wantNotFound(t, err)
+		_, err = s.users.GetUserByEmail(ctx, "bob@example.com")
+		wantNotFound(t, err)
+
+		wantConflict(t, s.users.CreateUser(ctx, model.User{ID: uuid.New(), Username: "alice", Email: "other@example.com"}), "username")
+		wantConflict(t, s.users.CreateUser(ctx, model.User{ID: uuid.New(), Username: "other", Email: "alice@example.com"}), "email")
+		wantConflict(t, s.users.CreateUser(ctx, model.User{ID: alice.ID, Username: "other", Email: "other@example.com"}), "id")
+
+		if err := s.users.SetEmailVerified(ctx, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.users.UpdatePassword(ctx, alice.ID, "new hash"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.users.GetUserByID(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.EmailVerified || got.Password != "new hash" || got.PasswordChangedAt.IsZero() {
+			t.Fatalf("got %+v after verifying and changing password", got)
+		}
+		wantNotFound(t, s.users.SetEmailVerified(ctx, uuid.New()))
+		wantNotFound(t, s.users.UpdatePassword(ctx, uuid.New(), "hash"))
+	})
+}
+
+func TestIdentities(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		alice := createUser(t, s, "alice")
+		bob := createUser(t, s, "bob")
+
+		identity := store.Identity{Issuer: "https://idp.example.com", Subject: "123", UserID: alice.ID}
+		if err := s.users.LinkIdentity(ctx, identity); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.users.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != alice.ID {
+			t.Fatalf("identity resolved to %s, want %s", got.ID, alice.ID)
+		}
+
+		wantConflict(t, s.users.LinkIdentity(ctx, store.Identity{Issuer: identity.Issuer, Subject: "123", UserID: bob.ID}), "identity")
+		wantNotFound(t, s.users.LinkIdentity(ctx, store.Identity{Issuer: identity.Issuer, Subject: "456", UserID: uuid.New()}))
+		_, err = s.users.GetUserByIdentity(ctx, identity.Issuer, "456")
+		wantNotFound(t, err)
+		_, err = s.users.GetUserByIdentity(ctx, "https://other.example.com", "123")
+		wantNotFound(t, err)
+	})
+}
+
+func TestTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		alice := createUser(t, s, "alice")
+
+		token := store.ActionToken{ID: uuid.New(), UserID: alice.ID, Purpose: "verify", ExpiresAt: now().Add(time.Hour)}
+		if err := s.tokens.CreateToken(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+		wantConflict(t, s.tokens.CreateToken(ctx, token), "id")
+
+		_, err := s.tokens.ConsumeToken(ctx, token.ID, "reset")
+		wantNotFound(t, err)
+		userID, err := s.tokens.ConsumeToken(ctx, token.ID, "verify")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userID != alice.ID {
+			t.Fatalf("token belongs to %s, want %s", userID, alice.ID)
+		}
+		_, err = s.tokens.ConsumeToken(ctx, token.ID, "verify")
+		wantNotFound(t, err)
+
+		expired := store.ActionToken{ID: uuid.New(), UserID: alice.ID, Purpose: "verify", ExpiresAt: now().Add(-time.Minute)}
+		if err := s.tokens.CreateToken(ctx, expired); err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.tokens.ConsumeToken(ctx, expired.ID, "verify")
+		wantNotFound(t, err)
+		_, err = s.tokens.ConsumeToken(ctx, uuid.New(), "verify")
+		wantNotFound(t, err)
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		alice := createUser(t, s, "alice")
+		bob := createUser(t, s, "bob")
+
+		created := now()
+		older := store.APIKey{ID: uuid.New(), UserID: alice.ID, Name: "ci", Prefix: "ak_1", Hash: []byte("hash-1"),
+			Scopes: []string{"exec"}, CreatedAt: created.Add(-time.Hour)}
+		newer := store.APIKey{ID: uuid.New(), UserID: alice.ID, Name: "laptop", Prefix: "ak_2", Hash: []byte("hash-2"),
+			Scopes: []string{"exec", "history:read"}, CreatedAt: created}
+		past := created.Add(-time.Minute)
+		expired := store.APIKey{ID: uuid.New(), UserID: alice.ID, Name: "old", Prefix: "ak_3", Hash: []byte("hash-3"),
+			Scopes: []string{"exec"}, ExpiresAt: &past, CreatedAt: created.Add(-2 * time.Hour)}
+		for _, key := range []store.APIKey{older, newer, expired} {
+			if err := s.keys.CreateAPIKey(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wantConflict(t, s.keys.CreateAPIKey(ctx, store.APIKey{ID: uuid.New(), UserID: bob.ID, Name: "dup", Prefix: "ak_4",
+			Hash: []byte("hash-1"), Scopes: []string{"exec"}, CreatedAt: created}), "key")
+		wantConflict(t, s.keys.CreateAPIKey(ctx, store.APIKey{ID: older.ID, UserID: bob.ID, Name: "dup", Prefix: "ak_4",
+			Hash: []byte("hash-4"), Scopes: []string{"exec"}, CreatedAt: created}), "id")
+
+		keys, err := s.keys.ListAPIKeys(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 3 || keys[0].ID != newer.ID || keys[1].ID != older.ID || keys[2].ID != expired.ID {
+			t.Fatalf("listed %+v, want newest first", keys)
+		}
+
+		used, err := s.keys.UseAPIKey(ctx, []byte("hash-2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used.ID != newer.ID || used.LastUsedAt == nil {
+			t.Fatalf("used %+v, want %s with last use recorded", used, newer.ID)
+		}
+		_, err = s.keys.UseAPIKey(ctx, []byte("hash-3"))
+		wantNotFound(t, err)
+		_, err = s.keys.UseAPIKey(ctx, []byte("no such key"))
+		wantNotFound(t, err)
+
+		wantNotFound(t, s.keys.RevokeAPIKey(ctx, bob.ID, older.ID))
+		if err := s.keys.RevokeAPIKey(ctx, alice.ID, older.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantNotFound(t, s.keys.RevokeAPIKey(ctx, alice.ID, older.ID))
+		_, err = s.keys.UseAPIKey(ctx, []byte("hash-1"))
+		wantNotFound(t, err)
+		keys, err = s.keys.ListAPIKeys(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("listed %d keys after revoking one, want 2", len(keys))
+		}
+	})
+}
+
+func TestExecutions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s stores) {
+		ctx := context.Background()
+		alice := createUser(t, s, "alice")
+		bob := createUser(t, s, "bob")
+
+		base := now()
+		execution := func(user uuid.UUID, age time.Duration, language, status string) store.Execution {
+			return store.Execution{
+				ID: uuid.New(), UserID: user, Language: language, Status: status,
+				Code: "print(1)", Stdin: "in", Dependencies: []string{"requests"}, PackageJSON: []byte(`{"a": 1}`),
+				CodeSHA256: "sha", Output: "1\n", Image: "python:3.12", CreatedAt: base.Add(-age),
+			}
+		}
+		// Two runs share a timestamp, so pages must break ties on the ID.
+		runs := []store.Execution{
+			execution(alice.ID, 0, "python", store.ExecutionSuccess),
+			execution(alice.ID, time.Second, "python", store.ExecutionError),
+			execution(alice.ID, time.Second, "javascript", store.ExecutionSuccess),
+			execution(alice.ID, time.Minute, "python", store.ExecutionSuccess),
+			execution(alice.ID, time.Hour, "python", store.ExecutionTimeout),
+		}
+		runs[0].CodeRetained = true
+		for _, e := range append(runs, execution(bob.ID, 0, "python", store.ExecutionSuccess)) {
+			if err := s.executions.CreateExecution(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		retained, err := s.executions.GetExecution(ctx, alice.ID, runs[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !retained.CodeRetained || retained.Code != "print(1)" || retained.Stdin != "in" ||
+			len(retained.Dependencies) != 1 || string(retained.PackageJSON) != `{"a": 1}` {
+			t.Fatalf("retained run read back as %+v", retained)
+		}
+		dropped, err := s.executions.GetExecution(ctx, alice.ID, runs[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped.CodeRetained || dropped.Code != "" || dropped.Stdin != "" || dropped.Dependencies != nil || dropped.PackageJSON != nil {
+			t.Fatalf("run without retained code read back as %+v", dropped)
+		}
+		if dropped.CodeSHA256 != "sha" || !dropped.CreatedAt.Equal(runs[1].CreatedAt) {
+			t.Fatalf("run read back as %+v", dropped)
+		}
+		_, err = s.executions.GetExecution(ctx, bob.ID, runs[0].ID)
+		wantNotFound(t, err)
+
+		// Page through alice's history two at a time.
+		var paged []uuid.UUID
+		filter := store.ExecutionFilter{UserID: alice.ID, Limit: 2}
+		for {
+			page, err := s.executions.ListExecutions(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range page {
+				paged = append(paged, e.ID)
+			}
+			if len(page) < filter.Limit {
+				break
+			}
+			last := page[len(page)-1]
+			filter.After = &store.ExecutionCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+		all, err := s.executions.ListExecutions(ctx, store.ExecutionFilter{UserID: alice.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(runs) || len(paged) != len(runs) {
+			t.Fatalf("listed %d runs and paged through %d, want %d", len(all), len(paged), len(runs))
+		}
+		for i, e := range all {
+			if paged[i] != e.ID {
+				t.Fatalf("page order %v differs from listing order at %d", paged, i)
+			}
+			if i > 0 && e.CreatedAt.After(all[i-1].CreatedAt) {
+				t.Fatalf("listing is not newest first at %d", i)
+			}
+		}
+		if all[0].ID != runs[0].ID || all[4].ID != runs[4].ID {
+			t.Fatalf("listing starts with %s and ends with %s", all[0].ID, all[4].ID)
+		}
+
+		for _, tc := range []struct {
+			name   string
+			filter store.ExecutionFilter
+			want   int
+		}{
+			{"language", store.ExecutionFilter{UserID: alice.ID, Language: "python"}, 4},
+			{"status", store.ExecutionFilter{UserID: alice.ID, Status: store.ExecutionSuccess}, 3},
+			{"from", store.ExecutionFilter{UserID: alice.ID, From: base.Add(-time.Second)}, 3},
+			{"to", store.ExecutionFilter{UserID: alice.ID, To: base.Add(-time.Second)}, 2},
+			{"limit", store.ExecutionFilter{UserID: alice.ID, Limit: 1}, 1},
+			{"other user", store.ExecutionFilter{UserID: uuid.New()}, 0},
+		} {
+			got, err := s.executions.ListExecutions(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if len(got) != tc.want {
+				t.Fatalf("%s: listed %d runs, want %d", tc.name, len(got), tc.want)
+			}
+		}
+
+		removed, err := s.executions.DeleteExecutionsBefore(ctx, base.Add(-time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 2 {
+			t.Fatalf("removed %d runs, want 2", removed)
+		}
+	})
+}
@@ -3,9 +3,16 @@ package model
 import "github.com/google/uuid"
 
 type UserResponse struct {
-    Username string    `json:"username"`
-    ID       uuid.UUID `json:"id"`
-    Email    string    `json:"email"`
+    Username      string    `json:"username"`
+    ID            uuid.UUID `json:"id"`
+    Email         string    `json:"email"`
+    EmailVerified bool      `json:"email_verified"`
+}
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 type LoginRequest struct {
@@ -16,4 +23,17 @@ type LoginRequest struct {
 type AuthResponse struct {
 	UserResponse
 	Token string `json:"token"`
-}
\ No newline at end of file
+}
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
@@ -1,8 +1,140 @@
 package model
 
+import (
+    "encoding/json"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+type ExecRequest struct {
+    Code     string `json:"code"`
+    Language string `json:"language"`
+    Stdin    string `json:"stdin,omitempty"`
+    // Dependencies are installed before the code runs: pip requirement
+    // specifiers for Python, npm package specs for JavaScript.
+    Dependencies []string `json:"dependencies,omitempty"`
+    // PackageJSON is a package.json whose dependencies are installed before
+    // the code runs; JavaScript only.
+    PackageJSON json.RawMessage `json:"package_json,omitempty"`
+    // Priority is interactive (the default) or batch. Batch runs wait
+    // behind interactive ones when containers are scarce.
+    Priority string `json:"priority,omitempty"`
+}
+
+// QueueReport describes how long a run waited for a free container.
+type QueueReport struct {
+    // Position is where the run joined the queue, starting at 1.
+    Position int   `json:"position"`
+    WaitMS   int64 `json:"wait_ms"`
+}
+
+// InstallReport describes installing a run's dependencies. Its output is
+// kept apart from the program's.
+type InstallReport struct {
+    Output     string `json:"output"`
+    Error      string `json:"error,omitempty"`
+    ExitCode   *int   `json:"exit_code,omitempty"`
+    DurationMS int64  `json:"duration_ms"`
+}
+
 type ExecResponse struct {
     Code     string `json:"code"`
     Language string `json:"language"`
+    // Runtime is the OCI runtime the code ran under.
+    Runtime  string `json:"runtime,omitempty"`
     Output   string `json:"output"`
     Error    string `json:"error"`
-}
\ No newline at end of file
+    // Install is present when the request declared dependencies. If it
+    // reports an error the code was not run.
+    Install *InstallReport `json:"install,omitempty"`
+    // Queue is present when no container was free and the run had to
+    // wait for one.
+    Queue *QueueReport `json:"queue,omitempty"`
+    // ExecutionID identifies the run in the caller's history, if recorded.
+    ExecutionID *uuid.UUID `json:"execution_id,omitempty"`
+}
+
+// ExecutionSummary is a history entry as listed; Execution adds the code
+// and output.
+type ExecutionSummary struct {
+    ID         uuid.UUID `json:"id"`
+    Language   string    `json:"language"`
+    Status     string    `json:"status"`
+    ExitCode   *int      `json:"exit_code,omitempty"`
+    DurationMS int64     `json:"duration_ms"`
+    Image      string    `json:"image"`
+    CodeSHA256 string    `json:"code_sha256"`
+    // Rerunnable is false when the server did not retain the source.
+    Rerunnable bool      `json:"rerunnable"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+type Execution struct {
+    ExecutionSummary
+    Code         string          `json:"code,omitempty"`
+    Stdin        string          `json:"stdin,omitempty"`
+    Dependencies []string        `json:"dependencies,omitempty"`
+    PackageJSON  json.RawMessage `json:"package_json,omitempty"`
+    Output       string          `json:"output"`
+    Error        string          `json:"error"`
+}
+
+type ExecutionList struct {
+    Executions []ExecutionSummary `json:"executions"`
+    // NextCursor fetches the following page when passed as ?cursor=.
+    NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type FormatRequest struct {
+    Code     string `json:"code"`
+    Language string `json:"language"`
+}
+
+// Diagnostic severities.
+const (
+    SeverityError   = "error"
+    SeverityWarning = "warning"
+    SeverityInfo    = "info"
+)
+
+// Diagnostic is a problem a tool found in submitted code. Line and Column
+// start at 1.
+type Diagnostic struct {
+    // File is the name the code was checked under, such as "main.py".
+    File     string `json:"file,omitempty"`
+    Line     int    `json:"line"`
+    Column   int    `json:"column"`
+    Severity string `json:"severity"`
+    // Code identifies the rule or check, such as "F401" or "TS2322".
+    Code    string `json:"code,omitempty"`
+    Message string `json:"message"`
+    // Source is the tool that reported it, such as "black".
+    Source string `json:"source"`
+}
+
+type FormatResponse struct {
+    Language  string `json:"language"`
+    Formatter string `json:"formatter"`
+    // Formatted is the formatted code; it is empty if formatting failed.
+    Formatted string `json:"formatted"`
+    // Changed reports whether Formatted differs from the submitted code.
+    Changed bool   `json:"changed"`
+    Error   string `json:"error,omitempty"`
+    // Diagnostics locate the syntax errors that stopped the formatter.
+    Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+    Queue       *QueueReport `json:"queue,omitempty"`
+}
+
+type DiagnosticsRequest struct {
+    Code     string `json:"code"`
+    Language string `json:"language"`
+}
+
+type DiagnosticsResponse struct {
+    Language string `json:"language"`
+    Linter   string `json:"linter"`
+    // Diagnostics is empty, not absent, when the code is clean.
+    Diagnostics []Diagnostic `json:"diagnostics"`
+    Queue       *QueueReport `json:"queue,omitempty"`
+}
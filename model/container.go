@@ -5,24 +5,82 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 )
 
 type ContainerInfo struct {
-	ID string
+	ID       string
+	Language string
+	Image    string
+	// Runtime is the OCI runtime the container runs under.
+	Runtime string
+	// Security identifies the seccomp and AppArmor profiles the container
+	// was started with; empty when Docker's defaults apply.
+	Security string
+	// Host names the Docker host the container runs on.
+	Host string
 }
 
-func (c *ContainerInfo) ExecuteCode(execCmd []string, cli *client.Client, ctx context.Context) (string, error) {
+// ExitError reports a program that ran to completion with a non-zero exit
+// status. Output holds stdout followed by stderr.
+type ExitError struct {
+	ExitCode int
+	Output   string
+}
+
+// NewExitError reports a program that exited with code, combining what it
+// wrote to stdout and stderr.
+func NewExitError(code int, stdout, stderr string) *ExitError {
+	combined := stdout
+	if stderr != "" {
+		if combined != "" {
+			combined += "\n"
+		}
+		combined += "Stderr:\n" + stderr
+	}
+	return &ExitError{ExitCode: code, Output: combined}
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("python execution failed (exit %d):\n%s", e.ExitCode, e.Output)
+}
+
+// ExecOptions adjust how ExecuteCode runs a command.
+type ExecOptions struct {
+	// Stdin is fed to the command if non-empty.
+	Stdin string
+	// Env adds KEY=value pairs to the image's environment.
+	Env []string
+	// User overrides the image's user.
+	User string
+}
+
+// ExecClient is the part of the Docker client that runs commands in
+// containers.
+type ExecClient interface {
+	ContainerExecCreate(ctx context.Context, container string, options container.ExecOptions) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, options container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+}
+
+// ExecuteCode runs execCmd in the container.
+func (c *ContainerInfo) ExecuteCode(execCmd []string, opts ExecOptions, cli ExecClient, ctx context.Context) (string, error) {
+	stdin := opts.Stdin
+
 	execConfig := container.ExecOptions{
 		Cmd:          execCmd,
+		Env:          opts.Env,
+		User:         opts.User,
+		AttachStdin:  stdin != "",
 		AttachStdout: true,
 		AttachStderr: true,
 	}
-	fmt.Println("Executing code in container:", c.ID)
+	logger := slog.With("container_id", c.ID)
+	logger.DebugContext(ctx, "Executing code in container")
 
 	execResp, err := cli.ContainerExecCreate(ctx, c.ID, execConfig)
 	if err != nil {
@@ -35,6 +93,19 @@ func (c *ContainerInfo) ExecuteCode(execCmd []string, cli *client.Client, ctx co
 	}
 	defer attachResp.Close()
 
+	if stdin != "" {
+		// Written concurrently with reading the output so a program that
+		// prints before consuming its input cannot deadlock us. Closing the
+		// write side delivers EOF. A program may exit without reading
+		// everything, so write errors are not fatal.
+		go func() {
+			if _, err := io.WriteString(attachResp.Conn, stdin); err != nil {
+				logger.DebugContext(ctx, "stdin not fully consumed", "error", err)
+			}
+			attachResp.CloseWrite()
+		}()
+	}
+
 	var stdoutBuf, stderrBuf bytes.Buffer
 	copyErr := make(chan error, 1)
 	go func() {
@@ -52,29 +123,22 @@ func (c *ContainerInfo) ExecuteCode(execCmd []string, cli *client.Client, ctx co
 		return "", fmt.Errorf("python execution timed out: %w", ctx.Err())
 	case err := <-copyErr:
 		if err != nil {
-			log.Printf("warning: stdcopy incomplete: %v", err)
+			logger.WarnContext(ctx, "stdcopy incomplete", "error", err)
 		}
 	}
 
 	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
 	if err != nil {
-		log.Printf("warning: exec inspect failed: %v", err)
+		logger.WarnContext(ctx, "exec inspect failed", "error", err)
 	}
 
 	outStr := stdoutBuf.String()
 	errStr := stderrBuf.String()
 	if inspectResp.ExitCode != 0 {
-		combined := outStr
-		if errStr != "" {
-			if combined != "" {
-				combined += "\n"
-			}
-			combined += "Stderr:\n" + errStr
-		}
-		return outStr, fmt.Errorf("python execution failed (exit %d):\n%s", inspectResp.ExitCode, combined)
+		return outStr, NewExitError(inspectResp.ExitCode, outStr, errStr)
 	}
 	if errStr != "" {
-		log.Printf("python stderr (exit 0) in %s:\n%s", c.ID, errStr)
+		logger.DebugContext(ctx, "program wrote to stderr but exited 0", "stderr_bytes", len(errStr))
 	}
 	return outStr, nil
 }
\ No newline at end of file
@@ -0,0 +1,15 @@
+package model
+
+// ErrorResponse is the envelope every JSON error is returned in.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	// Code is a stable, machine-readable identifier such as
+	// "validation_failed" or "conflict".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields maps request field names to what is wrong with them.
+	Fields map[string]string `json:"fields,omitempty"`
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateLSPSessionRequest struct {
+	Language string `json:"language"`
+	// RootURI is the client's workspace root, a file:// URI. URIs under it
+	// are rewritten to the session's workspace in the sandbox and back. If
+	// empty, the client must use WorkspaceURI itself.
+	RootURI string `json:"root_uri,omitempty"`
+}
+
+type LSPSession struct {
+	ID        uuid.UUID `json:"id"`
+	Language  string    `json:"language"`
+	RootURI   string    `json:"root_uri,omitempty"`
+	Connected bool      `json:"connected"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateLSPSessionResponse is the only time the ticket is returned. It is
+// passed as ?ticket= to ConnectURL to open the session's WebSocket, once,
+// before TicketExpiresAt.
+type CreateLSPSessionResponse struct {
+	LSPSession
+	Ticket          string    `json:"ticket"`
+	TicketExpiresAt time.Time `json:"ticket_expires_at"`
+	ConnectURL      string    `json:"connect_url"`
+	// WorkspaceURI is the session's workspace as the language server
+	// sees it.
+	WorkspaceURI string `json:"workspace_uri"`
+}
+
+type LSPSessionList struct {
+	Sessions []LSPSession `json:"sessions"`
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notebook run and job statuses. A run that stops at a cell that raised is
+// failed; a run that goes on past errors is completed and lists them.
+const (
+	NotebookQueued    = "queued"
+	NotebookRunning   = "running"
+	NotebookCompleted = "completed"
+	NotebookFailed    = "failed"
+	NotebookTimedOut  = "timed_out"
+	NotebookCancelled = "cancelled"
+	// NotebookError means the notebook could not be run to the end for a
+	// reason other than its code, such as its kernel dying.
+	NotebookError = "error"
+)
+
+// NotebookResult is an executed notebook.
+type NotebookResult struct {
+	Status string `json:"status"`
+	// Notebook is the submitted nbformat 4 notebook with its code cells'
+	// outputs and execution counts filled in. Cells that were not run
+	// have none.
+	Notebook      json.RawMessage `json:"notebook"`
+	ExecutedCells int             `json:"executed_cells"`
+	// FailedCells are the indexes, in the notebook's cells, of the cells
+	// that raised.
+	FailedCells []int  `json:"failed_cells,omitempty"`
+	Error       string `json:"error,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+}
+
+type NotebookJob struct {
+	ID         uuid.UUID  `json:"id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ExecutedCells counts the code cells run so far, of TotalCells.
+	ExecutedCells int `json:"executed_cells"`
+	TotalCells    int `json:"total_cells"`
+	// Result is present once the job has finished.
+	Result *NotebookResult `json:"result,omitempty"`
+}
+
+type NotebookJobList struct {
+	Jobs []NotebookJob `json:"jobs"`
+}
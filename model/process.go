@@ -0,0 +1,99 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Process is a long-running command in a sandbox with its standard input
+// and output attached, such as a language server. Its stderr is discarded.
+type Process interface {
+	// Stdin is the command's standard input; closing it delivers EOF.
+	Stdin() io.WriteCloser
+	Stdout() io.Reader
+	// Wait blocks until the command exits or the process is closed. A
+	// non-zero exit status is an *ExitError.
+	Wait() error
+	// Close detaches from the command. It is not killed: commands run
+	// this way are expected to exit when their stdin closes.
+	Close() error
+}
+
+type dockerProcess struct {
+	conn   types.HijackedResponse
+	stdout *io.PipeReader
+	done   chan struct{}
+	err    error
+}
+
+// StartProcess starts execCmd in the container and returns with its
+// streams attached. Cancelling ctx detaches from it.
+func (c *ContainerInfo) StartProcess(ctx context.Context, execCmd []string, opts ExecOptions, cli ExecClient) (Process, error) {
+	execResp, err := cli.ContainerExecCreate(ctx, c.ID, container.ExecOptions{
+		Cmd:          execCmd,
+		Env:          opts.Env,
+		User:         opts.User,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec create failed: %w", err)
+	}
+	conn, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("exec attach failed: %w", err)
+	}
+
+	stdout, stdoutW := io.Pipe()
+	p := &dockerProcess{conn: conn, stdout: stdout, done: make(chan struct{})}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go func() {
+		defer close(p.done)
+		defer stop()
+		_, copyErr := stdcopy.StdCopy(stdoutW, io.Discard, conn.Reader)
+		stdoutW.Close()
+
+		inspectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		inspect, err := cli.ContainerExecInspect(inspectCtx, execResp.ID)
+		switch {
+		case err != nil:
+			slog.Warn("exec inspect failed", "container_id", c.ID, "error", err)
+		case !inspect.Running && inspect.ExitCode != 0:
+			p.err = NewExitError(inspect.ExitCode, "", "")
+		case inspect.Running && copyErr != nil && ctx.Err() == nil:
+			p.err = fmt.Errorf("exec stream failed: %w", copyErr)
+		}
+	}()
+	return p, nil
+}
+
+func (p *dockerProcess) Stdin() io.WriteCloser { return hijackedStdin{&p.conn} }
+func (p *dockerProcess) Stdout() io.Reader     { return p.stdout }
+
+func (p *dockerProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+func (p *dockerProcess) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+// hijackedStdin writes to an attached exec; closing it half-closes the
+// connection so the command sees EOF.
+type hijackedStdin struct {
+	conn *types.HijackedResponse
+}
+
+func (s hijackedStdin) Write(b []byte) (int, error) { return s.conn.Conn.Write(b) }
+func (s hijackedStdin) Close() error                { return s.conn.CloseWrite() }
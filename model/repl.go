@@ -0,0 +1,64 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MIMEBundle holds one value in several representations keyed by MIME
+// type, as in Jupyter: text/plain is always present; binary types such as
+// image/png are base64 encoded.
+type MIMEBundle map[string]any
+
+// CellError describes an exception raised by a cell.
+type CellError struct {
+	Name      string   `json:"ename"`
+	Value     string   `json:"evalue"`
+	Traceback []string `json:"traceback"`
+}
+
+// CellResult is the outcome of running one cell. A cell that raised is
+// still a result, with Error set.
+type CellResult struct {
+	// ExecutionCount numbers the session's cells from 1.
+	ExecutionCount int    `json:"execution_count"`
+	Stdout         string `json:"stdout"`
+	Stderr         string `json:"stderr"`
+	// Truncated is set if stdout or stderr hit the output limit.
+	Truncated bool `json:"truncated,omitempty"`
+	// Result is the value of the cell's trailing expression, if any.
+	Result MIMEBundle `json:"result,omitempty"`
+	// Display holds the values passed to display(), in order.
+	Display []MIMEBundle `json:"display,omitempty"`
+	Error   *CellError   `json:"error,omitempty"`
+	// TimedOut is set if the cell was interrupted for running too long.
+	TimedOut   bool  `json:"timed_out,omitempty"`
+	DurationMS int64 `json:"duration_ms"`
+}
+
+type CreateREPLSessionRequest struct {
+	Language string `json:"language"`
+}
+
+type REPLSession struct {
+	ID       uuid.UUID `json:"id"`
+	Language string    `json:"language"`
+	// Busy is set while a cell is running.
+	Busy           bool      `json:"busy"`
+	ExecutionCount int       `json:"execution_count"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastActiveAt   time.Time `json:"last_active_at"`
+	// IdleExpiresAt is when the session ends unless another cell is run;
+	// it never ends later than ExpiresAt.
+	IdleExpiresAt time.Time `json:"idle_expires_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type REPLSessionList struct {
+	Sessions []REPLSession `json:"sessions"`
+}
+
+type RunCellRequest struct {
+	Code string `json:"code"`
+}
@@ -1,10 +1,18 @@
 package model
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type User struct {
-	Username string `json:"username"`
-	ID       uuid.UUID `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
\ No newline at end of file
+	Username      string    `json:"username"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Password      string    `json:"password"`
+	EmailVerified bool      `json:"email_verified"`
+	// PasswordChangedAt is zero unless the password was changed after the
+	// account was created. Sessions issued before it are no longer valid.
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+}